@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/allerac/notifier/internal/consumers/core"
+	"github.com/allerac/notifier/internal/scheduler"
+)
+
+// requireAdmin guards operator endpoints with "Authorization: Bearer <token>".
+// With no token configured the admin API is disabled.
+func requireAdmin(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			writeError(w, http.StatusServiceUnavailable, "admin API disabled: NOTIFIER_ADMIN_TOKEN not set")
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Bounds for POST /admin/dlq/replay?count=N and GET /admin/jobs/schedule?n=N.
+const (
+	defaultReplayCount = 100
+	maxReplayCount     = 10000
+
+	defaultScheduleRuns = 5
+	maxScheduleRuns     = 100
+)
+
+// dlqReplayer re-injects dead-letter messages into the main stream.
+type dlqReplayer interface {
+	ReplayDLQ(ctx context.Context, maxCount int64) (int, error)
+}
+
+// streamStatter reports a consumer group's view of the streams.
+type streamStatter interface {
+	Stats(ctx context.Context) (core.Stats, error)
+}
+
+// registerAdminRoutes mounts the operator endpoints on mux. groups are the
+// running consumers, reported by GET /admin/dlq/stats.
+func registerAdminRoutes(mux *http.ServeMux, token string, sched *scheduler.Scheduler, dlq dlqReplayer, groups []streamStatter) {
+	mux.HandleFunc("GET /admin/jobs", requireAdmin(token, listJobsHandler(sched)))
+	mux.HandleFunc("GET /admin/jobs/schedule", requireAdmin(token, scheduleHandler(sched)))
+	mux.HandleFunc("POST /admin/jobs/{id}/trigger", requireAdmin(token, triggerJobHandler(sched)))
+	mux.HandleFunc("POST /admin/jobs/{id}/pause", requireAdmin(token, pauseJobHandler(sched)))
+	mux.HandleFunc("POST /admin/jobs/{id}/resume", requireAdmin(token, resumeJobHandler(sched)))
+	mux.HandleFunc("POST /admin/dlq/replay", requireAdmin(token, replayDLQHandler(dlq)))
+	mux.HandleFunc("GET /admin/dlq/stats", requireAdmin(token, dlqStatsHandler(groups)))
+}
+
+// listJobsHandler reports every job's scheduling state, including how many
+// jobs failed to register (e.g. invalid cron expression).
+func listJobsHandler(sched *scheduler.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := sched.ListJobs(r.Context())
+		if err != nil {
+			slog.Error("admin: list jobs failed", slog.Any("error", err))
+			writeError(w, http.StatusInternalServerError, "failed to list jobs")
+			return
+		}
+		failures := 0
+		for _, j := range jobs {
+			if j.RegistrationError != "" {
+				failures++
+			}
+		}
+		if jobs == nil {
+			jobs = []scheduler.JobStatus{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"jobs":                  jobs,
+			"registration_failures": failures,
+		})
+	}
+}
+
+// scheduleHandler previews the next ?n=N (default 5) fire times of every
+// registered job, keyed by job name.
+func scheduleHandler(sched *scheduler.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := defaultScheduleRuns
+		if raw := r.URL.Query().Get("n"); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil || v <= 0 || v > maxScheduleRuns {
+				writeError(w, http.StatusBadRequest, "n must be between 1 and "+strconv.Itoa(maxScheduleRuns))
+				return
+			}
+			n = v
+		}
+		writeJSON(w, http.StatusOK, sched.NextRunTimes(n))
+	}
+}
+
+// triggerJobHandler runs a job right away instead of waiting for its next
+// scheduled time. It answers 202 once the execution has started.
+func triggerJobHandler(sched *scheduler.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobID := r.PathValue("id")
+		err := sched.TriggerNow(r.Context(), jobID)
+		switch {
+		case errors.Is(err, scheduler.ErrJobNotFound):
+			writeError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, scheduler.ErrJobAlreadyRunning):
+			writeError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			slog.Error("admin: trigger job failed", slog.String("job_id", jobID), slog.Any("error", err))
+			writeError(w, http.StatusInternalServerError, "failed to trigger job")
+		default:
+			writeJSON(w, http.StatusAccepted, map[string]string{"job_id": jobID, "status": "triggered"})
+		}
+	}
+}
+
+// pauseJobHandler pauses a job so it no longer fires until resumed.
+func pauseJobHandler(sched *scheduler.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobID := r.PathValue("id")
+		err := sched.PauseJob(r.Context(), jobID)
+		switch {
+		case errors.Is(err, scheduler.ErrJobNotFound):
+			writeError(w, http.StatusNotFound, err.Error())
+		case err != nil:
+			slog.Error("admin: pause job failed", slog.String("job_id", jobID), slog.Any("error", err))
+			writeError(w, http.StatusInternalServerError, "failed to pause job")
+		default:
+			writeJSON(w, http.StatusOK, map[string]string{"job_id": jobID, "status": "paused"})
+		}
+	}
+}
+
+// resumeJobHandler unpauses a job and schedules it again. A job that is
+// unpaused but cannot be scheduled answers 422 with the registration error.
+func resumeJobHandler(sched *scheduler.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobID := r.PathValue("id")
+		err := sched.ResumeJob(r.Context(), jobID)
+		switch {
+		case errors.Is(err, scheduler.ErrJobNotFound):
+			writeError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, scheduler.ErrJobNotSchedulable):
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+		case err != nil:
+			slog.Error("admin: resume job failed", slog.String("job_id", jobID), slog.Any("error", err))
+			writeError(w, http.StatusInternalServerError, "failed to resume job")
+		default:
+			writeJSON(w, http.StatusOK, map[string]string{"job_id": jobID, "status": "resumed"})
+		}
+	}
+}
+
+// replayDLQHandler moves up to ?count=N (default 100) dead-letter messages
+// back onto the notifications stream.
+func replayDLQHandler(dlq dlqReplayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		count := int64(defaultReplayCount)
+		if raw := r.URL.Query().Get("count"); raw != "" {
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || n <= 0 || n > maxReplayCount {
+				writeError(w, http.StatusBadRequest, "count must be between 1 and "+strconv.Itoa(maxReplayCount))
+				return
+			}
+			count = n
+		}
+
+		replayed, err := dlq.ReplayDLQ(r.Context(), count)
+		if err != nil {
+			slog.Error("admin: replay DLQ failed", slog.Int("replayed", replayed), slog.Any("error", err))
+			writeJSON(w, http.StatusInternalServerError, map[string]any{
+				"error":    "failed to replay DLQ",
+				"replayed": replayed,
+			})
+			return
+		}
+		slog.Info("admin: replayed DLQ messages", slog.Int("replayed", replayed))
+		writeJSON(w, http.StatusOK, map[string]any{"replayed": replayed})
+	}
+}
+
+// dlqStatsHandler reports the DLQ and stream lengths and, per consumer group,
+// how many messages are pending and how long the oldest has been idle; the
+// top-level pending and oldest_pending_idle_seconds cover all groups.
+func dlqStatsHandler(groups []streamStatter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var dlqLen, streamLen, pending int64
+		var oldest float64
+		perGroup := make(map[string]any, len(groups))
+		for _, g := range groups {
+			st, err := g.Stats(r.Context())
+			if err != nil {
+				slog.Error("admin: DLQ stats failed", slog.Any("error", err))
+				writeError(w, http.StatusInternalServerError, "failed to read stream stats")
+				return
+			}
+			dlqLen, streamLen = st.DLQLength, st.StreamLength // the same for every group
+			pending += st.Pending
+			oldest = max(oldest, st.OldestIdle.Seconds())
+			perGroup[st.Group] = map[string]any{
+				"pending":                     st.Pending,
+				"oldest_pending_idle_seconds": st.OldestIdle.Seconds(),
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"dlq_length":                  dlqLen,
+			"stream_length":               streamLen,
+			"pending":                     pending,
+			"oldest_pending_idle_seconds": oldest,
+			"groups":                      perGroup,
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
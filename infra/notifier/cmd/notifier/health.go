@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const healthCheckTimeout = 3 * time.Second
+
+// healthCheck reports whether a dependency is usable.
+type healthCheck func(ctx context.Context) error
+
+// healthHandler runs every check at once and reports an aggregate status:
+// 200 {"status":"ok",...} when all pass, 503 {"status":"degraded",...} otherwise.
+// Each component is reported as "ok" or its error message. It serves /readyz
+// (and /health): a pod that fails it should get no traffic until it recovers.
+func healthHandler(checks map[string]healthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		var (
+			mu         sync.Mutex
+			wg         sync.WaitGroup
+			status     = "ok"
+			code       = http.StatusOK
+			components = make(map[string]string, len(checks))
+		)
+		for name, check := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result := "ok"
+				if err := check(ctx); err != nil {
+					result = err.Error()
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				components[name] = result
+				if result != "ok" {
+					status = "degraded"
+					code = http.StatusServiceUnavailable
+				}
+			}()
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":     status,
+			"components": components,
+		})
+	}
+}
+
+// livezHandler answers 200 {"status":"ok"} as long as the process can serve
+// HTTP at all, whatever its dependencies' state: a liveness probe that failed
+// on a database outage would restart every pod without fixing anything.
+func livezHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+}
+
+// dbHealthHandler reports the database connection pool:
+// 200 {"status":"ok","db":{"acquired":N,"idle":N,"total":N}} when ping passes,
+// 503 {"status":"degraded"} otherwise.
+func dbHealthHandler(ping healthCheck, stats func() map[string]int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := ping(ctx); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]any{"status": "degraded"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": "ok",
+			"db":     stats(),
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func okCheck(context.Context) error { return nil }
+
+func TestHealthHandler_AllOK(t *testing.T) {
+	rec := httptest.NewRecorder()
+	healthHandler(map[string]healthCheck{"postgres": okCheck})(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Status     string            `json:"status"`
+		Components map[string]string `json:"components"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "ok", body.Status)
+	assert.Equal(t, map[string]string{"postgres": "ok"}, body.Components)
+}
+
+func TestHealthHandler_FailingCheckDegrades(t *testing.T) {
+	failing := func(context.Context) error { return errors.New("connection refused") }
+	rec := httptest.NewRecorder()
+	healthHandler(map[string]healthCheck{"postgres": okCheck, "llm": failing})(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"degraded","components":{"postgres":"ok","llm":"connection refused"}}`, rec.Body.String())
+}
+
+func TestLivezHandler_IgnoresDependencies(t *testing.T) {
+	rec := httptest.NewRecorder()
+	livezHandler(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
+
+func TestDBHealthHandler_ReportsPoolStats(t *testing.T) {
+	stats := func() map[string]int { return map[string]int{"acquired": 1, "idle": 3, "total": 4} }
+	rec := httptest.NewRecorder()
+	dbHealthHandler(okCheck, stats)(rec, httptest.NewRequest(http.MethodGet, "/health/db", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"status":"ok","db":{"acquired":1,"idle":3,"total":4}}`, rec.Body.String())
+}
+
+func TestDBHealthHandler_PingFailure(t *testing.T) {
+	failing := func(context.Context) error { return errors.New("connection refused") }
+	stats := func() map[string]int {
+		t.Error("stats must not be read when ping fails")
+		return nil
+	}
+	rec := httptest.NewRecorder()
+	dbHealthHandler(failing, stats)(rec, httptest.NewRequest(http.MethodGet, "/health/db", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"degraded"}`, rec.Body.String())
+}
@@ -2,87 +2,501 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	_ "time/tzdata" // job time zones (CRON_TZ) must resolve in the alpine image
 
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/allerac/notifier/internal/api"
+	"github.com/allerac/notifier/internal/channels"
 	"github.com/allerac/notifier/internal/config"
+	"github.com/allerac/notifier/internal/consumers/browser"
+	"github.com/allerac/notifier/internal/consumers/core"
+	"github.com/allerac/notifier/internal/consumers/discord"
+	"github.com/allerac/notifier/internal/consumers/slack"
 	telegram "github.com/allerac/notifier/internal/consumers/telegram"
+	"github.com/allerac/notifier/internal/consumers/webhook"
+	"github.com/allerac/notifier/internal/consumers/webpush"
 	"github.com/allerac/notifier/internal/db"
+	notifiergrpc "github.com/allerac/notifier/internal/grpc"
+	"github.com/allerac/notifier/internal/logging"
 	"github.com/allerac/notifier/internal/publisher"
 	"github.com/allerac/notifier/internal/runner"
 	"github.com/allerac/notifier/internal/scheduler"
+	"github.com/allerac/notifier/internal/tracing"
+)
+
+// Per-stage shutdown budgets. The scheduler's comes from
+// NOTIFIER_SHUTDOWN_TIMEOUT and is the longest because a running job may be in
+// the middle of an LLM call (runner timeout is 120s).
+const (
+	consumerStopTimeout = 30 * time.Second
+	httpStopTimeout     = 5 * time.Second
+
+	// llmWarmupTimeout bounds the startup call that loads the model.
+	llmWarmupTimeout = 90 * time.Second
+
+	// PostgreSQL and Redis may still be starting when the notifier does (e.g.
+	// under docker compose); waits double from 2s up to 30s, ~3 min in all.
+	startupConnectAttempts = 10
+	startupConnectDelay    = 2 * time.Second
 )
 
 func main() {
-	cfg := config.Load()
+	cfg, err := config.LoadWithOverrides()
+	if err != nil {
+		fatal("failed to load configuration", err)
+	}
+
+	// Structured logs (JSON unless LOG_FORMAT=text); the standard log package is routed through it as well.
+	logger := logging.New(os.Stdout, cfg.LogFormat, logging.ParseLevel(cfg.LogLevel))
+	slog.SetDefault(logger)
+
+	if err := cfg.Validate(); err != nil {
+		fatal("invalid configuration", err)
+	}
+
+	limits, err := channels.ParseLimits(cfg.ChannelLimits)
+	if err != nil {
+		fatal("invalid NOTIFIER_CHANNEL_LIMITS", err)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// OpenTelemetry: spans for job execution → LLM call → stream → delivery
+	flushTraces, err := tracing.Setup(ctx, cfg.OTLPEndpoint, "allerac-notifier")
+	if err != nil {
+		fatal("failed to set up tracing", err)
+	}
+
 	// PostgreSQL
-	pool, err := db.Connect(ctx, cfg.DatabaseURL)
+	pool, err := db.ConnectWithRetry(ctx, cfg.DatabaseURL, db.PoolSettings{
+		MaxConns:        int32(cfg.DBMaxConns),
+		MinConns:        int32(cfg.DBMinConns),
+		MaxConnLifetime: cfg.DBMaxLifetime,
+		MaxConnIdleTime: cfg.DBMaxIdleTime,
+	}, startupConnectAttempts, startupConnectDelay)
 	if err != nil {
-		log.Fatalf("[notifier] Failed to connect to database: %v", err)
+		fatal("failed to connect to database", err)
 	}
-	defer pool.Close()
 
-	// Redis Stream publisher
-	pub, err := publisher.New(cfg.RedisURL)
+	// Redis Stream publisher. With REDIS_SENTINEL_MASTER set, it and every
+	// consumer find the master through Sentinel; REDIS_URL still supplies the
+	// password, DB and TLS settings.
+	sentinel := cfg.SentinelMaster != ""
+	redisOpts, _ := redis.ParseURL(cfg.RedisURL) // checked by Validate
+	var pub *publisher.Publisher
+	if sentinel {
+		pub, err = publisher.NewFromSentinel(cfg.SentinelMaster, cfg.SentinelAddrs, redisOpts)
+	} else {
+		pub, err = publisher.New(cfg.RedisURL)
+	}
 	if err != nil {
-		log.Fatalf("[notifier] Failed to create publisher: %v", err)
+		fatal("failed to create publisher", err)
 	}
-	defer pub.Close()
+	// The consumers share this Redis, so once it answers they can start too.
+	if err := pub.WaitReady(ctx, startupConnectAttempts, startupConnectDelay); err != nil {
+		fatal("failed to connect to redis", err)
+	}
+	pub.WithMaxLen(cfg.StreamMaxLen).WithTTL(cfg.MessageTTL).WithIdempotencyWindow(cfg.IdemWindow).WithLogger(logger).
+		WithSigningKey([]byte(cfg.SigningKey)).MustRegister(prometheus.DefaultRegisterer)
 
-	// LLM runner — prefer Allerac pipeline (tools + skills) over bare Ollama
+	// LLM runner — prefer Allerac pipeline (tools + skills) over a bare LLM provider
 	var run scheduler.Runner
+	checks := map[string]healthCheck{"postgres": pool.Ping, "redis": func(ctx context.Context) error {
+		return pub.Client().Ping(ctx).Err()
+	}}
 	if cfg.AlleracAppURL != "" && cfg.ExecutorSecret != "" {
 		run = runner.NewAllerac(cfg.AlleracAppURL, cfg.ExecutorSecret)
-		log.Printf("[notifier] Using Allerac runner: %s", cfg.AlleracAppURL)
+		slog.Info("using Allerac runner", slog.String("url", cfg.AlleracAppURL))
 	} else {
-		run = runner.New(cfg.OllamaBaseURL, cfg.LLMModel)
-		log.Printf("[notifier] Using Ollama runner: %s model=%s", cfg.OllamaBaseURL, cfg.LLMModel)
+		llmCfg := runner.Config{
+			Provider:  runner.ProviderType(cfg.LLMProvider),
+			BaseURL:   cfg.OllamaBaseURL,
+			Model:     cfg.LLMModel,
+			MaxTokens: cfg.LLMMaxTokens,
+		}
+		switch llmCfg.Provider {
+		case runner.ProviderOpenAI:
+			llmCfg.BaseURL = cfg.OpenAIBaseURL
+			llmCfg.APIKey = cfg.OpenAIAPIKey
+		case runner.ProviderAnthropic:
+			llmCfg.BaseURL = cfg.AnthropicURL
+			llmCfg.APIKey = cfg.AnthropicKey
+		}
+		llm, err := runner.NewFromConfig(llmCfg)
+		if err != nil {
+			fatal("invalid LLM configuration", err)
+		}
+		// Fail fast while the backend is down instead of hanging every job for the full timeout.
+		llm.WithCircuitBreaker(runner.NewCircuitBreaker(5, 60*time.Second)).WithLogger(logger).
+			WithMaxPromptChars(cfg.MaxPromptChars, cfg.PromptTruncate).WithFallbackModels(cfg.LLMFallbacks...)
+		if cfg.LLMCacheTTL > 0 {
+			llm.WithCache(cfg.LLMCacheTTL)
+		}
+		if err := llm.Ping(ctx); err != nil {
+			slog.Warn("LLM backend not ready", slog.Any("error", err))
+		} else {
+			// Load the model now rather than making the first job wait for it.
+			warmupCtx, cancel := context.WithTimeout(ctx, llmWarmupTimeout)
+			if err := llm.Warmup(warmupCtx); err != nil {
+				slog.Warn("LLM warmup failed", slog.Any("error", err))
+			}
+			cancel()
+		}
+		if cfg.HealthCheckLLM {
+			checks["llm"] = llm.Ping
+		}
+		run = llm
+		slog.Info("using LLM runner", slog.String("provider", cfg.LLMProvider), slog.String("url", llmCfg.BaseURL), slog.String("model", cfg.LLMModel))
 	}
 
 	// Scheduler: loads jobs from DB and fires them on cron
-	sched := scheduler.New(pool, run, pub)
+	sched := scheduler.New(pool, run, pub).
+		WithSeconds(cfg.CronSeconds).
+		WithMaxConcurrent(cfg.MaxConcurrent).
+		WithMetrics(prometheus.DefaultRegisterer).
+		WithRedis(pub.Client()).
+		WithChannelLimits(limits).
+		WithStopTimeout(cfg.StopTimeout).
+		WithLogger(logger)
+	if cfg.RateLimitRPS > 0 {
+		sched.WithRateLimiter(scheduler.NewTokenBucketRateLimiter(cfg.RateLimitRPS))
+	}
 	if err := sched.Start(ctx); err != nil {
-		log.Fatalf("[notifier] Failed to start scheduler: %v", err)
+		fatal("failed to start scheduler", err)
 	}
-	defer sched.Stop()
+	checks["scheduler"] = sched.Ping
 
 	// Live-reload: listens for pg_notify on 'scheduled_jobs_changed'
 	// so new/updated/deleted jobs take effect without restarting the service.
 	go sched.Watch(ctx, cfg.DatabaseURL)
+	// Periodic full reload catches changes whose NOTIFY was missed while disconnected.
+	if cfg.ReloadInterval > 0 {
+		go sched.ReloadLoop(ctx, cfg.ReloadInterval)
+	}
+
+	// Delivery metrics shared by all consumers
+	core.MustRegisterMetrics(prometheus.DefaultRegisterer)
 
 	// Telegram consumer: reads stream and delivers messages
-	tgConsumer, err := telegram.New(cfg.RedisURL, pool, cfg.EncryptionKey)
+	var tgConsumer *telegram.Consumer
+	if sentinel {
+		tgConsumer, err = telegram.NewFromSentinel(cfg.SentinelMaster, cfg.SentinelAddrs, redisOpts, pool, cfg.EncryptionKey)
+	} else {
+		tgConsumer, err = telegram.New(cfg.RedisURL, pool, cfg.EncryptionKey)
+	}
 	if err != nil {
-		log.Fatalf("[notifier] Failed to create Telegram consumer: %v", err)
+		fatal("failed to create Telegram consumer", err)
+	}
+	tgConsumer.WithMetrics(prometheus.DefaultRegisterer)
+	tgConsumer.WithLogger(logger)
+	tgConsumer.WithConsumerName(cfg.ConsumerName)
+	tgConsumer.WithDLQMaxLen(cfg.DLQMaxLen)
+	tgConsumer.WithWorkerCount(cfg.WorkerCount)
+	tgConsumer.WithSignatureVerification([]byte(cfg.SigningKey))
+	tgConsumer.WithWebhookSecret(cfg.WebhookSecret)
+	if cfg.DeliveryLog {
+		tgConsumer.WithArchival(pool)
+	}
+	if cfg.DLQAlertAt > 0 {
+		// The DLQ is shared by every channel, so one consumer watches it.
+		dlqAlerts := prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "notifier_dlq_alerts_total",
+			Help: "Reclaim ticks that found the DLQ above NOTIFICATIONS_DLQ_ALERT_THRESHOLD.",
+		})
+		prometheus.MustRegister(dlqAlerts)
+		tgConsumer.WithDLQAlertThreshold(cfg.DLQAlertAt, func(size int64) {
+			slog.Error("DLQ above alert threshold", slog.Int64("size", size), slog.Int64("threshold", cfg.DLQAlertAt))
+			dlqAlerts.Inc()
+		})
 	}
 	if err := tgConsumer.Start(ctx); err != nil {
-		log.Fatalf("[notifier] Failed to start Telegram consumer: %v", err)
+		fatal("failed to start Telegram consumer", err)
+	}
+	if cfg.WebhookURL != "" {
+		// Bots whose webhook could not be set keep their old one (or none);
+		// notification delivery does not depend on it.
+		regCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		if err := tgConsumer.RegisterWebhookURL(regCtx, cfg.WebhookURL); err != nil {
+			slog.Warn("telegram webhook registration failed", slog.Any("error", err))
+		}
+		cancel()
+	}
+
+	// Webhook consumer: POSTs signed payloads to per-user callback URLs
+	var whConsumer *webhook.Consumer
+	if sentinel {
+		whConsumer, err = webhook.NewFromSentinel(cfg.SentinelMaster, cfg.SentinelAddrs, redisOpts, pool, cfg.EncryptionKey)
+	} else {
+		whConsumer, err = webhook.New(cfg.RedisURL, pool, cfg.EncryptionKey)
+	}
+	if err != nil {
+		fatal("failed to create webhook consumer", err)
+	}
+	whConsumer.WithLogger(logger)
+	whConsumer.WithConsumerName(cfg.ConsumerName)
+	whConsumer.WithDLQMaxLen(cfg.DLQMaxLen)
+	whConsumer.WithWorkerCount(cfg.WorkerCount)
+	whConsumer.WithSignatureVerification([]byte(cfg.SigningKey))
+	if cfg.DeliveryLog {
+		whConsumer.WithArchival(pool)
+	}
+	if err := whConsumer.Start(ctx); err != nil {
+		fatal("failed to start webhook consumer", err)
+	}
+
+	// Slack consumer: posts to per-user Slack Incoming Webhooks
+	var slackConsumer *slack.Consumer
+	if sentinel {
+		slackConsumer, err = slack.NewFromSentinel(cfg.SentinelMaster, cfg.SentinelAddrs, redisOpts, cfg.SlackWebhook, pool)
+	} else {
+		slackConsumer, err = slack.New(cfg.RedisURL, cfg.SlackWebhook, pool)
+	}
+	if err != nil {
+		fatal("failed to create Slack consumer", err)
+	}
+	slackConsumer.WithLogger(logger)
+	slackConsumer.WithConsumerName(cfg.ConsumerName)
+	slackConsumer.WithDLQMaxLen(cfg.DLQMaxLen)
+	slackConsumer.WithWorkerCount(cfg.WorkerCount)
+	slackConsumer.WithSignatureVerification([]byte(cfg.SigningKey))
+	if cfg.DeliveryLog {
+		slackConsumer.WithArchival(pool)
+	}
+	if err := slackConsumer.Start(ctx); err != nil {
+		fatal("failed to start Slack consumer", err)
 	}
 
-	// Minimal health endpoint
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"ok"}`))
-	})
+	consumers := []consumer{
+		{"telegram consumer", tgConsumer},
+		{"webhook consumer", whConsumer},
+		{"slack consumer", slackConsumer},
+	}
+
+	// Discord consumer: posts to per-user Discord webhooks, or as the bot to
+	// per-user channels. Webhooks need no token, so it always runs; without
+	// DISCORD_BOT_TOKEN only users with a webhook URL can be reached.
+	var discordConsumer *discord.Consumer
+	if sentinel {
+		discordConsumer, err = discord.NewFromSentinel(cfg.SentinelMaster, cfg.SentinelAddrs, redisOpts, cfg.DiscordToken, pool)
+	} else {
+		discordConsumer, err = discord.New(cfg.RedisURL, cfg.DiscordToken, pool)
+	}
+	if err != nil {
+		fatal("failed to create Discord consumer", err)
+	}
+	discordConsumer.WithLogger(logger)
+	discordConsumer.WithConsumerName(cfg.ConsumerName)
+	discordConsumer.WithDLQMaxLen(cfg.DLQMaxLen)
+	discordConsumer.WithWorkerCount(cfg.WorkerCount)
+	discordConsumer.WithSignatureVerification([]byte(cfg.SigningKey))
+	if cfg.DeliveryLog {
+		discordConsumer.WithArchival(pool)
+	}
+	if err := discordConsumer.Start(ctx); err != nil {
+		fatal("failed to start Discord consumer", err)
+	}
+	consumers = append(consumers, consumer{"discord consumer", discordConsumer})
+
+	// Web Push consumer: delivers the browser channel to per-user push
+	// subscriptions; it needs the VAPID keys the subscriptions were made with.
+	if cfg.VAPIDPrivate != "" {
+		vapid := webpush.VAPID{PublicKey: cfg.VAPIDPublic, PrivateKey: cfg.VAPIDPrivate, Subject: cfg.VAPIDSubject}
+		var pushConsumer *webpush.Consumer
+		if sentinel {
+			pushConsumer, err = webpush.NewFromSentinel(cfg.SentinelMaster, cfg.SentinelAddrs, redisOpts, pool, vapid)
+		} else {
+			pushConsumer, err = webpush.New(cfg.RedisURL, pool, vapid)
+		}
+		if err != nil {
+			fatal("failed to create Web Push consumer", err)
+		}
+		pushConsumer.WithLogger(logger)
+		pushConsumer.WithConsumerName(cfg.ConsumerName)
+		pushConsumer.WithDLQMaxLen(cfg.DLQMaxLen)
+		pushConsumer.WithWorkerCount(cfg.WorkerCount)
+		pushConsumer.WithSignatureVerification([]byte(cfg.SigningKey))
+		if cfg.DeliveryLog {
+			pushConsumer.WithArchival(pool)
+		}
+		if err := pushConsumer.Start(ctx); err != nil {
+			fatal("failed to start Web Push consumer", err)
+		}
+		consumers = append(consumers, consumer{"web push consumer", pushConsumer})
+	}
+
+	// Browser WebSocket consumer: sends the browser channel to the user's open
+	// tabs on any instance (over Redis pub/sub), queueing it for their next
+	// connection when they have none. Sockets authenticate with a token signed
+	// with BROWSER_WS_SECRET.
+	var browserConsumer *browser.Consumer
+	if cfg.BrowserSecret != "" {
+		if sentinel {
+			browserConsumer, err = browser.NewFromSentinel(cfg.SentinelMaster, cfg.SentinelAddrs, redisOpts, pool, cfg.BrowserSecret)
+		} else {
+			browserConsumer, err = browser.New(cfg.RedisURL, pool, cfg.BrowserSecret)
+		}
+		if err != nil {
+			fatal("failed to create browser WebSocket consumer", err)
+		}
+		browserConsumer.WithLogger(logger)
+		browserConsumer.WithConsumerName(cfg.ConsumerName)
+		browserConsumer.WithDLQMaxLen(cfg.DLQMaxLen)
+		browserConsumer.WithWorkerCount(cfg.WorkerCount)
+		browserConsumer.WithSignatureVerification([]byte(cfg.SigningKey))
+		if cfg.DeliveryLog {
+			browserConsumer.WithArchival(pool)
+		}
+		if err := browserConsumer.Start(ctx); err != nil {
+			fatal("failed to start browser WebSocket consumer", err)
+		}
+		consumers = append(consumers, consumer{"browser websocket consumer", browserConsumer})
+	}
+
+	// Each consumer's own Redis connection and read loop
+	for _, c := range consumers {
+		checks[c.name] = c.c.Ping
+	}
+
+	// Liveness and readiness probes (/health is /readyz), Prometheus metrics and token-protected admin API
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", livezHandler)
+	mux.HandleFunc("/readyz", healthHandler(checks))
+	mux.HandleFunc("/health", healthHandler(checks))
+	mux.HandleFunc("/health/db", dbHealthHandler(pool.Ping, func() map[string]int { return db.PoolStats(pool) }))
+	mux.Handle("/metrics", promhttp.Handler())
+	groups := make([]streamStatter, 0, len(consumers))
+	for _, c := range consumers {
+		groups = append(groups, c.c)
+	}
+	registerAdminRoutes(mux, cfg.AdminToken, sched, tgConsumer, groups)
+	srv := &http.Server{Addr: ":3002", Handler: mux}
 	go func() {
-		if err := http.ListenAndServe(":3002", nil); err != nil && err != http.ErrServerClosed {
-			log.Printf("[notifier] Health server error: %v", err)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("health server error", slog.Any("error", err))
 		}
 	}()
 
-	log.Printf("[notifier] Running.")
+	// Job management REST API, behind the same token as the admin endpoints,
+	// the Telegram webhook and the browser WebSocket endpoint
+	apiMux := http.NewServeMux()
+	apiMux.Handle("/api/", api.New(pool, cfg.AdminToken).WithExecutionHistory(sched).WithLogger(logger))
+	apiMux.Handle("/telegram/webhook", telegram.NewWebhookHandler(tgConsumer, cfg.WebhookSecret).WithLogger(logger))
+	if browserConsumer != nil {
+		apiMux.HandleFunc("GET /ws", browserConsumer.ServeWS)
+	}
+	apiSrv := &http.Server{Addr: ":3003", Handler: apiMux}
+	go func() {
+		if err := apiSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("api server error", slog.Any("error", err))
+		}
+	}()
+
+	// The same job management API over gRPC, with TriggerJob and health checks
+	var grpcSrv *notifiergrpc.Server
+	if cfg.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			fatal("failed to listen for gRPC", err)
+		}
+		grpcSrv = notifiergrpc.NewServer(notifiergrpc.NewJobServer(pool).WithTrigger(sched).WithLogger(logger), cfg.AdminToken)
+		go func() {
+			if err := grpcSrv.Serve(lis); err != nil {
+				slog.Error("grpc server error", slog.Any("error", err))
+			}
+		}()
+	}
+
+	slog.Info("running")
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	<-sig
 
-	log.Printf("[notifier] Shutting down...")
+	slog.Info("shutting down")
+	shutdown(cancel, sched, cfg.StopTimeout, consumers, pub, pool, srv, apiSrv, grpcSrv, flushTraces)
+}
+
+// fatal logs err and exits, like log.Fatal.
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	os.Exit(1)
+}
+
+// consumer is a stream consumer as seen by the shutdown sequence.
+type consumer struct {
+	name string
+	c    interface {
+		Ping(ctx context.Context) error
+		Stats(ctx context.Context) (core.Stats, error)
+		Stop(ctx context.Context) error
+		Close() error
+	}
+}
+
+// shutdown stops the pipeline front to back so work already in progress is
+// delivered rather than dropped:
+//
+//  1. stop firing new jobs and wait for running executions to publish
+//  2. let the consumer deliver what is already on the stream
+//  3. flush pending trace spans
+//  4. cancel background loops and close Redis/DB connections
+//
+// Each stage has its own timeout; a stage that overruns is logged and the
+// sequence moves on so the process still exits.
+func shutdown(
+	cancel context.CancelFunc,
+	sched *scheduler.Scheduler,
+	schedulerStopTimeout time.Duration,
+	consumers []consumer,
+	pub *publisher.Publisher,
+	pool *pgxpool.Pool,
+	srv, apiSrv *http.Server,
+	grpcSrv *notifiergrpc.Server,
+	flushTraces func(context.Context) error,
+) {
+	stage := func(name string, timeout time.Duration, stop func(context.Context) error) {
+		ctx, done := context.WithTimeout(context.Background(), timeout)
+		defer done()
+		start := time.Now()
+		if err := stop(ctx); err != nil {
+			slog.Warn("shutdown stage did not finish cleanly", slog.String("stage", name), slog.Any("error", err))
+			return
+		}
+		slog.Info("shutdown stage stopped", slog.String("stage", name), slog.Duration("took", time.Since(start).Round(time.Millisecond)))
+	}
+
+	stage("scheduler", schedulerStopTimeout, sched.Stop)
+	for _, c := range consumers {
+		stage(c.name, consumerStopTimeout, c.c.Stop)
+	}
+	stage("health server", httpStopTimeout, srv.Shutdown)
+	stage("api server", httpStopTimeout, apiSrv.Shutdown)
+	if grpcSrv != nil {
+		stage("grpc server", httpStopTimeout, grpcSrv.Shutdown)
+	}
+	stage("tracing", httpStopTimeout, flushTraces)
+
 	cancel()
+	for _, c := range consumers {
+		if err := c.c.Close(); err != nil {
+			slog.Warn("failed to close redis", slog.String("stage", c.name), slog.Any("error", err))
+		}
+	}
+	if err := pub.Close(); err != nil {
+		slog.Warn("failed to close redis", slog.String("stage", "publisher"), slog.Any("error", err))
+	}
+	pool.Close()
+	slog.Info("shutdown complete")
 }
@@ -0,0 +1,392 @@
+// Package api serves the job management REST API: CRUD over scheduled_jobs
+// for tools that manage jobs outside the web app, plus their execution
+// history. The scheduler picks up every change through the scheduled_jobs
+// NOTIFY trigger, so the API only asks it for history.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/allerac/notifier/internal/publisher"
+	"github.com/allerac/notifier/internal/scheduler"
+)
+
+const (
+	// maxBodyBytes bounds a create/update request body.
+	maxBodyBytes = 1 << 20
+
+	// Execution history paging: pageSize defaults to defaultPageSize and may
+	// not exceed maxPageSize.
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	// defaultUsageRange is the token-usage window when no from is given.
+	defaultUsageRange = 30 * 24 * time.Hour
+)
+
+// DBPool is the subset of pgxpool.Pool used by the Server.
+type DBPool interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// ExecutionHistory pages through a job's executions and sums their token
+// usage; implemented by *scheduler.Scheduler.
+type ExecutionHistory interface {
+	GetExecutionHistory(ctx context.Context, jobID string, page, pageSize int) ([]scheduler.ExecutionRecord, int, error)
+	GetTokenUsage(ctx context.Context, jobID string, from, to time.Time) (scheduler.TokenUsage, error)
+}
+
+// Job is the API representation of a scheduled_jobs row. It mirrors
+// scheduler.Job, with durations in whole seconds as they are stored.
+type Job struct {
+	ID                   string                     `json:"id"`
+	UserID               string                     `json:"user_id"`
+	Name                 string                     `json:"name"`
+	CronExpr             string                     `json:"cron_expr,omitempty"`
+	Prompt               string                     `json:"prompt"`
+	SystemPrompt         string                     `json:"system_prompt,omitempty"`
+	Channels             []string                   `json:"channels"`
+	MessageFormat        string                     `json:"message_format,omitempty"`
+	Timezone             string                     `json:"timezone,omitempty"`
+	TimeoutSeconds       int                        `json:"timeout_seconds,omitempty"`
+	RunAt                *time.Time                 `json:"run_at,omitempty"`
+	MaxAttempts          int                        `json:"max_attempts,omitempty"`
+	RetryDelaySeconds    int                        `json:"retry_delay_seconds,omitempty"`
+	DedupWindowSeconds   int                        `json:"dedup_window_seconds,omitempty"`
+	DeliveryDelaySeconds int                        `json:"delivery_delay_seconds,omitempty"`
+	CatchUpSeconds       int                        `json:"catch_up_window_seconds,omitempty"`
+	TemplateVars         map[string]string          `json:"template_vars,omitempty"`
+	Metadata             map[string]json.RawMessage `json:"metadata,omitempty"`
+	Enabled              bool                       `json:"enabled"`
+}
+
+// JobRequest is the body of POST /api/v1/jobs and PUT /api/v1/jobs/{id}.
+// PUT replaces every field except UserID, which cannot change; Enabled is
+// left as is when omitted (and defaults to true on create).
+type JobRequest struct {
+	UserID               string                     `json:"user_id"`
+	Name                 string                     `json:"name"`
+	CronExpr             string                     `json:"cron_expr"`
+	Prompt               string                     `json:"prompt"`
+	SystemPrompt         string                     `json:"system_prompt"`
+	Channels             []string                   `json:"channels"`
+	MessageFormat        string                     `json:"message_format"`
+	Timezone             string                     `json:"timezone"`
+	TimeoutSeconds       int                        `json:"timeout_seconds"`
+	RunAt                *time.Time                 `json:"run_at"`
+	MaxAttempts          int                        `json:"max_attempts"`
+	RetryDelaySeconds    int                        `json:"retry_delay_seconds"`
+	DedupWindowSeconds   int                        `json:"dedup_window_seconds"`
+	DeliveryDelaySeconds int                        `json:"delivery_delay_seconds"`
+	CatchUpSeconds       int                        `json:"catch_up_window_seconds"`
+	TemplateVars         map[string]string          `json:"template_vars"`
+	Metadata             map[string]json.RawMessage `json:"metadata"`
+	Enabled              *bool                      `json:"enabled"`
+}
+
+// validate reports the first problem that would make the row unschedulable
+// or violate a column constraint. The cron expression itself is checked by
+// the scheduler, which records a failure in registration_error.
+func (r JobRequest) validate() error {
+	switch {
+	case strings.TrimSpace(r.Name) == "":
+		return errors.New("name is required")
+	case strings.TrimSpace(r.Prompt) == "":
+		return errors.New("prompt is required")
+	case r.CronExpr == "" && r.RunAt == nil:
+		return errors.New("cron_expr or run_at is required")
+	case len(r.Channels) == 0:
+		return errors.New("at least one channel is required")
+	case r.TimeoutSeconds < 0, r.MaxAttempts < 0, r.RetryDelaySeconds < 0,
+		r.DedupWindowSeconds < 0, r.DeliveryDelaySeconds < 0, r.CatchUpSeconds < 0:
+		return errors.New("numeric settings must not be negative")
+	}
+	switch publisher.Format(r.MessageFormat) {
+	case publisher.FormatPlain, publisher.FormatMarkdownV2, publisher.FormatHTML:
+	default:
+		return fmt.Errorf("unknown message_format %q", r.MessageFormat)
+	}
+	if r.Timezone != "" {
+		if _, err := time.LoadLocation(r.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", r.Timezone)
+		}
+	}
+	return nil
+}
+
+// Server is an http.Handler serving the /api/v1 job endpoints, protected by
+// "Authorization: Bearer <token>".
+type Server struct {
+	store   *Store
+	token   string
+	history ExecutionHistory
+	mux     *http.ServeMux
+	logger  *slog.Logger
+}
+
+// New creates a Server backed by db. With an empty token every request is
+// answered 503, like the admin API.
+func New(db DBPool, token string) *Server {
+	s := &Server{store: NewStore(db), token: token, mux: http.NewServeMux(), logger: slog.Default()}
+	s.mux.HandleFunc("GET /api/v1/jobs", s.auth(s.listJobs))
+	s.mux.HandleFunc("POST /api/v1/jobs", s.auth(s.createJob))
+	s.mux.HandleFunc("GET /api/v1/jobs/{id}", s.auth(s.getJob))
+	s.mux.HandleFunc("PUT /api/v1/jobs/{id}", s.auth(s.updateJob))
+	s.mux.HandleFunc("DELETE /api/v1/jobs/{id}", s.auth(s.deleteJob))
+	s.mux.HandleFunc("GET /api/v1/jobs/{id}/executions", s.auth(s.listExecutions))
+	s.mux.HandleFunc("GET /api/v1/jobs/{id}/token-usage", s.auth(s.tokenUsage))
+	return s
+}
+
+// WithExecutionHistory serves GET /api/v1/jobs/{id}/executions and
+// /token-usage from h; without it those endpoints answer 503.
+func (s *Server) WithExecutionHistory(h ExecutionHistory) *Server {
+	s.history = h
+	return s
+}
+
+// WithLogger sets the logger used by the server (default slog.Default()).
+func (s *Server) WithLogger(l *slog.Logger) *Server {
+	s.logger = l
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" {
+			writeError(w, http.StatusServiceUnavailable, "job API disabled: NOTIFIER_ADMIN_TOKEN not set")
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// listJobs returns every job, disabled ones included, optionally filtered by
+// ?user_id=.
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.store.List(r.Context(), r.URL.Query().Get("user_id"))
+	if err != nil {
+		s.writeStoreError(w, "list jobs", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": jobs})
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.lookup(w, r)
+	if ok {
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+func (s *Server) createJob(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeRequest(w, r)
+	if !ok {
+		return
+	}
+	job, err := s.store.Create(r.Context(), req)
+	if err != nil {
+		s.writeStoreError(w, "create job", err)
+		return
+	}
+	s.logger.Info("api: job created", slog.String("job_id", job.ID), slog.String("job_name", job.Name))
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusCreated, job)
+}
+
+func (s *Server) updateJob(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeRequest(w, r)
+	if !ok {
+		return
+	}
+	job, err := s.store.Update(r.Context(), r.PathValue("id"), req)
+	if err != nil {
+		s.writeStoreError(w, "update job", err)
+		return
+	}
+	s.logger.Info("api: job updated", slog.String("job_id", job.ID), slog.String("job_name", job.Name))
+	writeJSON(w, http.StatusOK, job)
+}
+
+// deleteJob disables the job rather than deleting the row, so its execution
+// history is kept; PUT with "enabled": true brings it back.
+func (s *Server) deleteJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.store.Disable(r.Context(), id); err != nil {
+		s.writeStoreError(w, "delete job", err)
+		return
+	}
+	s.logger.Info("api: job disabled", slog.String("job_id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listExecutions returns one page of the job's executions, newest first:
+// ?page= (1-based, default 1) and ?pageSize= (default 20, at most 100).
+func (s *Server) listExecutions(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		writeError(w, http.StatusServiceUnavailable, "execution history unavailable")
+		return
+	}
+	page, ok := queryInt(w, r, "page", 1)
+	if !ok {
+		return
+	}
+	pageSize, ok := queryInt(w, r, "pageSize", defaultPageSize)
+	if !ok {
+		return
+	}
+	if pageSize > maxPageSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("pageSize must be at most %d", maxPageSize))
+		return
+	}
+	job, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	records, total, err := s.history.GetExecutionHistory(r.Context(), job.ID, page, pageSize)
+	if err != nil {
+		s.internalError(w, "list executions", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": records, "total": total, "page": page, "pageSize": pageSize})
+}
+
+// tokenUsage answers the job's LLM token usage over executions started in
+// [from, to). Both are RFC3339 times or YYYY-MM-DD dates (midnight UTC); to
+// defaults to now and from to defaultUsageRange before to.
+func (s *Server) tokenUsage(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		writeError(w, http.StatusServiceUnavailable, "execution history unavailable")
+		return
+	}
+	to, ok := queryTime(w, r, "to", time.Now().UTC())
+	if !ok {
+		return
+	}
+	from, ok := queryTime(w, r, "from", to.Add(-defaultUsageRange))
+	if !ok {
+		return
+	}
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+	job, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	usage, err := s.history.GetTokenUsage(r.Context(), job.ID, from, to)
+	if err != nil {
+		s.internalError(w, "token usage", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// queryTime parses the time query parameter name as RFC3339 or YYYY-MM-DD,
+// writing 400 if it is malformed; def is returned when it is absent.
+func queryTime(w http.ResponseWriter, r *http.Request, name string, def time.Time) (time.Time, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, true
+	}
+	writeError(w, http.StatusBadRequest, name+" must be an RFC3339 time or a YYYY-MM-DD date")
+	return time.Time{}, false
+}
+
+// queryInt parses the positive integer query parameter name, writing 400 if
+// it is malformed; def is returned when it is absent.
+func queryInt(w http.ResponseWriter, r *http.Request, name string, def int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		writeError(w, http.StatusBadRequest, name+" must be a positive integer")
+		return 0, false
+	}
+	return n, true
+}
+
+// lookup loads the job named by the {id} path value, writing 404 if there is
+// none.
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (Job, bool) {
+	job, err := s.store.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		s.writeStoreError(w, "get job", err)
+		return Job{}, false
+	}
+	return job, true
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request) (JobRequest, bool) {
+	var req JobRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+// writeStoreError maps the Store's client errors to 404, 400 and 409, and
+// anything else to 500.
+func (s *Server) writeStoreError(w http.ResponseWriter, op string, err error) {
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidJob):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrNameTaken):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		s.internalError(w, op, err)
+	}
+}
+
+func (s *Server) internalError(w http.ResponseWriter, op string, err error) {
+	s.logger.Error("api: "+op+" failed", slog.Any("error", err))
+	writeError(w, http.StatusInternalServerError, op+" failed")
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
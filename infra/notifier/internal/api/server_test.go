@@ -0,0 +1,479 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/api"
+	"github.com/allerac/notifier/internal/scheduler"
+)
+
+const (
+	token = "s3cret"
+	alice = "00000000-0000-0000-0000-00000000000a"
+	bob   = "00000000-0000-0000-0000-00000000000b"
+)
+
+// --- mock DB ---
+
+// mockDB is an in-memory scheduled_jobs table answering the Server's queries.
+type mockDB struct {
+	mu   sync.Mutex
+	jobs []api.Job
+	err  error
+}
+
+func (m *mockDB) Query(_ context.Context, sql string, args ...any) (pgx.Rows, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	var rows mockRows
+	for _, j := range m.jobs {
+		if len(args) == 0 || j.UserID == args[0] {
+			rows.jobs = append(rows.jobs, j)
+		}
+	}
+	return &rows, nil
+}
+
+func (m *mockDB) QueryRow(_ context.Context, sql string, args ...any) pgx.Row {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.err != nil:
+		return errRow{m.err}
+	case strings.Contains(sql, "SELECT EXISTS"):
+		for _, j := range m.jobs {
+			if j.UserID == args[0] && j.Name == args[1] && j.Enabled && j.ID != args[2] {
+				return valueRow{true}
+			}
+		}
+		return valueRow{false}
+	case strings.Contains(sql, "INSERT INTO scheduled_jobs"):
+		j := api.Job{ID: fmt.Sprintf("00000000-0000-0000-0001-%012d", len(m.jobs)+1), UserID: args[0].(string)}
+		setFields(&j, args[1:])
+		m.jobs = append(m.jobs, j)
+		return jobRow{j}
+	case strings.Contains(sql, "UPDATE scheduled_jobs"):
+		for i := range m.jobs {
+			if m.jobs[i].ID == args[0] {
+				setFields(&m.jobs[i], args[1:])
+				return jobRow{m.jobs[i]}
+			}
+		}
+		return errRow{pgx.ErrNoRows}
+	default: // SELECT ... WHERE id = $1
+		for _, j := range m.jobs {
+			if j.ID == args[0] {
+				return jobRow{j}
+			}
+		}
+		return errRow{pgx.ErrNoRows}
+	}
+}
+
+func (m *mockDB) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return pgconn.CommandTag{}, m.err
+	}
+	for i := range m.jobs {
+		if m.jobs[i].ID == args[0] && strings.Contains(sql, "SET enabled = false") {
+			m.jobs[i].Enabled = false
+			return pgconn.NewCommandTag("UPDATE 1"), nil
+		}
+	}
+	return pgconn.NewCommandTag("UPDATE 0"), nil
+}
+
+// setFields applies the name..enabled arguments shared by INSERT and UPDATE.
+func setFields(j *api.Job, a []any) {
+	j.Name, j.CronExpr, j.Prompt, j.SystemPrompt = a[0].(string), a[1].(string), a[2].(string), a[3].(string)
+	j.Channels, j.MessageFormat, j.Timezone = a[4].([]string), a[5].(string), a[6].(string)
+	j.TimeoutSeconds, j.RunAt, j.MaxAttempts = a[7].(int), a[8].(*time.Time), a[9].(int)
+	j.RetryDelaySeconds, j.DedupWindowSeconds, j.DeliveryDelaySeconds = a[10].(int), a[11].(int), a[12].(int)
+	j.CatchUpSeconds = a[13].(int)
+	j.TemplateVars, _ = a[14].(map[string]string)
+	j.Metadata, _ = a[15].(map[string]json.RawMessage)
+	j.Enabled = a[16].(bool)
+}
+
+// jobRow scans a job in the Server's column order.
+type jobRow struct{ j api.Job }
+
+func (r jobRow) Scan(dest ...any) error {
+	j := r.j
+	src := []any{j.ID, j.UserID, j.Name, j.CronExpr, j.Prompt, j.SystemPrompt, j.Channels, j.MessageFormat, j.Timezone,
+		j.TimeoutSeconds, j.RunAt, j.MaxAttempts, j.RetryDelaySeconds, j.DedupWindowSeconds, j.DeliveryDelaySeconds, j.CatchUpSeconds,
+		j.TemplateVars, j.Metadata, j.Enabled}
+	for i, v := range src {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+type valueRow struct{ v bool }
+
+func (r valueRow) Scan(dest ...any) error {
+	*dest[0].(*bool) = r.v
+	return nil
+}
+
+type errRow struct{ err error }
+
+func (r errRow) Scan(...any) error { return r.err }
+
+type mockRows struct {
+	jobs []api.Job
+	pos  int
+}
+
+func (r *mockRows) Close()                                       {}
+func (r *mockRows) Err() error                                   { return nil }
+func (r *mockRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *mockRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *mockRows) Values() ([]any, error)                       { return nil, nil }
+func (r *mockRows) RawValues() [][]byte                          { return nil }
+func (r *mockRows) Conn() *pgx.Conn                              { return nil }
+
+func (r *mockRows) Next() bool {
+	r.pos++
+	return r.pos <= len(r.jobs)
+}
+
+func (r *mockRows) Scan(dest ...any) error { return jobRow{r.jobs[r.pos-1]}.Scan(dest...) }
+
+// mockHistory returns canned executions and records the paging it was asked for.
+type mockHistory struct {
+	records []scheduler.ExecutionRecord
+	total   int
+	usage   scheduler.TokenUsage
+
+	jobID          string
+	page, pageSize int
+	from, to       time.Time
+}
+
+func (h *mockHistory) GetExecutionHistory(_ context.Context, jobID string, page, pageSize int) ([]scheduler.ExecutionRecord, int, error) {
+	h.jobID, h.page, h.pageSize = jobID, page, pageSize
+	return h.records, h.total, nil
+}
+
+func (h *mockHistory) GetTokenUsage(_ context.Context, jobID string, from, to time.Time) (scheduler.TokenUsage, error) {
+	h.jobID, h.from, h.to = jobID, from, to
+	u := h.usage
+	u.JobID, u.From, u.To = jobID, from, to
+	return u, nil
+}
+
+// --- helpers ---
+
+func newServer(t *testing.T, db *mockDB) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(api.New(db, token))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// do sends body (if non-nil) as JSON with the bearer token and decodes the
+// response into out (if non-nil).
+func do(t *testing.T, srv *httptest.Server, method, path string, body, out any) *http.Response {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, err := http.NewRequest(method, srv.URL+path, &buf)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if out != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp
+}
+
+func briefing(userID string) map[string]any {
+	return map[string]any{
+		"user_id":   userID,
+		"name":      "Morning Briefing",
+		"cron_expr": "0 8 * * *",
+		"prompt":    "Summarise my day",
+		"channels":  []string{"telegram"},
+	}
+}
+
+func create(t *testing.T, srv *httptest.Server, body map[string]any) api.Job {
+	t.Helper()
+	var job api.Job
+	resp := do(t, srv, http.MethodPost, "/api/v1/jobs", body, &job)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	return job
+}
+
+// --- tests ---
+
+func TestServer_RequiresToken(t *testing.T) {
+	srv := newServer(t, &mockDB{})
+	resp, err := srv.Client().Get(srv.URL + "/api/v1/jobs")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	disabled := httptest.NewServer(api.New(&mockDB{}, ""))
+	defer disabled.Close()
+	resp, err = disabled.Client().Get(disabled.URL + "/api/v1/jobs")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestServer_CreateAndGet(t *testing.T) {
+	srv := newServer(t, &mockDB{})
+	body := briefing(alice)
+	body["template_vars"] = map[string]string{"city": "Lisbon"}
+	body["delivery_delay_seconds"] = 3600
+	body["catch_up_window_seconds"] = 900
+
+	var created api.Job
+	resp := do(t, srv, http.MethodPost, "/api/v1/jobs", body, &created)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "/api/v1/jobs/"+created.ID, resp.Header.Get("Location"))
+	assert.True(t, created.Enabled, "jobs are enabled by default")
+	assert.Equal(t, map[string]string{"city": "Lisbon"}, created.TemplateVars)
+	assert.Equal(t, 3600, created.DeliveryDelaySeconds)
+	assert.Equal(t, 900, created.CatchUpSeconds)
+
+	var got api.Job
+	resp = do(t, srv, http.MethodGet, "/api/v1/jobs/"+created.ID, nil, &got)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, created, got)
+}
+
+func TestServer_CreateValidates(t *testing.T) {
+	srv := newServer(t, &mockDB{})
+	cases := map[string]func(map[string]any){
+		"missing name":     func(b map[string]any) { delete(b, "name") },
+		"no schedule":      func(b map[string]any) { delete(b, "cron_expr") },
+		"no channels":      func(b map[string]any) { b["channels"] = []string{} },
+		"bad user":         func(b map[string]any) { b["user_id"] = "alice" },
+		"bad timezone":     func(b map[string]any) { b["timezone"] = "Mars/Olympus" },
+		"bad format":       func(b map[string]any) { b["message_format"] = "BBCode" },
+		"negative timeout": func(b map[string]any) { b["timeout_seconds"] = -1 },
+		"unknown field":    func(b map[string]any) { b["cron"] = "0 8 * * *" },
+	}
+	for name, mutate := range cases {
+		t.Run(name, func(t *testing.T) {
+			body := briefing(alice)
+			mutate(body)
+			var out map[string]string
+			resp := do(t, srv, http.MethodPost, "/api/v1/jobs", body, &out)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			assert.NotEmpty(t, out["error"])
+		})
+	}
+}
+
+func TestServer_CreateDuplicateNameConflicts(t *testing.T) {
+	srv := newServer(t, &mockDB{})
+	create(t, srv, briefing(alice))
+
+	resp := do(t, srv, http.MethodPost, "/api/v1/jobs", briefing(alice), nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	create(t, srv, briefing(bob)) // names are per user
+}
+
+func TestServer_DeletedJobReleasesName(t *testing.T) {
+	srv := newServer(t, &mockDB{})
+	job := create(t, srv, briefing(alice))
+
+	resp := do(t, srv, http.MethodDelete, "/api/v1/jobs/"+job.ID, nil, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	create(t, srv, briefing(alice))
+}
+
+func TestServer_List(t *testing.T) {
+	srv := newServer(t, &mockDB{})
+	create(t, srv, briefing(alice))
+	create(t, srv, briefing(bob))
+
+	var all struct{ Jobs []api.Job }
+	resp := do(t, srv, http.MethodGet, "/api/v1/jobs", nil, &all)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, all.Jobs, 2)
+
+	var mine struct{ Jobs []api.Job }
+	do(t, srv, http.MethodGet, "/api/v1/jobs?user_id="+bob, nil, &mine)
+	require.Len(t, mine.Jobs, 1)
+	assert.Equal(t, bob, mine.Jobs[0].UserID)
+}
+
+func TestServer_Update(t *testing.T) {
+	srv := newServer(t, &mockDB{})
+	job := create(t, srv, briefing(alice))
+
+	body := briefing("")
+	delete(body, "user_id")
+	body["name"] = "Evening Briefing"
+	body["cron_expr"] = "0 20 * * *"
+	var updated api.Job
+	resp := do(t, srv, http.MethodPut, "/api/v1/jobs/"+job.ID, body, &updated)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, job.ID, updated.ID)
+	assert.Equal(t, alice, updated.UserID)
+	assert.Equal(t, "Evening Briefing", updated.Name)
+	assert.Equal(t, "0 20 * * *", updated.CronExpr)
+	assert.True(t, updated.Enabled, "omitted enabled keeps the current state")
+}
+
+func TestServer_UpdateConflicts(t *testing.T) {
+	srv := newServer(t, &mockDB{})
+	job := create(t, srv, briefing(alice))
+	other := briefing(alice)
+	other["name"] = "Weekly Report"
+	create(t, srv, other)
+
+	resp := do(t, srv, http.MethodPut, "/api/v1/jobs/"+job.ID, other, nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = do(t, srv, http.MethodPut, "/api/v1/jobs/"+job.ID, briefing(alice), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "keeping its own name is not a conflict")
+
+	resp = do(t, srv, http.MethodPut, "/api/v1/jobs/"+job.ID, briefing(bob), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "user_id cannot change")
+}
+
+func TestServer_DeleteIsSoft(t *testing.T) {
+	srv := newServer(t, &mockDB{})
+	job := create(t, srv, briefing(alice))
+
+	resp := do(t, srv, http.MethodDelete, "/api/v1/jobs/"+job.ID, nil, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	var got api.Job
+	resp = do(t, srv, http.MethodGet, "/api/v1/jobs/"+job.ID, nil, &got)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, got.Enabled)
+}
+
+func TestServer_UnknownJob(t *testing.T) {
+	srv := newServer(t, &mockDB{})
+	for _, id := range []string{"00000000-0000-0000-0000-000000000099", "not-a-uuid"} {
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+			resp := do(t, srv, method, "/api/v1/jobs/"+id, briefing(alice), nil)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode, "%s %s", method, id)
+		}
+	}
+}
+
+func TestServer_DatabaseError(t *testing.T) {
+	srv := newServer(t, &mockDB{err: fmt.Errorf("connection refused")})
+	var out map[string]string
+	resp := do(t, srv, http.MethodGet, "/api/v1/jobs", nil, &out)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, "list jobs failed", out["error"])
+}
+
+func TestServer_ListExecutions(t *testing.T) {
+	db := &mockDB{}
+	started := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	history := &mockHistory{
+		records: []scheduler.ExecutionRecord{{ID: "exec-1", Status: "completed", Result: "Good morning!", StartedAt: started}},
+		total:   41,
+	}
+	srv := httptest.NewServer(api.New(db, token).WithExecutionHistory(history))
+	t.Cleanup(srv.Close)
+	job := create(t, srv, briefing(alice))
+
+	var out struct {
+		Data     []scheduler.ExecutionRecord `json:"data"`
+		Total    int                         `json:"total"`
+		Page     int                         `json:"page"`
+		PageSize int                         `json:"pageSize"`
+	}
+	resp := do(t, srv, http.MethodGet, "/api/v1/jobs/"+job.ID+"/executions?page=3&pageSize=10", nil, &out)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, history.records, out.Data)
+	assert.Equal(t, 41, out.Total)
+	assert.Equal(t, 3, out.Page)
+	assert.Equal(t, 10, out.PageSize)
+	assert.Equal(t, job.ID, history.jobID)
+	assert.Equal(t, [2]int{3, 10}, [2]int{history.page, history.pageSize})
+
+	resp = do(t, srv, http.MethodGet, "/api/v1/jobs/"+job.ID+"/executions", nil, &out)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, [2]int{1, 20}, [2]int{history.page, history.pageSize}, "defaults")
+}
+
+func TestServer_TokenUsage(t *testing.T) {
+	history := &mockHistory{usage: scheduler.TokenUsage{Executions: 3, PromptTokens: 120, CompletionTokens: 480, TotalTokens: 600}}
+	srv := httptest.NewServer(api.New(&mockDB{}, token).WithExecutionHistory(history))
+	t.Cleanup(srv.Close)
+	job := create(t, srv, briefing(alice))
+
+	var out scheduler.TokenUsage
+	resp := do(t, srv, http.MethodGet, "/api/v1/jobs/"+job.ID+"/token-usage?from=2026-03-01&to=2026-04-01T00:00:00Z", nil, &out)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, job.ID, out.JobID)
+	assert.Equal(t, 600, out.TotalTokens)
+	assert.Equal(t, 3, out.Executions)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), history.from)
+	assert.Equal(t, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), history.to)
+
+	resp = do(t, srv, http.MethodGet, "/api/v1/jobs/"+job.ID+"/token-usage", nil, &out)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 30*24*time.Hour, history.to.Sub(history.from), "defaults to the last 30 days")
+}
+
+func TestServer_TokenUsageRejectsBadRange(t *testing.T) {
+	srv := httptest.NewServer(api.New(&mockDB{}, token).WithExecutionHistory(&mockHistory{}))
+	t.Cleanup(srv.Close)
+	job := create(t, srv, briefing(alice))
+
+	for _, q := range []string{"from=yesterday", "to=2026-13-01", "from=2026-04-01&to=2026-03-01", "from=2026-03-01&to=2026-03-01"} {
+		resp := do(t, srv, http.MethodGet, "/api/v1/jobs/"+job.ID+"/token-usage?"+q, nil, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, q)
+	}
+	resp := do(t, srv, http.MethodGet, "/api/v1/jobs/00000000-0000-0000-0000-000000000099/token-usage", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(t, newServer(t, &mockDB{}), http.MethodGet, "/api/v1/jobs/"+job.ID+"/token-usage", nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "no history configured")
+}
+
+func TestServer_ListExecutionsRejectsBadPaging(t *testing.T) {
+	srv := httptest.NewServer(api.New(&mockDB{}, token).WithExecutionHistory(&mockHistory{}))
+	t.Cleanup(srv.Close)
+	job := create(t, srv, briefing(alice))
+
+	for _, q := range []string{"page=0", "page=x", "pageSize=-1", "pageSize=101"} {
+		resp := do(t, srv, http.MethodGet, "/api/v1/jobs/"+job.ID+"/executions?"+q, nil, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, q)
+	}
+	resp := do(t, srv, http.MethodGet, "/api/v1/jobs/00000000-0000-0000-0000-000000000099/executions", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(t, newServer(t, &mockDB{}), http.MethodGet, "/api/v1/jobs/"+job.ID+"/executions", nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "no history configured")
+}
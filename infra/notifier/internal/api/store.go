@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Errors returned by Store, wrapped with a message meant for the client; test
+// with errors.Is.
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrInvalidJob  = errors.New("invalid job")
+	ErrNameTaken   = errors.New("job name taken")
+)
+
+// storeError is a Store error whose message can be shown to the client as is.
+type storeError struct {
+	kind error
+	msg  string
+}
+
+func (e *storeError) Error() string { return e.msg }
+func (e *storeError) Unwrap() error { return e.kind }
+
+func invalid(msg string) error { return &storeError{ErrInvalidJob, msg} }
+
+var errNotFound = &storeError{ErrJobNotFound, "job not found"}
+
+// Store reads and writes scheduled_jobs for the job management APIs (REST
+// here, gRPC in internal/grpc). Failures the client caused wrap
+// ErrJobNotFound, ErrInvalidJob or ErrNameTaken; anything else is a database
+// error.
+type Store struct {
+	db DBPool
+}
+
+// NewStore creates a Store backed by db.
+func NewStore(db DBPool) *Store {
+	return &Store{db: db}
+}
+
+const jobColumns = `id, user_id, name, COALESCE(cron_expr, ''), prompt, COALESCE(system_prompt, ''), channels, COALESCE(message_format, ''), COALESCE(timezone, ''), COALESCE(timeout_seconds, 0), run_at, COALESCE(max_attempts, 0), COALESCE(retry_delay_seconds, 0), COALESCE(dedup_window_seconds, 0), COALESCE(delivery_delay_seconds, 0), COALESCE(catch_up_window_seconds, 0), COALESCE(template_vars, '{}'), COALESCE(metadata, '{}'), enabled`
+
+func scanJob(row pgx.Row) (Job, error) {
+	var j Job
+	err := row.Scan(&j.ID, &j.UserID, &j.Name, &j.CronExpr, &j.Prompt, &j.SystemPrompt, &j.Channels, &j.MessageFormat, &j.Timezone,
+		&j.TimeoutSeconds, &j.RunAt, &j.MaxAttempts, &j.RetryDelaySeconds, &j.DedupWindowSeconds, &j.DeliveryDelaySeconds, &j.CatchUpSeconds,
+		&j.TemplateVars, &j.Metadata, &j.Enabled)
+	return j, err
+}
+
+// List returns every job, disabled ones included, in creation order; with a
+// userID only that user's.
+func (s *Store) List(ctx context.Context, userID string) ([]Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM scheduled_jobs`
+	var args []any
+	if userID != "" {
+		if uuid.Validate(userID) != nil {
+			return nil, invalid("invalid user_id")
+		}
+		query += ` WHERE user_id = $1`
+		args = append(args, userID)
+	}
+	rows, err := s.db.Query(ctx, query+` ORDER BY created_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// Get returns the job with the given id.
+func (s *Store) Get(ctx context.Context, id string) (Job, error) {
+	if uuid.Validate(id) != nil {
+		return Job{}, errNotFound
+	}
+	job, err := scanJob(s.db.QueryRow(ctx, `SELECT `+jobColumns+` FROM scheduled_jobs WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, errNotFound
+	}
+	return job, err
+}
+
+// Create inserts the job described by req; Enabled defaults to true.
+func (s *Store) Create(ctx context.Context, req JobRequest) (Job, error) {
+	if err := req.validate(); err != nil {
+		return Job{}, invalid(err.Error())
+	}
+	if uuid.Validate(req.UserID) != nil {
+		return Job{}, invalid("user_id must be a UUID")
+	}
+	if err := s.checkName(ctx, req.UserID, req.Name, ""); err != nil {
+		return Job{}, err
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+	job, err := scanJob(s.db.QueryRow(ctx, `
+		INSERT INTO scheduled_jobs (user_id, name, cron_expr, prompt, system_prompt, channels, message_format, timezone,
+			timeout_seconds, run_at, max_attempts, retry_delay_seconds, dedup_window_seconds, delivery_delay_seconds,
+			catch_up_window_seconds, template_vars, metadata, enabled)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), $6, NULLIF($7, ''), NULLIF($8, ''),
+			NULLIF($9, 0), $10, NULLIF($11, 0), NULLIF($12, 0), NULLIF($13, 0), NULLIF($14, 0),
+			NULLIF($15, 0), $16, $17, $18)
+		RETURNING `+jobColumns,
+		req.UserID, req.Name, req.CronExpr, req.Prompt, req.SystemPrompt, req.Channels, req.MessageFormat, req.Timezone,
+		req.TimeoutSeconds, req.RunAt, req.MaxAttempts, req.RetryDelaySeconds, req.DedupWindowSeconds, req.DeliveryDelaySeconds,
+		req.CatchUpSeconds, nilIfEmpty(req.TemplateVars), nilIfEmpty(req.Metadata), enabled))
+	return job, constraintError(err)
+}
+
+// Update replaces the settings of job id with req. UserID cannot change, and
+// Enabled is kept when req leaves it nil.
+func (s *Store) Update(ctx context.Context, id string, req JobRequest) (Job, error) {
+	current, err := s.Get(ctx, id)
+	if err != nil {
+		return Job{}, err
+	}
+	if err := req.validate(); err != nil {
+		return Job{}, invalid(err.Error())
+	}
+	if req.UserID != "" && req.UserID != current.UserID {
+		return Job{}, invalid("user_id cannot be changed")
+	}
+	if err := s.checkName(ctx, current.UserID, req.Name, current.ID); err != nil {
+		return Job{}, err
+	}
+	enabled := current.Enabled
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	job, err := scanJob(s.db.QueryRow(ctx, `
+		UPDATE scheduled_jobs
+		SET name = $2, cron_expr = NULLIF($3, ''), prompt = $4, system_prompt = NULLIF($5, ''), channels = $6,
+			message_format = NULLIF($7, ''), timezone = NULLIF($8, ''), timeout_seconds = NULLIF($9, 0), run_at = $10,
+			max_attempts = NULLIF($11, 0), retry_delay_seconds = NULLIF($12, 0), dedup_window_seconds = NULLIF($13, 0),
+			delivery_delay_seconds = NULLIF($14, 0), catch_up_window_seconds = NULLIF($15, 0), template_vars = $16,
+			metadata = $17, enabled = $18
+		WHERE id = $1
+		RETURNING `+jobColumns,
+		current.ID, req.Name, req.CronExpr, req.Prompt, req.SystemPrompt, req.Channels, req.MessageFormat, req.Timezone,
+		req.TimeoutSeconds, req.RunAt, req.MaxAttempts, req.RetryDelaySeconds, req.DedupWindowSeconds, req.DeliveryDelaySeconds,
+		req.CatchUpSeconds, nilIfEmpty(req.TemplateVars), nilIfEmpty(req.Metadata), enabled))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, errNotFound // deleted meanwhile
+	}
+	return job, constraintError(err)
+}
+
+// Disable soft-deletes job id: the row and its execution history are kept,
+// and updating it with Enabled true brings it back.
+func (s *Store) Disable(ctx context.Context, id string) error {
+	if uuid.Validate(id) != nil {
+		return errNotFound
+	}
+	tag, err := s.db.Exec(ctx, `UPDATE scheduled_jobs SET enabled = false WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// checkName returns an ErrNameTaken error if the user already has another
+// enabled job called name; disabled (deleted) jobs do not hold on to their
+// names. excludeID is the job being updated, or "" on create.
+func (s *Store) checkName(ctx context.Context, userID, name, excludeID string) error {
+	var taken bool
+	err := s.db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM scheduled_jobs
+			WHERE user_id = $1 AND name = $2 AND enabled = true AND id::text <> $3
+		)
+	`, userID, name, excludeID).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("check job name: %w", err)
+	}
+	if taken {
+		return &storeError{ErrNameTaken, fmt.Sprintf("user already has a job named %q", name)}
+	}
+	return nil
+}
+
+// constraintError reports constraint violations (e.g. an unknown user_id) as
+// ErrInvalidJob and returns any other error unchanged.
+func constraintError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "23") {
+		return invalid(pgErr.Message)
+	}
+	return err
+}
+
+// nilIfEmpty stores an empty JSON map as NULL, which the scheduler reads as {}.
+func nilIfEmpty[V any](m map[string]V) any {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,148 @@
+package channels
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Mode controls what happens to content that exceeds a channel's limit.
+type Mode string
+
+const (
+	// Split delivers over-long content as several consecutive messages.
+	Split Mode = "split"
+	// Truncate cuts over-long content and appends an ellipsis.
+	Truncate Mode = "truncate"
+)
+
+const ellipsis = "…"
+
+// Limit is the maximum message length (in characters) accepted by a channel.
+type Limit struct {
+	MaxLen int
+	Mode   Mode
+}
+
+// Limits maps a channel name (as used in scheduled_jobs.channels) to its limit.
+// Channels without an entry are delivered unchanged.
+type Limits map[string]Limit
+
+// DefaultLimits returns the known limits of the delivery channels. Telegram
+// and Discord have none: their consumers split over-long content themselves,
+// the Telegram one without cutting through markup, and send the parts in
+// order.
+func DefaultLimits() Limits {
+	return Limits{
+		"slack": {MaxLen: 40000, Mode: Truncate},
+		"sms":   {MaxLen: 160, Mode: Split},
+	}
+}
+
+// ParseLimits parses a comma-separated list of channel limits on top of
+// DefaultLimits. Each entry is "channel=max" or "channel=max:mode", e.g.
+//
+//	slack=3000,sms=160:truncate,webhook=0
+//
+// A max of 0 removes the limit for that channel.
+func ParseLimits(spec string) (Limits, error) {
+	limits := DefaultLimits()
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		channel, value, ok := strings.Cut(entry, "=")
+		if !ok || channel == "" {
+			return nil, fmt.Errorf("invalid channel limit %q: expected channel=max[:mode]", entry)
+		}
+		rawMax, rawMode, hasMode := strings.Cut(value, ":")
+		maxLen, err := strconv.Atoi(rawMax)
+		if err != nil || maxLen < 0 {
+			return nil, fmt.Errorf("invalid max length in %q", entry)
+		}
+		if maxLen == 0 {
+			delete(limits, channel)
+			continue
+		}
+		mode := Split
+		if existing, ok := limits[channel]; ok {
+			mode = existing.Mode
+		}
+		if hasMode {
+			mode = Mode(rawMode)
+			if mode != Split && mode != Truncate {
+				return nil, fmt.Errorf("invalid mode %q in %q: expected split or truncate", rawMode, entry)
+			}
+		}
+		limits[channel] = Limit{MaxLen: maxLen, Mode: mode}
+	}
+	return limits, nil
+}
+
+// Apply fits content to the channel's limit and returns the message(s) to
+// deliver, in order. Content within the limit (or for a channel without a
+// limit) is returned as a single message.
+func (l Limits) Apply(channel, content string) []string {
+	limit, ok := l[channel]
+	if !ok || limit.MaxLen <= 0 || utf8.RuneCountInString(content) <= limit.MaxLen {
+		return []string{content}
+	}
+	if limit.Mode == Truncate {
+		return []string{TruncateText(content, limit.MaxLen)}
+	}
+	return SplitText(content, limit.MaxLen)
+}
+
+// TruncateText shortens s to at most max runes, ending with an ellipsis when cut.
+func TruncateText(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	if max <= 1 {
+		return string(runes[:max])
+	}
+	return strings.TrimRight(string(runes[:max-1]), " \n") + ellipsis
+}
+
+// SplitText splits s into chunks of at most max runes. It prefers to break at
+// a paragraph boundary, then a line break, then the end of a sentence, then a
+// space, and only hard-cuts when none of those appears in the second half of
+// the window. Cuts always fall on rune boundaries.
+func SplitText(s string, max int) []string {
+	if s == "" {
+		return []string{""}
+	}
+	if max <= 0 {
+		return []string{s}
+	}
+
+	var chunks []string
+	runes := []rune(s)
+	for len(runes) > max {
+		cut := breakPoint(runes[:max])
+		chunk := strings.TrimRight(string(runes[:cut]), " \n")
+		if chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		runes = []rune(strings.TrimLeft(string(runes[cut:]), " \n"))
+	}
+	if len(runes) > 0 {
+		chunks = append(chunks, string(runes))
+	}
+	return chunks
+}
+
+// breakPoint returns the index in window after which to cut.
+func breakPoint(window []rune) int {
+	text := string(window)
+	minCut := len(text) / 2 // byte offset; avoid producing tiny chunks
+	for _, sep := range []string{"\n\n", "\n", ". ", "! ", "? ", " "} {
+		if i := strings.LastIndex(text, sep); i > 0 && i >= minCut {
+			return utf8.RuneCountInString(text[:i+len(sep)])
+		}
+	}
+	return len(window)
+}
@@ -0,0 +1,100 @@
+package channels_test
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/channels"
+)
+
+func TestLimits_Apply_SameContentDiffersPerChannel(t *testing.T) {
+	limits, err := channels.ParseLimits("long=50:split,short=20:truncate")
+	require.NoError(t, err)
+
+	content := "First sentence here. Second sentence follows. Third one ends it."
+
+	long := limits.Apply("long", content)
+	require.Len(t, long, 2, "split into two messages")
+	assert.Equal(t, "First sentence here. Second sentence follows.", long[0])
+	assert.Equal(t, "Third one ends it.", long[1])
+
+	short := limits.Apply("short", content)
+	require.Len(t, short, 1, "truncated to a single message")
+	assert.Equal(t, 20, utf8.RuneCountInString(short[0]))
+	assert.True(t, strings.HasSuffix(short[0], "…"))
+}
+
+func TestLimits_Apply_UnknownChannelUnchanged(t *testing.T) {
+	content := strings.Repeat("x", 10000)
+	assert.Equal(t, []string{content}, channels.DefaultLimits().Apply("browser", content))
+}
+
+func TestLimits_Apply_WithinLimitUnchanged(t *testing.T) {
+	assert.Equal(t, []string{"hello"}, channels.DefaultLimits().Apply("sms", "hello"))
+}
+
+func TestParseLimits_OverridesDefaults(t *testing.T) {
+	limits, err := channels.ParseLimits("slack=3000, sms=0, telegram=1000")
+	require.NoError(t, err)
+
+	assert.Equal(t, channels.Limit{MaxLen: 3000, Mode: channels.Truncate}, limits["slack"], "mode kept from default")
+	assert.NotContains(t, limits, "sms", "0 removes the limit")
+	assert.Equal(t, channels.Limit{MaxLen: 1000, Mode: channels.Split}, limits["telegram"], "new limits split")
+}
+
+func TestParseLimits_Invalid(t *testing.T) {
+	for _, spec := range []string{"telegram", "telegram=abc", "telegram=-1", "telegram=10:chop", "=10"} {
+		_, err := channels.ParseLimits(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestSplitText_PrefersParagraphBoundary(t *testing.T) {
+	text := strings.Repeat("a", 30) + "\n\n" + strings.Repeat("b", 30)
+	chunks := channels.SplitText(text, 40)
+	assert.Equal(t, []string{strings.Repeat("a", 30), strings.Repeat("b", 30)}, chunks)
+}
+
+func TestSplitText_HardCutKeepsRunesIntact(t *testing.T) {
+	text := strings.Repeat("é", 25)
+	chunks := channels.SplitText(text, 10)
+	require.Len(t, chunks, 3)
+	for _, c := range chunks {
+		assert.True(t, utf8.ValidString(c))
+		assert.LessOrEqual(t, utf8.RuneCountInString(c), 10)
+	}
+	assert.Equal(t, text, strings.Join(chunks, ""))
+}
+
+func TestSplitText_FallsBackToSentenceBoundary(t *testing.T) {
+	text := strings.Repeat("a", 25) + ". " + strings.Repeat("b", 25)
+	chunks := channels.SplitText(text, 40)
+	assert.Equal(t, []string{strings.Repeat("a", 25) + ".", strings.Repeat("b", 25)}, chunks)
+}
+
+func TestSplitText_ExactlyAtLimitIsOneChunk(t *testing.T) {
+	text := strings.Repeat("a", 4096)
+	assert.Equal(t, []string{text}, channels.SplitText(text, 4096))
+}
+
+func TestSplitText_SingleWordOverLimitIsHardCut(t *testing.T) {
+	text := strings.Repeat("a", 4097)
+	assert.Equal(t, []string{strings.Repeat("a", 4096), "a"}, channels.SplitText(text, 4096))
+}
+
+func TestSplitText_MultiByteRunesAtBoundary(t *testing.T) {
+	// The limit falls between the two emoji, each 4 bytes long.
+	text := strings.Repeat("a", 4095) + "😀😀"
+	chunks := channels.SplitText(text, 4096)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, strings.Repeat("a", 4095)+"😀", chunks[0])
+	assert.Equal(t, "😀", chunks[1])
+}
+
+func TestSplitText_Empty(t *testing.T) {
+	assert.Equal(t, []string{""}, channels.SplitText("", 4096))
+}
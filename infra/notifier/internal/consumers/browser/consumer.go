@@ -0,0 +1,378 @@
+package browser
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/allerac/notifier/internal/consumers/core"
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+const (
+	channelName   = "browser"
+	consumerGroup = "browser-ws-group"
+
+	// writeTimeout bounds a single WebSocket write; a socket that cannot take
+	// a message in time is closed and dropped from the registry.
+	writeTimeout = 5 * time.Second
+
+	// maxPending is how many queued notifications are sent at a time.
+	maxPending = 100
+
+	// userChannelPrefix + user_id is the Redis pub/sub channel that carries
+	// the user's notifications to every instance holding one of their
+	// sockets. An instance subscribes while it holds at least one.
+	userChannelPrefix = "notifications:browser:"
+
+	// pendingSignal, published on a user's channel, asks the instances with
+	// the user's sockets to send what is queued in
+	// pending_browser_notifications.
+	pendingSignal = "pending"
+)
+
+// DBPool is the subset of pgxpool.Pool used by the Consumer.
+type DBPool interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Notification is the JSON text frame a browser receives per notification.
+type Notification struct {
+	JobID     string    `json:"job_id,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Consumer reads "browser" notifications from the Redis Stream and sends them
+// to every WebSocket the user has open, on any instance: the instance that
+// reads a message publishes it on the user's pub/sub channel, and each
+// instance holding a socket of the user writes it to its sockets. Users with
+// no socket anywhere get the notification stored in
+// pending_browser_notifications and sent when they next connect. Stream
+// handling comes from the embedded Dispatcher.
+type Consumer struct {
+	*core.Dispatcher
+
+	redis  *redis.Client
+	pubsub *redis.PubSub
+	db     DBPool
+	secret []byte
+
+	mu     sync.Mutex
+	conns  map[string][]*websocket.Conn // user_id → live sockets
+	listen sync.Once
+
+	pendingMu sync.Mutex // one sendPending at a time, so no row is sent twice
+}
+
+// New creates a Consumer that accepts WebSocket connections carrying a token
+// signed with secret (see Token).
+func New(redisURL string, db DBPool, secret string) (*Consumer, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	return newConsumer(redis.NewClient(opts), db, secret), nil
+}
+
+// NewFromSentinel is New for a Sentinel-managed Redis master (see
+// publisher.SentinelClient).
+func NewFromSentinel(masterName string, sentinelAddrs []string, opts *redis.Options, db DBPool, secret string) (*Consumer, error) {
+	client, err := publisher.SentinelClient(masterName, sentinelAddrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newConsumer(client, db, secret), nil
+}
+
+func newConsumer(client *redis.Client, db DBPool, secret string) *Consumer {
+	c := &Consumer{
+		redis:  client,
+		pubsub: client.Subscribe(context.Background()),
+		db:     db,
+		secret: []byte(secret),
+		conns:  make(map[string][]*websocket.Conn),
+	}
+	c.Dispatcher = core.New(client, channelName, consumerGroup, c).WithDeadLetterDB(db).WithDeliveryReceipts(db)
+	return c
+}
+
+// Deliver implements core.Deliverer.
+func (c *Consumer) Deliver(ctx context.Context, msg redis.XMessage) error {
+	return c.ProcessMessage(ctx, msg)
+}
+
+// ProcessMessage publishes a single stream message to the instances holding
+// the user's sockets, or queues it for their next connection. Exported for
+// testing.
+func (c *Consumer) ProcessMessage(ctx context.Context, msg redis.XMessage) error {
+	userID, _ := msg.Values["user_id"].(string)
+	n := Notification{CreatedAt: time.Now().UTC()}
+	n.JobID, _ = msg.Values["job_id"].(string)
+	n.Content, _ = msg.Values["content"].(string)
+	n.Title = publisher.MetadataOf(msg.Values)["subject"]
+	if n.Title == "" {
+		n.Title, _ = msg.Values["job_name"].(string)
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	instances, err := c.redis.Publish(ctx, userChannelPrefix+userID, data).Result()
+	if err != nil {
+		return fmt.Errorf("publish browser notification for user %s: %w", userID, err)
+	}
+	if instances > 0 {
+		c.MessageLogger(msg).Info("delivered message", slog.String("message_id", msg.ID), slog.String("user_id", userID),
+			slog.Int64("instances", instances))
+		return nil
+	}
+	_, err = c.db.Exec(ctx, `
+		INSERT INTO pending_browser_notifications (user_id, job_id, title, content, created_at)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5)
+	`, userID, n.JobID, n.Title, n.Content, n.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("queue browser notification for user %s: %w", userID, err)
+	}
+	// A socket that connected after the publish may have read the queue
+	// before the insert; have its instance read it again.
+	if err := c.redis.Publish(ctx, userChannelPrefix+userID, pendingSignal).Err(); err != nil {
+		c.MessageLogger(msg).Warn("failed to signal queued message", slog.String("message_id", msg.ID), slog.Any("error", err))
+	}
+	c.MessageLogger(msg).Info("user offline, queued message", slog.String("message_id", msg.ID), slog.String("user_id", userID))
+	return nil
+}
+
+// Close stops listening for other instances' notifications and releases the
+// Redis connection. Call it after Stop.
+func (c *Consumer) Close() error {
+	c.pubsub.Close()
+	return c.Dispatcher.Close()
+}
+
+// Connections returns how many sockets userID has open on this instance.
+func (c *Consumer) Connections(userID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.conns[userID])
+}
+
+// listenUsers writes the notifications published on the subscribed user
+// channels to this instance's sockets until the subscription is closed.
+func (c *Consumer) listenUsers() {
+	for msg := range c.pubsub.Channel() {
+		userID := strings.TrimPrefix(msg.Channel, userChannelPrefix)
+		if msg.Payload == pendingSignal {
+			if err := c.sendPending(context.Background(), userID); err != nil {
+				c.Logger().Error("failed to send queued browser notifications", slog.String("user_id", userID), slog.Any("error", err))
+			}
+			continue
+		}
+		c.broadcast(context.Background(), userID, []byte(msg.Payload))
+	}
+}
+
+// broadcast writes data to every socket of userID on this instance and
+// returns how many took it. Sockets that fail are closed and unregistered.
+func (c *Consumer) broadcast(ctx context.Context, userID string, data []byte) int {
+	c.mu.Lock()
+	conns := append([]*websocket.Conn(nil), c.conns[userID]...)
+	c.mu.Unlock()
+
+	sent := 0
+	for _, conn := range conns {
+		if err := write(ctx, conn, data); err != nil {
+			c.Logger().Warn("dropping browser connection", slog.String("user_id", userID), slog.Any("error", err))
+			c.unregister(userID, conn)
+			conn.Close(websocket.StatusInternalError, "write failed")
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+func write(ctx context.Context, conn *websocket.Conn, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+	return conn.Write(ctx, websocket.MessageText, data)
+}
+
+// register adds conn to userID's sockets, subscribing to the user's channel
+// for the first one.
+func (c *Consumer) register(ctx context.Context, userID string, conn *websocket.Conn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.conns[userID]) == 0 {
+		if err := c.pubsub.Subscribe(ctx, userChannelPrefix+userID); err != nil {
+			return err
+		}
+		c.listen.Do(func() { go c.listenUsers() })
+	}
+	c.conns[userID] = append(c.conns[userID], conn)
+	return nil
+}
+
+// unregister removes conn from userID's sockets, unsubscribing from the
+// user's channel with the last one.
+func (c *Consumer) unregister(userID string, conn *websocket.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := c.conns[userID]
+	found := false
+	for i, cc := range conns {
+		if cc == conn {
+			conns = append(conns[:i], conns[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+	if len(conns) > 0 {
+		c.conns[userID] = conns
+		return
+	}
+	delete(c.conns, userID)
+	if err := c.pubsub.Unsubscribe(context.Background(), userChannelPrefix+userID); err != nil {
+		c.Logger().Warn("failed to unsubscribe browser user", slog.String("user_id", userID), slog.Any("error", err))
+	}
+}
+
+// ServeWS upgrades a browser's request to a WebSocket and keeps it registered
+// for the user until it closes. The request carries ?user_id=U&expires=E&token=T
+// as minted by Token; without a secret every request is answered 503.
+// Notifications queued while the user was offline are sent once the socket is
+// registered, so none queued in between is missed.
+func (c *Consumer) ServeWS(w http.ResponseWriter, r *http.Request) {
+	if len(c.secret) == 0 {
+		http.Error(w, "browser WebSocket disabled: BROWSER_WS_SECRET not set", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	userID := q.Get("user_id")
+	if err := c.verify(userID, q.Get("expires"), q.Get("token"), time.Now()); err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return // Accept has written the error response
+	}
+	defer conn.CloseNow()
+
+	// Browsers send nothing; CloseRead answers pings and reports the close.
+	ctx := conn.CloseRead(r.Context())
+	if err := c.register(ctx, userID, conn); err != nil {
+		c.Logger().Error("failed to subscribe browser user", slog.String("user_id", userID), slog.Any("error", err))
+		conn.Close(websocket.StatusInternalError, "subscribe failed")
+		return
+	}
+	defer c.unregister(userID, conn)
+	c.Logger().Debug("browser connected", slog.String("user_id", userID))
+
+	if err := c.sendPending(ctx, userID); err != nil {
+		c.Logger().Error("failed to send queued browser notifications", slog.String("user_id", userID), slog.Any("error", err))
+	}
+	<-ctx.Done()
+	c.Logger().Debug("browser disconnected", slog.String("user_id", userID))
+}
+
+// sendPending writes the user's queued notifications, oldest first, to their
+// sockets on this instance, deleting each one once a socket has taken it.
+func (c *Consumer) sendPending(ctx context.Context, userID string) error {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	rows, err := c.db.Query(ctx, `
+		SELECT id, COALESCE(job_id::text, ''), title, content, created_at
+		FROM pending_browser_notifications
+		WHERE user_id = $1
+		ORDER BY created_at
+		LIMIT $2
+	`, userID, maxPending)
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		id string
+		n  Notification
+	}
+	var queued []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.n.JobID, &p.n.Title, &p.n.Content, &p.n.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		queued = append(queued, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range queued {
+		data, err := json.Marshal(p.n)
+		if err != nil {
+			return err
+		}
+		if c.broadcast(ctx, userID, data) == 0 {
+			return nil // no socket left; the rest stays queued
+		}
+		if _, err := c.db.Exec(ctx, `DELETE FROM pending_browser_notifications WHERE id = $1`, p.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Token returns the token the web app hands a browser so it can connect to
+// ServeWS as userID until expires: hex HMAC-SHA256 over "userID:expires"
+// (Unix seconds) keyed with the shared secret.
+func Token(secret, userID string, expires time.Time) string {
+	return sign([]byte(secret), userID, strconv.FormatInt(expires.Unix(), 10))
+}
+
+func sign(secret []byte, userID, expires string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(userID + ":" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *Consumer) verify(userID, expires, token string, now time.Time) error {
+	if userID == "" {
+		return errors.New("missing user_id")
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return errors.New("invalid expires")
+	}
+	if now.Unix() > exp {
+		return errors.New("token expired")
+	}
+	if !hmac.Equal([]byte(token), []byte(sign(c.secret, userID, expires))) {
+		return errors.New("bad token")
+	}
+	return nil
+}
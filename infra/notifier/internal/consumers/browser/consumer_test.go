@@ -0,0 +1,287 @@
+package browser_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/coder/websocket"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/consumers/browser"
+)
+
+const secret = "ws-secret"
+
+// --- mock DB ---
+
+// mockDB serves canned pending notifications and records every Exec.
+type mockDB struct {
+	pending [][]any // id, job_id, title, content, created_at
+
+	mu    sync.Mutex
+	execs []execCall
+}
+
+type execCall struct {
+	sql  string
+	args []any
+}
+
+func (m *mockDB) Query(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &mockRows{rows: m.pending}, nil
+}
+
+func (m *mockDB) setPending(rows [][]any) {
+	m.mu.Lock()
+	m.pending = rows
+	m.mu.Unlock()
+}
+
+func (m *mockDB) QueryRow(_ context.Context, _ string, _ ...any) pgx.Row {
+	return nil
+}
+
+func (m *mockDB) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.mu.Lock()
+	m.execs = append(m.execs, execCall{sql: sql, args: args})
+	m.mu.Unlock()
+	return pgconn.CommandTag{}, nil
+}
+
+// execsMatching returns the recorded Exec calls whose SQL contains substr.
+func (m *mockDB) execsMatching(substr string) []execCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []execCall
+	for _, e := range m.execs {
+		if strings.Contains(e.sql, substr) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// mockRows iterates over canned rows, assigning values to Scan destinations by position.
+type mockRows struct {
+	rows [][]any
+	i    int
+}
+
+func (r *mockRows) Close()                                       {}
+func (r *mockRows) Err() error                                   { return nil }
+func (r *mockRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *mockRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *mockRows) Values() ([]any, error)                       { return r.rows[r.i-1], nil }
+func (r *mockRows) RawValues() [][]byte                          { return nil }
+func (r *mockRows) Conn() *pgx.Conn                              { return nil }
+
+func (r *mockRows) Next() bool {
+	r.i++
+	return r.i <= len(r.rows)
+}
+
+func (r *mockRows) Scan(dest ...any) error {
+	for i, d := range dest {
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r.rows[r.i-1][i]))
+	}
+	return nil
+}
+
+// --- helpers ---
+
+func newTestConsumer(t *testing.T, db *mockDB, secret string) (*browser.Consumer, *httptest.Server) {
+	t.Helper()
+	return newInstance(t, miniredis.RunT(t), db, secret)
+}
+
+// newInstance creates a Consumer on mr, standing for one notifier instance.
+func newInstance(t *testing.T, mr *miniredis.Miniredis, db *mockDB, secret string) (*browser.Consumer, *httptest.Server) {
+	t.Helper()
+	c, err := browser.New("redis://"+mr.Addr(), db, secret)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	srv := httptest.NewServer(http.HandlerFunc(c.ServeWS))
+	t.Cleanup(srv.Close)
+	return c, srv
+}
+
+func wsURL(srv *httptest.Server, userID, token string, expires time.Time) string {
+	q := url.Values{"user_id": {userID}, "expires": {strconv.FormatInt(expires.Unix(), 10)}, "token": {token}}
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?" + q.Encode()
+}
+
+// connect opens a socket for userID and waits until the consumer has
+// registered it.
+func connect(t *testing.T, c *browser.Consumer, srv *httptest.Server, userID string) *websocket.Conn {
+	t.Helper()
+	expires := time.Now().Add(time.Minute)
+	before := c.Connections(userID)
+	conn, _, err := websocket.Dial(context.Background(), wsURL(srv, userID, browser.Token(secret, userID, expires), expires), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.CloseNow() })
+	require.Eventually(t, func() bool { return c.Connections(userID) > before }, time.Second, 5*time.Millisecond)
+	return conn
+}
+
+func read(t *testing.T, conn *websocket.Conn) browser.Notification {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, data, err := conn.Read(ctx)
+	require.NoError(t, err)
+	var n browser.Notification
+	require.NoError(t, json.Unmarshal(data, &n))
+	return n
+}
+
+func xMessage(userID, content string) redis.XMessage {
+	return redis.XMessage{
+		ID: "1-0",
+		Values: map[string]interface{}{
+			"job_id":   "job-1",
+			"job_name": "Morning Briefing",
+			"user_id":  userID,
+			"channel":  "browser",
+			"content":  content,
+		},
+	}
+}
+
+// --- tests ---
+
+func TestConsumer_ProcessMessage_SendsToEveryLiveSocket(t *testing.T) {
+	db := &mockDB{}
+	c, srv := newTestConsumer(t, db, secret)
+	tab1 := connect(t, c, srv, "user-1")
+	tab2 := connect(t, c, srv, "user-1")
+	other := connect(t, c, srv, "user-2")
+
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "Good morning!")))
+
+	for _, conn := range []*websocket.Conn{tab1, tab2} {
+		n := read(t, conn)
+		assert.Equal(t, "Good morning!", n.Content)
+		assert.Equal(t, "Morning Briefing", n.Title)
+		assert.Equal(t, "job-1", n.JobID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := other.Read(ctx)
+	assert.Error(t, err, "other users get nothing")
+	assert.Empty(t, db.execsMatching("INSERT INTO pending_browser_notifications"))
+}
+
+func TestConsumer_ProcessMessage_ReachesSocketsOnOtherInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	db := &mockDB{}
+	a, srvA := newInstance(t, mr, db, secret)
+	b, _ := newInstance(t, mr, db, secret)
+	conn := connect(t, a, srvA, "user-1")
+
+	require.NoError(t, b.ProcessMessage(context.Background(), xMessage("user-1", "Good morning!")))
+
+	assert.Equal(t, "Good morning!", read(t, conn).Content)
+	assert.Equal(t, 0, b.Connections("user-1"))
+	assert.Empty(t, db.execsMatching("INSERT INTO pending_browser_notifications"))
+}
+
+func TestConsumer_PendingSignalSendsQueuedNotifications(t *testing.T) {
+	mr := miniredis.RunT(t)
+	db := &mockDB{}
+	c, srv := newInstance(t, mr, db, secret)
+	conn := connect(t, c, srv, "user-1")
+
+	// Queued by another instance after this socket read the queue.
+	db.setPending([][]any{{"p-1", "job-1", "Morning Briefing", "late", time.Now().UTC()}})
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rc.Close()
+	require.NoError(t, rc.Publish(context.Background(), "notifications:browser:user-1", "pending").Err())
+
+	assert.Equal(t, "late", read(t, conn).Content)
+	require.Eventually(t, func() bool {
+		return len(db.execsMatching("DELETE FROM pending_browser_notifications")) == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestConsumer_ProcessMessage_QueuesWhenOffline(t *testing.T) {
+	db := &mockDB{}
+	c, _ := newTestConsumer(t, db, secret)
+
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "Good morning!")))
+
+	inserts := db.execsMatching("INSERT INTO pending_browser_notifications")
+	require.Len(t, inserts, 1)
+	assert.Equal(t, []any{"user-1", "job-1", "Morning Briefing", "Good morning!"}, inserts[0].args[:4])
+}
+
+func TestConsumer_ServeWS_SendsPendingOnConnect(t *testing.T) {
+	created := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	db := &mockDB{pending: [][]any{
+		{"p-1", "job-1", "Morning Briefing", "first", created},
+		{"p-2", "", "", "second", created.Add(time.Minute)},
+	}}
+	c, srv := newTestConsumer(t, db, secret)
+
+	conn := connect(t, c, srv, "user-1")
+
+	assert.Equal(t, browser.Notification{JobID: "job-1", Title: "Morning Briefing", Content: "first", CreatedAt: created}, read(t, conn))
+	assert.Equal(t, "second", read(t, conn).Content)
+	require.Eventually(t, func() bool {
+		return len(db.execsMatching("DELETE FROM pending_browser_notifications")) == 2
+	}, time.Second, 5*time.Millisecond)
+	deletes := db.execsMatching("DELETE FROM pending_browser_notifications")
+	assert.Equal(t, []any{"p-1"}, deletes[0].args)
+	assert.Equal(t, []any{"p-2"}, deletes[1].args)
+}
+
+func TestConsumer_ServeWS_UnregistersClosedSocket(t *testing.T) {
+	db := &mockDB{}
+	c, srv := newTestConsumer(t, db, secret)
+	conn := connect(t, c, srv, "user-1")
+
+	require.NoError(t, conn.Close(websocket.StatusNormalClosure, ""))
+
+	require.Eventually(t, func() bool { return c.Connections("user-1") == 0 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "hi")))
+	assert.Len(t, db.execsMatching("INSERT INTO pending_browser_notifications"), 1)
+}
+
+func TestConsumer_ServeWS_RejectsBadTokens(t *testing.T) {
+	c, srv := newTestConsumer(t, &mockDB{}, secret)
+	valid := time.Now().Add(time.Minute)
+	expired := time.Now().Add(-time.Minute)
+
+	for name, u := range map[string]string{
+		"wrong secret": wsURL(srv, "user-1", browser.Token("other", "user-1", valid), valid),
+		"other user":   wsURL(srv, "user-2", browser.Token(secret, "user-1", valid), valid),
+		"expired":      wsURL(srv, "user-1", browser.Token(secret, "user-1", expired), expired),
+	} {
+		_, resp, err := websocket.Dial(context.Background(), u, nil)
+		require.Error(t, err, name)
+		require.NotNil(t, resp, name)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, name)
+	}
+	assert.Equal(t, 0, c.Connections("user-1"))
+
+	_, disabled := newTestConsumer(t, &mockDB{}, "")
+	_, resp, err := websocket.Dial(context.Background(), wsURL(disabled, "user-1", browser.Token("", "user-1", valid), valid), nil)
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
@@ -0,0 +1,960 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/allerac/notifier/internal/db"
+	"github.com/allerac/notifier/internal/publisher"
+	"github.com/allerac/notifier/internal/retry"
+	"github.com/allerac/notifier/internal/tracing"
+)
+
+var tracer = otel.Tracer("github.com/allerac/notifier/internal/consumers/core")
+
+const (
+	maxDeliveryAttempts = 3
+	readBlock           = 5 * time.Second
+
+	// Failed messages stay in the PEL and are retried by the reclaim loop once
+	// their backoff (see retryBackoff) has elapsed. The reclaim cadence and
+	// idle threshold are therefore shorter than the smallest backoff.
+	reclaimInterval      = 15 * time.Second
+	minIdleBeforeReclaim = 20 * time.Second
+
+	// deliveryLease marks a message as in progress while it is being delivered
+	// so the reclaim loop (of this or another instance) does not pick it up
+	// concurrently. It also bounds how long a message held by a crashed
+	// instance waits before being retried.
+	deliveryLease = 5 * time.Minute
+
+	// defaultStopTimeout bounds how long Stop waits for in-flight deliveries.
+	defaultStopTimeout = 30 * time.Second
+
+	// delayedPollInterval is how often due notifications are moved from the
+	// delayed set back to the stream, i.e. the delivery precision of DeliverAfter.
+	delayedPollInterval = 5 * time.Second
+
+	// defaultDLQMaxLen is the approximate cap on the DLQ stream; older dead
+	// letters remain in the dead_letters table (see WithDeadLetterDB).
+	defaultDLQMaxLen = 10000
+
+	// idempotencyTTL is how long a delivered idempotency key keeps later
+	// messages with the same key from being delivered.
+	idempotencyTTL = 24 * time.Hour
+
+	attemptsKeyPrefix    = "notifications:attempts:"
+	retryAtKeyPrefix     = "notifications:retry_at:"    // unix ms before which the message is not retried
+	idempotencyKeyPrefix = "notifications:idempotency:" // + group:key → idempotencyDelivering | idempotencyDelivered
+
+	idempotencyDelivering = "delivering"
+	idempotencyDelivered  = "delivered"
+)
+
+// readStreams are the XREADGROUP stream arguments: every notification stream,
+// high priority first, each read from ">" (new messages).
+var readStreams = []string{publisher.HighPriorityStreamName, publisher.StreamName, ">", ">"}
+
+// retryBackoffs is the base wait after the n-th failed attempt (index n-1).
+var retryBackoffs = []time.Duration{30 * time.Second, 2 * time.Minute, 5 * time.Minute}
+
+// retryBackoff returns how long to wait before retrying a message whose
+// attempt-th delivery failed: the base backoff with ±20% jitter, so messages
+// that failed together do not all retry at the same moment.
+func retryBackoff(attempt int64) time.Duration {
+	i := min(max(attempt, 1), int64(len(retryBackoffs))) - 1
+	base := retryBackoffs[i]
+	return time.Duration(float64(base) * (0.8 + 0.4*rand.Float64()))
+}
+
+// Deliverer sends one stream message to its destination. A returned error
+// counts as a failed attempt; the message is retried and eventually moved to
+// the DLQ.
+type Deliverer interface {
+	Deliver(ctx context.Context, msg redis.XMessage) error
+}
+
+// DeadLetterDB is the subset of pgxpool.Pool used to record dead letters.
+type DeadLetterDB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// deliveryKey carries a *deliveryInfo through the Deliver context.
+type deliveryKey struct{}
+
+// deliveryInfo is what a Deliverer reports about a successful delivery for
+// the delivery log and receipts.
+type deliveryInfo struct {
+	recipient         string
+	providerMessageID string
+}
+
+// SetRecipient records where a Deliverer sent the message on its channel,
+// e.g. the Telegram chat ID, for the delivery log (see WithArchival).
+func SetRecipient(ctx context.Context, recipient string) {
+	if info, ok := ctx.Value(deliveryKey{}).(*deliveryInfo); ok {
+		info.recipient = recipient
+	}
+}
+
+// SetProviderMessageID records the ID the channel's provider gave the
+// delivered message, e.g. the Telegram message_id, for the delivery receipt
+// (see WithDeliveryReceipts).
+func SetProviderMessageID(ctx context.Context, id string) {
+	if info, ok := ctx.Value(deliveryKey{}).(*deliveryInfo); ok {
+		info.providerMessageID = id
+	}
+}
+
+// Dispatcher is the channel-agnostic half of a stream consumer: it reads the
+// notifications stream with its own consumer group, hands the messages for one
+// channel to a Deliverer, and takes care of reclaiming, attempt tracking, DLQ
+// routing and graceful shutdown.
+type Dispatcher struct {
+	redis     *redis.Client
+	channel   string
+	group     string
+	consumer  string // this instance's name within group
+	deliverer Deliverer
+	logger    *slog.Logger
+	onDLQ     func(msg redis.XMessage, reason string)
+	deadDB    DeadLetterDB // optional; see WithDeadLetterDB
+	archiveDB db.Execer    // optional; see WithArchival
+	receiptDB db.Execer    // optional; see WithDeliveryReceipts
+	dlqMaxLen int64        // approximate DLQ stream cap (XADD MAXLEN ~); 0 = unbounded
+	verifyKey []byte       // optional; see WithSignatureVerification
+
+	dlqAlertThreshold int64 // see WithDLQAlertThreshold
+	dlqAlert          func(size int64)
+
+	stopReading context.CancelFunc
+	reading     atomic.Bool // the consume loop is running; see Ping
+	stopTimeout time.Duration
+	wg          sync.WaitGroup // consume + reclaim loops
+	inflight    sync.WaitGroup // ProcessWithDLQ calls started by the loops
+	workers     chan struct{}  // bounds concurrent deliveries; nil = one at a time
+}
+
+// New creates a Dispatcher that delivers messages whose "channel" field equals
+// channel through d, reading as consumer group group. It takes ownership of
+// client; Close closes it.
+func New(client *redis.Client, channel, group string, d Deliverer) *Dispatcher {
+	return &Dispatcher{
+		redis:       client,
+		channel:     channel,
+		group:       group,
+		consumer:    DefaultConsumerName(),
+		deliverer:   d,
+		logger:      consumerLogger(slog.Default(), channel),
+		stopTimeout: defaultStopTimeout,
+		dlqMaxLen:   defaultDLQMaxLen,
+	}
+}
+
+// DefaultConsumerName returns a consumer name unique to this process: the
+// hostname plus a random suffix, so replicas never share PEL ownership.
+func DefaultConsumerName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "notifier"
+	}
+	return fmt.Sprintf("%s-%08x", host, rand.Uint32())
+}
+
+// WithConsumerName overrides the name this instance uses within the consumer
+// group. Use a stable name (e.g. the pod name) so a restarted instance resumes
+// its own pending messages; an empty name keeps the default.
+func (d *Dispatcher) WithConsumerName(name string) *Dispatcher {
+	if name != "" {
+		d.consumer = name
+	}
+	return d
+}
+
+// ConsumerName returns the name this instance uses within the consumer group.
+func (d *Dispatcher) ConsumerName() string {
+	return d.consumer
+}
+
+// WithStopTimeout sets how long Stop waits for in-flight deliveries before
+// giving up (default 30s).
+func (d *Dispatcher) WithStopTimeout(timeout time.Duration) *Dispatcher {
+	d.stopTimeout = timeout
+	return d
+}
+
+// WithDeadLetterHook registers fn to be called whenever a message is moved to
+// the DLQ, e.g. to count it in a metric.
+func (d *Dispatcher) WithDeadLetterHook(fn func(msg redis.XMessage, reason string)) *Dispatcher {
+	d.onDLQ = fn
+	return d
+}
+
+// WithDeadLetterDB also records every message moved to the DLQ as a row in the
+// dead_letters table, which outlives the capped DLQ stream.
+func (d *Dispatcher) WithDeadLetterDB(db DeadLetterDB) *Dispatcher {
+	d.deadDB = db
+	return d
+}
+
+// WithArchival records every delivered message as a row in
+// notification_delivery_log. An archival failure is logged and the message is
+// ACKed regardless: it has already been delivered.
+func (d *Dispatcher) WithArchival(db db.Execer) *Dispatcher {
+	d.archiveDB = db
+	return d
+}
+
+// WithDeliveryReceipts records a receipt for every delivered message in
+// notification_deliveries. Like archival, a failed insert is logged only.
+func (d *Dispatcher) WithDeliveryReceipts(db db.Execer) *Dispatcher {
+	d.receiptDB = db
+	return d
+}
+
+// WithDLQMaxLen sets the approximate cap on the DLQ stream (default 10000);
+// 0 leaves it unbounded.
+func (d *Dispatcher) WithDLQMaxLen(n int64) *Dispatcher {
+	d.dlqMaxLen = n
+	return d
+}
+
+// WithDLQAlertThreshold makes every reclaim tick check the DLQ length and call
+// callback with it while it exceeds threshold, so a growing DLQ gets noticed.
+// The DLQ is shared by all channels, so registering it on one Dispatcher per
+// process is enough.
+func (d *Dispatcher) WithDLQAlertThreshold(threshold int64, callback func(size int64)) *Dispatcher {
+	d.dlqAlertThreshold = threshold
+	d.dlqAlert = callback
+	return d
+}
+
+// WithWorkerCount lets up to n messages be delivered at once instead of one
+// after the other, for channels whose provider is slow to answer. Messages may
+// then be delivered out of stream order, also for the same user. n <= 1 keeps
+// serial delivery. It must be called before Start.
+func (d *Dispatcher) WithWorkerCount(n int) *Dispatcher {
+	if n > 1 {
+		d.workers = make(chan struct{}, n)
+	} else {
+		d.workers = nil
+	}
+	return d
+}
+
+// WithSignatureVerification rejects messages whose signature field does not
+// match key (see publisher.WithSigningKey): they are moved to the DLQ without
+// being delivered, since no retry can make them valid. A nil or empty key
+// disables the check.
+func (d *Dispatcher) WithSignatureVerification(key []byte) *Dispatcher {
+	d.verifyKey = key
+	return d
+}
+
+// WithLogger sets the logger used by the dispatcher and its Deliverer (see
+// Logger); records carry the component and channel (default slog.Default()).
+func (d *Dispatcher) WithLogger(l *slog.Logger) *Dispatcher {
+	d.logger = consumerLogger(l, d.channel)
+	return d
+}
+
+// Logger returns the dispatcher's logger, for use by the Deliverer.
+func (d *Dispatcher) Logger() *slog.Logger {
+	return d.logger
+}
+
+// MessageLogger is Logger tagged with msg's trace_id, when it has one, so
+// every line about a notification can be joined with the scheduler's log of
+// the execution that produced it.
+func (d *Dispatcher) MessageLogger(msg redis.XMessage) *slog.Logger {
+	if traceID, _ := msg.Values["trace_id"].(string); traceID != "" {
+		return d.logger.With(slog.String("trace_id", traceID))
+	}
+	return d.logger
+}
+
+func consumerLogger(l *slog.Logger, channel string) *slog.Logger {
+	return l.With(slog.String("component", "consumer"), slog.String("channel", channel))
+}
+
+// Start creates the consumer group (if needed) and begins consuming in background goroutines.
+// The goroutines run until Stop is called or ctx is cancelled.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	for _, stream := range publisher.Streams {
+		err := d.redis.XGroupCreateMkStream(ctx, stream, d.group, "$").Err()
+		if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+			return fmt.Errorf("create consumer group on %s: %w", stream, err)
+		}
+	}
+	readCtx, stopReading := context.WithCancel(ctx)
+	d.stopReading = stopReading
+
+	d.logger.Info("consumer started", slog.String("consumer", d.consumer), slog.Any("streams", publisher.Streams))
+	d.wg.Add(3)
+	d.reading.Store(true)
+	go func() {
+		defer d.wg.Done()
+		defer d.reading.Store(false)
+		d.consume(ctx, readCtx)
+	}()
+	go func() {
+		defer d.wg.Done()
+		d.reclaimLoop(readCtx)
+	}()
+	go func() {
+		defer d.wg.Done()
+		d.delayedLoop(readCtx)
+	}()
+	return nil
+}
+
+// WaitReady pings Redis until it answers, up to attempts times with a
+// doubling delay (see retry.Ping). Call it before Start, which fails if Redis
+// is unreachable.
+func (d *Dispatcher) WaitReady(ctx context.Context, attempts int, delay time.Duration) error {
+	return retry.Ping(ctx, "redis", attempts, delay, func(ctx context.Context) error {
+		return d.redis.Ping(ctx).Err()
+	})
+}
+
+// Stop stops waiting for new messages, delivers whatever is already queued on
+// the stream for this group, and returns once the background goroutines have
+// exited and every in-flight delivery has finished. If ctx expires or the
+// stop timeout elapses first, Stop returns an error and the remaining messages
+// stay pending for the next consumer to pick up.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	if d.stopReading != nil {
+		d.stopReading()
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.stopTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		d.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		d.logger.Info("consumer stopped")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for in-flight deliveries: %w", ctx.Err())
+	}
+}
+
+// Ping reports whether the consumer is reading its streams (between Start
+// and Stop) and Redis answers; it has the signature of a health check.
+func (d *Dispatcher) Ping(ctx context.Context) error {
+	if !d.reading.Load() {
+		return fmt.Errorf("%s consumer not running", d.channel)
+	}
+	return d.redis.Ping(ctx).Err()
+}
+
+// Close releases the Redis connection. Call it after Stop.
+func (d *Dispatcher) Close() error {
+	return d.redis.Close()
+}
+
+// consume reads new messages from the streams in a loop. Reads block on
+// readCtx; once it is cancelled the loop switches to non-blocking reads and
+// returns as soon as the streams have nothing left for this group. Deliveries
+// use ctx so a stop request does not abort a message half-way through. Each
+// batch delivers the high-priority stream's messages first.
+func (d *Dispatcher) consume(ctx, readCtx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		draining := readCtx.Err() != nil
+		args := &redis.XReadGroupArgs{
+			Group:    d.group,
+			Consumer: d.consumer,
+			Streams:  readStreams,
+			Count:    10,
+			Block:    readBlock,
+		}
+		readFrom := readCtx
+		if draining {
+			args.Block = -1 // return immediately instead of waiting for new messages
+			readFrom = ctx
+		}
+
+		msgs, err := d.redis.XReadGroup(readFrom, args).Result()
+
+		if err != nil {
+			if draining {
+				return // redis.Nil: nothing left to deliver
+			}
+			if err != redis.Nil && readCtx.Err() == nil {
+				d.logger.Error("stream read failed", slog.Any("error", err))
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		for _, stream := range msgs {
+			for _, msg := range stream.Messages {
+				channel, _ := msg.Values["channel"].(string)
+				if channel != d.channel {
+					d.redis.XAck(ctx, stream.Stream, d.group, msg.ID)
+					continue
+				}
+				d.process(ctx, msg)
+			}
+		}
+	}
+}
+
+// reclaimLoop periodically reclaims messages that have been stuck in the PEL
+// (read but never acknowledged) longer than minIdleBeforeReclaim, including
+// messages owned by other consumers in the group that have since died.
+// ProcessWithDLQ skips the ones whose retry backoff has not elapsed yet.
+func (d *Dispatcher) reclaimLoop(ctx context.Context) {
+	ticker := time.NewTicker(reclaimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.reclaimStuck(ctx)
+			d.CheckDLQ(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) reclaimStuck(ctx context.Context) {
+	for _, stream := range publisher.Streams {
+		msgs, _, err := d.redis.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    d.group,
+			Consumer: d.consumer,
+			MinIdle:  minIdleBeforeReclaim,
+			Start:    "0-0",
+			Count:    100,
+		}).Result()
+		if err != nil {
+			d.logger.Error("XAutoClaim failed", slog.String("stream", stream), slog.Any("error", err))
+			continue
+		}
+		if len(msgs) > 0 {
+			d.logger.Info("reclaimed stuck messages from PEL", slog.String("stream", stream), slog.Int("count", len(msgs)))
+			reclaimed.WithLabelValues(d.channel).Add(float64(len(msgs)))
+			for _, msg := range msgs {
+				d.process(ctx, msg)
+			}
+		}
+	}
+}
+
+// process runs ProcessWithDLQ for a message read by one of the loops, tracked
+// so Stop can wait for it. With a worker count it waits for a free worker slot
+// and delivers in the background; otherwise it delivers before returning.
+func (d *Dispatcher) process(ctx context.Context, msg redis.XMessage) {
+	d.inflight.Add(1)
+	if d.workers == nil {
+		defer d.inflight.Done()
+		d.ProcessWithDLQ(ctx, msg)
+		return
+	}
+	d.workers <- struct{}{}
+	go func() {
+		defer d.inflight.Done()
+		defer func() { <-d.workers }()
+		d.ProcessWithDLQ(ctx, msg)
+	}()
+}
+
+// ProcessWithDLQ wraps Deliver with attempt tracking, retry backoff and
+// dead-letter routing. On success it ACKs the message. On failure it leaves the
+// message pending and records when it may be retried; until then calls for it
+// return without delivering. On repeated failure it moves it to the DLQ.
+// Exported so it can be called directly in tests.
+func (d *Dispatcher) ProcessWithDLQ(ctx context.Context, msg redis.XMessage) {
+	attemptsKey := attemptsKeyPrefix + msg.ID
+	retryAtKey := retryAtKeyPrefix + msg.ID
+
+	if retryAt, err := d.redis.Get(ctx, retryAtKey).Int64(); err == nil && time.Now().UnixMilli() < retryAt {
+		return // still backing off (or being delivered elsewhere)
+	}
+
+	// Continue the trace started by the job that published the message.
+	ctx, span := tracer.Start(tracing.Extract(ctx, msg.Values), "consumer.process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.message.id", msg.ID),
+			attribute.String("notification.channel", d.channel),
+			attribute.String("messaging.consumer.group.name", d.group),
+		))
+	defer span.End()
+
+	if len(d.verifyKey) > 0 && !publisher.VerifySignature(d.verifyKey, msg.Values) {
+		const reason = "invalid signature"
+		d.MessageLogger(msg).Warn("message moved to DLQ", slog.String("message_id", msg.ID), slog.String("reason", reason))
+		span.SetStatus(codes.Error, reason)
+		deliveries.WithLabelValues(d.channel, resultRejected).Inc()
+		d.moveToDLQ(ctx, msg, reason)
+		d.redis.Del(ctx, attemptsKey, retryAtKey)
+		d.ack(ctx, msg)
+		return
+	}
+
+	if deliverAfter, ok := deliverAfter(msg); ok && time.Now().UTC().Before(deliverAfter) {
+		span.SetAttributes(attribute.String("delivery.deferred_until", deliverAfter.Format(time.RFC3339)))
+		d.park(ctx, msg, deliverAfter)
+		return
+	}
+
+	if expiresAt, ok := expiresAt(msg); ok && time.Now().UTC().After(expiresAt) {
+		d.MessageLogger(msg).Warn("message expired, skipping delivery",
+			slog.String("message_id", msg.ID), slog.Time("expired_at", expiresAt))
+		span.SetAttributes(attribute.Bool("delivery.expired", true))
+		deliveries.WithLabelValues(d.channel, resultExpired).Inc()
+		d.redis.Del(ctx, attemptsKey, retryAtKey)
+		d.ack(ctx, msg)
+		return
+	}
+
+	attempts, _ := d.redis.Incr(ctx, attemptsKey).Result()
+	d.redis.Expire(ctx, attemptsKey, 24*time.Hour)
+
+	if attempts > maxDeliveryAttempts {
+		reason := fmt.Sprintf("exceeded %d delivery attempts", maxDeliveryAttempts)
+		d.MessageLogger(msg).Warn("message moved to DLQ", slog.String("message_id", msg.ID), slog.String("reason", reason))
+		span.SetStatus(codes.Error, reason)
+		deliveries.WithLabelValues(d.channel, resultDeadLettered).Inc()
+		d.moveToDLQ(ctx, msg, reason)
+		d.redis.Del(ctx, attemptsKey, retryAtKey)
+		d.ack(ctx, msg)
+		return
+	}
+
+	idemKey, claim := d.claimIdempotencyKey(ctx, msg)
+	switch claim {
+	case claimDuplicate:
+		d.MessageLogger(msg).Info("duplicate message, skipping delivery", slog.String("message_id", msg.ID))
+		span.SetAttributes(attribute.Bool("delivery.duplicate", true))
+		deliveries.WithLabelValues(d.channel, resultDuplicate).Inc()
+		d.redis.Del(ctx, attemptsKey, retryAtKey)
+		d.ack(ctx, msg)
+		return
+	case claimBusy:
+		// A message with the same key is being delivered right now. Leave this
+		// one pending, without using up an attempt; a later reclaim finds it
+		// either delivered (a duplicate) or released after a failure.
+		d.redis.Decr(ctx, attemptsKey)
+		return
+	}
+
+	d.setRetryAt(ctx, retryAtKey, deliveryLease)
+	span.SetAttributes(attribute.Int64("delivery.attempt", attempts))
+	var info deliveryInfo
+	if err := d.deliverer.Deliver(context.WithValue(ctx, deliveryKey{}, &info), msg); err != nil {
+		if claim == claimOwned {
+			d.redis.Del(ctx, idemKey)
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "delivery failed")
+		deliveries.WithLabelValues(d.channel, resultFailed).Inc()
+		backoff := retryBackoff(attempts)
+		d.setRetryAt(ctx, retryAtKey, backoff)
+		d.MessageLogger(msg).Warn("delivery attempt failed, retrying",
+			slog.String("message_id", msg.ID), slog.Int64("attempt", attempts), slog.Int("max_attempts", maxDeliveryAttempts),
+			slog.Duration("retry_in", backoff.Round(time.Second)), slog.Any("error", err))
+		// Do NOT ACK — reclaimLoop will pick it up once the backoff has elapsed
+		return
+	}
+
+	deliveries.WithLabelValues(d.channel, resultDelivered).Inc()
+	// Messages from publishers older than the created_at field carry none.
+	if createdAt, ok := publisher.CreatedAtOf(msg.Values); ok {
+		deliveryLatency.WithLabelValues(d.channel).Observe(time.Since(createdAt).Seconds())
+	}
+	if claim == claimOwned {
+		d.redis.Set(ctx, idemKey, idempotencyDelivered, idempotencyTTL)
+	}
+	d.redis.Del(ctx, attemptsKey, retryAtKey)
+	d.recordDelivery(ctx, msg, info)
+	d.ack(ctx, msg)
+}
+
+// recordDelivery writes the receipt and delivery log row for the delivered
+// msg, for whichever of them is enabled. Failures are logged only.
+func (d *Dispatcher) recordDelivery(ctx context.Context, msg redis.XMessage, info deliveryInfo) {
+	field := func(name string) string {
+		v, _ := msg.Values[name].(string)
+		return v
+	}
+	now := time.Now().UTC()
+	if d.receiptDB != nil {
+		err := db.RecordReceipt(ctx, d.receiptDB, db.DeliveryReceipt{
+			JobID:         field("job_id"),
+			UserID:        field("user_id"),
+			Channel:       d.channel,
+			StreamID:      msg.ID,
+			ProviderMsgID: info.providerMessageID,
+			DeliveredAt:   now,
+		})
+		if err != nil {
+			d.MessageLogger(msg).Error("failed to record delivery receipt", slog.String("message_id", msg.ID), slog.Any("error", err))
+		}
+	}
+	if d.archiveDB != nil {
+		err := db.LogDelivery(ctx, d.archiveDB, db.DeliveryRecord{
+			UserID:      field("user_id"),
+			JobID:       field("job_id"),
+			Channel:     d.channel,
+			Content:     field("content"),
+			ChatID:      info.recipient,
+			DeliveredAt: now,
+		})
+		if err != nil {
+			d.MessageLogger(msg).Error("failed to archive delivery", slog.String("message_id", msg.ID), slog.Any("error", err))
+		}
+	}
+}
+
+// claimResult is the outcome of claimIdempotencyKey.
+type claimResult int
+
+const (
+	claimNone      claimResult = iota // no key, or Redis failed: deliver without the check
+	claimOwned                        // this delivery holds the key
+	claimDuplicate                    // the key was already delivered
+	claimBusy                         // another delivery holds the key
+)
+
+// claimIdempotencyKey marks msg's idempotency key as being delivered by this
+// call, for as long as the delivery lease. The claim is released on failure
+// and turned into "delivered" for idempotencyTTL on success. A Redis error is
+// logged and the message delivered anyway: a possible duplicate beats a lost
+// notification.
+func (d *Dispatcher) claimIdempotencyKey(ctx context.Context, msg redis.XMessage) (string, claimResult) {
+	key, _ := msg.Values["idempotency_key"].(string)
+	if key == "" {
+		return "", claimNone
+	}
+	key = idempotencyKeyPrefix + d.group + ":" + key
+	claimed, err := d.redis.SetNX(ctx, key, idempotencyDelivering, deliveryLease).Result()
+	if err != nil {
+		d.MessageLogger(msg).Warn("idempotency check failed, delivering anyway", slog.String("message_id", msg.ID), slog.Any("error", err))
+		return "", claimNone
+	}
+	if claimed {
+		return key, claimOwned
+	}
+	state, err := d.redis.Get(ctx, key).Result()
+	switch {
+	case err == redis.Nil:
+		// Released or expired in between; try again next time.
+		return "", claimBusy
+	case err != nil:
+		d.MessageLogger(msg).Warn("idempotency check failed, delivering anyway", slog.String("message_id", msg.ID), slog.Any("error", err))
+		return "", claimNone
+	case state == idempotencyDelivered:
+		return key, claimDuplicate
+	default:
+		return key, claimBusy
+	}
+}
+
+// ack acknowledges msg on the stream it was read from.
+func (d *Dispatcher) ack(ctx context.Context, msg redis.XMessage) {
+	d.redis.XAck(ctx, publisher.StreamOf(msg.Values), d.group, msg.ID)
+}
+
+// deliverAfter returns the message's deliver_after time, if it has one.
+func deliverAfter(msg redis.XMessage) (time.Time, bool) {
+	raw, _ := msg.Values["deliver_after"].(string)
+	if raw == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	return t, err == nil
+}
+
+// expiresAt returns when the message stops being worth delivering: the time it
+// was added to the stream (from its ID) plus its ttl_seconds. A delayed message
+// is re-added when due, so its TTL runs from its delivery time.
+func expiresAt(msg redis.XMessage) (time.Time, bool) {
+	raw, _ := msg.Values["ttl_seconds"].(string)
+	ttl, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || ttl <= 0 {
+		return time.Time{}, false
+	}
+	ms, _, _ := strings.Cut(msg.ID, "-")
+	addedMs, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(addedMs).UTC().Add(time.Duration(ttl) * time.Second), true
+}
+
+// park holds back a message that is not due yet in the delayed set and ACKs it;
+// delayedLoop puts it back on the stream once deliverAfter has passed. The
+// message is kept in the stream if it cannot be parked, so it is retried.
+func (d *Dispatcher) park(ctx context.Context, msg redis.XMessage, deliverAfter time.Time) {
+	member, err := json.Marshal(delayedMessage{ID: msg.ID, Values: msg.Values})
+	if err != nil {
+		d.MessageLogger(msg).Error("failed to encode delayed message", slog.String("message_id", msg.ID), slog.Any("error", err))
+		return
+	}
+	if err := d.redis.ZAdd(ctx, publisher.DelayedSetName, redis.Z{
+		Score:  float64(deliverAfter.Unix()),
+		Member: member,
+	}).Err(); err != nil {
+		d.MessageLogger(msg).Error("failed to delay message", slog.String("message_id", msg.ID), slog.Any("error", err))
+		return
+	}
+	d.ack(ctx, msg)
+	d.MessageLogger(msg).Info("message delayed", slog.String("message_id", msg.ID), slog.Time("deliver_after", deliverAfter))
+}
+
+// delayedMessage is a member of the delayed set. The original ID keeps
+// otherwise identical notifications from collapsing into one member.
+type delayedMessage struct {
+	ID     string                 `json:"id"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// delayedLoop periodically moves due messages from the delayed set back onto
+// the stream.
+func (d *Dispatcher) delayedLoop(ctx context.Context) {
+	ticker := time.NewTicker(delayedPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.MoveDueDelayed(ctx, time.Now()); err != nil {
+				d.logger.Error("failed to move delayed messages", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// MoveDueDelayed re-publishes every delayed message due at now to the stream,
+// without its deliver_after so it is delivered on arrival. The set is shared by
+// all consumers and instances; each member is claimed with ZREM first, so it is
+// moved exactly once. It returns how many messages were moved.
+func (d *Dispatcher) MoveDueDelayed(ctx context.Context, now time.Time) (int, error) {
+	members, err := d.redis.ZRangeByScore(ctx, publisher.DelayedSetName, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.Unix(), 10),
+		Count: 100,
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("read delayed set: %w", err)
+	}
+
+	moved := 0
+	for _, member := range members {
+		if n, err := d.redis.ZRem(ctx, publisher.DelayedSetName, member).Result(); err != nil || n == 0 {
+			continue // claimed by another consumer
+		}
+		var dm delayedMessage
+		if err := json.Unmarshal([]byte(member), &dm); err != nil {
+			d.logger.Error("dropping undecodable delayed message", slog.String("member", member), slog.Any("error", err))
+			continue
+		}
+		delete(dm.Values, "deliver_after")
+		if err := d.redis.XAdd(ctx, &redis.XAddArgs{
+			Stream: publisher.StreamOf(dm.Values),
+			Values: dm.Values,
+		}).Err(); err != nil {
+			// Put it back so the next poll tries again.
+			d.redis.ZAdd(ctx, publisher.DelayedSetName, redis.Z{Score: float64(now.Unix()), Member: member})
+			return moved, fmt.Errorf("re-publish delayed message %s: %w", dm.ID, err)
+		}
+		moved++
+	}
+	if moved > 0 {
+		d.logger.Info("moved due delayed messages to the stream", slog.Int("count", moved))
+	}
+	return moved, nil
+}
+
+func (d *Dispatcher) setRetryAt(ctx context.Context, key string, after time.Duration) {
+	d.redis.Set(ctx, key, time.Now().Add(after).UnixMilli(), 24*time.Hour)
+}
+
+func (d *Dispatcher) moveToDLQ(ctx context.Context, msg redis.XMessage, reason string) {
+	if d.onDLQ != nil {
+		d.onDLQ(msg, reason)
+	}
+	values := make(map[string]interface{}, len(msg.Values)+4)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values["dlq_reason"] = reason
+	values["dlq_original_id"] = msg.ID
+	values["dlq_consumer_group"] = d.group
+	values["dlq_timestamp"] = time.Now().UTC().Format(time.RFC3339)
+
+	args := &redis.XAddArgs{
+		Stream: publisher.DLQStreamName,
+		Values: values,
+	}
+	if d.dlqMaxLen > 0 {
+		args.MaxLen = d.dlqMaxLen
+		args.Approx = true
+	}
+	if err := d.redis.XAdd(ctx, args).Err(); err != nil {
+		d.MessageLogger(msg).Error("failed to write message to DLQ", slog.String("message_id", msg.ID), slog.Any("error", err))
+	}
+	d.updateDLQLength(ctx)
+	if d.deadDB != nil {
+		d.recordDeadLetter(ctx, msg, reason)
+	}
+}
+
+// recordDeadLetter inserts msg into dead_letters. Failures are logged only:
+// the Redis DLQ entry has already been written.
+func (d *Dispatcher) recordDeadLetter(ctx context.Context, msg redis.XMessage, reason string) {
+	field := func(name string) string {
+		v, _ := msg.Values[name].(string)
+		return v
+	}
+	if _, err := d.deadDB.Exec(ctx, `
+		INSERT INTO dead_letters (job_id, user_id, channel, content, reason, original_id, trace_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+	`, field("job_id"), field("user_id"), field("channel"), field("content"), reason, msg.ID, field("trace_id")); err != nil {
+		d.MessageLogger(msg).Error("failed to record dead letter", slog.String("message_id", msg.ID), slog.Any("error", err))
+	}
+}
+
+// ReplayDLQ moves up to maxCount of the oldest dead-letter messages back onto
+// the main stream for another round of delivery. The dlq_* metadata is
+// stripped and the attempts counter of the original message is reset. The DLQ
+// is shared by all consumers, so messages for every channel are replayed; each
+// consumer group picks up its own. It returns how many messages were replayed.
+func (d *Dispatcher) ReplayDLQ(ctx context.Context, maxCount int64) (int, error) {
+	msgs, err := d.redis.XRangeN(ctx, publisher.DLQStreamName, "-", "+", maxCount).Result()
+	if err != nil {
+		return 0, fmt.Errorf("read DLQ: %w", err)
+	}
+
+	replayed := 0
+	for _, msg := range msgs {
+		values := make(map[string]interface{}, len(msg.Values))
+		for k, v := range msg.Values {
+			if !strings.HasPrefix(k, "dlq_") {
+				values[k] = v
+			}
+		}
+		if originalID, ok := msg.Values["dlq_original_id"].(string); ok {
+			d.redis.Del(ctx, attemptsKeyPrefix+originalID, retryAtKeyPrefix+originalID)
+		}
+
+		if err := d.redis.XAdd(ctx, &redis.XAddArgs{
+			Stream: publisher.StreamOf(values),
+			Values: values,
+		}).Err(); err != nil {
+			return replayed, fmt.Errorf("replay DLQ message %s: %w", msg.ID, err)
+		}
+		if err := d.redis.XDel(ctx, publisher.DLQStreamName, msg.ID).Err(); err != nil {
+			return replayed, fmt.Errorf("delete DLQ message %s: %w", msg.ID, err)
+		}
+		replayed++
+	}
+	if replayed > 0 {
+		d.logger.Info("replayed messages from DLQ", slog.Int("count", replayed))
+		d.updateDLQLength(ctx)
+	}
+	return replayed, nil
+}
+
+// CheckDLQ refreshes notifier_dlq_length and calls the DLQ alert callback if
+// the DLQ is over its threshold (see WithDLQAlertThreshold). The reclaim loop
+// runs it on every tick; exported for testing.
+func (d *Dispatcher) CheckDLQ(ctx context.Context) {
+	n, err := d.redis.XLen(ctx, publisher.DLQStreamName).Result()
+	if err != nil {
+		d.logger.Warn("failed to read DLQ length", slog.Any("error", err))
+		return
+	}
+	dlqLength.Set(float64(n))
+	if d.dlqAlert != nil && n > d.dlqAlertThreshold {
+		d.dlqAlert(n)
+	}
+}
+
+// Stats is a snapshot of the streams as seen by one consumer group; see
+// Dispatcher.Stats.
+type Stats struct {
+	Group        string
+	DLQLength    int64 // entries in the DLQ, shared by every channel
+	StreamLength int64 // entries in the notification streams, high priority included
+	Pending      int64 // messages the group has read but not acknowledged (its PEL)
+	// OldestIdle is how long the oldest pending message has gone without
+	// being delivered or reclaimed; 0 with nothing pending.
+	OldestIdle time.Duration
+}
+
+// Stats reports the DLQ and notification stream lengths and the consumer
+// group's pending entries. A pending count that keeps growing, or an
+// OldestIdle well past the reclaim interval, means deliveries are stuck.
+func (d *Dispatcher) Stats(ctx context.Context) (Stats, error) {
+	st := Stats{Group: d.group}
+	var err error
+	if st.DLQLength, err = d.redis.XLen(ctx, publisher.DLQStreamName).Result(); err != nil {
+		return Stats{}, fmt.Errorf("read DLQ length: %w", err)
+	}
+	for _, stream := range publisher.Streams {
+		n, err := d.redis.XLen(ctx, stream).Result()
+		if err != nil {
+			return Stats{}, fmt.Errorf("read %s length: %w", stream, err)
+		}
+		st.StreamLength += n
+
+		pending, err := d.redis.XPending(ctx, stream, d.group).Result()
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				continue // no consumer has started yet
+			}
+			return Stats{}, fmt.Errorf("read %s pending entries: %w", stream, err)
+		}
+		if pending.Count == 0 {
+			continue
+		}
+		st.Pending += pending.Count
+		oldest, err := d.redis.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream, Group: d.group, Start: pending.Lower, End: pending.Lower, Count: 1,
+		}).Result()
+		if err != nil {
+			return Stats{}, fmt.Errorf("read %s oldest pending entry: %w", stream, err)
+		}
+		if len(oldest) > 0 && oldest[0].Idle > st.OldestIdle {
+			st.OldestIdle = oldest[0].Idle
+		}
+	}
+	return st, nil
+}
+
+// updateDLQLength refreshes notifier_dlq_length from the DLQ stream.
+func (d *Dispatcher) updateDLQLength(ctx context.Context) {
+	if n, err := d.redis.XLen(ctx, publisher.DLQStreamName).Result(); err == nil {
+		dlqLength.Set(float64(n))
+	}
+}
@@ -0,0 +1,862 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/allerac/notifier/internal/consumers/core"
+	"github.com/allerac/notifier/internal/logging"
+	"github.com/allerac/notifier/internal/publisher"
+	"github.com/allerac/notifier/internal/tracing"
+)
+
+// fakeDeliverer records delivered message contents and fails while err is set.
+type fakeDeliverer struct {
+	mu        sync.Mutex
+	delivered []string
+	err       error
+}
+
+func (f *fakeDeliverer) Deliver(_ context.Context, msg redis.XMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	content, _ := msg.Values["content"].(string)
+	f.delivered = append(f.delivered, content)
+	return nil
+}
+
+func (f *fakeDeliverer) contents() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.delivered...)
+}
+
+func newDispatcher(t *testing.T, d core.Deliverer) (*core.Dispatcher, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	disp := core.New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "sms", "sms-group", d)
+	t.Cleanup(func() { disp.Close() })
+	return disp, redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+func message(id, content string) redis.XMessage {
+	return redis.XMessage{ID: id, Values: map[string]interface{}{
+		"job_id": "job-1", "user_id": "user-1", "channel": "sms", "content": content,
+	}}
+}
+
+func TestDispatcher_ProcessWithDLQ_SuccessClearsAttempts(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+
+	disp.ProcessWithDLQ(ctx, message("1-0", "hello"))
+
+	assert.Equal(t, []string{"hello"}, d.contents())
+	assert.Equal(t, int64(0), rc.Exists(ctx, "notifications:attempts:1-0").Val())
+}
+
+func TestDispatcher_ProcessWithDLQ_FailureCountsAttempt(t *testing.T) {
+	d := &fakeDeliverer{err: fmt.Errorf("gateway down")}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+
+	disp.ProcessWithDLQ(ctx, message("1-0", "hello"))
+
+	attempts, _ := rc.Get(ctx, "notifications:attempts:1-0").Int64()
+	assert.Equal(t, int64(1), attempts)
+	dlq, _ := rc.XRange(ctx, publisher.DLQStreamName, "-", "+").Result()
+	assert.Empty(t, dlq)
+}
+
+func TestDispatcher_ProcessWithDLQ_MovesToDLQAndCallsHook(t *testing.T) {
+	d := &fakeDeliverer{err: fmt.Errorf("gateway down")}
+	disp, rc := newDispatcher(t, d)
+	var hooked []string
+	disp.WithDeadLetterHook(func(msg redis.XMessage, reason string) {
+		hooked = append(hooked, msg.ID+": "+reason)
+	})
+	ctx := context.Background()
+	rc.Set(ctx, "notifications:attempts:1-0", 3, 0)
+
+	disp.ProcessWithDLQ(ctx, message("1-0", "hello"))
+
+	dlq, err := rc.XRange(ctx, publisher.DLQStreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, dlq, 1)
+	assert.Equal(t, "hello", dlq[0].Values["content"])
+	assert.Equal(t, "sms-group", dlq[0].Values["dlq_consumer_group"])
+	assert.Equal(t, []string{"1-0: exceeded 3 delivery attempts"}, hooked)
+}
+
+// deadLetterAll moves n distinct messages to the DLQ through disp.
+func deadLetterAll(t *testing.T, disp *core.Dispatcher, rc *redis.Client, n int) {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("%d-0", i+1)
+		require.NoError(t, rc.Set(ctx, "notifications:attempts:"+id, 3, 0).Err())
+		disp.ProcessWithDLQ(ctx, message(id, "hello"))
+	}
+}
+
+func TestDispatcher_WithDLQMaxLen_BoundsDLQ(t *testing.T) {
+	const maxLen = 50
+	const trimMargin = 100 // XADD MAXLEN ~ may keep up to a macro node's worth extra
+	disp, rc := newDispatcher(t, &fakeDeliverer{err: fmt.Errorf("gateway down")})
+	disp.WithDLQMaxLen(maxLen)
+
+	deadLetterAll(t, disp, rc, 10*maxLen)
+
+	length := rc.XLen(context.Background(), publisher.DLQStreamName).Val()
+	assert.LessOrEqual(t, length, int64(maxLen+trimMargin))
+	assert.GreaterOrEqual(t, length, int64(maxLen))
+}
+
+func TestDispatcher_WithDLQMaxLen_ZeroDoesNotTrim(t *testing.T) {
+	disp, rc := newDispatcher(t, &fakeDeliverer{err: fmt.Errorf("gateway down")})
+	disp.WithDLQMaxLen(0)
+
+	deadLetterAll(t, disp, rc, 30)
+
+	assert.Equal(t, int64(30), rc.XLen(context.Background(), publisher.DLQStreamName).Val())
+}
+
+func TestDispatcher_CheckDLQ_AlertsAboveThreshold(t *testing.T) {
+	const threshold = 5
+	disp, rc := newDispatcher(t, &fakeDeliverer{err: fmt.Errorf("gateway down")})
+	var sizes []int64
+	disp.WithDLQAlertThreshold(threshold, func(size int64) { sizes = append(sizes, size) })
+
+	deadLetterAll(t, disp, rc, threshold)
+	disp.CheckDLQ(context.Background())
+	assert.Empty(t, sizes, "at the threshold")
+
+	rc.XAdd(context.Background(), &redis.XAddArgs{Stream: publisher.DLQStreamName, Values: map[string]any{"content": "x"}})
+	disp.CheckDLQ(context.Background())
+	assert.Equal(t, []int64{threshold + 1}, sizes)
+}
+
+// fakeDeadLetterDB records the arguments of every Exec and fails while err is set.
+type fakeDeadLetterDB struct {
+	rows [][]any
+	err  error
+}
+
+func (f *fakeDeadLetterDB) Exec(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+	if f.err != nil {
+		return pgconn.CommandTag{}, f.err
+	}
+	f.rows = append(f.rows, args)
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+
+func TestDispatcher_ProcessWithDLQ_RecordsDeadLetterInDB(t *testing.T) {
+	disp, rc := newDispatcher(t, &fakeDeliverer{err: fmt.Errorf("gateway down")})
+	db := &fakeDeadLetterDB{}
+	disp.WithDeadLetterDB(db)
+	ctx := context.Background()
+	rc.Set(ctx, "notifications:attempts:1-0", 3, 0)
+	msg := message("1-0", "hello")
+	msg.Values["trace_id"] = "trace-1"
+
+	disp.ProcessWithDLQ(ctx, msg)
+
+	require.Len(t, db.rows, 1)
+	assert.Equal(t, []any{"job-1", "user-1", "sms", "hello", "exceeded 3 delivery attempts", "1-0", "trace-1"}, db.rows[0])
+}
+
+func TestDispatcher_ProcessWithDLQ_DBFailureKeepsRedisDLQ(t *testing.T) {
+	disp, rc := newDispatcher(t, &fakeDeliverer{err: fmt.Errorf("gateway down")})
+	disp.WithDeadLetterDB(&fakeDeadLetterDB{err: fmt.Errorf("db down")})
+	ctx := context.Background()
+	rc.Set(ctx, "notifications:attempts:1-0", 3, 0)
+
+	disp.ProcessWithDLQ(ctx, message("1-0", "hello"))
+
+	dlq, err := rc.XRange(ctx, publisher.DLQStreamName, "-", "+").Result()
+	require.NoError(t, err)
+	assert.Len(t, dlq, 1)
+	assert.Zero(t, rc.Exists(ctx, "notifications:attempts:1-0").Val(), "message is still retired")
+}
+
+// recipientDeliverer reports a recipient and provider message ID for every
+// message it delivers.
+type recipientDeliverer struct{ recipient, providerID string }
+
+func (r recipientDeliverer) Deliver(ctx context.Context, _ redis.XMessage) error {
+	core.SetRecipient(ctx, r.recipient)
+	core.SetProviderMessageID(ctx, r.providerID)
+	return nil
+}
+
+func TestDispatcher_ProcessWithDLQ_RecordsDeliveryReceipt(t *testing.T) {
+	disp, rc := newDispatcher(t, recipientDeliverer{recipient: "12345", providerID: "77"})
+	db := &fakeDeadLetterDB{}
+	disp.WithDeliveryReceipts(db)
+	msg := pendingMessage(t, rc, 0, "")
+
+	disp.ProcessWithDLQ(context.Background(), msg)
+
+	require.Len(t, db.rows, 1)
+	assert.Equal(t, []any{"job-1", "user-1", "sms", msg.ID}, db.rows[0][:4])
+	assert.Equal(t, "77", db.rows[0][5])
+}
+
+func TestDispatcher_ProcessWithDLQ_ArchivesDelivery(t *testing.T) {
+	disp, rc := newDispatcher(t, recipientDeliverer{recipient: "12345"})
+	db := &fakeDeadLetterDB{}
+	disp.WithArchival(db)
+	ctx := context.Background()
+	msg := pendingMessage(t, rc, 0, "")
+
+	disp.ProcessWithDLQ(ctx, msg)
+
+	require.Len(t, db.rows, 1)
+	assert.Equal(t, []any{"user-1", "job-1", "sms", "weather"}, db.rows[0][:4])
+	assert.WithinDuration(t, time.Now(), db.rows[0][4].(time.Time), time.Minute)
+	assert.Equal(t, "12345", db.rows[0][5])
+}
+
+func TestDispatcher_ProcessWithDLQ_ArchivalFailureStillAcks(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	disp.WithArchival(&fakeDeadLetterDB{err: fmt.Errorf("db down")})
+	ctx := context.Background()
+	msg := pendingMessage(t, rc, 0, "")
+
+	disp.ProcessWithDLQ(ctx, msg)
+
+	assert.Equal(t, []string{"weather"}, d.contents())
+	pending, err := rc.XPending(ctx, publisher.StreamName, "sms-group").Result()
+	require.NoError(t, err)
+	assert.Zero(t, pending.Count, "delivered message ACKed")
+}
+
+func TestDispatcher_Start_DeliversOnlyItsChannel(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+	require.NoError(t, disp.Start(ctx))
+
+	pub := publisher.NewFromClient(rc)
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "not mine"}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "sms", Content: "mine"}))
+
+	require.Eventually(t, func() bool { return len(d.contents()) == 1 }, 2*time.Second, 5*time.Millisecond)
+	require.NoError(t, disp.Stop(ctx))
+
+	assert.Equal(t, []string{"mine"}, d.contents())
+	pending, err := rc.XPending(ctx, publisher.StreamName, "sms-group").Result()
+	require.NoError(t, err)
+	assert.Zero(t, pending.Count, "other channels are ACKed without delivery")
+}
+
+func TestDispatcher_Ping_ReportsReadLoop(t *testing.T) {
+	disp, _ := newDispatcher(t, &fakeDeliverer{})
+	ctx := context.Background()
+
+	assert.ErrorContains(t, disp.Ping(ctx), "sms consumer not running")
+	require.NoError(t, disp.Start(ctx))
+	assert.NoError(t, disp.Ping(ctx))
+	require.NoError(t, disp.Stop(ctx))
+	assert.Error(t, disp.Ping(ctx), "stopped")
+}
+
+func TestDispatcher_Stats_ReportsLengthsAndPending(t *testing.T) {
+	disp, rc := newDispatcher(t, &fakeDeliverer{})
+	ctx := context.Background()
+
+	st, err := disp.Stats(ctx)
+	require.NoError(t, err, "streams and group not created yet")
+	assert.Equal(t, core.Stats{Group: "sms-group"}, st)
+
+	pub := publisher.NewFromClient(rc)
+	for i := range 3 {
+		require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "sms", Content: fmt.Sprint(i)}))
+	}
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "sms", Content: "urgent", Priority: publisher.PriorityHigh}))
+	require.NoError(t, rc.XAdd(ctx, &redis.XAddArgs{Stream: publisher.DLQStreamName, Values: map[string]any{"content": "dead"}}).Err())
+	// Read two messages as the group without acknowledging them.
+	require.NoError(t, rc.XGroupCreate(ctx, publisher.StreamName, "sms-group", "0").Err())
+	require.NoError(t, rc.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "sms-group", Consumer: "stuck", Streams: []string{publisher.StreamName, ">"}, Count: 2,
+	}).Err())
+	time.Sleep(30 * time.Millisecond)
+
+	st, err = disp.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), st.DLQLength)
+	assert.Equal(t, int64(4), st.StreamLength, "both priorities counted")
+	assert.Equal(t, int64(2), st.Pending)
+	assert.GreaterOrEqual(t, st.OldestIdle, 30*time.Millisecond)
+}
+
+func TestDispatcher_Start_DeliversHighPriorityFirst(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+	// Create the groups at the start of the streams so the backlog published
+	// below is read by the first XREADGROUP.
+	for _, stream := range publisher.Streams {
+		require.NoError(t, rc.XGroupCreateMkStream(ctx, stream, "sms-group", "0").Err())
+	}
+	pub := publisher.NewFromClient(rc)
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "sms", Content: "digest 1"}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "sms", Content: "digest 2"}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-2", Channel: "sms", Content: "alert", Priority: publisher.PriorityHigh,
+	}))
+
+	require.NoError(t, disp.Start(ctx))
+	require.Eventually(t, func() bool { return len(d.contents()) == 3 }, 2*time.Second, 5*time.Millisecond)
+	require.NoError(t, disp.Stop(ctx))
+
+	assert.Equal(t, []string{"alert", "digest 1", "digest 2"}, d.contents())
+	for _, stream := range publisher.Streams {
+		pending, err := rc.XPending(ctx, stream, "sms-group").Result()
+		require.NoError(t, err)
+		assert.Zero(t, pending.Count, "%s: delivered messages are ACKed on their own stream", stream)
+	}
+}
+
+func TestDefaultConsumerName_IsUniquePerCall(t *testing.T) {
+	a, b := core.DefaultConsumerName(), core.DefaultConsumerName()
+	assert.NotEqual(t, a, b)
+	assert.NotEmpty(t, a)
+}
+
+func TestDispatcher_WithConsumerName(t *testing.T) {
+	disp, _ := newDispatcher(t, &fakeDeliverer{})
+	generated := disp.ConsumerName()
+
+	assert.Equal(t, generated, disp.WithConsumerName("").ConsumerName(), "empty keeps the default")
+	assert.Equal(t, "pod-0", disp.WithConsumerName("pod-0").ConsumerName())
+}
+
+func TestDispatcher_DistinctConsumersGetDistinctMessages(t *testing.T) {
+	mr := miniredis.RunT(t)
+	newClient := func() *redis.Client { return redis.NewClient(&redis.Options{Addr: mr.Addr()}) }
+	a := core.New(newClient(), "sms", "sms-group", &fakeDeliverer{})
+	b := core.New(newClient(), "sms", "sms-group", &fakeDeliverer{})
+	defer a.Close()
+	defer b.Close()
+	require.NotEqual(t, a.ConsumerName(), b.ConsumerName())
+
+	rc := newClient()
+	ctx := context.Background()
+	require.NoError(t, rc.XGroupCreateMkStream(ctx, publisher.StreamName, "sms-group", "$").Err())
+	pub := publisher.NewFromClient(rc)
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{Channel: "sms", Content: "one"}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{Channel: "sms", Content: "two"}))
+
+	read := func(consumer string) redis.XMessage {
+		streams, err := rc.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group: "sms-group", Consumer: consumer,
+			Streams: []string{publisher.StreamName, ">"}, Count: 1, Block: -1,
+		}).Result()
+		require.NoError(t, err)
+		require.Len(t, streams[0].Messages, 1)
+		return streams[0].Messages[0]
+	}
+	msgA, msgB := read(a.ConsumerName()), read(b.ConsumerName())
+	assert.NotEqual(t, msgA.ID, msgB.ID)
+
+	// Each message is pending under the consumer that read it.
+	for name, id := range map[string]string{a.ConsumerName(): msgA.ID, b.ConsumerName(): msgB.ID} {
+		pending, err := rc.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: publisher.StreamName, Group: "sms-group", Consumer: name, Start: "-", End: "+", Count: 10,
+		}).Result()
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		assert.Equal(t, id, pending[0].ID)
+	}
+}
+
+// retryIn returns how far in the future the message's retry_at lies.
+func retryIn(t *testing.T, rc *redis.Client, id string) time.Duration {
+	t.Helper()
+	ms, err := rc.Get(context.Background(), "notifications:retry_at:"+id).Int64()
+	require.NoError(t, err)
+	return time.Until(time.UnixMilli(ms))
+}
+
+func TestDispatcher_ProcessWithDLQ_BackoffGrowsWithAttempts(t *testing.T) {
+	cases := []struct {
+		previousAttempts int
+		base             time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 2 * time.Minute},
+		{2, 5 * time.Minute},
+	}
+	for _, tc := range cases {
+		disp, rc := newDispatcher(t, &fakeDeliverer{err: fmt.Errorf("gateway down")})
+		ctx := context.Background()
+		if tc.previousAttempts > 0 {
+			rc.Set(ctx, "notifications:attempts:1-0", tc.previousAttempts, 0)
+		}
+
+		disp.ProcessWithDLQ(ctx, message("1-0", "hello"))
+
+		wait := retryIn(t, rc, "1-0")
+		assert.GreaterOrEqual(t, wait, time.Duration(float64(tc.base)*0.8)-time.Second, "attempt %d", tc.previousAttempts+1)
+		assert.LessOrEqual(t, wait, time.Duration(float64(tc.base)*1.2), "attempt %d", tc.previousAttempts+1)
+	}
+}
+
+func TestDispatcher_ProcessWithDLQ_RespectsBackoff(t *testing.T) {
+	d := &fakeDeliverer{err: fmt.Errorf("gateway down")}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+	msg := message("1-0", "hello")
+
+	disp.ProcessWithDLQ(ctx, msg) // attempt 1 fails
+	d.mu.Lock()
+	d.err = nil
+	d.mu.Unlock()
+
+	disp.ProcessWithDLQ(ctx, msg) // reclaimed too early: skipped
+	assert.Empty(t, d.contents())
+	attempts, _ := rc.Get(ctx, "notifications:attempts:1-0").Int64()
+	assert.Equal(t, int64(1), attempts, "a skipped retry does not use up an attempt")
+
+	rc.Set(ctx, "notifications:retry_at:1-0", time.Now().Add(-time.Second).UnixMilli(), 0)
+	disp.ProcessWithDLQ(ctx, msg) // backoff elapsed
+	assert.Equal(t, []string{"hello"}, d.contents())
+	assert.Equal(t, int64(0), rc.Exists(ctx, "notifications:retry_at:1-0", "notifications:attempts:1-0").Val())
+}
+
+func TestDispatcher_WithLogger_LogsFailedAttempt(t *testing.T) {
+	var buf bytes.Buffer
+	disp, _ := newDispatcher(t, &fakeDeliverer{err: fmt.Errorf("gateway down")})
+	disp.WithLogger(logging.NewLogger(&buf, slog.LevelInfo))
+
+	disp.ProcessWithDLQ(context.Background(), message("1-0", "hello"))
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record), buf.String())
+	assert.Equal(t, "delivery attempt failed, retrying", record["msg"])
+	assert.Equal(t, "consumer", record["component"])
+	assert.Equal(t, "sms", record["channel"])
+	assert.Equal(t, "1-0", record["message_id"])
+	assert.Equal(t, float64(1), record["attempt"])
+	assert.Equal(t, "gateway down", record["error"])
+}
+
+func TestDispatcher_WithLogger_TagsTraceID(t *testing.T) {
+	var buf bytes.Buffer
+	disp, _ := newDispatcher(t, &fakeDeliverer{err: fmt.Errorf("gateway down")})
+	disp.WithLogger(logging.NewLogger(&buf, slog.LevelInfo))
+	msg := message("1-0", "hello")
+	msg.Values["trace_id"] = "trace-1"
+
+	disp.ProcessWithDLQ(context.Background(), msg)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record), buf.String())
+	assert.Equal(t, "trace-1", record["trace_id"])
+}
+
+func TestDispatcher_ProcessWithDLQ_DelaysMessageNotYetDue(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+	msg := message("1-0", "good morning")
+	msg.Values["deliver_after"] = time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	disp.ProcessWithDLQ(ctx, msg)
+
+	assert.Empty(t, d.contents(), "not delivered before deliver_after")
+	assert.Equal(t, int64(1), rc.ZCard(ctx, publisher.DelayedSetName).Val())
+	assert.Equal(t, int64(0), rc.Exists(ctx, "notifications:attempts:1-0").Val(), "parking is not an attempt")
+}
+
+func TestDispatcher_ProcessWithDLQ_DeliversWhenDeliverAfterPassed(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+	msg := message("1-0", "late")
+	msg.Values["deliver_after"] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+
+	disp.ProcessWithDLQ(ctx, msg)
+
+	assert.Equal(t, []string{"late"}, d.contents())
+	assert.Equal(t, int64(0), rc.ZCard(ctx, publisher.DelayedSetName).Val())
+}
+
+func TestDispatcher_MoveDueDelayed_RepublishesOnceDue(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+	deliverAt := time.Now().Add(5 * time.Hour)
+	msg := message("1-0", "good morning")
+	msg.Values["deliver_after"] = deliverAt.UTC().Format(time.RFC3339)
+	disp.ProcessWithDLQ(ctx, msg)
+
+	moved, err := disp.MoveDueDelayed(ctx, deliverAt.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, moved, "not due yet")
+
+	moved, err = disp.MoveDueDelayed(ctx, deliverAt.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+	assert.Equal(t, int64(0), rc.ZCard(ctx, publisher.DelayedSetName).Val())
+
+	msgs, err := rc.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "good morning", msgs[0].Values["content"])
+	assert.NotContains(t, msgs[0].Values, "deliver_after")
+
+	disp.ProcessWithDLQ(ctx, msgs[0])
+	assert.Equal(t, []string{"good morning"}, d.contents())
+}
+
+func TestDispatcher_MoveDueDelayed_KeepsPriority(t *testing.T) {
+	disp, rc := newDispatcher(t, &fakeDeliverer{})
+	ctx := context.Background()
+	deliverAt := time.Now().Add(time.Hour)
+	msg := message("1-0", "alert")
+	msg.Values["deliver_after"] = deliverAt.UTC().Format(time.RFC3339)
+	msg.Values["priority"] = "10"
+	disp.ProcessWithDLQ(ctx, msg)
+
+	moved, err := disp.MoveDueDelayed(ctx, deliverAt.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+
+	assert.Zero(t, rc.XLen(ctx, publisher.StreamName).Val())
+	msgs, err := rc.XRange(ctx, publisher.HighPriorityStreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "alert", msgs[0].Values["content"])
+}
+
+func TestDispatcher_MoveDueDelayed_KeepsIdenticalNotificationsApart(t *testing.T) {
+	disp, rc := newDispatcher(t, &fakeDeliverer{})
+	ctx := context.Background()
+	deliverAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for _, id := range []string{"1-0", "2-0"} {
+		msg := message(id, "same text")
+		msg.Values["deliver_after"] = deliverAt
+		disp.ProcessWithDLQ(ctx, msg)
+	}
+
+	moved, err := disp.MoveDueDelayed(ctx, time.Now().Add(2*time.Hour))
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, moved)
+	assert.Equal(t, int64(2), rc.XLen(ctx, publisher.StreamName).Val())
+}
+
+// pendingMessage adds a message with the given age and TTL to the stream and
+// reads it into the dispatcher group's PEL, as the consume loop would.
+func pendingMessage(t *testing.T, rc *redis.Client, age time.Duration, ttlSeconds string) redis.XMessage {
+	t.Helper()
+	ctx := context.Background()
+	id := fmt.Sprintf("%d-0", time.Now().Add(-age).UnixMilli())
+	require.NoError(t, rc.XAdd(ctx, &redis.XAddArgs{
+		Stream: publisher.StreamName,
+		ID:     id,
+		Values: map[string]interface{}{"job_id": "job-1", "user_id": "user-1", "channel": "sms", "content": "weather", "ttl_seconds": ttlSeconds},
+	}).Err())
+	require.NoError(t, rc.XGroupCreate(ctx, publisher.StreamName, "sms-group", "0").Err())
+	streams, err := rc.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "sms-group", Consumer: "test", Streams: []string{publisher.StreamName, ">"}, Count: 1,
+	}).Result()
+	require.NoError(t, err)
+	return streams[0].Messages[0]
+}
+
+func TestDispatcher_ProcessWithDLQ_DeliversWithinTTL(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	msg := pendingMessage(t, rc, 0, "1")
+
+	disp.ProcessWithDLQ(context.Background(), msg)
+
+	assert.Equal(t, []string{"weather"}, d.contents())
+}
+
+func TestDispatcher_ProcessWithDLQ_SkipsAndAcksExpiredMessage(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+	msg := pendingMessage(t, rc, 2*time.Second, "1")
+
+	disp.ProcessWithDLQ(ctx, msg)
+
+	assert.Empty(t, d.contents(), "expired message not delivered")
+	pending, err := rc.XPending(ctx, publisher.StreamName, "sms-group").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count, "expired message ACKed")
+	assert.Equal(t, int64(0), rc.XLen(ctx, publisher.DLQStreamName).Val(), "expiry is not a dead letter")
+}
+
+// keyedMessage is a message carrying idempotency key key.
+func keyedMessage(id, content, key string) redis.XMessage {
+	msg := message(id, content)
+	msg.Values["idempotency_key"] = key
+	return msg
+}
+
+func TestDispatcher_ProcessWithDLQ_SkipsDuplicateIdempotencyKey(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+
+	disp.ProcessWithDLQ(ctx, keyedMessage("1-0", "hello", "k1"))
+	disp.ProcessWithDLQ(ctx, keyedMessage("2-0", "hello", "k1"))
+	disp.ProcessWithDLQ(ctx, keyedMessage("3-0", "hello", "k2"))
+
+	assert.Equal(t, []string{"hello", "hello"}, d.contents(), "k1 delivered once, k2 once")
+	assert.Equal(t, "delivered", rc.Get(ctx, "notifications:idempotency:sms-group:k1").Val())
+	assert.Equal(t, int64(0), rc.Exists(ctx, "notifications:attempts:2-0").Val(), "duplicate cleaned up")
+}
+
+func TestDispatcher_ProcessWithDLQ_FailedDeliveryReleasesIdempotencyKey(t *testing.T) {
+	d := &fakeDeliverer{err: fmt.Errorf("gateway down")}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+
+	disp.ProcessWithDLQ(ctx, keyedMessage("1-0", "hello", "k1"))
+	assert.Equal(t, int64(0), rc.Exists(ctx, "notifications:idempotency:sms-group:k1").Val(), "released for the retry")
+
+	d.err = nil
+	disp.ProcessWithDLQ(ctx, keyedMessage("2-0", "hello", "k1"))
+	assert.Equal(t, []string{"hello"}, d.contents())
+}
+
+func TestDispatcher_ProcessWithDLQ_LeavesMessagePendingWhileKeyIsBusy(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+	rc.Set(ctx, "notifications:idempotency:sms-group:k1", "delivering", time.Minute)
+
+	disp.ProcessWithDLQ(ctx, keyedMessage("1-0", "hello", "k1"))
+
+	assert.Empty(t, d.contents())
+	attempts, _ := rc.Get(ctx, "notifications:attempts:1-0").Int64()
+	assert.Equal(t, int64(0), attempts, "waiting on another delivery is not an attempt")
+}
+
+func TestDispatcher_ProcessWithDLQ_IdempotencyKeysArePerGroup(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+	rc.Set(ctx, "notifications:idempotency:telegram-group:k1", "delivered", time.Minute)
+	rc.Set(ctx, "notifications:idempotency:sms-archive-group:k1", "delivered", time.Minute)
+
+	disp.ProcessWithDLQ(ctx, keyedMessage("1-0", "hello", "k1"))
+
+	assert.Equal(t, []string{"hello"}, d.contents(), "another group of the same channel does not count")
+}
+
+// metricValue returns the counter or gauge value of the name sample whose
+// labels are exactly labels (name/value pairs), or 0 if there is none.
+func metricValue(t *testing.T, reg *prometheus.Registry, name string, labels ...string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	samples:
+		for _, m := range f.GetMetric() {
+			if len(m.GetLabel())*2 != len(labels) {
+				continue
+			}
+			for i, l := range m.GetLabel() {
+				if l.GetName() != labels[2*i] || l.GetValue() != labels[2*i+1] {
+					continue samples
+				}
+			}
+			if m.GetCounter() != nil {
+				return m.GetCounter().GetValue()
+			}
+			return m.GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestDispatcher_Metrics_CountDeliveryOutcomes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	core.MustRegisterMetrics(reg)
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+	// The collectors are shared by every dispatcher in the process, so compare
+	// against the values before this test.
+	count := func(result string) float64 {
+		return metricValue(t, reg, "notifier_deliveries_total", "channel", "sms", "result", result)
+	}
+	delivered, failed, dead := count("delivered"), count("failed"), count("dead_lettered")
+
+	disp.ProcessWithDLQ(ctx, message("1-0", "ok"))
+	d.err = fmt.Errorf("gateway down")
+	disp.ProcessWithDLQ(ctx, message("2-0", "retry me"))
+	rc.Set(ctx, "notifications:attempts:3-0", 3, 0)
+	disp.ProcessWithDLQ(ctx, message("3-0", "give up"))
+
+	assert.Equal(t, delivered+1, count("delivered"))
+	assert.Equal(t, failed+1, count("failed"))
+	assert.Equal(t, dead+1, count("dead_lettered"))
+	assert.Equal(t, 1.0, metricValue(t, reg, "notifier_dlq_length"))
+
+	replayed, err := disp.ReplayDLQ(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, replayed)
+	assert.Equal(t, 0.0, metricValue(t, reg, "notifier_dlq_length"))
+}
+
+// latencyCount returns how many sms delivery latencies reg has observed, and
+// their sum in seconds.
+func latencyCount(t *testing.T, reg *prometheus.Registry) (uint64, float64) {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != "notifier_delivery_latency_seconds" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if m.GetLabel()[0].GetValue() == "sms" {
+				return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+			}
+		}
+	}
+	return 0, 0
+}
+
+func TestDispatcher_Metrics_ObserveDeliveryLatency(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	core.MustRegisterMetrics(reg)
+	d := &fakeDeliverer{}
+	disp, _ := newDispatcher(t, d)
+	ctx := context.Background()
+	count, sum := latencyCount(t, reg)
+
+	msg := message("1-0", "hello")
+	msg.Values["created_at"] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano)
+	disp.ProcessWithDLQ(ctx, msg)
+
+	gotCount, gotSum := latencyCount(t, reg)
+	assert.Equal(t, count+1, gotCount)
+	assert.InDelta(t, 60, gotSum-sum, 5)
+}
+
+func TestDispatcher_ProcessWithDLQ_WithoutCreatedAt(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	core.MustRegisterMetrics(reg)
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	ctx := context.Background()
+	count, _ := latencyCount(t, reg)
+
+	disp.ProcessWithDLQ(ctx, message("1-0", "from an older publisher"))
+
+	assert.Equal(t, []string{"from an older publisher"}, d.contents())
+	assert.Zero(t, rc.Exists(ctx, "notifications:attempts:1-0").Val())
+	gotCount, _ := latencyCount(t, reg)
+	assert.Equal(t, count, gotCount, "no latency without created_at")
+}
+
+func TestDispatcher_ProcessWithDLQ_ContinuesPublisherTrace(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	_, err := tracing.Setup(context.Background(), "", "notifier-test")
+	require.NoError(t, err)
+
+	disp, rc := newDispatcher(t, &fakeDeliverer{})
+	ctx := context.Background()
+	require.NoError(t, publisher.NewFromClient(rc).Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "sms", Content: "hi"}))
+	msgs, err := rc.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+
+	disp.ProcessWithDLQ(ctx, msgs[0])
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		spans[s.Name()] = s
+	}
+	pub, cons := spans["publisher.stream_xadd"], spans["consumer.process"]
+	require.NotNil(t, pub)
+	require.NotNil(t, cons)
+	assert.Equal(t, pub.SpanContext().TraceID(), cons.SpanContext().TraceID())
+	assert.Equal(t, pub.SpanContext().SpanID(), cons.Parent().SpanID(), "consumer span is a child of the publish span")
+}
+
+func TestDispatcher_WithSignatureVerification_DeliversSignedMessage(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	key := []byte("stream-secret")
+	disp.WithSignatureVerification(key)
+	ctx := context.Background()
+	require.NoError(t, publisher.NewFromClient(rc).WithSigningKey(key).
+		Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "sms", Content: "hi"}))
+	msgs, err := rc.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+
+	disp.ProcessWithDLQ(ctx, msgs[0])
+
+	assert.Equal(t, []string{"hi"}, d.contents())
+}
+
+func TestDispatcher_WithSignatureVerification_DeadLettersTamperedMessage(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	key := []byte("stream-secret")
+	disp.WithSignatureVerification(key)
+	ctx := context.Background()
+	require.NoError(t, publisher.NewFromClient(rc).WithSigningKey(key).
+		Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "sms", Content: "hi"}))
+	msgs, err := rc.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	msg := msgs[0]
+	msg.Values["content"] = "tampered"
+
+	disp.ProcessWithDLQ(ctx, msg)
+
+	assert.Empty(t, d.contents(), "not delivered")
+	dlq, err := rc.XRange(ctx, publisher.DLQStreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, dlq, 1, "dead-lettered on the first attempt")
+	assert.Equal(t, "invalid signature", dlq[0].Values["dlq_reason"])
+}
+
+func TestDispatcher_WithSignatureVerification_RejectsUnsignedMessage(t *testing.T) {
+	d := &fakeDeliverer{}
+	disp, rc := newDispatcher(t, d)
+	disp.WithSignatureVerification([]byte("stream-secret"))
+	ctx := context.Background()
+
+	disp.ProcessWithDLQ(ctx, message("1-0", "hello"))
+
+	assert.Empty(t, d.contents())
+	assert.Equal(t, int64(1), rc.XLen(ctx, publisher.DLQStreamName).Val())
+}
@@ -0,0 +1,43 @@
+package core
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// The dispatcher metrics are shared by every Dispatcher in the process (one
+// per channel) and labelled by channel. They are always updated;
+// MustRegisterMetrics exposes them on a registry.
+var (
+	deliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifier_deliveries_total",
+		Help: "Delivery outcomes, by channel and result (delivered, failed, expired, dead_lettered, duplicate).",
+	}, []string{"channel", "result"})
+	reclaimed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifier_reclaimed_total",
+		Help: "Messages reclaimed from the pending entries list, by channel.",
+	}, []string{"channel"})
+	deliveryLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "notifier_delivery_latency_seconds",
+		Help:    "Time from a notification's creation to its delivery, by channel. Includes any deferral and retries.",
+		Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600},
+	}, []string{"channel"})
+	dlqLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "notifier_dlq_length",
+		Help: "Number of entries in the dead-letter stream, refreshed on every reclaim tick, DLQ write and replay.",
+	})
+)
+
+// Values of the deliveries "result" label.
+const (
+	resultDelivered    = "delivered"
+	resultFailed       = "failed"
+	resultExpired      = "expired"
+	resultDeadLettered = "dead_lettered"
+	resultDuplicate    = "duplicate"
+	resultRejected     = "rejected" // failed signature verification
+)
+
+// MustRegisterMetrics registers the dispatcher metrics with reg (typically
+// prometheus.DefaultRegisterer). Call it once per process; it panics if they
+// are already registered.
+func MustRegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(deliveries, reclaimed, deliveryLatency, dlqLength)
+}
@@ -0,0 +1,266 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/allerac/notifier/internal/channels"
+	"github.com/allerac/notifier/internal/consumers/core"
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+const (
+	channelName    = "discord"
+	consumerGroup  = "discord-group"
+	requestTimeout = 10 * time.Second
+
+	discordBaseURL = "https://discord.com/api/v10"
+
+	// Discord 429 handling: resend in place at most maxRateLimitRetries times,
+	// and never wait longer than maxRetryAfterWait for a single retry_after.
+	maxRateLimitRetries = 3
+	maxRetryAfterWait   = 30 * time.Second
+
+	// maxMessageLen is the longest content Discord accepts in one message.
+	maxMessageLen = 2000
+)
+
+// DBPool is the subset of pgxpool.Pool used by the Consumer.
+type DBPool interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Consumer reads "discord" notifications from the Redis Stream and posts them
+// to the user's Discord webhook, or with the bot to the user's Discord channel
+// when they have no webhook. Stream handling comes from the embedded
+// Dispatcher.
+type Consumer struct {
+	*core.Dispatcher
+
+	db         DBPool
+	botToken   string
+	baseURL    string
+	httpClient *http.Client
+
+	mu           sync.Mutex
+	blockedUntil time.Time // set when Discord reports the rate-limit bucket exhausted
+}
+
+// New creates a Consumer that posts as the bot authenticated by botToken. An
+// empty botToken limits it to users with a webhook URL.
+func New(redisURL, botToken string, db DBPool) (*Consumer, error) {
+	return NewForTest(redisURL, botToken, db, discordBaseURL)
+}
+
+// NewFromSentinel is New for a Sentinel-managed Redis master (see
+// publisher.SentinelClient).
+func NewFromSentinel(masterName string, sentinelAddrs []string, opts *redis.Options, botToken string, db DBPool) (*Consumer, error) {
+	client, err := publisher.SentinelClient(masterName, sentinelAddrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newConsumer(client, botToken, db, discordBaseURL), nil
+}
+
+// NewForTest creates a Consumer that talks to baseURL instead of the Discord API.
+func NewForTest(redisURL, botToken string, db DBPool, baseURL string) (*Consumer, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	return newConsumer(redis.NewClient(opts), botToken, db, baseURL), nil
+}
+
+func newConsumer(client *redis.Client, botToken string, db DBPool, baseURL string) *Consumer {
+	c := &Consumer{
+		db:         db,
+		botToken:   botToken,
+		baseURL:    baseURL,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}
+	c.Dispatcher = core.New(client, channelName, consumerGroup, c).WithDeadLetterDB(db).WithDeliveryReceipts(db)
+	return c
+}
+
+// Deliver implements core.Deliverer.
+func (c *Consumer) Deliver(ctx context.Context, msg redis.XMessage) error {
+	return c.ProcessMessage(ctx, msg)
+}
+
+// ProcessMessage posts a single stream message to the user's Discord webhook
+// or channel. Content longer than maxMessageLen is split (on rune boundaries,
+// preferring line and sentence breaks) and posted as consecutive messages in
+// order; the first part that fails aborts the rest and its error is returned
+// so the message is retried as a whole. Exported for testing.
+func (c *Consumer) ProcessMessage(ctx context.Context, msg redis.XMessage) error {
+	userID, _ := msg.Values["user_id"].(string)
+	content, _ := msg.Values["content"].(string)
+
+	channelID, webhookURL, err := c.getTarget(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("get discord channel for user %s: %w", userID, err)
+	}
+
+	url, auth := webhookURL, ""
+	if url == "" {
+		if c.botToken == "" {
+			return fmt.Errorf("user %s has no discord webhook and DISCORD_BOT_TOKEN is not set", userID)
+		}
+		url, auth = fmt.Sprintf("%s/channels/%s/messages", c.baseURL, channelID), "Bot "+c.botToken
+	}
+
+	c.MessageLogger(msg).Info("delivering message", slog.String("message_id", msg.ID), slog.String("user_id", userID))
+	chunks := channels.SplitText(content, maxMessageLen)
+	for i, chunk := range chunks {
+		body, err := json.Marshal(map[string]string{"content": chunk})
+		if err != nil {
+			return fmt.Errorf("marshal payload: %w", err)
+		}
+		if err := c.send(ctx, url, auth, body); err != nil {
+			if len(chunks) > 1 {
+				return fmt.Errorf("part %d/%d: %w", i+1, len(chunks), err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// getTarget returns the user's Discord channel ID and webhook URL; either may
+// be empty.
+func (c *Consumer) getTarget(ctx context.Context, userID string) (channelID, webhookURL string, err error) {
+	err = c.db.QueryRow(ctx, `
+		SELECT COALESCE(channel_id, ''), COALESCE(webhook_url, '')
+		FROM user_discord_channels
+		WHERE user_id = $1 AND enabled = true
+		LIMIT 1
+	`, userID).Scan(&channelID, &webhookURL)
+	return channelID, webhookURL, err
+}
+
+// send posts body to url, with auth as the Authorization header unless it is
+// empty (webhook URLs carry their own token). It first waits out a rate-limit
+// bucket that an earlier response reported as exhausted. When Discord answers
+// 429 it waits for the advertised retry_after and resends in place, so a
+// rate-limit burst does not use up the message's delivery attempts. Waits
+// longer than maxRetryAfterWait, or more than maxRateLimitRetries in a row,
+// are returned as errors instead.
+func (c *Consumer) send(ctx context.Context, url, auth string, body []byte) error {
+	for retries := 0; ; retries++ {
+		if err := c.waitForBucket(ctx); err != nil {
+			return err
+		}
+		retryAfter, err := c.post(ctx, url, auth, body)
+		if err == nil || retryAfter == 0 {
+			return err
+		}
+		if retries >= maxRateLimitRetries {
+			return fmt.Errorf("%w (gave up after %d rate-limit retries)", err, retries)
+		}
+		if retryAfter > maxRetryAfterWait {
+			return fmt.Errorf("%w (retry_after %s exceeds cap %s)", err, retryAfter, maxRetryAfterWait)
+		}
+		c.Logger().Warn("rate limited, retrying", slog.Duration("retry_in", retryAfter))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+// waitForBucket sleeps until the rate-limit bucket reported exhausted by an
+// earlier response has reset.
+func (c *Consumer) waitForBucket(ctx context.Context) error {
+	c.mu.Lock()
+	wait := time.Until(c.blockedUntil)
+	c.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	wait = min(wait, maxRetryAfterWait)
+	c.Logger().Debug("rate-limit bucket exhausted, waiting", slog.Duration("wait", wait))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// post performs one API call. On HTTP 429 it also returns how long Discord
+// asked us to wait before retrying.
+func (c *Consumer) post(ctx context.Context, url, auth string, body []byte) (retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("discord request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// X-RateLimit-Remaining: 0 means the next request in this bucket would be
+	// rejected until X-RateLimit-Reset-After (seconds, fractional) has passed.
+	resetAfter := parseSeconds(resp.Header.Get("X-RateLimit-Reset-After"))
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" && resetAfter > 0 {
+		c.mu.Lock()
+		c.blockedUntil = time.Now().Add(resetAfter)
+		c.mu.Unlock()
+	}
+
+	// Bot messages answer 200 with the message, webhooks 204 No Content.
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
+		return 0, nil
+	}
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 512)) // body is best-effort diagnostics
+	var apiErr struct {
+		Message    string  `json:"message"`
+		RetryAfter float64 `json:"retry_after"`
+	}
+	_ = json.Unmarshal(raw, &apiErr)
+	err = fmt.Errorf("discord API returned %d", resp.StatusCode)
+	if apiErr.Message != "" {
+		err = fmt.Errorf("discord API returned %d: %s", resp.StatusCode, apiErr.Message)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter = time.Duration(apiErr.RetryAfter * float64(time.Second))
+		if retryAfter <= 0 {
+			retryAfter = resetAfter
+		}
+		if retryAfter <= 0 {
+			retryAfter = time.Second
+		}
+	}
+	return retryAfter, err
+}
+
+// parseSeconds parses a header value in (possibly fractional) seconds, such
+// as "1.250"; anything unparsable is 0.
+func parseSeconds(v string) time.Duration {
+	s, err := strconv.ParseFloat(v, 64)
+	if err != nil || s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,302 @@
+package discord_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/consumers/discord"
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+// --- mock DB ---
+
+// mockDB returns a fixed Discord channel ID and webhook URL for every user.
+type mockDB struct {
+	channelID  string
+	webhookURL string
+	err        error
+}
+
+func (m *mockDB) QueryRow(_ context.Context, _ string, _ ...any) pgx.Row {
+	return &mockRow{db: m}
+}
+
+func (m *mockDB) Exec(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+type mockRow struct{ db *mockDB }
+
+func (r *mockRow) Scan(dest ...any) error {
+	if r.db.err != nil {
+		return r.db.err
+	}
+	*dest[0].(*string) = r.db.channelID
+	*dest[1].(*string) = r.db.webhookURL
+	return nil
+}
+
+// request is one call received by discordServer.
+type request struct {
+	path    string
+	auth    string
+	content string
+	at      time.Time
+}
+
+// discordServer is a mock Discord API; respond decides the answer to the n-th
+// call (1-based).
+type discordServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []request
+}
+
+func newDiscordServer(t *testing.T, respond func(n int, w http.ResponseWriter)) *discordServer {
+	t.Helper()
+	s := &discordServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		s.mu.Lock()
+		s.requests = append(s.requests, request{
+			path: r.URL.Path, auth: r.Header.Get("Authorization"), content: body["content"], at: time.Now(),
+		})
+		n := len(s.requests)
+		s.mu.Unlock()
+		respond(n, w)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *discordServer) received() []request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]request(nil), s.requests...)
+}
+
+func ok(_ int, w http.ResponseWriter) {
+	w.Write([]byte(`{"id":"1"}`))
+}
+
+// --- helpers ---
+
+func newTestConsumer(t *testing.T, mr *miniredis.Miniredis, db *mockDB, baseURL string) *discord.Consumer {
+	t.Helper()
+	c, err := discord.NewForTest("redis://"+mr.Addr(), "bot-token", db, baseURL)
+	require.NoError(t, err)
+	return c
+}
+
+func xMessage(userID, content string) redis.XMessage {
+	return redis.XMessage{
+		ID: "1-0",
+		Values: map[string]interface{}{
+			"job_id":  "job-1",
+			"user_id": userID,
+			"channel": "discord",
+			"content": content,
+		},
+	}
+}
+
+// --- tests ---
+
+func TestConsumer_ProcessMessage_PostsToUserChannel(t *testing.T) {
+	srv := newDiscordServer(t, ok)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{channelID: "123456"}, srv.URL)
+
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "Good morning!")))
+
+	reqs := srv.received()
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "/channels/123456/messages", reqs[0].path)
+	assert.Equal(t, "Bot bot-token", reqs[0].auth)
+	assert.Equal(t, "Good morning!", reqs[0].content)
+}
+
+func TestConsumer_ProcessMessage_PostsToUserWebhook(t *testing.T) {
+	srv := newDiscordServer(t, func(_ int, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mr := miniredis.RunT(t)
+	db := &mockDB{channelID: "123456", webhookURL: srv.URL + "/api/webhooks/42/secret"}
+	c, err := discord.NewForTest("redis://"+mr.Addr(), "", db, "http://unused")
+	require.NoError(t, err)
+
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "Good morning!")))
+
+	reqs := srv.received()
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "/api/webhooks/42/secret", reqs[0].path)
+	assert.Empty(t, reqs[0].auth)
+	assert.Equal(t, "Good morning!", reqs[0].content)
+}
+
+func TestConsumer_ProcessMessage_ChannelWithoutBotToken(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c, err := discord.NewForTest("redis://"+mr.Addr(), "", &mockDB{channelID: "123456"}, "http://unused")
+	require.NoError(t, err)
+
+	err = c.ProcessMessage(context.Background(), xMessage("user-1", "hi"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "DISCORD_BOT_TOKEN is not set")
+}
+
+func TestConsumer_ProcessMessage_NoChannel(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{err: pgx.ErrNoRows}, "http://unused")
+
+	err := c.ProcessMessage(context.Background(), xMessage("unknown-user", "hi"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "get discord channel")
+}
+
+func TestConsumer_ProcessMessage_DiscordError(t *testing.T) {
+	srv := newDiscordServer(t, func(_ int, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message":"Missing Access","code":50001}`))
+	})
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{channelID: "123456"}, srv.URL)
+
+	err := c.ProcessMessage(context.Background(), xMessage("user-1", "hi"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+	assert.Contains(t, err.Error(), "Missing Access")
+}
+
+func TestConsumer_ProcessMessage_SplitsLongContent(t *testing.T) {
+	srv := newDiscordServer(t, ok)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{channelID: "123456"}, srv.URL)
+
+	// Multi-byte runes; 1500 + 1500 runes does not fit in one message.
+	first := strings.Repeat("é", 1499) + "."
+	second := strings.Repeat("ü", 1500)
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", first+"\n"+second)))
+
+	reqs := srv.received()
+	require.Len(t, reqs, 2, "posted as two messages")
+	assert.Equal(t, first, reqs[0].content, "split at the line break, in order")
+	assert.Equal(t, second, reqs[1].content)
+}
+
+func TestConsumer_ProcessMessage_FailedPartFailsMessage(t *testing.T) {
+	srv := newDiscordServer(t, func(n int, w http.ResponseWriter) {
+		if n == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ok(n, w)
+	})
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{channelID: "123456"}, srv.URL)
+
+	err := c.ProcessMessage(context.Background(), xMessage("user-1", strings.Repeat("word ", 1500)))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "part 2/4")
+	assert.Len(t, srv.received(), 2, "stops at the failing part")
+}
+
+func TestConsumer_ProcessMessage_RetriesInPlaceOnRateLimit(t *testing.T) {
+	srv := newDiscordServer(t, func(n int, w http.ResponseWriter) {
+		if n == 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset-After", "0.3")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message":"You are being rate limited.","retry_after":0.3,"global":false}`))
+			return
+		}
+		ok(n, w)
+	})
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{channelID: "123456"}, srv.URL)
+	ctx := context.Background()
+
+	c.ProcessWithDLQ(ctx, xMessage("user-1", "hi"))
+
+	reqs := srv.received()
+	require.Len(t, reqs, 2)
+	assert.GreaterOrEqual(t, reqs[1].at.Sub(reqs[0].at), 300*time.Millisecond, "waited retry_after")
+	// Delivered on the first attempt: the attempts counter was cleaned up, not bumped twice.
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	assert.Equal(t, int64(0), rc.Exists(ctx, "notifications:attempts:1-0").Val())
+}
+
+func TestConsumer_ProcessMessage_WaitsForExhaustedBucket(t *testing.T) {
+	srv := newDiscordServer(t, func(n int, w http.ResponseWriter) {
+		if n == 1 {
+			// Accepted, but the bucket is now empty for the next 300ms.
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset-After", "0.3")
+		}
+		ok(n, w)
+	})
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{channelID: "123456"}, srv.URL)
+
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "first")))
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "second")))
+
+	reqs := srv.received()
+	require.Len(t, reqs, 2)
+	assert.GreaterOrEqual(t, reqs[1].at.Sub(reqs[0].at), 300*time.Millisecond, "second send waited for the reset")
+}
+
+func TestConsumer_ProcessMessage_RateLimitWaitIsCapped(t *testing.T) {
+	var calls atomic.Int32
+	srv := newDiscordServer(t, func(_ int, w http.ResponseWriter) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"message":"You are being rate limited.","retry_after":3600}`))
+	})
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{channelID: "123456"}, srv.URL)
+	start := time.Now()
+
+	err := c.ProcessMessage(context.Background(), xMessage("user-1", "hi"))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds cap")
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Less(t, time.Since(start), time.Second, "did not sleep for the huge retry_after")
+}
+
+func TestConsumer_ProcessWithDLQ_MovesToDLQAfterMaxAttempts(t *testing.T) {
+	srv := newDiscordServer(t, func(_ int, w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) })
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{channelID: "123456"}, srv.URL)
+	ctx := context.Background()
+	msg := xMessage("user-1", "Hello!")
+
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	rc.Set(ctx, "notifications:attempts:"+msg.ID, 3, 0)
+
+	c.ProcessWithDLQ(ctx, msg)
+
+	dlqMsgs, err := rc.XRange(ctx, publisher.DLQStreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, dlqMsgs, 1)
+	assert.Equal(t, "discord-group", dlqMsgs[0].Values["dlq_consumer_group"])
+	assert.Equal(t, msg.ID, dlqMsgs[0].Values["dlq_original_id"])
+}
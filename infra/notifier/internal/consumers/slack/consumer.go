@@ -0,0 +1,148 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/allerac/notifier/internal/consumers/core"
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+const (
+	channelName    = "slack"
+	consumerGroup  = "slack-group"
+	requestTimeout = 10 * time.Second
+)
+
+// DBPool is the subset of pgxpool.Pool used by the Consumer.
+type DBPool interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Payload is the JSON body POSTed to a Slack Incoming Webhook.
+type Payload struct {
+	Text     string `json:"text"`
+	Username string `json:"username,omitempty"`
+	Channel  string `json:"channel,omitempty"`
+}
+
+// Consumer reads "slack" notifications from the Redis Stream and posts them
+// to the user's Slack Incoming Webhook. Stream handling comes from the
+// embedded Dispatcher.
+type Consumer struct {
+	*core.Dispatcher
+
+	db         DBPool
+	webhookURL string
+	httpClient *http.Client
+}
+
+// New creates a Consumer. webhookURL is used for users without a row in
+// user_slack_webhooks; leave it empty to require one per user (a shared
+// webhook posts every user's notifications to the same workspace).
+func New(redisURL, webhookURL string, db DBPool) (*Consumer, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	return newConsumer(redis.NewClient(opts), webhookURL, db), nil
+}
+
+// NewFromSentinel is New for a Sentinel-managed Redis master (see
+// publisher.SentinelClient).
+func NewFromSentinel(masterName string, sentinelAddrs []string, opts *redis.Options, webhookURL string, db DBPool) (*Consumer, error) {
+	client, err := publisher.SentinelClient(masterName, sentinelAddrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newConsumer(client, webhookURL, db), nil
+}
+
+func newConsumer(client *redis.Client, webhookURL string, db DBPool) *Consumer {
+	c := &Consumer{
+		db:         db,
+		webhookURL: webhookURL,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}
+	c.Dispatcher = core.New(client, channelName, consumerGroup, c).WithDeadLetterDB(db).WithDeliveryReceipts(db)
+	return c
+}
+
+// Deliver implements core.Deliverer.
+func (c *Consumer) Deliver(ctx context.Context, msg redis.XMessage) error {
+	return c.ProcessMessage(ctx, msg)
+}
+
+// ProcessMessage posts a single stream message to the user's Slack webhook. Exported for testing.
+func (c *Consumer) ProcessMessage(ctx context.Context, msg redis.XMessage) error {
+	userID, _ := msg.Values["user_id"].(string)
+	jobName, _ := msg.Values["job_name"].(string)
+	content, _ := msg.Values["content"].(string)
+
+	t, err := c.getTarget(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("get slack webhook for user %s: %w", userID, err)
+	}
+
+	body, err := json.Marshal(Payload{Text: content, Username: jobName, Channel: t.channel})
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+
+	c.MessageLogger(msg).Info("delivering message", slog.String("message_id", msg.ID), slog.String("user_id", userID))
+	return c.post(ctx, t.url, body)
+}
+
+// target is where a user's Slack messages go.
+type target struct {
+	url     string
+	channel string // e.g. "#alerts"; empty posts to the webhook's default channel
+}
+
+func (c *Consumer) getTarget(ctx context.Context, userID string) (target, error) {
+	var t target
+	err := c.db.QueryRow(ctx, `
+		SELECT webhook_url, COALESCE(slack_channel, '')
+		FROM user_slack_webhooks
+		WHERE user_id = $1 AND enabled = true
+		LIMIT 1
+	`, userID).Scan(&t.url, &t.channel)
+	if errors.Is(err, pgx.ErrNoRows) && c.webhookURL != "" {
+		return target{url: c.webhookURL}, nil
+	}
+	return t, err
+}
+
+func (c *Consumer) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("slack request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Slack answers "ok" with 200; errors such as "channel_not_found" or
+	// "invalid_token" come with a 4xx and the reason in the body.
+	if resp.StatusCode != http.StatusOK {
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("slack returned %d: %s", resp.StatusCode, bytes.TrimSpace(reason))
+	}
+	return nil
+}
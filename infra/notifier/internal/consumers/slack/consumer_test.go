@@ -0,0 +1,210 @@
+package slack_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/consumers/slack"
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+// --- mock DB ---
+
+// mockDB returns a fixed webhook URL and channel override for every user.
+type mockDB struct {
+	url     string
+	channel string
+	err     error
+}
+
+func (m *mockDB) QueryRow(_ context.Context, _ string, _ ...any) pgx.Row {
+	return &mockRow{db: m}
+}
+
+func (m *mockDB) Exec(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+type mockRow struct{ db *mockDB }
+
+func (r *mockRow) Scan(dest ...any) error {
+	if r.db.err != nil {
+		return r.db.err
+	}
+	*dest[0].(*string) = r.db.url
+	*dest[1].(*string) = r.db.channel
+	return nil
+}
+
+// slackServer is a mock Incoming Webhook endpoint that records the bodies it receives.
+type slackServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	bodies []map[string]string
+}
+
+func newSlackServer(t *testing.T, status int) *slackServer {
+	t.Helper()
+	s := &slackServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		raw, _ := io.ReadAll(r.Body)
+		var body map[string]string
+		assert.NoError(t, json.Unmarshal(raw, &body))
+		s.mu.Lock()
+		s.bodies = append(s.bodies, body)
+		s.mu.Unlock()
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			fmt.Fprint(w, "ok")
+		} else {
+			fmt.Fprint(w, "channel_not_found")
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *slackServer) received() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]string(nil), s.bodies...)
+}
+
+// --- helpers ---
+
+func newTestConsumer(t *testing.T, mr *miniredis.Miniredis, webhookURL string, db *mockDB) *slack.Consumer {
+	t.Helper()
+	c, err := slack.New("redis://"+mr.Addr(), webhookURL, db)
+	require.NoError(t, err)
+	return c
+}
+
+func xMessage(userID, content string) redis.XMessage {
+	return redis.XMessage{
+		ID: "1-0",
+		Values: map[string]interface{}{
+			"job_id":   "job-1",
+			"job_name": "Morning Briefing",
+			"user_id":  userID,
+			"channel":  "slack",
+			"content":  content,
+		},
+	}
+}
+
+// --- tests ---
+
+func TestConsumer_ProcessMessage_PostsToUserWebhook(t *testing.T) {
+	srv := newSlackServer(t, http.StatusOK)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, "", &mockDB{url: srv.URL})
+
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "Good morning!")))
+
+	bodies := srv.received()
+	require.Len(t, bodies, 1)
+	assert.Equal(t, map[string]string{"text": "Good morning!", "username": "Morning Briefing"}, bodies[0])
+}
+
+func TestConsumer_ProcessMessage_ChannelOverride(t *testing.T) {
+	srv := newSlackServer(t, http.StatusOK)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, "", &mockDB{url: srv.URL, channel: "#alerts"})
+
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "Disk almost full")))
+
+	bodies := srv.received()
+	require.Len(t, bodies, 1)
+	assert.Equal(t, "#alerts", bodies[0]["channel"])
+	assert.Equal(t, "Disk almost full", bodies[0]["text"])
+}
+
+func TestConsumer_ProcessMessage_FallsBackToDefaultWebhook(t *testing.T) {
+	srv := newSlackServer(t, http.StatusOK)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, srv.URL, &mockDB{err: pgx.ErrNoRows})
+
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "hi")))
+
+	assert.Len(t, srv.received(), 1)
+}
+
+func TestConsumer_ProcessMessage_NoWebhook(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, "", &mockDB{err: pgx.ErrNoRows})
+
+	err := c.ProcessMessage(context.Background(), xMessage("unknown-user", "hi"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "get slack webhook")
+}
+
+func TestConsumer_ProcessMessage_SlackError(t *testing.T) {
+	srv := newSlackServer(t, http.StatusNotFound)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, "", &mockDB{url: srv.URL, channel: "#gone"})
+
+	err := c.ProcessMessage(context.Background(), xMessage("user-1", "hi"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+	assert.Contains(t, err.Error(), "channel_not_found")
+}
+
+func TestConsumer_ProcessWithDLQ_MovesToDLQAfterMaxAttempts(t *testing.T) {
+	srv := newSlackServer(t, http.StatusInternalServerError)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, "", &mockDB{url: srv.URL})
+	ctx := context.Background()
+	msg := xMessage("user-1", "Hello!")
+
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	rc.Set(ctx, "notifications:attempts:"+msg.ID, 3, 0)
+
+	c.ProcessWithDLQ(ctx, msg)
+
+	dlqMsgs, err := rc.XRange(ctx, publisher.DLQStreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, dlqMsgs, 1)
+	assert.Equal(t, "slack-group", dlqMsgs[0].Values["dlq_consumer_group"])
+	assert.Equal(t, msg.ID, dlqMsgs[0].Values["dlq_original_id"])
+}
+
+func TestConsumer_DeliversFromStream(t *testing.T) {
+	srv := newSlackServer(t, http.StatusOK)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, "", &mockDB{url: srv.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, c.Start(ctx))
+	defer c.Close()
+
+	pub, err := publisher.New("redis://" + mr.Addr())
+	require.NoError(t, err)
+	defer pub.Close()
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", JobName: "Standup", UserID: "user-1", Channel: "telegram", Content: "not for slack",
+	}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", JobName: "Standup", UserID: "user-1", Channel: "slack", Content: "Standup in 5 minutes",
+	}))
+
+	require.Eventually(t, func() bool { return len(srv.received()) == 1 }, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, map[string]string{"text": "Standup in 5 minutes", "username": "Standup"}, srv.received()[0])
+	require.NoError(t, c.Stop(context.Background()))
+}
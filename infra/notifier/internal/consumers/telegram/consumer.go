@@ -5,184 +5,197 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"html"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/redis/go-redis/v9"
 
+	"github.com/allerac/notifier/internal/consumers/core"
 	"github.com/allerac/notifier/internal/crypto"
 	"github.com/allerac/notifier/internal/publisher"
 )
 
 const (
-	consumerGroup       = "telegram-group"
-	consumerName        = "notifier-consumer-1"
-	maxDeliveryAttempts = 3
-	reclaimInterval     = time.Minute
-	minIdleBeforeReclaim = 5 * time.Minute
+	channelName   = "telegram"
+	consumerGroup = "telegram-group"
+
+	telegramAPIURL = "https://api.telegram.org"
+
+	// Telegram 429 handling: resend in place at most maxRateLimitRetries times,
+	// and never wait longer than maxRetryAfterWait for a single retry_after.
+	maxRateLimitRetries = 3
+	maxRetryAfterWait   = 30 * time.Second
+
+	// maxMessageLen is the longest text the Bot API accepts in one sendMessage.
+	maxMessageLen = 4096
+
+	// sentKeyPrefix + stream:ID records that a message is being sent
+	// (deliverySending, for up to sendingTTL) or has been (deliverySent, for
+	// sentTTL), so a reclaimed message is not sent twice. The stream is part
+	// of the key because IDs are only unique within one stream.
+	sentKeyPrefix   = "notifications:delivered:"
+	deliverySending = "sending"
+	deliverySent    = "1"
+	sendingTTL      = 5 * time.Minute
+	sentTTL         = 24 * time.Hour
 )
 
 // DBPool is the subset of pgxpool.Pool used by the Consumer.
 type DBPool interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
 }
 
 // Consumer reads notifications from the Redis Stream and delivers them via Telegram.
+// Stream handling (Start, Stop, retries, DLQ) comes from the embedded Dispatcher.
 type Consumer struct {
+	*core.Dispatcher
+
 	redis           *redis.Client
 	db              DBPool
 	encryptionKey   string
+	webhookSecret   string // see WithWebhookSecret
 	telegramBaseURL string
 	httpClient      *http.Client
+	metrics         metrics
 }
 
 // New creates a Consumer using the production Telegram API.
 func New(redisURL string, db DBPool, encryptionKey string) (*Consumer, error) {
-	return newConsumer(redisURL, db, encryptionKey, "https://api.telegram.org")
+	return NewForTest(redisURL, db, encryptionKey, telegramAPIURL)
 }
 
-// NewForTest creates a Consumer with a custom Telegram API base URL, useful in tests.
-func NewForTest(redisURL string, db DBPool, encryptionKey, telegramBaseURL string) (*Consumer, error) {
-	return newConsumer(redisURL, db, encryptionKey, telegramBaseURL)
+// NewFromSentinel is New for a Sentinel-managed Redis master (see
+// publisher.SentinelClient).
+func NewFromSentinel(masterName string, sentinelAddrs []string, opts *redis.Options, db DBPool, encryptionKey string) (*Consumer, error) {
+	client, err := publisher.SentinelClient(masterName, sentinelAddrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newConsumer(client, db, encryptionKey, telegramAPIURL), nil
 }
 
-func newConsumer(redisURL string, db DBPool, encryptionKey, telegramBaseURL string) (*Consumer, error) {
+// NewForTest creates a Consumer with a custom Telegram API base URL, useful in tests.
+func NewForTest(redisURL string, db DBPool, encryptionKey, telegramBaseURL string) (*Consumer, error) {
 	opts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, fmt.Errorf("parse redis url: %w", err)
 	}
-	return &Consumer{
-		redis:           redis.NewClient(opts),
+	return newConsumer(redis.NewClient(opts), db, encryptionKey, telegramBaseURL), nil
+}
+
+func newConsumer(client *redis.Client, db DBPool, encryptionKey, telegramBaseURL string) *Consumer {
+	c := &Consumer{
+		redis:           client,
 		db:              db,
 		encryptionKey:   encryptionKey,
 		telegramBaseURL: telegramBaseURL,
 		httpClient:      &http.Client{Timeout: 10 * time.Second},
-	}, nil
-}
-
-// Start creates the consumer group (if needed) and begins consuming in background goroutines.
-func (c *Consumer) Start(ctx context.Context) error {
-	err := c.redis.XGroupCreateMkStream(ctx, publisher.StreamName, consumerGroup, "$").Err()
-	if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
-		return fmt.Errorf("create consumer group: %w", err)
+		metrics:         newMetrics(),
 	}
-	log.Printf("[telegram-consumer] Started, listening on stream %q", publisher.StreamName)
-	go c.consume(ctx)
-	go c.reclaimLoop(ctx)
-	return nil
+	c.Dispatcher = core.New(client, channelName, consumerGroup, c).
+		WithDeadLetterHook(func(redis.XMessage, string) { c.metrics.dlq.Inc() }).
+		WithDeadLetterDB(db).
+		WithDeliveryReceipts(db)
+	return c
 }
 
-// consume reads new messages from the stream in a loop.
-func (c *Consumer) consume(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
-
-		msgs, err := c.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
-			Group:    consumerGroup,
-			Consumer: consumerName,
-			Streams:  []string{publisher.StreamName, ">"},
-			Count:    10,
-			Block:    5 * time.Second,
-		}).Result()
+// WithStopTimeout sets how long Stop waits for in-flight deliveries before
+// giving up (default 30s).
+func (c *Consumer) WithStopTimeout(d time.Duration) *Consumer {
+	c.Dispatcher.WithStopTimeout(d)
+	return c
+}
 
-		if err != nil {
-			if err != redis.Nil && ctx.Err() == nil {
-				log.Printf("[telegram-consumer] Read error: %v", err)
-				time.Sleep(time.Second)
-			}
-			continue
-		}
+// WithWorkerCount lets up to n messages be sent at once (default 1, one after
+// the other). Messages to the same chat may then arrive out of order.
+func (c *Consumer) WithWorkerCount(n int) *Consumer {
+	c.Dispatcher.WithWorkerCount(n)
+	return c
+}
 
-		for _, stream := range msgs {
-			for _, msg := range stream.Messages {
-				channel, _ := msg.Values["channel"].(string)
-				if channel != "telegram" {
-					c.redis.XAck(ctx, publisher.StreamName, consumerGroup, msg.ID)
-					continue
-				}
-				c.ProcessWithDLQ(ctx, msg)
-			}
-		}
-	}
+// WithWebhookSecret sets the secret_token RegisterWebhookURL gives Telegram to
+// send with every update, which the WebhookHandler checks.
+func (c *Consumer) WithWebhookSecret(secret string) *Consumer {
+	c.webhookSecret = secret
+	return c
 }
 
-// reclaimLoop periodically reclaims messages that have been stuck in the PEL
-// (read but never acknowledged) longer than minIdleBeforeReclaim.
-func (c *Consumer) reclaimLoop(ctx context.Context) {
-	ticker := time.NewTicker(reclaimInterval)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			c.reclaimStuck(ctx)
-		}
-	}
+// Deliver implements core.Deliverer.
+func (c *Consumer) Deliver(ctx context.Context, msg redis.XMessage) error {
+	return c.ProcessMessage(ctx, msg)
 }
 
-func (c *Consumer) reclaimStuck(ctx context.Context) {
-	msgs, _, err := c.redis.XAutoClaim(ctx, &redis.XAutoClaimArgs{
-		Stream:   publisher.StreamName,
-		Group:    consumerGroup,
-		Consumer: consumerName,
-		MinIdle:  minIdleBeforeReclaim,
-		Start:    "0-0",
-		Count:    100,
-	}).Result()
+// ProcessMessage delivers a single stream message via Telegram. Exported for testing.
+//
+// A message already sent under its stream ID, e.g. by an instance that crashed
+// before ACKing it, is skipped and reported as delivered (see claimDelivery).
+func (c *Consumer) ProcessMessage(ctx context.Context, msg redis.XMessage) error {
+	key, err := c.claimDelivery(ctx, msg)
 	if err != nil {
-		log.Printf("[telegram-consumer] XAutoClaim error: %v", err)
-		return
+		return err
 	}
-	if len(msgs) > 0 {
-		log.Printf("[telegram-consumer] Reclaimed %d stuck message(s) from PEL", len(msgs))
-		for _, msg := range msgs {
-			c.ProcessWithDLQ(ctx, msg)
-		}
+	if key == "" {
+		c.metrics.skipped.Inc()
+		c.MessageLogger(msg).Info("message already sent, skipping delivery", slog.String("message_id", msg.ID))
+		return nil
 	}
-}
-
-// ProcessWithDLQ wraps ProcessMessage with attempt tracking and dead-letter routing.
-// On success it ACKs the message. On repeated failure it moves it to the DLQ.
-// Exported so it can be called directly in tests.
-func (c *Consumer) ProcessWithDLQ(ctx context.Context, msg redis.XMessage) {
-	attemptsKey := "notifications:attempts:" + msg.ID
-	attempts, _ := c.redis.Incr(ctx, attemptsKey).Result()
-	c.redis.Expire(ctx, attemptsKey, 24*time.Hour)
 
-	if attempts > maxDeliveryAttempts {
-		reason := fmt.Sprintf("exceeded %d delivery attempts", maxDeliveryAttempts)
-		log.Printf("[telegram-consumer] Message %s → DLQ: %s", msg.ID, reason)
-		c.moveToDLQ(ctx, msg, reason)
-		c.redis.Del(ctx, attemptsKey)
-		c.redis.XAck(ctx, publisher.StreamName, consumerGroup, msg.ID)
-		return
+	start := time.Now()
+	err = c.deliver(ctx, msg)
+	c.metrics.duration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		c.redis.Del(ctx, key)
+		return err
 	}
+	c.metrics.processed.Inc()
+	c.redis.Set(ctx, key, deliverySent, sentTTL)
+	return nil
+}
 
-	if err := c.ProcessMessage(ctx, msg); err != nil {
-		log.Printf("[telegram-consumer] Attempt %d/%d for message %s failed: %v",
-			attempts, maxDeliveryAttempts, msg.ID, err)
-		// Do NOT ACK — reclaimLoop will reclaim after minIdleBeforeReclaim
-		return
+// claimDelivery marks msg as being sent by this call, under its stream and ID,
+// for as long as the send may take. It returns the key to update once the send
+// is over, or "" if msg has already been sent. While another call holds the
+// claim it returns an error, so the message is retried rather than ACKed
+// unsent; the claim of an instance that died mid-send expires with
+// sendingTTL. A Redis error is logged and the message sent anyway: a possible
+// duplicate beats a lost notification.
+func (c *Consumer) claimDelivery(ctx context.Context, msg redis.XMessage) (string, error) {
+	key := sentKeyPrefix + publisher.StreamOf(msg.Values) + ":" + msg.ID
+	claimed, err := c.redis.SetNX(ctx, key, deliverySending, sendingTTL).Result()
+	if err != nil {
+		c.MessageLogger(msg).Warn("sent-message check failed, delivering anyway", slog.String("message_id", msg.ID), slog.Any("error", err))
+		return key, nil
 	}
-
-	c.redis.Del(ctx, attemptsKey)
-	c.redis.XAck(ctx, publisher.StreamName, consumerGroup, msg.ID)
+	if claimed {
+		return key, nil
+	}
+	if state, _ := c.redis.Get(ctx, key).Result(); state == deliverySent {
+		return "", nil
+	}
+	return "", fmt.Errorf("message %s is being sent by another consumer", msg.ID)
 }
 
-// ProcessMessage delivers a single stream message via Telegram. Exported for testing.
-func (c *Consumer) ProcessMessage(ctx context.Context, msg redis.XMessage) error {
+func (c *Consumer) deliver(ctx context.Context, msg redis.XMessage) error {
 	userID, _ := msg.Values["user_id"].(string)
 	content, _ := msg.Values["content"].(string)
+	format, _ := msg.Values["format"].(string)
+	meta := publisher.MetadataOf(msg.Values)
+	content = withSubject(meta["subject"], content, publisher.Format(format))
+	replyTo, _ := strconv.ParseInt(meta["reply_to"], 10, 64)
+	botName := botNameOf(msg.Values, meta)
 
-	chatID, encryptedToken, err := c.getChatIDAndToken(ctx, userID)
+	chatID, encryptedToken, err := c.getChatIDAndToken(ctx, userID, botName)
 	if err != nil {
+		if botName != "" {
+			return fmt.Errorf("get chat info for user %s, bot %q: %w", userID, botName, err)
+		}
 		return fmt.Errorf("get chat info for user %s: %w", userID, err)
 	}
 
@@ -191,60 +204,191 @@ func (c *Consumer) ProcessMessage(ctx context.Context, msg redis.XMessage) error
 		return fmt.Errorf("decrypt bot token for user %s: %w", userID, err)
 	}
 
-	log.Printf("[telegram-consumer] Delivering to chat_id=%d", chatID)
-	return c.sendMessage(chatID, content, botToken)
+	attrs := []any{slog.String("message_id", msg.ID), slog.Int64("chat_id", chatID)}
+	if botName != "" {
+		attrs = append(attrs, slog.String("bot_name", botName))
+	}
+	if jobMeta, _ := msg.Values["metadata"].(string); jobMeta != "" {
+		attrs = append(attrs, slog.String("job_metadata", jobMeta))
+	}
+	c.MessageLogger(msg).Info("delivering message", attrs...)
+	core.SetRecipient(ctx, strconv.FormatInt(chatID, 10))
+	messageID, err := c.sendMessage(ctx, chatID, content, publisher.Format(format), replyTo, botToken)
+	if err != nil {
+		return err
+	}
+	if messageID != 0 {
+		core.SetProviderMessageID(ctx, strconv.FormatInt(messageID, 10))
+	}
+	return nil
 }
 
-func (c *Consumer) moveToDLQ(ctx context.Context, msg redis.XMessage, reason string) {
-	values := make(map[string]interface{}, len(msg.Values)+4)
-	for k, v := range msg.Values {
-		values[k] = v
+// withSubject puts subject, if any, on a bold first line above text.
+func withSubject(subject, text string, format publisher.Format) string {
+	switch {
+	case subject == "":
+		return text
+	case format == publisher.FormatMarkdownV2:
+		return "**" + subject + "**\n\n" + text
+	case format == publisher.FormatHTML:
+		return "<b>" + html.EscapeString(subject) + "</b>\n\n" + text
+	default:
+		return subject + "\n\n" + text
 	}
-	values["dlq_reason"] = reason
-	values["dlq_original_id"] = msg.ID
-	values["dlq_consumer_group"] = consumerGroup
-	values["dlq_timestamp"] = time.Now().UTC().Format(time.RFC3339)
+}
 
-	if err := c.redis.XAdd(ctx, &redis.XAddArgs{
-		Stream: publisher.DLQStreamName,
-		MaxLen: 10000,
-		Approx: true,
-		Values: values,
-	}).Err(); err != nil {
-		log.Printf("[telegram-consumer] Failed to write message %s to DLQ: %v", msg.ID, err)
+// botNameOf returns the bot a message should be sent with: "bot_name" from
+// the notification metadata, else from the job metadata, else "" for the
+// user's default bot.
+func botNameOf(values map[string]interface{}, meta map[string]string) string {
+	if name := meta["bot_name"]; name != "" {
+		return name
+	}
+	raw, _ := values["metadata"].(string)
+	var jobMeta struct {
+		BotName string `json:"bot_name"`
 	}
+	_ = json.Unmarshal([]byte(raw), &jobMeta) // metadata is optional and free-form
+	return jobMeta.BotName
 }
 
-func (c *Consumer) getChatIDAndToken(ctx context.Context, userID string) (chatID int64, encryptedToken string, err error) {
+// getChatIDAndToken resolves the user's enabled bot named botName (their
+// oldest enabled bot when botName is "") and the chat to send to with it,
+// preferring a chat mapped to that bot over one not mapped to any bot.
+func (c *Consumer) getChatIDAndToken(ctx context.Context, userID, botName string) (chatID int64, encryptedToken string, err error) {
 	err = c.db.QueryRow(ctx, `
 		SELECT tcm.telegram_chat_id, tbc.bot_token
-		FROM telegram_chat_mapping tcm
-		JOIN telegram_bot_configs tbc ON tbc.user_id = tcm.user_id AND tbc.enabled = true
-		WHERE tcm.user_id = $1
+		FROM telegram_bot_configs tbc
+		JOIN telegram_chat_mapping tcm ON tcm.user_id = tbc.user_id AND (tcm.bot_id = tbc.id OR tcm.bot_id IS NULL)
+		WHERE tbc.user_id = $1 AND tbc.enabled = true AND ($2 = '' OR tbc.bot_name = $2)
+		ORDER BY tbc.created_at, tcm.bot_id IS NULL
 		LIMIT 1
-	`, userID).Scan(&chatID, &encryptedToken)
+	`, userID, botName).Scan(&chatID, &encryptedToken)
 	return chatID, encryptedToken, err
 }
 
-func (c *Consumer) sendMessage(chatID int64, text, botToken string) error {
+// apiResponse is the envelope of every Telegram Bot API response.
+type apiResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Result      struct {
+		MessageID int64 `json:"message_id"`
+	} `json:"result"`
+	Parameters struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// sendMessage posts text to chatID, rendered according to format. Text longer
+// than maxMessageLen once rendered is split (on rune boundaries, preferring
+// line and sentence breaks; see splitMessage) and sent as consecutive messages
+// in order; the first chunk that fails aborts the rest and its error is
+// returned so the message is retried as a whole.
+// A non-zero replyTo makes the first chunk a reply to that Telegram message.
+// It returns the Telegram message_id of the first chunk.
+func (c *Consumer) sendMessage(ctx context.Context, chatID int64, text string, format publisher.Format, replyTo int64, botToken string) (int64, error) {
+	chunks := splitMessage(text, format, maxMessageLen)
+	var firstID int64
+	for i, chunk := range chunks {
+		if i > 0 {
+			replyTo = 0
+		}
+		messageID, err := c.sendChunk(ctx, chatID, chunk, format, replyTo, botToken)
+		if err != nil {
+			if len(chunks) > 1 {
+				return 0, fmt.Errorf("part %d/%d: %w", i+1, len(chunks), err)
+			}
+			return 0, err
+		}
+		if i == 0 {
+			firstID = messageID
+		}
+	}
+	return firstID, nil
+}
+
+// sendChunk posts a single message of at most maxMessageLen characters,
+// already rendered for format by splitMessage. When Telegram answers 429 it
+// waits for the advertised retry_after and resends in place, so a rate-limit
+// burst does not use up the message's delivery attempts. Waits longer than
+// maxRetryAfterWait, or more than maxRateLimitRetries in a row, are returned
+// as errors instead. It returns the message_id Telegram assigned to the sent
+// message.
+func (c *Consumer) sendChunk(ctx context.Context, chatID int64, text string, format publisher.Format, replyTo int64, botToken string) (int64, error) {
 	payload := map[string]interface{}{
 		"chat_id": chatID,
 		"text":    text,
 	}
+	if replyTo != 0 {
+		// Still send if the original message has been deleted in the meantime.
+		payload["reply_parameters"] = map[string]interface{}{
+			"message_id":                  replyTo,
+			"allow_sending_without_reply": true,
+		}
+	}
+	switch format {
+	case publisher.FormatMarkdownV2, publisher.FormatHTML:
+		payload["parse_mode"] = string(format)
+	}
 	body, err := json.Marshal(payload)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	url := fmt.Sprintf("%s/bot%s/sendMessage", c.telegramBaseURL, botToken)
-	resp, err := c.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	for retries := 0; ; retries++ {
+		messageID, retryAfter, err := c.post(ctx, url, body)
+		if err == nil || retryAfter == 0 {
+			return messageID, err
+		}
+		if retries >= maxRateLimitRetries {
+			return 0, fmt.Errorf("%w (gave up after %d rate-limit retries)", err, retries)
+		}
+		if retryAfter > maxRetryAfterWait {
+			return 0, fmt.Errorf("%w (retry_after %s exceeds cap %s)", err, retryAfter, maxRetryAfterWait)
+		}
+		c.Logger().Warn("rate limited, retrying", slog.Int64("chat_id", chatID), slog.Duration("retry_in", retryAfter))
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+// post performs one Bot API call and returns the result's message_id (0 if
+// the response carries none). On HTTP 429 it also returns how long Telegram
+// asked us to wait before retrying.
+func (c *Consumer) post(ctx context.Context, url string, body []byte) (messageID int64, retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("telegram request: %w", err)
+		return 0, 0, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("telegram request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("telegram API returned %d", resp.StatusCode)
+	var apiResp apiResponse
+	// The message has been sent either way, so the body is best-effort: it
+	// supplies the message_id receipt and the error diagnostics.
+	_ = json.NewDecoder(resp.Body).Decode(&apiResp)
+	if resp.StatusCode == http.StatusOK {
+		return apiResp.Result.MessageID, 0, nil
 	}
-	return nil
+	err = fmt.Errorf("telegram API returned %d", resp.StatusCode)
+	if apiResp.Description != "" {
+		err = fmt.Errorf("telegram API returned %d: %s", resp.StatusCode, apiResp.Description)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter = time.Duration(apiResp.Parameters.RetryAfter) * time.Second
+		if retryAfter <= 0 {
+			retryAfter = time.Second
+		}
+	}
+	return 0, retryAfter, err
 }
@@ -4,12 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,16 +33,58 @@ import (
 // --- mock DB ---
 
 // mockDB returns a fixed chatID + plain-text botToken (no encryption needed in tests).
+// A message selecting a bot by name gets that bot's token from bots instead.
 type mockDB struct {
 	chatID   int64
 	botToken string
+	bots     map[string]string // bot_name → plain-text token
 	err      error
+
+	mu    sync.Mutex // guards execs; workers deliver concurrently
+	execs []execCall
+}
+
+type execCall struct {
+	sql  string
+	args []any
+}
+
+func (m *mockDB) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	return &mockRows{}, nil
 }
 
-func (m *mockDB) QueryRow(_ context.Context, _ string, _ ...any) pgx.Row {
+func (m *mockDB) QueryRow(_ context.Context, _ string, args ...any) pgx.Row {
+	if name, _ := args[1].(string); name != "" {
+		token, ok := m.bots[name]
+		if !ok {
+			return &mockRow{err: pgx.ErrNoRows}
+		}
+		return &mockRow{chatID: m.chatID, botToken: token}
+	}
 	return &mockRow{chatID: m.chatID, botToken: m.botToken, err: m.err}
 }
 
+func (m *mockDB) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.execs = append(m.execs, execCall{sql: sql, args: args})
+	return pgconn.CommandTag{}, nil
+}
+
+// execsMatching returns the arguments of the recorded Exec calls whose SQL
+// contains substr.
+func (m *mockDB) execsMatching(substr string) [][]any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out [][]any
+	for _, e := range m.execs {
+		if strings.Contains(e.sql, substr) {
+			out = append(out, e.args)
+		}
+	}
+	return out
+}
+
 type mockRow struct {
 	chatID   int64
 	botToken string
@@ -107,6 +161,227 @@ func TestConsumer_ProcessMessage_Success(t *testing.T) {
 	assert.Equal(t, "Hello, World!", receivedText)
 }
 
+// capturePayloads returns a Telegram stub that records each sendMessage body.
+func capturePayloads(t *testing.T) (*httptest.Server, *[]map[string]interface{}) {
+	t.Helper()
+	var payloads []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &payloads
+}
+
+func TestConsumer_ProcessMessage_PlainTextHasNoParseMode(t *testing.T) {
+	tgSrv, payloads := capturePayloads(t)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "Price: 3.50 - done!")))
+
+	require.Len(t, *payloads, 1)
+	assert.NotContains(t, (*payloads)[0], "parse_mode")
+	assert.Equal(t, "Price: 3.50 - done!", (*payloads)[0]["text"])
+}
+
+func TestConsumer_ProcessMessage_MarkdownV2IsEscaped(t *testing.T) {
+	tgSrv, payloads := capturePayloads(t)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+
+	msg := xMessage("user-1", "**Total:** 3.50 - done!")
+	msg.Values["format"] = string(publisher.FormatMarkdownV2)
+	require.NoError(t, c.ProcessMessage(context.Background(), msg))
+
+	require.Len(t, *payloads, 1)
+	assert.Equal(t, "MarkdownV2", (*payloads)[0]["parse_mode"])
+	assert.Equal(t, `*Total:* 3\.50 \- done\!`, (*payloads)[0]["text"])
+}
+
+func TestConsumer_ProcessMessage_HTMLIsSentAsIs(t *testing.T) {
+	tgSrv, payloads := capturePayloads(t)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+
+	msg := xMessage("user-1", "<b>Total:</b> 3.50")
+	msg.Values["format"] = string(publisher.FormatHTML)
+	require.NoError(t, c.ProcessMessage(context.Background(), msg))
+
+	require.Len(t, *payloads, 1)
+	assert.Equal(t, "HTML", (*payloads)[0]["parse_mode"])
+	assert.Equal(t, "<b>Total:</b> 3.50", (*payloads)[0]["text"])
+}
+
+func TestConsumer_ProcessMessage_UsesMetadata(t *testing.T) {
+	tgSrv, payloads := capturePayloads(t)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+
+	msg := xMessage("user-1", "All services up.")
+	msg.Values["format"] = string(publisher.FormatHTML)
+	msg.Values["meta"] = `{"subject":"Status <daily>","reply_to":"42"}`
+	require.NoError(t, c.ProcessMessage(context.Background(), msg))
+
+	require.Len(t, *payloads, 1)
+	assert.Equal(t, "<b>Status &lt;daily&gt;</b>\n\nAll services up.", (*payloads)[0]["text"])
+	reply, ok := (*payloads)[0]["reply_parameters"].(map[string]interface{})
+	require.True(t, ok, "reply_parameters set")
+	assert.Equal(t, float64(42), reply["message_id"])
+	assert.Equal(t, true, reply["allow_sending_without_reply"])
+}
+
+func TestConsumer_ProcessMessage_SubjectInMarkdownV2(t *testing.T) {
+	tgSrv, payloads := capturePayloads(t)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+
+	msg := xMessage("user-1", "Done.")
+	msg.Values["format"] = string(publisher.FormatMarkdownV2)
+	msg.Values["meta"] = `{"subject":"Report v1.2"}`
+	require.NoError(t, c.ProcessMessage(context.Background(), msg))
+
+	require.Len(t, *payloads, 1)
+	assert.Equal(t, "*Report v1\\.2*\n\nDone\\.", (*payloads)[0]["text"])
+	assert.NotContains(t, (*payloads)[0], "reply_parameters")
+}
+
+func TestConsumer_ProcessMessage_SplitsLongText(t *testing.T) {
+	tgSrv, payloads := capturePayloads(t)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+
+	// Multi-byte runes throughout; 3000 + 3000 runes does not fit in one message.
+	first := strings.Repeat("é", 2999) + "."
+	second := strings.Repeat("ü", 3000)
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", first+"\n"+second)))
+
+	require.Len(t, *payloads, 2, "sent as two messages")
+	for _, p := range *payloads {
+		text := p["text"].(string)
+		assert.True(t, utf8.ValidString(text), "no rune cut in half")
+		assert.LessOrEqual(t, utf8.RuneCountInString(text), 4096)
+		assert.Equal(t, float64(1), p["chat_id"])
+	}
+	assert.Equal(t, first, (*payloads)[0]["text"], "split at the line break, in order")
+	assert.Equal(t, second, (*payloads)[1]["text"])
+}
+
+func TestConsumer_ProcessMessage_SplitsEscapedMarkdownV2(t *testing.T) {
+	tgSrv, payloads := capturePayloads(t)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+
+	// 4096 runes fit before escaping, but every "." "-" "(" ")" "!" gains a
+	// backslash.
+	text := []rune(strings.Repeat("v1.2-rc (beta)! ", 300))[:4096]
+	msg := xMessage("user-1", string(text))
+	msg.Values["format"] = string(publisher.FormatMarkdownV2)
+	require.NoError(t, c.ProcessMessage(context.Background(), msg))
+
+	require.Greater(t, len(*payloads), 1, "split after escaping")
+	var sent strings.Builder
+	for _, p := range *payloads {
+		chunk := p["text"].(string)
+		assert.LessOrEqual(t, utf8.RuneCountInString(chunk), 4096)
+		assert.Equal(t, "MarkdownV2", p["parse_mode"])
+		trailing := len(chunk) - len(strings.TrimRight(chunk, `\`))
+		assert.Zero(t, trailing%2, "no escape cut from its character")
+		sent.WriteString(chunk + " ")
+	}
+	assert.Equal(t, strings.Fields(telegram.EscapeMarkdownV2(string(text))), strings.Fields(sent.String()), "nothing lost")
+}
+
+func TestConsumer_ProcessMessage_SplitsHTMLBetweenTags(t *testing.T) {
+	tgSrv, payloads := capturePayloads(t)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+
+	text := "<b>Weekly report</b>\n\n" + strings.Repeat(`<a href="https://example.com/item">item</a> &amp; `, 150) +
+		"<i>" + strings.Repeat("long italic text ", 200) + "</i>"
+	msg := xMessage("user-1", text)
+	msg.Values["format"] = string(publisher.FormatHTML)
+	require.NoError(t, c.ProcessMessage(context.Background(), msg))
+
+	require.Greater(t, len(*payloads), 1)
+	for i, p := range *payloads {
+		chunk := p["text"].(string)
+		assert.LessOrEqual(t, utf8.RuneCountInString(chunk), 4096)
+		assert.Equal(t, strings.Count(chunk, "<"), strings.Count(chunk, ">"), "part %d: no tag cut", i)
+		assert.Equal(t, strings.Count(chunk, "<a "), strings.Count(chunk, "</a>"), "part %d: links closed", i)
+		assert.Equal(t, strings.Count(chunk, "<i>"), strings.Count(chunk, "</i>"), "part %d: italics closed", i)
+		assert.Equal(t, strings.Count(chunk, "&"), strings.Count(chunk, "&amp;"), "part %d: no entity cut", i)
+	}
+	last := (*payloads)[len(*payloads)-1]["text"].(string)
+	assert.True(t, strings.HasPrefix(last, "<i>"), "italics reopened in the next part")
+}
+
+func TestConsumer_ProcessMessage_FailedChunkFailsMessage(t *testing.T) {
+	var calls atomic.Int32
+	tgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}))
+	defer tgSrv.Close()
+
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+
+	err := c.ProcessMessage(context.Background(), xMessage("user-1", strings.Repeat("word ", 3000)))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "part 2/4")
+	assert.Equal(t, int32(2), calls.Load(), "stops at the failing chunk")
+}
+
+func TestConsumer_ProcessMessage_SelectsBotByName(t *testing.T) {
+	var paths []string
+	tgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}))
+	defer tgSrv.Close()
+	mr := miniredis.RunT(t)
+	db := &mockDB{chatID: 1, botToken: "default-tok", bots: map[string]string{"sales": "sales-tok", "support": "support-tok"}}
+	c := newTestConsumer(t, mr, db, tgSrv.URL)
+
+	byMeta := xMessage("user-1", "hi")
+	byMeta.Values["meta"] = `{"bot_name":"sales"}`
+	byJob := xMessage("user-1", "hi")
+	byJob.Values["metadata"] = `{"bot_name":"support","report_type":"weekly"}`
+	both := xMessage("user-1", "hi")
+	both.Values["meta"] = `{"bot_name":"sales"}`
+	both.Values["metadata"] = `{"bot_name":"support"}`
+	for i, msg := range []redis.XMessage{byMeta, byJob, both, xMessage("user-1", "hi")} {
+		msg.ID = fmt.Sprintf("%d-0", i+1)
+		require.NoError(t, c.ProcessMessage(context.Background(), msg))
+	}
+
+	assert.Equal(t, []string{
+		"/botsales-tok/sendMessage",
+		"/botsupport-tok/sendMessage",
+		"/botsales-tok/sendMessage", // the notification's choice wins over the job's
+		"/botdefault-tok/sendMessage",
+	}, paths)
+}
+
+func TestConsumer_ProcessMessage_UnknownBotName(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, "http://localhost")
+
+	msg := xMessage("user-1", "hi")
+	msg.Values["meta"] = `{"bot_name":"marketing"}`
+	err := c.ProcessMessage(context.Background(), msg)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `bot "marketing"`)
+}
+
 func TestConsumer_ProcessMessage_NoChatID(t *testing.T) {
 	mr := miniredis.RunT(t)
 	c := newTestConsumer(t, mr, &mockDB{err: fmt.Errorf("no rows in result set")}, "http://localhost")
@@ -132,6 +407,127 @@ func TestConsumer_ProcessMessage_TelegramAPIError(t *testing.T) {
 	assert.Contains(t, err.Error(), "401")
 }
 
+func TestConsumer_ProcessMessage_SkipsAlreadySentMessage(t *testing.T) {
+	tgSrv, payloads := capturePayloads(t)
+	mr := miniredis.RunT(t)
+	reg := prometheus.NewRegistry()
+	db := &mockDB{chatID: 1, botToken: "tok"}
+	first := newTestConsumer(t, mr, db, tgSrv.URL)
+	// A second instance reclaiming the message after the first one crashed
+	// before its ACK.
+	second := newTestConsumer(t, mr, db, tgSrv.URL).WithMetrics(reg)
+
+	require.NoError(t, first.ProcessMessage(context.Background(), xMessage("user-1", "hi")))
+	require.NoError(t, second.ProcessMessage(context.Background(), xMessage("user-1", "hi")))
+
+	assert.Len(t, *payloads, 1)
+	assert.Equal(t, 1.0, gathered(t, reg, "notifier_telegram_skipped_total").GetCounter().GetValue())
+	assert.Equal(t, 0.0, gathered(t, reg, "notifier_telegram_processed_total").GetCounter().GetValue())
+	assert.InDelta(t, 24*time.Hour, mr.TTL("notifications:delivered:notifications:1-0"), float64(time.Second))
+}
+
+func TestConsumer_ProcessMessage_FailedSendCanBeRetried(t *testing.T) {
+	fail := true
+	var sends int
+	tgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sends++
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}))
+	defer tgSrv.Close()
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+
+	require.Error(t, c.ProcessMessage(context.Background(), xMessage("user-1", "hi")))
+	fail = false
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "hi")))
+
+	assert.Equal(t, 2, sends, "a failed send does not mark the message as sent")
+}
+
+func TestConsumer_ProcessMessage_MessageBeingSentElsewhere(t *testing.T) {
+	tgSrv, payloads := capturePayloads(t)
+	mr := miniredis.RunT(t)
+	mr.Set("notifications:delivered:notifications:1-0", "sending")
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+
+	err := c.ProcessMessage(context.Background(), xMessage("user-1", "hi"))
+
+	require.Error(t, err, "left pending for a retry rather than ACKed unsent")
+	assert.Empty(t, *payloads)
+}
+
+func TestConsumer_ProcessMessage_RetriesInPlaceOnRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	tgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}))
+	defer tgSrv.Close()
+
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "test-bot-token"}, tgSrv.URL)
+	ctx := context.Background()
+	msg := xMessage("user-1", "hello")
+
+	start := time.Now()
+	c.ProcessWithDLQ(ctx, msg)
+
+	assert.Equal(t, int32(2), calls.Load(), "resent after the rate limit")
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "waited retry_after")
+
+	// Delivered on the first attempt: the attempts counter was cleaned up, not bumped twice.
+	rc := newRedisClient(mr)
+	exists, _ := rc.Exists(ctx, "notifications:attempts:"+msg.ID).Result()
+	assert.Equal(t, int64(0), exists)
+}
+
+func TestConsumer_ProcessMessage_RateLimitWaitIsCapped(t *testing.T) {
+	var calls atomic.Int32
+	tgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests","parameters":{"retry_after":86400}}`))
+	}))
+	defer tgSrv.Close()
+
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "test-bot-token"}, tgSrv.URL)
+
+	start := time.Now()
+	err := c.ProcessMessage(context.Background(), xMessage("user-1", "hello"))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "429")
+	assert.Contains(t, err.Error(), "exceeds cap")
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Less(t, time.Since(start), time.Second, "did not sleep for the huge retry_after")
+}
+
+func TestConsumer_ProcessMessage_RateLimitRespectsContext(t *testing.T) {
+	tgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"ok":false,"error_code":429,"parameters":{"retry_after":5}}`))
+	}))
+	defer tgSrv.Close()
+
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "test-bot-token"}, tgSrv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := c.ProcessMessage(ctx, xMessage("user-1", "hello"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
 // --- DLQ tests ---
 
 func TestConsumer_ProcessWithDLQ_SuccessACKsMessage(t *testing.T) {
@@ -158,6 +554,59 @@ func TestConsumer_ProcessWithDLQ_SuccessACKsMessage(t *testing.T) {
 	assert.Empty(t, dlqMsgs, "DLQ should be empty on success")
 }
 
+// sentMessage answers sendMessage like Telegram, with message_id id.
+func sentMessage(t *testing.T, id int64) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": map[string]any{"message_id": id}})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestConsumer_ProcessWithDLQ_ArchivesWithChatID(t *testing.T) {
+	mr := miniredis.RunT(t)
+	db := &mockDB{chatID: 111, botToken: "test-bot-token"}
+	c := newTestConsumer(t, mr, db, sentMessage(t, 7).URL)
+	c.WithArchival(db)
+
+	c.ProcessWithDLQ(context.Background(), xMessage("user-1", "Hello!"))
+
+	rows := db.execsMatching("INSERT INTO notification_delivery_log")
+	require.Len(t, rows, 1)
+	assert.Equal(t, []any{"user-1", "job-1", "telegram", "Hello!"}, rows[0][:4])
+	assert.Equal(t, "111", rows[0][5])
+}
+
+func TestConsumer_ProcessWithDLQ_RecordsDeliveryReceipt(t *testing.T) {
+	mr := miniredis.RunT(t)
+	db := &mockDB{chatID: 111, botToken: "test-bot-token"}
+	c := newTestConsumer(t, mr, db, sentMessage(t, 4242).URL)
+
+	c.ProcessWithDLQ(context.Background(), xMessage("user-1", "Hello!"))
+
+	rows := db.execsMatching("INSERT INTO notification_deliveries")
+	require.Len(t, rows, 1)
+	assert.Equal(t, []any{"job-1", "user-1", "telegram", "1-0"}, rows[0][:4])
+	assert.WithinDuration(t, time.Now(), rows[0][4].(time.Time), time.Minute)
+	assert.Equal(t, "4242", rows[0][5])
+}
+
+func TestConsumer_ProcessWithDLQ_NoReceiptOnFailure(t *testing.T) {
+	tgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer tgSrv.Close()
+
+	mr := miniredis.RunT(t)
+	db := &mockDB{chatID: 111, botToken: "test-bot-token"}
+	c := newTestConsumer(t, mr, db, tgSrv.URL)
+
+	c.ProcessWithDLQ(context.Background(), xMessage("user-1", "Hello!"))
+
+	assert.Empty(t, db.execsMatching("INSERT INTO notification_deliveries"))
+}
+
 func TestConsumer_ProcessWithDLQ_MovesToDLQAfterMaxAttempts(t *testing.T) {
 	mr := miniredis.RunT(t)
 	// DB always fails → ProcessMessage always returns an error
@@ -226,3 +675,270 @@ func TestConsumer_ProcessWithDLQ_DLQPreservesOriginalPayload(t *testing.T) {
 	assert.Equal(t, "Important message", dlqMsgs[0].Values["content"])
 	assert.Equal(t, "42-0", dlqMsgs[0].Values["dlq_original_id"])
 }
+
+// --- Stop tests ---
+
+// slowTelegram answers sendMessage after delay and records delivered texts.
+func slowTelegram(t *testing.T, delay time.Duration) (*httptest.Server, chan string) {
+	t.Helper()
+	delivered := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		time.Sleep(delay)
+		delivered <- payload["text"].(string)
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, delivered
+}
+
+// startWithMessage starts c and publishes one telegram message once the
+// consumer group exists, returning when the Telegram stub has been called.
+func startWithMessage(t *testing.T, c *telegram.Consumer, mr *miniredis.Miniredis, content string) {
+	t.Helper()
+	require.NoError(t, c.Start(context.Background()))
+	pub := publisher.NewFromClient(newRedisClient(mr))
+	require.NoError(t, pub.Publish(context.Background(), publisher.Notification{
+		JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: content,
+	}))
+	require.Eventually(t, func() bool {
+		pending, err := newRedisClient(mr).XPending(context.Background(), publisher.StreamName, "telegram-group").Result()
+		return err == nil && pending.Count == 1
+	}, 2*time.Second, 5*time.Millisecond, "message picked up")
+}
+
+func TestConsumer_Stop_WaitsForInFlightDelivery(t *testing.T) {
+	tgSrv, delivered := slowTelegram(t, 300*time.Millisecond)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL)
+	defer c.Close()
+
+	startWithMessage(t, c, mr, "slow one")
+
+	require.NoError(t, c.Stop(context.Background()))
+
+	select {
+	case text := <-delivered:
+		assert.Equal(t, "slow one", text)
+	default:
+		t.Fatal("Stop returned before the in-flight delivery finished")
+	}
+	pending, err := newRedisClient(mr).XPending(context.Background(), publisher.StreamName, "telegram-group").Result()
+	require.NoError(t, err)
+	assert.Zero(t, pending.Count, "message ACKed before Stop returned")
+}
+
+func TestConsumer_Stop_TimesOut(t *testing.T) {
+	tgSrv, _ := slowTelegram(t, time.Second)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL).
+		WithStopTimeout(50 * time.Millisecond)
+	defer c.Close()
+
+	startWithMessage(t, c, mr, "too slow")
+
+	start := time.Now()
+	err := c.Stop(context.Background())
+
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "Stop honours its timeout")
+}
+
+// --- ReplayDLQ tests ---
+
+// addDLQEntry writes a dead-letter entry the way moveToDLQ does.
+func addDLQEntry(t *testing.T, rc *redis.Client, originalID, content string) {
+	t.Helper()
+	require.NoError(t, rc.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: publisher.DLQStreamName,
+		Values: map[string]interface{}{
+			"job_id": "job-1", "user_id": "user-1", "channel": "telegram", "content": content,
+			"format":             "",
+			"dlq_reason":         "exceeded 3 delivery attempts",
+			"dlq_original_id":    originalID,
+			"dlq_consumer_group": "telegram-group",
+			"dlq_timestamp":      "2026-01-01T00:00:00Z",
+		},
+	}).Err())
+}
+
+func TestConsumer_ReplayDLQ_MovesMessagesBackToStream(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{}, "http://localhost")
+	rc := newRedisClient(mr)
+	ctx := context.Background()
+
+	addDLQEntry(t, rc, "7-0", "Replay me")
+	rc.Set(ctx, "notifications:attempts:7-0", 4, 0)
+
+	n, err := c.ReplayDLQ(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	stream, err := rc.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, stream, 1)
+	assert.Equal(t, "Replay me", stream[0].Values["content"])
+	assert.Equal(t, "telegram", stream[0].Values["channel"])
+	for k := range stream[0].Values {
+		assert.False(t, strings.HasPrefix(k, "dlq_"), "metadata field %q stripped", k)
+	}
+
+	dlq, _ := rc.XRange(ctx, publisher.DLQStreamName, "-", "+").Result()
+	assert.Empty(t, dlq, "replayed message removed from DLQ")
+	assert.Equal(t, int64(0), rc.Exists(ctx, "notifications:attempts:7-0").Val(), "attempts reset")
+}
+
+func TestConsumer_ReplayDLQ_RespectsMaxCount(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{}, "http://localhost")
+	rc := newRedisClient(mr)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		addDLQEntry(t, rc, fmt.Sprintf("%d-0", i+1), fmt.Sprintf("msg %d", i))
+	}
+
+	n, err := c.ReplayDLQ(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	stream, _ := rc.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.Len(t, stream, 2)
+	assert.Equal(t, "msg 0", stream[0].Values["content"], "oldest first")
+	dlq, _ := rc.XRange(ctx, publisher.DLQStreamName, "-", "+").Result()
+	require.Len(t, dlq, 1)
+	assert.Equal(t, "msg 2", dlq[0].Values["content"])
+}
+
+// --- metrics tests ---
+
+// gathered returns the single, unlabelled sample of the named metric family.
+func gathered(t *testing.T, reg *prometheus.Registry, name string) *dto.Metric {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() == name {
+			return f.GetMetric()[0]
+		}
+	}
+	t.Fatalf("metric %s not gathered", name)
+	return nil
+}
+
+func TestConsumer_Metrics_RecordSuccessfulDelivery(t *testing.T) {
+	tgSrv, _ := capturePayloads(t)
+	mr := miniredis.RunT(t)
+	reg := prometheus.NewRegistry()
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL).WithMetrics(reg)
+
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("user-1", "hi")))
+
+	assert.GreaterOrEqual(t, gathered(t, reg, "notifier_telegram_delivery_duration_seconds").GetHistogram().GetSampleCount(), uint64(1))
+	assert.Equal(t, 1.0, gathered(t, reg, "notifier_telegram_processed_total").GetCounter().GetValue())
+	assert.Equal(t, 0.0, gathered(t, reg, "notifier_telegram_dlq_total").GetCounter().GetValue())
+}
+
+func TestConsumer_Metrics_CountDLQMoves(t *testing.T) {
+	mr := miniredis.RunT(t)
+	reg := prometheus.NewRegistry()
+	c := newTestConsumer(t, mr, &mockDB{err: fmt.Errorf("no chat mapping")}, "http://localhost").WithMetrics(reg)
+	ctx := context.Background()
+	msg := xMessage("bad-user", "Hello!")
+	newRedisClient(mr).Set(ctx, "notifications:attempts:"+msg.ID, 3, 0)
+
+	c.ProcessWithDLQ(ctx, msg)
+
+	assert.Equal(t, 1.0, gathered(t, reg, "notifier_telegram_dlq_total").GetCounter().GetValue())
+	assert.Equal(t, 0.0, gathered(t, reg, "notifier_telegram_processed_total").GetCounter().GetValue())
+}
+
+func TestConsumer_NewFromSentinel_StartsOnMaster(t *testing.T) {
+	master := miniredis.RunT(t)
+	sentinel := miniredis.RunT(t)
+	host, port, err := net.SplitHostPort(master.Addr())
+	require.NoError(t, err)
+	// Answer the Sentinel queries go-redis makes: the master address, no peers.
+	require.NoError(t, sentinel.Server().Register("SENTINEL", func(c *server.Peer, _ string, args []string) {
+		if args[0] == "get-master-addr-by-name" {
+			c.WriteLen(2)
+			c.WriteBulk(host)
+			c.WriteBulk(port)
+			return
+		}
+		c.WriteLen(0)
+	}))
+
+	c, err := telegram.NewFromSentinel("mymaster", []string{sentinel.Addr()}, nil, &mockDB{}, "")
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	require.NoError(t, c.Start(context.Background()))
+	t.Cleanup(func() { c.Stop(context.Background()) })
+
+	groups, err := redis.NewClient(&redis.Options{Addr: master.Addr()}).XInfoGroups(context.Background(), publisher.StreamName).Result()
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, "telegram-group", groups[0].Name)
+}
+
+func TestConsumer_WithWorkerCount_DeliversConcurrently(t *testing.T) {
+	tgSrv, delivered := slowTelegram(t, 200*time.Millisecond)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{chatID: 1, botToken: "tok"}, tgSrv.URL).WithWorkerCount(4)
+	defer c.Close()
+	require.NoError(t, c.Start(context.Background()))
+
+	pub := publisher.NewFromClient(newRedisClient(mr))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, pub.Publish(context.Background(), publisher.Notification{
+			JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: fmt.Sprintf("msg %d", i),
+		}))
+	}
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		select {
+		case <-delivered:
+		case <-time.After(2 * time.Second):
+			t.Fatal("not all messages delivered")
+		}
+	}
+	assert.Less(t, time.Since(start), 600*time.Millisecond, "four 200ms sends overlap")
+	require.NoError(t, c.Stop(context.Background()))
+}
+
+// BenchmarkConsumer_ProcessMessage measures end-to-end throughput, from
+// publish to Telegram send, with a Telegram stub that answers immediately.
+func BenchmarkConsumer_ProcessMessage(b *testing.B) {
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			var sent atomic.Int64
+			tgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sent.Add(1)
+				json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+			}))
+			defer tgSrv.Close()
+			mr := miniredis.RunT(b)
+			c, err := telegram.NewForTest("redis://"+mr.Addr(), &mockDB{chatID: 1, botToken: "tok"}, "", tgSrv.URL)
+			require.NoError(b, err)
+			c.WithWorkerCount(workers)
+			c.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+			defer c.Close()
+			require.NoError(b, c.Start(context.Background()))
+			pub := publisher.NewFromClient(newRedisClient(mr))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, pub.Publish(context.Background(), publisher.Notification{
+					JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "hello",
+				}))
+			}
+			for sent.Load() < int64(b.N) {
+				time.Sleep(time.Millisecond)
+			}
+			b.StopTimer()
+			require.NoError(b, c.Stop(context.Background()))
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package telegram
+
+import "strings"
+
+// markdownV2Reserved are the characters Telegram requires to be escaped with a
+// backslash anywhere outside code entities in MarkdownV2 text.
+const markdownV2Reserved = "_*[]()~`>#+-=|{}.!\\"
+
+// FormatMarkdown escapes s for parse_mode "MarkdownV2" (see
+// EscapeMarkdownV2), e.g. for content published with
+// publisher.ParseModeMarkdown.
+func FormatMarkdown(s string) string {
+	return EscapeMarkdownV2(s)
+}
+
+// EscapeMarkdownV2 prepares LLM-style Markdown for parse_mode "MarkdownV2".
+// Code blocks (```…```), inline code (`…`) and **bold** spans are kept as
+// formatting; every other reserved character is escaped so Telegram shows it
+// literally instead of rejecting the message with a 400. Unterminated markers
+// are escaped too.
+func EscapeMarkdownV2(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + len(s)/8)
+	for len(s) > 0 {
+		switch {
+		case strings.HasPrefix(s, "```"):
+			if end := strings.Index(s[3:], "```"); end >= 0 {
+				b.WriteString("```" + escapeCode(s[3:3+end]) + "```")
+				s = s[3+end+3:]
+				continue
+			}
+		case strings.HasPrefix(s, "`"):
+			if end := strings.IndexByte(s[1:], '`'); end >= 0 {
+				b.WriteString("`" + escapeCode(s[1:1+end]) + "`")
+				s = s[1+end+1:]
+				continue
+			}
+		case strings.HasPrefix(s, "**"):
+			if end := strings.Index(s[2:], "**"); end > 0 {
+				b.WriteString("*" + escapeText(s[2:2+end]) + "*")
+				s = s[2+end+2:]
+				continue
+			}
+		}
+		// Plain character (or a marker with no closing counterpart).
+		if strings.IndexByte(markdownV2Reserved, s[0]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[0])
+		s = s[1:]
+	}
+	return b.String()
+}
+
+// escapeText escapes every MarkdownV2 reserved character in s.
+func escapeText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(markdownV2Reserved, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// escapeCode escapes the two characters that are special inside code entities.
+func escapeCode(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(s)
+}
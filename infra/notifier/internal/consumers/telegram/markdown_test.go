@@ -0,0 +1,36 @@
+package telegram_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	telegram "github.com/allerac/notifier/internal/consumers/telegram"
+)
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+	}{
+		{"plain text", "Hello world", "Hello world"},
+		{"reserved characters", "v1.2 - ok! (a+b=c) #tag", `v1\.2 \- ok\! \(a\+b\=c\) \#tag`},
+		{"backslash", `C:\tmp`, `C:\\tmp`},
+		{"bold is kept", "**Weather:** sunny.", `*Weather:* sunny\.`},
+		{"single asterisks are escaped", "2 * 3 = 6", `2 \* 3 \= 6`},
+		{"unterminated bold", "**oops", `\*\*oops`},
+		{"inline code", "run `go test ./...` now.", "run `go test ./...` now\\."},
+		{"code block", "```go\nx := a.b - 1\n```", "```go\nx := a.b - 1\n```"},
+		{"backtick and backslash in code", "`a\\b`", "`a\\\\b`"},
+		{"unterminated code", "a ` b", "a \\` b"},
+		{"non-ascii", "Olá — 5°C.", `Olá — 5°C\.`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, telegram.EscapeMarkdownV2(tc.in))
+		})
+	}
+}
+
+func TestFormatMarkdown(t *testing.T) {
+	assert.Equal(t, `*Weather:* sunny \(5°C\)\.`, telegram.FormatMarkdown("**Weather:** sunny (5°C)."))
+}
@@ -0,0 +1,41 @@
+package telegram
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// metrics are the Prometheus collectors maintained by a Consumer. They are
+// always updated; WithMetrics exposes them on a registry.
+type metrics struct {
+	processed prometheus.Counter
+	skipped   prometheus.Counter
+	dlq       prometheus.Counter
+	duration  prometheus.Histogram
+}
+
+func newMetrics() metrics {
+	return metrics{
+		processed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "notifier_telegram_processed_total",
+			Help: "Messages successfully delivered to Telegram.",
+		}),
+		skipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "notifier_telegram_skipped_total",
+			Help: "Messages not sent again because they had already been sent under the same stream ID.",
+		}),
+		dlq: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "notifier_telegram_dlq_total",
+			Help: "Messages moved to the dead-letter stream by the Telegram consumer.",
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "notifier_telegram_delivery_duration_seconds",
+			Help:    "Time spent delivering one message, including lookups and rate-limit waits.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+}
+
+// WithMetrics registers the consumer's metrics with reg (typically
+// prometheus.DefaultRegisterer). It panics if they are already registered.
+func (c *Consumer) WithMetrics(reg prometheus.Registerer) *Consumer {
+	reg.MustRegister(c.metrics.processed, c.metrics.skipped, c.metrics.dlq, c.metrics.duration)
+	return c
+}
@@ -0,0 +1,179 @@
+package telegram
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/allerac/notifier/internal/channels"
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+// splitMessage renders text for format and splits it into messages of at
+// most maxLen characters as sent, so markup added by rendering (MarkdownV2
+// escapes) or kept from the text (HTML tags) never pushes a message over the
+// Bot API limit.
+func splitMessage(text string, format publisher.Format, maxLen int) []string {
+	switch format {
+	case publisher.FormatMarkdownV2:
+		var chunks []string
+		for _, chunk := range channels.SplitText(text, maxLen) {
+			chunks = append(chunks, fitEscaped(chunk, maxLen)...)
+		}
+		return chunks
+	case publisher.FormatHTML:
+		return splitHTML(text, maxLen)
+	}
+	return channels.SplitText(text, maxLen)
+}
+
+// fitEscaped escapes chunk for MarkdownV2, splitting it again with a limit
+// shrunk by the escaping overhead while the result is over maxLen. Each part
+// is escaped on its own, so a cut never separates a backslash from the
+// character it escapes, and a code or bold span cut in two is escaped as
+// literal text instead of being left unterminated.
+func fitEscaped(chunk string, maxLen int) []string {
+	escaped := EscapeMarkdownV2(chunk)
+	n := utf8.RuneCountInString(escaped)
+	if n <= maxLen {
+		return []string{escaped}
+	}
+	runes := utf8.RuneCountInString(chunk)
+	limit := max(min(runes*maxLen/n, runes-1), 1)
+	var parts []string
+	for _, part := range channels.SplitText(chunk, limit) {
+		parts = append(parts, fitEscaped(part, maxLen)...)
+	}
+	return parts
+}
+
+// splitHTML splits Telegram HTML into chunks of at most maxLen characters
+// that each parse on their own. Cuts fall between tags, entities and words
+// (after the whitespace following a word), and tags still open at a cut are
+// closed at the end of the chunk and opened again at the start of the next.
+func splitHTML(s string, maxLen int) []string {
+	if maxLen <= 0 || utf8.RuneCountInString(s) <= maxLen {
+		return []string{s}
+	}
+	var (
+		chunks []string
+		b      strings.Builder
+		n      int      // runes in b
+		body   bool     // b holds more than the reopened tags
+		open   []string // opening tags not closed yet, outermost first
+	)
+	closing := func(open []string) string {
+		var c strings.Builder
+		for i := len(open) - 1; i >= 0; i-- {
+			c.WriteString("</" + tagName(open[i]) + ">")
+		}
+		return c.String()
+	}
+	flush := func() {
+		chunks = append(chunks, strings.TrimRight(b.String(), " \n")+closing(open))
+		b.Reset()
+		n, body = 0, false
+		for _, tag := range open {
+			b.WriteString(tag)
+			n += utf8.RuneCountInString(tag)
+		}
+	}
+
+	for _, tok := range htmlTokens(s) {
+		if !body && strings.TrimSpace(tok) == "" {
+			continue // no chunk starts with whitespace
+		}
+		tag := isTag(tok)
+		after := open
+		if tag {
+			after = applyTag(open, tok)
+		}
+		size := utf8.RuneCountInString(tok)
+		if n+size+utf8.RuneCountInString(closing(after)) > maxLen && body {
+			flush()
+			if strings.TrimSpace(tok) == "" {
+				continue
+			}
+		}
+		// A word too long for a chunk of its own is hard-cut.
+		for !tag && !isEntity(tok) {
+			room := maxLen - n - utf8.RuneCountInString(closing(open))
+			if size <= room || room <= 0 {
+				break
+			}
+			cut := len(string([]rune(tok)[:room]))
+			b.WriteString(tok[:cut])
+			n, body = n+room, true
+			flush()
+			tok, size = tok[cut:], size-room
+		}
+		b.WriteString(tok)
+		n, body, open = n+size, true, after
+	}
+	if body {
+		chunks = append(chunks, strings.TrimRight(b.String(), " \n")+closing(open))
+	}
+	return chunks
+}
+
+// htmlTokens splits s into tags, entities and words, each word carrying the
+// whitespace that follows it.
+func htmlTokens(s string) []string {
+	var tokens []string
+	for len(s) > 0 {
+		end := 0
+		switch s[0] {
+		case '<':
+			end = strings.IndexByte(s, '>') + 1
+		case '&':
+			if i := strings.IndexByte(s, ';'); i > 0 && i <= 10 {
+				end = i + 1
+			}
+		}
+		if end == 0 {
+			// A word: up to the next whitespace, tag or entity, plus the
+			// whitespace after it.
+			end = len(s)
+			if i := strings.IndexAny(s[1:], " \n<&"); i >= 0 {
+				end = i + 1
+			}
+			for end < len(s) && (s[end] == ' ' || s[end] == '\n') {
+				end++
+			}
+		}
+		tokens = append(tokens, s[:end])
+		s = s[end:]
+	}
+	return tokens
+}
+
+func isTag(tok string) bool {
+	return strings.HasPrefix(tok, "<") && strings.HasSuffix(tok, ">")
+}
+
+func isEntity(tok string) bool {
+	return strings.HasPrefix(tok, "&") && strings.HasSuffix(tok, ";")
+}
+
+// applyTag returns the open tags after tag: an opening tag is pushed, a
+// closing one pops its most recent opening tag.
+func applyTag(open []string, tag string) []string {
+	if !strings.HasPrefix(tag, "</") {
+		return append(open[:len(open):len(open)], tag)
+	}
+	name := tagName(tag)
+	for i := len(open) - 1; i >= 0; i-- {
+		if tagName(open[i]) == name {
+			return append(open[:i:i], open[i+1:]...)
+		}
+	}
+	return open
+}
+
+// tagName returns the element name of an opening or closing tag.
+func tagName(tag string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(tag, "<"), "/")
+	if i := strings.IndexAny(name, " >"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
@@ -0,0 +1,213 @@
+package telegram
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/allerac/notifier/internal/crypto"
+)
+
+// maxUpdateBytes bounds a webhook request body; updates are a few KB.
+const maxUpdateBytes = 1 << 20
+
+// secretHeader carries the secret_token given to setWebhook on every update
+// Telegram posts.
+const secretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Update is the part of a Telegram Update object the notifier reads.
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message,omitempty"`
+}
+
+// Message is an incoming Telegram message.
+type Message struct {
+	MessageID int64  `json:"message_id"`
+	From      *User  `json:"from,omitempty"`
+	Chat      Chat   `json:"chat"`
+	Text      string `json:"text,omitempty"`
+}
+
+// User is the Telegram account that sent a message.
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username,omitempty"`
+}
+
+// Chat is the conversation a message was sent in.
+type Chat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type,omitempty"`
+}
+
+// command returns the bot command m starts with, without a "@BotName"
+// suffix, and the text after it, e.g. "/start" and "abc" for
+// "/start@AlleracBot abc"; "" if it is not one.
+func (m *Message) command() (cmd, payload string) {
+	if !strings.HasPrefix(m.Text, "/") {
+		return "", ""
+	}
+	cmd, payload, _ = strings.Cut(m.Text, " ")
+	cmd, _, _ = strings.Cut(cmd, "@")
+	return cmd, strings.TrimSpace(payload)
+}
+
+// UpdateHandler acts on Telegram updates; implemented by *Consumer.
+type UpdateHandler interface {
+	HandleUpdate(ctx context.Context, update Update) error
+}
+
+// WebhookHandler receives the updates Telegram posts to a bot's webhook (see
+// RegisterWebhookURL) and passes them to an UpdateHandler.
+type WebhookHandler struct {
+	updates UpdateHandler
+	secret  string
+	logger  *slog.Logger
+}
+
+// NewWebhookHandler creates a WebhookHandler that accepts only updates
+// carrying secret in the X-Telegram-Bot-Api-Secret-Token header. With an
+// empty secret every request is answered 503.
+func NewWebhookHandler(updates UpdateHandler, secret string) *WebhookHandler {
+	return &WebhookHandler{updates: updates, secret: secret, logger: slog.Default()}
+}
+
+// WithLogger sets the logger used by the handler (default slog.Default()).
+func (h *WebhookHandler) WithLogger(l *slog.Logger) *WebhookHandler {
+	h.logger = l
+	return h
+}
+
+// ServeHTTP implements http.Handler. Updates the UpdateHandler ignores are
+// acknowledged with 200 as well, so Telegram does not redeliver them.
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.secret == "" {
+		http.Error(w, "telegram webhook disabled: TELEGRAM_WEBHOOK_SECRET not set", http.StatusServiceUnavailable)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(h.secret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var update Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateBytes)).Decode(&update); err != nil {
+		http.Error(w, "invalid update: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.updates.HandleUpdate(r.Context(), update); err != nil {
+		// A non-2xx answer makes Telegram redeliver the update later.
+		h.logger.Error("telegram webhook: update failed", slog.Int64("update_id", update.UpdateID), slog.Any("error", err))
+		http.Error(w, "update failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleUpdate registers the chat of a /start command in
+// telegram_chat_mapping, so notifications reach users without a manual
+// insert, and ignores every other update. The sender must be listed in a
+// bot's allowed_telegram_ids; the chat is then mapped to that bot's owner.
+// A "/start <userID>" deep link (t.me/<bot>?start=<userID>) restricts this to
+// the bots of that user, so a Telegram account several users allow is mapped
+// to the one whose link it followed; a payload that is not a user ID is
+// ignored along with the update.
+func (c *Consumer) HandleUpdate(ctx context.Context, update Update) error {
+	m := update.Message
+	if m == nil || m.From == nil {
+		return nil
+	}
+	cmd, userID := m.command()
+	if cmd != "/start" {
+		return nil
+	}
+	attrs := []any{slog.Int64("chat_id", m.Chat.ID), slog.Int64("telegram_user_id", m.From.ID)}
+	if userID != "" && uuid.Validate(userID) != nil {
+		c.Logger().Info("telegram /start with a payload that is not a user ID, ignored", attrs...)
+		return nil
+	}
+	tag, err := c.db.Exec(ctx, `
+		INSERT INTO telegram_chat_mapping (telegram_chat_id, user_id, telegram_user_id, telegram_username)
+		SELECT $1, tbc.user_id, $2, NULLIF($3, '')
+		FROM telegram_bot_configs tbc
+		WHERE $2 = ANY(tbc.allowed_telegram_ids) AND tbc.enabled = true AND ($4 = '' OR tbc.user_id::text = $4)
+		ORDER BY tbc.created_at
+		LIMIT 1
+		ON CONFLICT (telegram_chat_id) DO UPDATE
+		SET user_id = EXCLUDED.user_id, telegram_user_id = EXCLUDED.telegram_user_id,
+			telegram_username = EXCLUDED.telegram_username, updated_at = NOW()
+	`, m.Chat.ID, m.From.ID, m.From.Username, userID)
+	if err != nil {
+		return fmt.Errorf("register chat %d: %w", m.Chat.ID, err)
+	}
+	if userID != "" {
+		attrs = append(attrs, slog.String("user_id", userID))
+	}
+	if tag.RowsAffected() == 0 {
+		c.Logger().Info("telegram /start from a telegram user no bot allows, ignored", attrs...)
+		return nil
+	}
+	c.Logger().Info("telegram chat registered", attrs...)
+	return nil
+}
+
+// RegisterWebhookURL points the webhook of every enabled bot at webhookURL
+// with setWebhook, along with the secret set by WithWebhookSecret, so that
+// Telegram posts their updates there instead of holding them for getUpdates.
+// A bot that cannot be registered does not stop the others; their errors are
+// returned together.
+func (c *Consumer) RegisterWebhookURL(ctx context.Context, webhookURL string) error {
+	rows, err := c.db.Query(ctx, `SELECT bot_name, bot_token FROM telegram_bot_configs WHERE enabled = true ORDER BY created_at`)
+	if err != nil {
+		return fmt.Errorf("list telegram bots: %w", err)
+	}
+	type bot struct{ name, encryptedToken string }
+	var bots []bot
+	for rows.Next() {
+		var b bot
+		if err := rows.Scan(&b.name, &b.encryptedToken); err != nil {
+			rows.Close()
+			return fmt.Errorf("list telegram bots: %w", err)
+		}
+		bots = append(bots, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("list telegram bots: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"url":             webhookURL,
+		"secret_token":    c.webhookSecret,
+		"allowed_updates": []string{"message"},
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, b := range bots {
+		token, err := crypto.SafeDecrypt(b.encryptedToken, c.encryptionKey)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bot %q: decrypt token: %w", b.name, err))
+			continue
+		}
+		if _, _, err := c.post(ctx, fmt.Sprintf("%s/bot%s/setWebhook", c.telegramBaseURL, token), body); err != nil {
+			errs = append(errs, fmt.Errorf("bot %q: setWebhook: %w", b.name, err))
+			continue
+		}
+		c.Logger().Info("telegram webhook registered", slog.String("bot_name", b.name))
+	}
+	return errors.Join(errs...)
+}
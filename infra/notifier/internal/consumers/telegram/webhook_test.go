@@ -0,0 +1,224 @@
+package telegram_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	telegram "github.com/allerac/notifier/internal/consumers/telegram"
+)
+
+const (
+	webhookSecret = "hook-s3cret"
+	ownerID       = "0b6f6a4e-8d3c-4a57-9a0e-2f1f6f5d8c11"
+	otherUserID   = "5e2d1c7a-1b9f-4f0e-8c3d-7a6b5c4d3e21"
+)
+
+// mappingDB records telegram_chat_mapping upserts; like the real query, it
+// inserts nothing for a Telegram user no bot allows, or whose bot is not owned
+// by the user a /start payload names. Query lists bots.
+type mappingDB struct {
+	allowed map[int64]string // telegram user ID → owner of the bot allowing it
+	bots    [][]any          // bot_name, bot_token
+	err     error
+
+	mu      sync.Mutex
+	upserts [][]any
+}
+
+func (m *mappingDB) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	return &mockRows{rows: m.bots}, m.err
+}
+
+func (m *mappingDB) QueryRow(context.Context, string, ...any) pgx.Row { return nil }
+
+func (m *mappingDB) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return pgconn.CommandTag{}, m.err
+	}
+	owner, ok := m.allowed[args[1].(int64)]
+	if !strings.Contains(sql, "INSERT INTO telegram_chat_mapping") || !ok || (args[3] != "" && args[3] != owner) {
+		return pgconn.NewCommandTag("INSERT 0 0"), nil
+	}
+	m.upserts = append(m.upserts, args)
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+
+// mockRows iterates over canned rows, assigning values to Scan destinations by position.
+type mockRows struct {
+	rows [][]any
+	i    int
+}
+
+func (r *mockRows) Close()                                       {}
+func (r *mockRows) Err() error                                   { return nil }
+func (r *mockRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *mockRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *mockRows) Values() ([]any, error)                       { return r.rows[r.i-1], nil }
+func (r *mockRows) RawValues() [][]byte                          { return nil }
+func (r *mockRows) Conn() *pgx.Conn                              { return nil }
+
+func (r *mockRows) Next() bool {
+	r.i++
+	return r.i <= len(r.rows)
+}
+
+func (r *mockRows) Scan(dest ...any) error {
+	for i, d := range dest {
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r.rows[r.i-1][i]))
+	}
+	return nil
+}
+
+// newUpdateConsumer creates a Consumer for update handling only; it never
+// connects to Redis.
+func newUpdateConsumer(t *testing.T, db *mappingDB, tgBaseURL string) *telegram.Consumer {
+	t.Helper()
+	c, err := telegram.NewForTest("redis://127.0.0.1:1", db, "", tgBaseURL)
+	require.NoError(t, err)
+	return c
+}
+
+func newWebhookServer(t *testing.T, db *mappingDB, secret string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(telegram.NewWebhookHandler(newUpdateConsumer(t, db, ""), secret))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// postUpdate sends a Telegram Update body with the given secret header.
+func postUpdate(t *testing.T, srv *httptest.Server, secret, body string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func messageUpdate(fromID, chatID int64, text string) string {
+	return fmt.Sprintf(`{"update_id": 10001, "message": {"message_id": 7, "date": 1760000000,
+		"from": {"id": %d, "is_bot": false, "first_name": "Ana", "username": "ana"},
+		"chat": {"id": %d, "type": "private"}, "text": %q}}`, fromID, chatID, text)
+}
+
+// decodeUpdate parses a messageUpdate body as the webhook would.
+func decodeUpdate(t *testing.T, body string) telegram.Update {
+	t.Helper()
+	var u telegram.Update
+	require.NoError(t, json.Unmarshal([]byte(body), &u))
+	return u
+}
+
+func TestWebhookHandler_StartRegistersChat(t *testing.T) {
+	db := &mappingDB{allowed: map[int64]string{4242: ownerID}}
+	srv := newWebhookServer(t, db, webhookSecret)
+
+	assert.Equal(t, http.StatusOK, postUpdate(t, srv, webhookSecret, messageUpdate(4242, 987654, "/start")))
+	assert.Equal(t, http.StatusOK, postUpdate(t, srv, webhookSecret, messageUpdate(4242, -100123, "/start@AlleracBot")))
+
+	require.Len(t, db.upserts, 2)
+	assert.Equal(t, []any{int64(987654), int64(4242), "ana", ""}, db.upserts[0])
+	assert.Equal(t, int64(-100123), db.upserts[1][0])
+}
+
+func TestWebhookHandler_IgnoresOtherUpdates(t *testing.T) {
+	db := &mappingDB{allowed: map[int64]string{4242: ownerID}}
+	srv := newWebhookServer(t, db, webhookSecret)
+
+	assert.Equal(t, http.StatusOK, postUpdate(t, srv, webhookSecret, messageUpdate(4242, 987654, "hello")))
+	assert.Equal(t, http.StatusOK, postUpdate(t, srv, webhookSecret, messageUpdate(4242, 987654, "/stop")))
+	assert.Equal(t, http.StatusOK, postUpdate(t, srv, webhookSecret, `{"update_id": 10002, "edited_message": {}}`))
+	assert.Equal(t, http.StatusOK, postUpdate(t, srv, webhookSecret, messageUpdate(1, 987654, "/start")), "unknown user acknowledged")
+
+	assert.Empty(t, db.upserts)
+}
+
+func TestWebhookHandler_RejectsBadRequests(t *testing.T) {
+	db := &mappingDB{allowed: map[int64]string{4242: ownerID}}
+	srv := newWebhookServer(t, db, webhookSecret)
+
+	assert.Equal(t, http.StatusUnauthorized, postUpdate(t, srv, "wrong", messageUpdate(4242, 987654, "/start")))
+	assert.Equal(t, http.StatusUnauthorized, postUpdate(t, srv, "", messageUpdate(4242, 987654, "/start")))
+	assert.Equal(t, http.StatusBadRequest, postUpdate(t, srv, webhookSecret, `{"update_id":`))
+	assert.Equal(t, http.StatusServiceUnavailable,
+		postUpdate(t, newWebhookServer(t, db, ""), "", messageUpdate(4242, 987654, "/start")), "disabled without a secret")
+
+	resp, err := srv.Client().Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	assert.Empty(t, db.upserts)
+}
+
+func TestWebhookHandler_DatabaseErrorAsksForRedelivery(t *testing.T) {
+	srv := newWebhookServer(t, &mappingDB{err: fmt.Errorf("connection refused")}, webhookSecret)
+
+	assert.Equal(t, http.StatusInternalServerError, postUpdate(t, srv, webhookSecret, messageUpdate(4242, 987654, "/start")))
+}
+
+func TestConsumer_HandleUpdate_StartWithUserID(t *testing.T) {
+	db := &mappingDB{allowed: map[int64]string{4242: ownerID}}
+	c := newUpdateConsumer(t, db, "")
+	ctx := context.Background()
+
+	require.NoError(t, c.HandleUpdate(ctx, decodeUpdate(t, messageUpdate(4242, 987654, "/start "+ownerID))))
+	require.NoError(t, c.HandleUpdate(ctx, decodeUpdate(t, messageUpdate(4242, 555, "/start "+otherUserID))), "another user's link")
+	require.NoError(t, c.HandleUpdate(ctx, decodeUpdate(t, messageUpdate(4242, 556, "/start not-a-user"))), "invalid payload")
+
+	require.Len(t, db.upserts, 1)
+	assert.Equal(t, []any{int64(987654), int64(4242), "ana", ownerID}, db.upserts[0])
+}
+
+func TestConsumer_RegisterWebhookURL(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls = map[string]map[string]any{} // path → body
+	)
+	tgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		mu.Lock()
+		calls[r.URL.Path] = body
+		mu.Unlock()
+		if strings.Contains(r.URL.Path, "revoked") {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"ok":false,"error_code":401,"description":"Unauthorized"}`)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true,"result":true,"description":"Webhook was set"}`)
+	}))
+	defer tgSrv.Close()
+
+	db := &mappingDB{bots: [][]any{{"main", "tok-main"}, {"old", "tok-revoked"}, {"alerts", "tok-alerts"}}}
+	c := newUpdateConsumer(t, db, tgSrv.URL).WithWebhookSecret(webhookSecret)
+
+	err := c.RegisterWebhookURL(context.Background(), "https://notifier.example.com/telegram/webhook")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `bot "old"`)
+	assert.Contains(t, err.Error(), "Unauthorized")
+	require.Len(t, calls, 3, "a failing bot does not stop the others")
+	body := calls["/bottok-main/setWebhook"]
+	assert.Equal(t, "https://notifier.example.com/telegram/webhook", body["url"])
+	assert.Equal(t, webhookSecret, body["secret_token"])
+	assert.Equal(t, []any{"message"}, body["allowed_updates"])
+	assert.Contains(t, calls, "/bottok-alerts/setWebhook")
+}
@@ -0,0 +1,182 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/allerac/notifier/internal/consumers/core"
+	"github.com/allerac/notifier/internal/crypto"
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+const (
+	channelName    = "webhook"
+	consumerGroup  = "webhook-group"
+	defaultTimeout = 10 * time.Second
+
+	// SignatureHeader carries "sha256=<hex HMAC-SHA256 of the request body>",
+	// keyed with the user's webhook secret.
+	SignatureHeader = "X-Allerac-Signature"
+)
+
+// DBPool is the subset of pgxpool.Pool used by the Consumer.
+type DBPool interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Payload is the JSON body POSTed to the user's callback URL.
+type Payload struct {
+	JobID       string `json:"job_id"`
+	UserID      string `json:"user_id"`
+	Content     string `json:"content"`
+	DeliveredAt string `json:"delivered_at"`
+}
+
+// Consumer reads "webhook" notifications from the Redis Stream and POSTs them
+// to the callback URL configured for the user. Stream handling comes from the
+// embedded Dispatcher.
+type Consumer struct {
+	*core.Dispatcher
+
+	db            DBPool
+	encryptionKey string
+	httpClient    *http.Client
+}
+
+// New creates a Consumer. encryptionKey decrypts the per-user signing secrets
+// (plain-text secrets are used as-is).
+func New(redisURL string, db DBPool, encryptionKey string) (*Consumer, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	return newConsumer(redis.NewClient(opts), db, encryptionKey), nil
+}
+
+// NewFromSentinel is New for a Sentinel-managed Redis master (see
+// publisher.SentinelClient).
+func NewFromSentinel(masterName string, sentinelAddrs []string, opts *redis.Options, db DBPool, encryptionKey string) (*Consumer, error) {
+	client, err := publisher.SentinelClient(masterName, sentinelAddrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newConsumer(client, db, encryptionKey), nil
+}
+
+func newConsumer(client *redis.Client, db DBPool, encryptionKey string) *Consumer {
+	c := &Consumer{
+		db:            db,
+		encryptionKey: encryptionKey,
+		// Per-URL timeouts are applied through the request context.
+		httpClient: &http.Client{},
+	}
+	c.Dispatcher = core.New(client, channelName, consumerGroup, c).WithDeadLetterDB(db).WithDeliveryReceipts(db)
+	return c
+}
+
+// Deliver implements core.Deliverer.
+func (c *Consumer) Deliver(ctx context.Context, msg redis.XMessage) error {
+	return c.ProcessMessage(ctx, msg)
+}
+
+// ProcessMessage POSTs a single stream message to the user's callback URL. Exported for testing.
+func (c *Consumer) ProcessMessage(ctx context.Context, msg redis.XMessage) error {
+	jobID, _ := msg.Values["job_id"].(string)
+	userID, _ := msg.Values["user_id"].(string)
+	content, _ := msg.Values["content"].(string)
+
+	target, err := c.getTarget(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("get webhook url for user %s: %w", userID, err)
+	}
+
+	body, err := json.Marshal(Payload{
+		JobID:       jobID,
+		UserID:      userID,
+		Content:     content,
+		DeliveredAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+
+	c.MessageLogger(msg).Info("delivering message", slog.String("message_id", msg.ID), slog.String("user_id", userID))
+	return c.post(ctx, target, body)
+}
+
+// target is a user's callback configuration.
+type target struct {
+	url     string
+	secret  string
+	timeout time.Duration
+}
+
+func (c *Consumer) getTarget(ctx context.Context, userID string) (target, error) {
+	var t target
+	var encryptedSecret string
+	var timeoutMs int
+	err := c.db.QueryRow(ctx, `
+		SELECT url, secret, timeout_ms
+		FROM user_webhook_urls
+		WHERE user_id = $1 AND enabled = true
+		LIMIT 1
+	`, userID).Scan(&t.url, &encryptedSecret, &timeoutMs)
+	if err != nil {
+		return t, err
+	}
+
+	t.secret, err = crypto.SafeDecrypt(encryptedSecret, c.encryptionKey)
+	if err != nil {
+		return t, fmt.Errorf("decrypt webhook secret: %w", err)
+	}
+	t.timeout = defaultTimeout
+	if timeoutMs > 0 {
+		t.timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+	return t, nil
+}
+
+func (c *Consumer) post(ctx context.Context, t target, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(t.secret, body))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the X-Allerac-Signature value for body: "sha256=" followed by
+// the hex-encoded HMAC-SHA256 of body keyed with secret. Receivers recompute it
+// over the raw request body and compare with hmac.Equal.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,164 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/consumers/webhook"
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+// --- mock DB ---
+
+// mockDB returns a fixed url, plain-text secret and timeout for every user.
+type mockDB struct {
+	url       string
+	secret    string
+	timeoutMs int
+	err       error
+}
+
+func (m *mockDB) QueryRow(_ context.Context, _ string, _ ...any) pgx.Row {
+	return &mockRow{db: m}
+}
+
+func (m *mockDB) Exec(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+type mockRow struct{ db *mockDB }
+
+func (r *mockRow) Scan(dest ...any) error {
+	if r.db.err != nil {
+		return r.db.err
+	}
+	*dest[0].(*string) = r.db.url
+	*dest[1].(*string) = r.db.secret
+	*dest[2].(*int) = r.db.timeoutMs
+	return nil
+}
+
+// --- helpers ---
+
+func newTestConsumer(t *testing.T, mr *miniredis.Miniredis, db *mockDB) *webhook.Consumer {
+	t.Helper()
+	c, err := webhook.New("redis://"+mr.Addr(), db, "")
+	require.NoError(t, err)
+	return c
+}
+
+func xMessage(userID, content string) redis.XMessage {
+	return redis.XMessage{
+		ID: "1-0",
+		Values: map[string]interface{}{
+			"job_id":  "job-1",
+			"user_id": userID,
+			"channel": "webhook",
+			"content": content,
+		},
+	}
+}
+
+// --- tests ---
+
+func TestConsumer_ProcessMessage_PostsSignedPayload(t *testing.T) {
+	const secret = "user-secret"
+
+	var gotBody []byte
+	var gotSignature, gotContentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSignature = r.Header.Get(webhook.SignatureHeader)
+		gotContentType = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{url: srv.URL, secret: secret, timeoutMs: 1000})
+
+	err := c.ProcessMessage(context.Background(), xMessage("user-1", "Hello, World!"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "application/json", gotContentType)
+	require.NotEmpty(t, gotSignature, "signature header present")
+	assert.Equal(t, webhook.Sign(secret, gotBody), gotSignature, "signature matches body")
+
+	var fields map[string]string
+	require.NoError(t, json.Unmarshal(gotBody, &fields))
+	assert.Len(t, fields, 4)
+	assert.Equal(t, "job-1", fields["job_id"])
+	assert.Equal(t, "user-1", fields["user_id"])
+	assert.Equal(t, "Hello, World!", fields["content"])
+	_, err = time.Parse(time.RFC3339, fields["delivered_at"])
+	assert.NoError(t, err, "delivered_at is RFC3339")
+}
+
+func TestConsumer_ProcessMessage_NonSuccessStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{url: srv.URL, secret: "s"})
+
+	err := c.ProcessMessage(context.Background(), xMessage("user-1", "hi"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "502")
+}
+
+func TestConsumer_ProcessMessage_PerURLTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{url: srv.URL, secret: "s", timeoutMs: 20})
+
+	err := c.ProcessMessage(context.Background(), xMessage("user-1", "hi"))
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestConsumer_ProcessMessage_NoWebhookURL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{err: fmt.Errorf("no rows in result set")})
+
+	err := c.ProcessMessage(context.Background(), xMessage("unknown-user", "hi"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "get webhook url")
+}
+
+func TestConsumer_ProcessWithDLQ_MovesToDLQAfterMaxAttempts(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{err: fmt.Errorf("no webhook")})
+	ctx := context.Background()
+	msg := xMessage("bad-user", "Hello!")
+
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	rc.Set(ctx, "notifications:attempts:"+msg.ID, 3, 0)
+
+	c.ProcessWithDLQ(ctx, msg)
+
+	dlqMsgs, err := rc.XRange(ctx, publisher.DLQStreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, dlqMsgs, 1)
+	assert.Equal(t, "webhook-group", dlqMsgs[0].Values["dlq_consumer_group"])
+	assert.Equal(t, msg.ID, dlqMsgs[0].Values["dlq_original_id"])
+}
@@ -0,0 +1,194 @@
+package webpush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/allerac/notifier/internal/channels"
+	"github.com/allerac/notifier/internal/consumers/core"
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+const (
+	channelName    = "browser"
+	consumerGroup  = "browser-group"
+	requestTimeout = 10 * time.Second
+
+	// Push services accept about 4 KB of encrypted payload; the body is cut
+	// well below that so the title and JSON framing always fit.
+	maxBodyLen = 1000
+
+	// defaultPushTTL is how long the push service keeps a notification for an
+	// offline browser when the message has no ttl_seconds of its own.
+	defaultPushTTL = 24 * time.Hour
+)
+
+// ErrSubscriptionGone is returned when the push service reports the user's
+// subscription as expired or unsubscribed (404/410); it has been deleted.
+var ErrSubscriptionGone = errors.New("push subscription gone")
+
+// DBPool is the subset of pgxpool.Pool used by the Consumer.
+type DBPool interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// VAPID identifies the application server to the push services.
+type VAPID struct {
+	PublicKey  string
+	PrivateKey string
+	Subject    string // contact for the push service operator, e.g. "mailto:ops@example.com"
+}
+
+// Payload is the JSON the service worker receives in its push event.
+type Payload struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	JobID string `json:"job_id"`
+}
+
+// Consumer reads "browser" notifications from the Redis Stream and sends them
+// with the Web Push Protocol to the user's push subscription. Stream handling
+// comes from the embedded Dispatcher.
+type Consumer struct {
+	*core.Dispatcher
+
+	db         DBPool
+	vapid      VAPID
+	httpClient *http.Client
+}
+
+// New creates a Consumer that signs its push requests with vapid.
+func New(redisURL string, db DBPool, vapid VAPID) (*Consumer, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	return newConsumer(redis.NewClient(opts), db, vapid), nil
+}
+
+// NewFromSentinel is New for a Sentinel-managed Redis master (see
+// publisher.SentinelClient).
+func NewFromSentinel(masterName string, sentinelAddrs []string, opts *redis.Options, db DBPool, vapid VAPID) (*Consumer, error) {
+	client, err := publisher.SentinelClient(masterName, sentinelAddrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newConsumer(client, db, vapid), nil
+}
+
+func newConsumer(client *redis.Client, db DBPool, vapid VAPID) *Consumer {
+	c := &Consumer{
+		db:         db,
+		vapid:      vapid,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}
+	c.Dispatcher = core.New(client, channelName, consumerGroup, c).WithDeadLetterDB(db).WithDeliveryReceipts(db)
+	return c
+}
+
+// Deliver implements core.Deliverer.
+func (c *Consumer) Deliver(ctx context.Context, msg redis.XMessage) error {
+	return c.ProcessMessage(ctx, msg)
+}
+
+// ProcessMessage pushes a single stream message to the user's browser. Exported for testing.
+func (c *Consumer) ProcessMessage(ctx context.Context, msg redis.XMessage) error {
+	jobID, _ := msg.Values["job_id"].(string)
+	jobName, _ := msg.Values["job_name"].(string)
+	userID, _ := msg.Values["user_id"].(string)
+	content, _ := msg.Values["content"].(string)
+
+	subID, sub, err := c.getSubscription(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("get push subscription for user %s: %w", userID, err)
+	}
+
+	title := publisher.MetadataOf(msg.Values)["subject"]
+	if title == "" {
+		title = jobName
+	}
+	body, err := json.Marshal(Payload{Title: title, Body: channels.TruncateText(content, maxBodyLen), JobID: jobID})
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+
+	c.MessageLogger(msg).Info("delivering message", slog.String("message_id", msg.ID), slog.String("user_id", userID))
+	resp, err := webpush.SendNotificationWithContext(ctx, body, &sub, &webpush.Options{
+		HTTPClient:      c.httpClient,
+		Subscriber:      c.vapid.Subject,
+		VAPIDPublicKey:  c.vapid.PublicKey,
+		VAPIDPrivateKey: c.vapid.PrivateKey,
+		TTL:             pushTTL(msg),
+		Urgency:         urgency(msg),
+	})
+	if err != nil {
+		return fmt.Errorf("push request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		// The browser unsubscribed or the subscription expired; it will never
+		// accept a push again.
+		if _, err := c.db.Exec(ctx, `DELETE FROM user_push_subscriptions WHERE id = $1`, subID); err != nil {
+			return fmt.Errorf("%w (%d), delete failed: %w", ErrSubscriptionGone, resp.StatusCode, err)
+		}
+		c.Logger().Warn("removed stale push subscription", slog.String("user_id", userID), slog.Int("status", resp.StatusCode))
+		return fmt.Errorf("%w (%d), removed", ErrSubscriptionGone, resp.StatusCode)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 512)) // body is best-effort diagnostics
+		return fmt.Errorf("push service returned %d: %s", resp.StatusCode, raw)
+	}
+	return nil
+}
+
+// getSubscription returns the user's most recent enabled push subscription,
+// stored as the browser's PushSubscription.toJSON().
+func (c *Consumer) getSubscription(ctx context.Context, userID string) (id string, sub webpush.Subscription, err error) {
+	var raw string
+	err = c.db.QueryRow(ctx, `
+		SELECT id, subscription
+		FROM user_push_subscriptions
+		WHERE user_id = $1 AND enabled = true
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, userID).Scan(&id, &raw)
+	if err != nil {
+		return "", sub, err
+	}
+	if err := json.Unmarshal([]byte(raw), &sub); err != nil {
+		return "", sub, fmt.Errorf("decode subscription: %w", err)
+	}
+	return id, sub, nil
+}
+
+// pushTTL is how many seconds the push service should hold the message for
+// an offline browser: the message's own ttl_seconds, or defaultPushTTL.
+func pushTTL(msg redis.XMessage) int {
+	raw, _ := msg.Values["ttl_seconds"].(string)
+	if ttl, err := strconv.Atoi(raw); err == nil && ttl > 0 {
+		return ttl
+	}
+	return int(defaultPushTTL.Seconds())
+}
+
+// urgency maps the notification priority onto the Web Push Urgency header.
+func urgency(msg redis.XMessage) webpush.Urgency {
+	if publisher.StreamOf(msg.Values) == publisher.HighPriorityStreamName {
+		return webpush.UrgencyHigh
+	}
+	return webpush.UrgencyNormal
+}
@@ -0,0 +1,218 @@
+package webpush_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pushlib "github.com/SherClockHolmes/webpush-go"
+
+	"github.com/allerac/notifier/internal/consumers/webpush"
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+// --- mock DB ---
+
+// mockDB returns one fixed subscription for every user and records deletes.
+type mockDB struct {
+	subscription string
+	err          error
+
+	mu      sync.Mutex
+	deleted []any
+}
+
+func (m *mockDB) QueryRow(_ context.Context, _ string, _ ...any) pgx.Row {
+	return &mockRow{db: m}
+}
+
+func (m *mockDB) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if strings.Contains(sql, "DELETE FROM user_push_subscriptions") {
+		m.mu.Lock()
+		m.deleted = append(m.deleted, args[0])
+		m.mu.Unlock()
+	}
+	return pgconn.CommandTag{}, nil
+}
+
+func (m *mockDB) deletedIDs() []any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]any(nil), m.deleted...)
+}
+
+type mockRow struct{ db *mockDB }
+
+func (r *mockRow) Scan(dest ...any) error {
+	if r.db.err != nil {
+		return r.db.err
+	}
+	*dest[0].(*string) = "sub-1"
+	*dest[1].(*string) = r.db.subscription
+	return nil
+}
+
+// --- helpers ---
+
+// subscriptionTo returns a browser PushSubscription JSON for endpoint, with
+// freshly generated client keys.
+func subscriptionTo(t *testing.T, endpoint string) string {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	auth := make([]byte, 16)
+	_, err = rand.Read(auth)
+	require.NoError(t, err)
+	raw, err := json.Marshal(map[string]any{
+		"endpoint": endpoint,
+		"keys": map[string]string{
+			"p256dh": base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+			"auth":   base64.RawURLEncoding.EncodeToString(auth),
+		},
+	})
+	require.NoError(t, err)
+	return string(raw)
+}
+
+// pushServer is a mock push service answering every request with status.
+func pushServer(t *testing.T, status int) (*httptest.Server, *[]*http.Request) {
+	t.Helper()
+	var mu sync.Mutex
+	var reqs []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		reqs = append(reqs, r)
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &reqs
+}
+
+func newTestConsumer(t *testing.T, mr *miniredis.Miniredis, db *mockDB) *webpush.Consumer {
+	t.Helper()
+	private, public, err := pushlib.GenerateVAPIDKeys()
+	require.NoError(t, err)
+	c, err := webpush.New("redis://"+mr.Addr(), db, webpush.VAPID{
+		PublicKey: public, PrivateKey: private, Subject: "mailto:ops@example.com",
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func xMessage(content string) redis.XMessage {
+	return redis.XMessage{
+		ID: "1-0",
+		Values: map[string]interface{}{
+			"job_id":   "job-1",
+			"job_name": "Morning Briefing",
+			"user_id":  "user-1",
+			"channel":  "browser",
+			"content":  content,
+		},
+	}
+}
+
+// --- tests ---
+
+func TestConsumer_ProcessMessage_PushesToSubscription(t *testing.T) {
+	srv, reqs := pushServer(t, http.StatusCreated)
+	db := &mockDB{subscription: subscriptionTo(t, srv.URL+"/push/abc")}
+	c := newTestConsumer(t, miniredis.RunT(t), db)
+
+	require.NoError(t, c.ProcessMessage(context.Background(), xMessage("Sunny, 24°C")))
+
+	require.Len(t, *reqs, 1)
+	r := (*reqs)[0]
+	assert.Equal(t, "/push/abc", r.URL.Path)
+	assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "vapid t="), r.Header.Get("Authorization"))
+	assert.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+	assert.Equal(t, "86400", r.Header.Get("TTL"))
+	assert.Equal(t, "normal", r.Header.Get("Urgency"))
+	assert.Empty(t, db.deletedIDs())
+}
+
+func TestConsumer_ProcessMessage_UsesMessageTTLAndPriority(t *testing.T) {
+	srv, reqs := pushServer(t, http.StatusCreated)
+	c := newTestConsumer(t, miniredis.RunT(t), &mockDB{subscription: subscriptionTo(t, srv.URL)})
+	msg := xMessage("Server down")
+	msg.Values["ttl_seconds"] = "300"
+	msg.Values["priority"] = "10"
+
+	require.NoError(t, c.ProcessMessage(context.Background(), msg))
+
+	require.Len(t, *reqs, 1)
+	assert.Equal(t, "300", (*reqs)[0].Header.Get("TTL"))
+	assert.Equal(t, "high", (*reqs)[0].Header.Get("Urgency"))
+}
+
+func TestConsumer_ProcessMessage_RemovesGoneSubscription(t *testing.T) {
+	for _, status := range []int{http.StatusGone, http.StatusNotFound} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			srv, _ := pushServer(t, status)
+			db := &mockDB{subscription: subscriptionTo(t, srv.URL)}
+			c := newTestConsumer(t, miniredis.RunT(t), db)
+
+			err := c.ProcessMessage(context.Background(), xMessage("hi"))
+
+			require.ErrorIs(t, err, webpush.ErrSubscriptionGone)
+			assert.Equal(t, []any{"sub-1"}, db.deletedIDs())
+		})
+	}
+}
+
+func TestConsumer_ProcessMessage_PushServiceError(t *testing.T) {
+	srv, _ := pushServer(t, http.StatusInternalServerError)
+	db := &mockDB{subscription: subscriptionTo(t, srv.URL)}
+	c := newTestConsumer(t, miniredis.RunT(t), db)
+
+	err := c.ProcessMessage(context.Background(), xMessage("hi"))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "500")
+	assert.False(t, errors.Is(err, webpush.ErrSubscriptionGone))
+	assert.Empty(t, db.deletedIDs(), "a server error keeps the subscription")
+}
+
+func TestConsumer_ProcessMessage_NoSubscription(t *testing.T) {
+	c := newTestConsumer(t, miniredis.RunT(t), &mockDB{err: pgx.ErrNoRows})
+
+	err := c.ProcessMessage(context.Background(), xMessage("hi"))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "get push subscription")
+}
+
+func TestConsumer_ProcessWithDLQ_MovesToDLQAfterMaxAttempts(t *testing.T) {
+	srv, _ := pushServer(t, http.StatusServiceUnavailable)
+	mr := miniredis.RunT(t)
+	c := newTestConsumer(t, mr, &mockDB{subscription: subscriptionTo(t, srv.URL)})
+	ctx := context.Background()
+	msg := xMessage("hi")
+
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	rc.Set(ctx, "notifications:attempts:"+msg.ID, 3, 0)
+
+	c.ProcessWithDLQ(ctx, msg)
+
+	dlqMsgs, err := rc.XRange(ctx, publisher.DLQStreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, dlqMsgs, 1)
+	assert.Equal(t, "browser-group", dlqMsgs[0].Values["dlq_consumer_group"])
+}
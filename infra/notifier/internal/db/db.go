@@ -3,19 +3,121 @@ package db
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/allerac/notifier/internal/retry"
 )
 
-// Connect creates a PostgreSQL connection pool and verifies connectivity.
-func Connect(ctx context.Context, url string) (*pgxpool.Pool, error) {
-	pool, err := pgxpool.New(ctx, url)
+// PoolSettings tune the connection pool, e.g. to cap the notifier's share of
+// a small PostgreSQL. Zero fields keep pgx's defaults (or the pool_*
+// parameters of the URL).
+type PoolSettings struct {
+	MaxConns        int32         // DB_MAX_CONNS
+	MinConns        int32         // DB_MIN_CONNS
+	MaxConnLifetime time.Duration // DB_MAX_CONN_LIFETIME
+	MaxConnIdleTime time.Duration // DB_MAX_CONN_IDLE_TIME
+}
+
+// PoolConfig parses url and applies the non-zero settings of s.
+func PoolConfig(url string, s PoolSettings) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	if s.MaxConns > 0 {
+		cfg.MaxConns = s.MaxConns
+	}
+	if s.MinConns > 0 {
+		cfg.MinConns = s.MinConns
+	}
+	if s.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = s.MaxConnLifetime
+	}
+	if s.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = s.MaxConnIdleTime
+	}
+	if cfg.MinConns > cfg.MaxConns {
+		return nil, fmt.Errorf("min conns (%d) exceeds max conns (%d)", cfg.MinConns, cfg.MaxConns)
+	}
+	return cfg, nil
+}
+
+// newPool creates a pool from url and s (see PoolConfig). It only parses;
+// connections are opened on demand.
+func newPool(ctx context.Context, url string, s PoolSettings) (*pgxpool.Pool, error) {
+	cfg, err := PoolConfig(url, s)
+	if err != nil {
+		return nil, fmt.Errorf("pool config: %w", err)
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("create pool: %w", err)
 	}
+	return pool, nil
+}
+
+// Connect creates a PostgreSQL connection pool with the default pool
+// settings and verifies connectivity.
+func Connect(ctx context.Context, url string) (*pgxpool.Pool, error) {
+	return connect(ctx, url, PoolSettings{})
+}
+
+func connect(ctx context.Context, url string, s PoolSettings) (*pgxpool.Pool, error) {
+	pool, err := newPool(ctx, url, s)
+	if err != nil {
+		return nil, err
+	}
 	if err := pool.Ping(ctx); err != nil {
 		pool.Close()
 		return nil, fmt.Errorf("ping database: %w", err)
 	}
 	return pool, nil
 }
+
+// ConnectWithRetry is Connect with pool settings s, for a database that may
+// not be up yet, e.g. when the notifier starts alongside PostgreSQL: see
+// RetryConnect. A URL or pool setting that cannot be used fails right away.
+func ConnectWithRetry(ctx context.Context, url string, s PoolSettings, maxAttempts int, baseDelay time.Duration) (*pgxpool.Pool, error) {
+	if _, err := PoolConfig(url, s); err != nil {
+		return nil, fmt.Errorf("pool config: %w", err)
+	}
+	return RetryConnect(ctx, url, maxAttempts, baseDelay, func(ctx context.Context, url string) (*pgxpool.Pool, error) {
+		return connect(ctx, url, s)
+	})
+}
+
+// ConnectFunc opens a verified connection pool; Connect is one.
+type ConnectFunc func(ctx context.Context, url string) (*pgxpool.Pool, error)
+
+// RetryConnect calls connect until it succeeds, maxAttempts calls have
+// failed, or ctx is done, logging every failure. It waits baseDelay after the
+// first failure and doubles the wait after each further one, plus a random
+// extra (see retry.Ping).
+func RetryConnect(ctx context.Context, url string, maxAttempts int, baseDelay time.Duration, connect ConnectFunc) (*pgxpool.Pool, error) {
+	var pool *pgxpool.Pool
+	err := retry.Ping(ctx, "postgres", maxAttempts, baseDelay, func(ctx context.Context) error {
+		p, err := connect(ctx, url)
+		if err != nil {
+			return err
+		}
+		pool = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// PoolStats returns the pool's connection counts: "acquired" (in use),
+// "idle" and "total".
+func PoolStats(pool *pgxpool.Pool) map[string]int {
+	stat := pool.Stat()
+	return map[string]int{
+		"acquired": int(stat.AcquiredConns()),
+		"idle":     int(stat.IdleConns()),
+		"total":    int(stat.TotalConns()),
+	}
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Execer is the subset of pgxpool.Pool used by LogDelivery and RecordReceipt.
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// DeliveryRecord is one notification_delivery_log row. ChatID is the
+// recipient on the channel (e.g. the Telegram chat ID) when the consumer
+// reports one, and stored as NULL otherwise.
+type DeliveryRecord struct {
+	UserID      string
+	JobID       string
+	Channel     string
+	Content     string
+	ChatID      string
+	DeliveredAt time.Time
+}
+
+// LogDelivery inserts record into notification_delivery_log.
+func LogDelivery(ctx context.Context, db Execer, record DeliveryRecord) error {
+	_, err := db.Exec(ctx, `
+		INSERT INTO notification_delivery_log (user_id, job_id, channel, content, delivered_at, chat_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+	`, record.UserID, record.JobID, record.Channel, record.Content, record.DeliveredAt, record.ChatID)
+	return err
+}
+
+// DeliveryReceipt is one notification_deliveries row: proof that the message
+// with StreamID was handed to the channel's provider. ProviderMsgID is the
+// provider's ID for it (e.g. the Telegram message_id) when the consumer
+// reports one, and stored as NULL otherwise.
+type DeliveryReceipt struct {
+	JobID         string
+	UserID        string
+	Channel       string
+	StreamID      string
+	ProviderMsgID string
+	DeliveredAt   time.Time
+}
+
+// RecordReceipt inserts receipt into notification_deliveries.
+func RecordReceipt(ctx context.Context, db Execer, receipt DeliveryReceipt) error {
+	_, err := db.Exec(ctx, `
+		INSERT INTO notification_deliveries (job_id, user_id, channel, stream_id, delivered_at, provider_message_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+	`, receipt.JobID, receipt.UserID, receipt.Channel, receipt.StreamID, receipt.DeliveredAt, receipt.ProviderMsgID)
+	return err
+}
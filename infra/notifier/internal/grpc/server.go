@@ -0,0 +1,303 @@
+// Package grpc serves the job management API over gRPC, as an alternative to
+// the REST API in internal/api: the same operations on the same api.Store,
+// plus TriggerJob, described by internal/proto/notifier.proto. The server
+// also answers the standard grpc.health.v1 health checks.
+package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/google/uuid"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/allerac/notifier/internal/api"
+	pb "github.com/allerac/notifier/internal/proto"
+	"github.com/allerac/notifier/internal/scheduler"
+)
+
+// Trigger runs a job immediately; implemented by *scheduler.Scheduler.
+type Trigger interface {
+	TriggerNow(ctx context.Context, jobID string) error
+}
+
+// JobServer implements pb.JobServiceServer on top of an api.Store.
+type JobServer struct {
+	pb.UnimplementedJobServiceServer
+
+	store   *api.Store
+	trigger Trigger
+	logger  *slog.Logger
+}
+
+// NewJobServer creates a JobServer backed by db.
+func NewJobServer(db api.DBPool) *JobServer {
+	return &JobServer{store: api.NewStore(db), logger: slog.Default()}
+}
+
+// WithTrigger serves TriggerJob through t; without it TriggerJob answers
+// UNAVAILABLE.
+func (s *JobServer) WithTrigger(t Trigger) *JobServer {
+	s.trigger = t
+	return s
+}
+
+// WithLogger sets the logger used by the server (default slog.Default()).
+func (s *JobServer) WithLogger(l *slog.Logger) *JobServer {
+	s.logger = l
+	return s
+}
+
+func (s *JobServer) CreateJob(ctx context.Context, req *pb.CreateJobRequest) (*pb.Job, error) {
+	spec, err := fromSpec(req.GetJob())
+	if err != nil {
+		return nil, err
+	}
+	job, err := s.store.Create(ctx, spec)
+	if err != nil {
+		return nil, s.storeError("create job", err)
+	}
+	s.logger.Info("grpc: job created", slog.String("job_id", job.ID), slog.String("job_name", job.Name))
+	return toProto(job)
+}
+
+func (s *JobServer) GetJob(ctx context.Context, req *pb.GetJobRequest) (*pb.Job, error) {
+	job, err := s.store.Get(ctx, req.GetId())
+	if err != nil {
+		return nil, s.storeError("get job", err)
+	}
+	return toProto(job)
+}
+
+func (s *JobServer) ListJobs(ctx context.Context, req *pb.ListJobsRequest) (*pb.ListJobsResponse, error) {
+	jobs, err := s.store.List(ctx, req.GetUserId())
+	if err != nil {
+		return nil, s.storeError("list jobs", err)
+	}
+	resp := &pb.ListJobsResponse{Jobs: make([]*pb.Job, 0, len(jobs))}
+	for _, j := range jobs {
+		pj, err := toProto(j)
+		if err != nil {
+			return nil, err
+		}
+		resp.Jobs = append(resp.Jobs, pj)
+	}
+	return resp, nil
+}
+
+func (s *JobServer) UpdateJob(ctx context.Context, req *pb.UpdateJobRequest) (*pb.Job, error) {
+	spec, err := fromSpec(req.GetJob())
+	if err != nil {
+		return nil, err
+	}
+	job, err := s.store.Update(ctx, req.GetId(), spec)
+	if err != nil {
+		return nil, s.storeError("update job", err)
+	}
+	s.logger.Info("grpc: job updated", slog.String("job_id", job.ID), slog.String("job_name", job.Name))
+	return toProto(job)
+}
+
+// DeleteJob disables the job rather than deleting the row, like the REST
+// API's DELETE.
+func (s *JobServer) DeleteJob(ctx context.Context, req *pb.DeleteJobRequest) (*emptypb.Empty, error) {
+	if err := s.store.Disable(ctx, req.GetId()); err != nil {
+		return nil, s.storeError("delete job", err)
+	}
+	s.logger.Info("grpc: job disabled", slog.String("job_id", req.GetId()))
+	return &emptypb.Empty{}, nil
+}
+
+// TriggerJob returns once the execution has started, like the admin API's
+// POST /admin/jobs/{id}/trigger.
+func (s *JobServer) TriggerJob(ctx context.Context, req *pb.TriggerJobRequest) (*emptypb.Empty, error) {
+	if s.trigger == nil {
+		return nil, status.Error(codes.Unavailable, "job triggering unavailable")
+	}
+	if uuid.Validate(req.GetId()) != nil {
+		return nil, status.Error(codes.NotFound, scheduler.ErrJobNotFound.Error())
+	}
+	err := s.trigger.TriggerNow(ctx, req.GetId())
+	switch {
+	case errors.Is(err, scheduler.ErrJobNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, scheduler.ErrJobAlreadyRunning):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		s.logger.Error("grpc: trigger job failed", slog.String("job_id", req.GetId()), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to trigger job")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// storeError maps the api.Store's client errors to NOT_FOUND,
+// INVALID_ARGUMENT and ALREADY_EXISTS, and anything else to INTERNAL.
+func (s *JobServer) storeError(op string, err error) error {
+	switch {
+	case errors.Is(err, api.ErrJobNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, api.ErrInvalidJob):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, api.ErrNameTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	s.logger.Error("grpc: "+op+" failed", slog.Any("error", err))
+	return status.Error(codes.Internal, op+" failed")
+}
+
+// fromSpec converts a JobSpec to the api.JobRequest the store validates.
+func fromSpec(spec *pb.JobSpec) (api.JobRequest, error) {
+	if spec == nil {
+		return api.JobRequest{}, status.Error(codes.InvalidArgument, "job is required")
+	}
+	req := api.JobRequest{
+		UserID:               spec.GetUserId(),
+		Name:                 spec.GetName(),
+		CronExpr:             spec.GetCronExpr(),
+		Prompt:               spec.GetPrompt(),
+		SystemPrompt:         spec.GetSystemPrompt(),
+		Channels:             spec.GetChannels(),
+		MessageFormat:        spec.GetMessageFormat(),
+		Timezone:             spec.GetTimezone(),
+		TimeoutSeconds:       int(spec.GetTimeoutSeconds()),
+		MaxAttempts:          int(spec.GetMaxAttempts()),
+		RetryDelaySeconds:    int(spec.GetRetryDelaySeconds()),
+		DedupWindowSeconds:   int(spec.GetDedupWindowSeconds()),
+		DeliveryDelaySeconds: int(spec.GetDeliveryDelaySeconds()),
+		CatchUpSeconds:       int(spec.GetCatchUpWindowSeconds()),
+		TemplateVars:         spec.GetTemplateVars(),
+		Enabled:              spec.Enabled,
+	}
+	if spec.RunAt != nil {
+		runAt := spec.RunAt.AsTime()
+		req.RunAt = &runAt
+	}
+	if len(spec.GetMetadata()) > 0 {
+		req.Metadata = make(map[string]json.RawMessage, len(spec.GetMetadata()))
+		for k, v := range spec.GetMetadata() {
+			raw, err := protojson.Marshal(v)
+			if err != nil {
+				return api.JobRequest{}, status.Errorf(codes.InvalidArgument, "metadata %q: %v", k, err)
+			}
+			req.Metadata[k] = raw
+		}
+	}
+	return req, nil
+}
+
+// toProto converts a job as stored to its protobuf form.
+func toProto(j api.Job) (*pb.Job, error) {
+	pj := &pb.Job{
+		Id:                   j.ID,
+		UserId:               j.UserID,
+		Name:                 j.Name,
+		CronExpr:             j.CronExpr,
+		Prompt:               j.Prompt,
+		SystemPrompt:         j.SystemPrompt,
+		Channels:             j.Channels,
+		MessageFormat:        j.MessageFormat,
+		Timezone:             j.Timezone,
+		TimeoutSeconds:       int32(j.TimeoutSeconds),
+		MaxAttempts:          int32(j.MaxAttempts),
+		RetryDelaySeconds:    int32(j.RetryDelaySeconds),
+		DedupWindowSeconds:   int32(j.DedupWindowSeconds),
+		DeliveryDelaySeconds: int32(j.DeliveryDelaySeconds),
+		CatchUpWindowSeconds: int32(j.CatchUpSeconds),
+		TemplateVars:         j.TemplateVars,
+		Enabled:              j.Enabled,
+	}
+	if j.RunAt != nil {
+		pj.RunAt = timestamppb.New(*j.RunAt)
+	}
+	if len(j.Metadata) > 0 {
+		pj.Metadata = make(map[string]*structpb.Value, len(j.Metadata))
+		for k, raw := range j.Metadata {
+			v := &structpb.Value{}
+			if err := protojson.Unmarshal(raw, v); err != nil {
+				return nil, status.Errorf(codes.Internal, "metadata %q: %v", k, err)
+			}
+			pj.Metadata[k] = v
+		}
+	}
+	return pj, nil
+}
+
+// Server is a gRPC server for a JobServer and the health service, protected
+// by "authorization: Bearer <token>" metadata. Health checks need no token.
+type Server struct {
+	grpc   *grpclib.Server
+	health *health.Server
+}
+
+// NewServer creates a Server for jobs. With an empty token every JobService
+// call is answered UNAVAILABLE, like the REST API's 503.
+func NewServer(jobs *JobServer, token string) *Server {
+	s := &Server{
+		grpc:   grpclib.NewServer(grpclib.UnaryInterceptor(authInterceptor(token))),
+		health: health.NewServer(),
+	}
+	pb.RegisterJobServiceServer(s.grpc, jobs)
+	healthpb.RegisterHealthServer(s.grpc, s.health)
+	s.health.SetServingStatus(pb.JobService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	return s
+}
+
+// Serve accepts connections on lis until Shutdown; see grpc.Server.Serve.
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpc.Serve(lis)
+}
+
+// Shutdown reports NOT_SERVING to health checks and waits for in-flight
+// calls to finish, cancelling those still running when ctx ends.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.health.Shutdown()
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.grpc.Stop()
+		return ctx.Err()
+	}
+}
+
+// authInterceptor checks the bearer token on every call except the health
+// service's.
+func authInterceptor(token string) grpclib.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpclib.UnaryServerInfo, handler grpclib.UnaryHandler) (any, error) {
+		if strings.HasPrefix(info.FullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") {
+			return handler(ctx, req)
+		}
+		if token == "" {
+			return nil, status.Error(codes.Unavailable, "job API disabled: NOTIFIER_ADMIN_TOKEN not set")
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		var got string
+		ok := false
+		if v := md.Get("authorization"); len(v) > 0 {
+			got, ok = strings.CutPrefix(v[0], "Bearer ")
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "unauthorized")
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,378 @@
+package grpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/allerac/notifier/internal/api"
+	notifiergrpc "github.com/allerac/notifier/internal/grpc"
+	pb "github.com/allerac/notifier/internal/proto"
+	"github.com/allerac/notifier/internal/scheduler"
+)
+
+const (
+	token = "s3cret"
+	alice = "00000000-0000-0000-0000-00000000000a"
+	bob   = "00000000-0000-0000-0000-00000000000b"
+)
+
+// --- mock DB ---
+
+// mockDB is an in-memory scheduled_jobs table answering the api.Store's queries.
+type mockDB struct {
+	mu   sync.Mutex
+	jobs []api.Job
+}
+
+func (m *mockDB) Query(_ context.Context, _ string, args ...any) (pgx.Rows, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var rows mockRows
+	for _, j := range m.jobs {
+		if len(args) == 0 || j.UserID == args[0] {
+			rows.jobs = append(rows.jobs, j)
+		}
+	}
+	return &rows, nil
+}
+
+func (m *mockDB) QueryRow(_ context.Context, sql string, args ...any) pgx.Row {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case strings.Contains(sql, "SELECT EXISTS"):
+		for _, j := range m.jobs {
+			if j.UserID == args[0] && j.Name == args[1] && j.Enabled && j.ID != args[2] {
+				return valueRow{true}
+			}
+		}
+		return valueRow{false}
+	case strings.Contains(sql, "INSERT INTO scheduled_jobs"):
+		j := api.Job{ID: fmt.Sprintf("00000000-0000-0000-0001-%012d", len(m.jobs)+1), UserID: args[0].(string)}
+		setFields(&j, args[1:])
+		m.jobs = append(m.jobs, j)
+		return jobRow{j}
+	case strings.Contains(sql, "UPDATE scheduled_jobs"):
+		for i := range m.jobs {
+			if m.jobs[i].ID == args[0] {
+				setFields(&m.jobs[i], args[1:])
+				return jobRow{m.jobs[i]}
+			}
+		}
+		return errRow{pgx.ErrNoRows}
+	default: // SELECT ... WHERE id = $1
+		for _, j := range m.jobs {
+			if j.ID == args[0] {
+				return jobRow{j}
+			}
+		}
+		return errRow{pgx.ErrNoRows}
+	}
+}
+
+func (m *mockDB) Exec(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.jobs {
+		if m.jobs[i].ID == args[0] {
+			m.jobs[i].Enabled = false
+			return pgconn.NewCommandTag("UPDATE 1"), nil
+		}
+	}
+	return pgconn.NewCommandTag("UPDATE 0"), nil
+}
+
+// setFields applies the name..enabled arguments shared by INSERT and UPDATE.
+func setFields(j *api.Job, a []any) {
+	j.Name, j.CronExpr, j.Prompt, j.SystemPrompt = a[0].(string), a[1].(string), a[2].(string), a[3].(string)
+	j.Channels, j.MessageFormat, j.Timezone = a[4].([]string), a[5].(string), a[6].(string)
+	j.TimeoutSeconds, j.RunAt, j.MaxAttempts = a[7].(int), a[8].(*time.Time), a[9].(int)
+	j.RetryDelaySeconds, j.DedupWindowSeconds, j.DeliveryDelaySeconds = a[10].(int), a[11].(int), a[12].(int)
+	j.CatchUpSeconds = a[13].(int)
+	j.TemplateVars, _ = a[14].(map[string]string)
+	j.Metadata, _ = a[15].(map[string]json.RawMessage)
+	j.Enabled = a[16].(bool)
+}
+
+// jobRow scans a job in the Store's column order.
+type jobRow struct{ j api.Job }
+
+func (r jobRow) Scan(dest ...any) error {
+	j := r.j
+	src := []any{j.ID, j.UserID, j.Name, j.CronExpr, j.Prompt, j.SystemPrompt, j.Channels, j.MessageFormat, j.Timezone,
+		j.TimeoutSeconds, j.RunAt, j.MaxAttempts, j.RetryDelaySeconds, j.DedupWindowSeconds, j.DeliveryDelaySeconds, j.CatchUpSeconds,
+		j.TemplateVars, j.Metadata, j.Enabled}
+	for i, v := range src {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+type valueRow struct{ v bool }
+
+func (r valueRow) Scan(dest ...any) error {
+	*dest[0].(*bool) = r.v
+	return nil
+}
+
+type errRow struct{ err error }
+
+func (r errRow) Scan(...any) error { return r.err }
+
+type mockRows struct {
+	jobs []api.Job
+	pos  int
+}
+
+func (r *mockRows) Close()                                       {}
+func (r *mockRows) Err() error                                   { return nil }
+func (r *mockRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *mockRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *mockRows) Values() ([]any, error)                       { return nil, nil }
+func (r *mockRows) RawValues() [][]byte                          { return nil }
+func (r *mockRows) Conn() *pgx.Conn                              { return nil }
+
+func (r *mockRows) Next() bool {
+	r.pos++
+	return r.pos <= len(r.jobs)
+}
+
+func (r *mockRows) Scan(dest ...any) error { return jobRow{r.jobs[r.pos-1]}.Scan(dest...) }
+
+// mockTrigger records the jobs it was asked to run and returns err.
+type mockTrigger struct {
+	err error
+	ids []string
+}
+
+func (m *mockTrigger) TriggerNow(_ context.Context, jobID string) error {
+	m.ids = append(m.ids, jobID)
+	return m.err
+}
+
+// --- helpers ---
+
+// dial serves jobs over an in-memory bufconn listener with serverToken and
+// returns a connection to it.
+func dial(t *testing.T, jobs *notifiergrpc.JobServer, serverToken string) *grpclib.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := notifiergrpc.NewServer(jobs, serverToken)
+	go srv.Serve(lis)
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+	conn, err := grpclib.NewClient("passthrough:///bufnet",
+		grpclib.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpclib.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func newClient(t *testing.T, db *mockDB) pb.JobServiceClient {
+	t.Helper()
+	return pb.NewJobServiceClient(dial(t, notifiergrpc.NewJobServer(db), token))
+}
+
+// authed returns a context carrying the bearer token.
+func authed() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func briefing(userID string) *pb.JobSpec {
+	return &pb.JobSpec{
+		UserId:   userID,
+		Name:     "Morning Briefing",
+		CronExpr: "0 8 * * *",
+		Prompt:   "Summarise my day",
+		Channels: []string{"telegram"},
+	}
+}
+
+func create(t *testing.T, c pb.JobServiceClient, spec *pb.JobSpec) *pb.Job {
+	t.Helper()
+	job, err := c.CreateJob(authed(), &pb.CreateJobRequest{Job: spec})
+	require.NoError(t, err)
+	return job
+}
+
+func requireCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	require.Error(t, err)
+	assert.Equal(t, want, status.Code(err), err.Error())
+}
+
+// --- tests ---
+
+func TestJobServer_RequiresToken(t *testing.T) {
+	c := newClient(t, &mockDB{})
+
+	_, err := c.ListJobs(context.Background(), &pb.ListJobsRequest{})
+	requireCode(t, err, codes.Unauthenticated)
+
+	wrong := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer nope")
+	_, err = c.ListJobs(wrong, &pb.ListJobsRequest{})
+	requireCode(t, err, codes.Unauthenticated)
+
+	disabled := pb.NewJobServiceClient(dial(t, notifiergrpc.NewJobServer(&mockDB{}), ""))
+	_, err = disabled.ListJobs(authed(), &pb.ListJobsRequest{})
+	requireCode(t, err, codes.Unavailable)
+}
+
+func TestJobServer_HealthCheckNeedsNoToken(t *testing.T) {
+	health := healthpb.NewHealthClient(dial(t, notifiergrpc.NewJobServer(&mockDB{}), token))
+
+	for _, service := range []string{"", pb.JobService_ServiceDesc.ServiceName} {
+		resp, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus(), "service %q", service)
+	}
+}
+
+func TestJobServer_CreateAndGet(t *testing.T) {
+	c := newClient(t, &mockDB{})
+	runAt := time.Date(2026, 5, 1, 9, 30, 0, 0, time.UTC)
+	spec := briefing(alice)
+	spec.RunAt = timestamppb.New(runAt)
+	spec.TemplateVars = map[string]string{"city": "Lisbon"}
+	spec.Metadata = map[string]*structpb.Value{"team": structpb.NewStringValue("ops")}
+
+	created := create(t, c, spec)
+	assert.NotEmpty(t, created.GetId())
+	assert.True(t, created.GetEnabled(), "enabled defaults to true")
+
+	got, err := c.GetJob(authed(), &pb.GetJobRequest{Id: created.GetId()})
+	require.NoError(t, err)
+	assert.Equal(t, alice, got.GetUserId())
+	assert.Equal(t, "Morning Briefing", got.GetName())
+	assert.Equal(t, []string{"telegram"}, got.GetChannels())
+	assert.Equal(t, runAt, got.GetRunAt().AsTime())
+	assert.Equal(t, map[string]string{"city": "Lisbon"}, got.GetTemplateVars())
+	assert.Equal(t, "ops", got.GetMetadata()["team"].GetStringValue())
+}
+
+func TestJobServer_CreateValidates(t *testing.T) {
+	c := newClient(t, &mockDB{})
+
+	_, err := c.CreateJob(authed(), &pb.CreateJobRequest{})
+	requireCode(t, err, codes.InvalidArgument)
+
+	noPrompt := briefing(alice)
+	noPrompt.Prompt = ""
+	_, err = c.CreateJob(authed(), &pb.CreateJobRequest{Job: noPrompt})
+	requireCode(t, err, codes.InvalidArgument)
+	assert.Contains(t, status.Convert(err).Message(), "prompt is required")
+
+	_, err = c.CreateJob(authed(), &pb.CreateJobRequest{Job: briefing("not-a-uuid")})
+	requireCode(t, err, codes.InvalidArgument)
+}
+
+func TestJobServer_CreateDuplicateNameAlreadyExists(t *testing.T) {
+	c := newClient(t, &mockDB{})
+	create(t, c, briefing(alice))
+
+	_, err := c.CreateJob(authed(), &pb.CreateJobRequest{Job: briefing(alice)})
+	requireCode(t, err, codes.AlreadyExists)
+
+	create(t, c, briefing(bob)) // names are per user
+}
+
+func TestJobServer_List(t *testing.T) {
+	c := newClient(t, &mockDB{})
+	create(t, c, briefing(alice))
+	create(t, c, briefing(bob))
+
+	all, err := c.ListJobs(authed(), &pb.ListJobsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, all.GetJobs(), 2)
+
+	mine, err := c.ListJobs(authed(), &pb.ListJobsRequest{UserId: alice})
+	require.NoError(t, err)
+	require.Len(t, mine.GetJobs(), 1)
+	assert.Equal(t, alice, mine.GetJobs()[0].GetUserId())
+
+	_, err = c.ListJobs(authed(), &pb.ListJobsRequest{UserId: "alice"})
+	requireCode(t, err, codes.InvalidArgument)
+}
+
+func TestJobServer_UpdateAndDelete(t *testing.T) {
+	c := newClient(t, &mockDB{})
+	job := create(t, c, briefing(alice))
+
+	spec := briefing("")
+	spec.Prompt = "Summarise my week"
+	updated, err := c.UpdateJob(authed(), &pb.UpdateJobRequest{Id: job.GetId(), Job: spec})
+	require.NoError(t, err)
+	assert.Equal(t, "Summarise my week", updated.GetPrompt())
+	assert.Equal(t, alice, updated.GetUserId())
+	assert.True(t, updated.GetEnabled(), "omitted enabled keeps the current state")
+
+	_, err = c.UpdateJob(authed(), &pb.UpdateJobRequest{Id: job.GetId(), Job: briefing(bob)})
+	requireCode(t, err, codes.InvalidArgument)
+
+	_, err = c.DeleteJob(authed(), &pb.DeleteJobRequest{Id: job.GetId()})
+	require.NoError(t, err)
+	got, err := c.GetJob(authed(), &pb.GetJobRequest{Id: job.GetId()})
+	require.NoError(t, err)
+	assert.False(t, got.GetEnabled(), "delete is soft")
+}
+
+func TestJobServer_UnknownJob(t *testing.T) {
+	c := newClient(t, &mockDB{})
+	unknown := "00000000-0000-0000-0000-0000000000ff"
+
+	_, err := c.GetJob(authed(), &pb.GetJobRequest{Id: unknown})
+	requireCode(t, err, codes.NotFound)
+	_, err = c.GetJob(authed(), &pb.GetJobRequest{Id: "nope"})
+	requireCode(t, err, codes.NotFound)
+	_, err = c.UpdateJob(authed(), &pb.UpdateJobRequest{Id: unknown, Job: briefing(alice)})
+	requireCode(t, err, codes.NotFound)
+	_, err = c.DeleteJob(authed(), &pb.DeleteJobRequest{Id: unknown})
+	requireCode(t, err, codes.NotFound)
+}
+
+func TestJobServer_TriggerJob(t *testing.T) {
+	id := "00000000-0000-0000-0001-000000000001"
+	trigger := &mockTrigger{}
+	c := pb.NewJobServiceClient(dial(t, notifiergrpc.NewJobServer(&mockDB{}).WithTrigger(trigger), token))
+
+	_, err := c.TriggerJob(authed(), &pb.TriggerJobRequest{Id: id})
+	require.NoError(t, err)
+	assert.Equal(t, []string{id}, trigger.ids)
+
+	trigger.err = scheduler.ErrJobAlreadyRunning
+	_, err = c.TriggerJob(authed(), &pb.TriggerJobRequest{Id: id})
+	requireCode(t, err, codes.FailedPrecondition)
+
+	trigger.err = scheduler.ErrJobNotFound
+	_, err = c.TriggerJob(authed(), &pb.TriggerJobRequest{Id: id})
+	requireCode(t, err, codes.NotFound)
+}
+
+func TestJobServer_TriggerJobWithoutScheduler(t *testing.T) {
+	c := newClient(t, &mockDB{})
+
+	_, err := c.TriggerJob(authed(), &pb.TriggerJobRequest{Id: "00000000-0000-0000-0001-000000000001"})
+	requireCode(t, err, codes.Unavailable)
+}
@@ -0,0 +1,45 @@
+// Package logging builds the service's structured (JSON or text) logger.
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// NewLogger returns a logger writing one JSON object per line to w, dropping
+// records below level.
+func NewLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return New(w, FormatJSON, level)
+}
+
+// Output formats accepted by New (LOG_FORMAT).
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// New returns a logger writing records at or above level to w, as logfmt-style
+// key=value lines for FormatText and as JSON objects otherwise.
+func New(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if strings.EqualFold(strings.TrimSpace(format), FormatText) {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// ParseLevel maps a LOG_LEVEL value ("debug", "info", "warn"/"warning",
+// "error", case-insensitive) to a slog.Level. Anything else is info.
+func ParseLevel(s string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
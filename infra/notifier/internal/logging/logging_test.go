@@ -0,0 +1,76 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/logging"
+)
+
+func TestNewLogger_WritesJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.NewLogger(&buf, slog.LevelInfo)
+
+	logger.Info("job executed", slog.String("job_id", "job-1"), slog.Int("attempt", 2))
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "job executed", record["msg"])
+	assert.Equal(t, "INFO", record["level"])
+	assert.Equal(t, "job-1", record["job_id"])
+	assert.Equal(t, float64(2), record["attempt"])
+	assert.Contains(t, record, "time")
+}
+
+func TestNewLogger_FiltersBelowLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.NewLogger(&buf, slog.LevelWarn)
+
+	logger.Info("dropped")
+	logger.Warn("kept")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], `"msg":"kept"`)
+}
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]slog.Level{
+		"debug":   slog.LevelDebug,
+		"INFO":    slog.LevelInfo,
+		"warn":    slog.LevelWarn,
+		"Warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+		"":        slog.LevelInfo,
+		"verbose": slog.LevelInfo,
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, logging.ParseLevel(in), "ParseLevel(%q)", in)
+	}
+}
+
+func TestNew_TextFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, "text", slog.LevelInfo)
+
+	logger.Info("job executed", slog.String("job_id", "job-1"))
+
+	assert.Contains(t, buf.String(), `msg="job executed"`)
+	assert.Contains(t, buf.String(), "job_id=job-1")
+}
+
+func TestNew_DefaultsToJSON(t *testing.T) {
+	for _, format := range []string{"json", "", "yaml"} {
+		var buf bytes.Buffer
+		logging.New(&buf, format, slog.LevelInfo).Info("hello")
+
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &record), "format %q", format)
+	}
+}
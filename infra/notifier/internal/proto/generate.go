@@ -0,0 +1,7 @@
+// Package proto holds the gRPC job management API (notifier.proto) and the
+// code generated from it. After editing notifier.proto, regenerate with
+// go generate ./internal/proto (needs protoc, protoc-gen-go and
+// protoc-gen-go-grpc on PATH).
+package proto
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative notifier.proto
@@ -1,29 +1,193 @@
 package publisher
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/allerac/notifier/internal/retry"
+	"github.com/allerac/notifier/internal/tracing"
 )
 
-// StreamName is the Redis Stream used for all notifications.
+var tracer = otel.Tracer("github.com/allerac/notifier/internal/publisher")
+
+// StreamName is the Redis Stream used for notifications of normal priority.
 const StreamName = "notifications"
 
+// HighPriorityStreamName is the Redis Stream used for notifications of
+// PriorityHigh and above; consumers read it before StreamName.
+const HighPriorityStreamName = "notifications:high"
+
+// Streams lists the notification streams in the order consumers read them.
+var Streams = []string{HighPriorityStreamName, StreamName}
+
 // DLQStreamName is the dead-letter stream for messages that exceeded delivery attempts.
 const DLQStreamName = "notifications:dead"
 
+// DelayedSetName is the sorted set holding notifications whose DeliverAfter
+// has not been reached yet, scored by that time (Unix seconds).
+const DelayedSetName = "notifications:delayed"
+
+// Format tells consumers how Content is marked up. The values match Telegram's
+// parse_mode; consumers for channels without rich text ignore it.
+type Format string
+
+const (
+	FormatPlain      Format = ""
+	FormatMarkdownV2 Format = "MarkdownV2"
+	FormatHTML       Format = "HTML"
+)
+
+// ParseMode is Format under the name of Telegram's parse_mode.
+type ParseMode = Format
+
+const (
+	ParseModeMarkdown = FormatMarkdownV2
+	ParseModeHTML     = FormatHTML
+)
+
+// Priority orders notifications: consumers deliver those of PriorityHigh and
+// above ahead of the rest. Values above PriorityNormal are high and are kept
+// as published.
+type Priority int
+
+const (
+	PriorityNormal Priority = 0 // the default, e.g. daily digests
+	PriorityHigh   Priority = 1
+)
+
+// StreamFor returns the stream notifications of priority p are published to.
+func StreamFor(p Priority) string {
+	if p >= PriorityHigh {
+		return HighPriorityStreamName
+	}
+	return StreamName
+}
+
+// StreamOf returns the stream a message with these stream values belongs on,
+// from its "priority" field; messages without one are PriorityNormal.
+func StreamOf(values map[string]interface{}) string {
+	raw, _ := values["priority"].(string)
+	p, _ := strconv.Atoi(raw)
+	return StreamFor(Priority(p))
+}
+
+// MetadataOf returns the Notification.Metadata carried by a message with these
+// stream values, or nil if it has none (or it does not parse).
+func MetadataOf(values map[string]interface{}) map[string]string {
+	raw, _ := values["meta"].(string)
+	if raw == "" {
+		return nil
+	}
+	var meta map[string]string
+	if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+		return nil
+	}
+	return meta
+}
+
+// CreatedAtOf returns the Notification.CreatedAt carried by a message with
+// these stream values; ok is false for messages published without one.
+func CreatedAtOf(values map[string]interface{}) (t time.Time, ok bool) {
+	raw, _ := values["created_at"].(string)
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	return t, err == nil
+}
+
 // Notification is a message to be delivered to a channel.
 type Notification struct {
 	JobID   string
+	JobName string // shown as the sender by channels that support one (Slack)
 	UserID  string
 	Channel string
 	Content string
+	Format  Format
+	// ParseMode is used as the Format when Format is empty.
+	ParseMode ParseMode
+	// DeliverAfter holds the notification back until this time; zero delivers
+	// right away.
+	DeliverAfter time.Time
+	// TTL drops the notification undelivered once it has been on the stream
+	// this long; zero uses the publisher default (see WithTTL).
+	TTL time.Duration
+	// Priority selects the stream; PriorityNormal (the zero value) unless set.
+	Priority Priority
+	// IdempotencyKey identifies the logical notification: consumers deliver a
+	// key at most once within their idempotency TTL. Empty derives one from
+	// the job, recipient, channel, content and publish window (see
+	// WithIdempotencyWindow).
+	IdempotencyKey string
+	// Metadata carries channel-specific extras such as "subject" or
+	// "reply_to"; consumers read it back with MetadataOf.
+	Metadata map[string]string
+	// JobMetadata is the job's own metadata as a JSON object, written to the
+	// stream unchanged under "metadata" for consumers and audit logs.
+	JobMetadata json.RawMessage
+	// CreatedAt is when the notification was produced, e.g. when the job's
+	// result came in; consumers measure delivery latency from it. Zero means
+	// the time of publishing.
+	CreatedAt time.Time
+	// TraceID identifies the job execution that produced the notification; it
+	// is written as trace_id and tags the consumers' log lines about it.
+	TraceID string
 }
 
 // Publisher writes notifications to a Redis Stream.
 type Publisher struct {
-	client *redis.Client
+	client  *redis.Client
+	metrics metrics
+	maxLen  int64         // approximate stream cap (XADD MAXLEN ~); 0 = unbounded
+	ttl     time.Duration // default Notification.TTL; 0 = never expire
+	idemWin time.Duration // bucket size for derived idempotency keys; 0 = none derived
+	logger  *slog.Logger
+
+	signingKey []byte // HMAC key for the signature field; nil = unsigned
+}
+
+// metrics are the Prometheus collectors maintained by a Publisher. They are
+// always updated; MustRegister exposes them on a registry.
+type metrics struct {
+	published    *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+	streamLength prometheus.Gauge
+}
+
+func newMetrics() metrics {
+	return metrics{
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "notifier_notifications_published_total",
+			Help: "Notifications written to the stream, by channel.",
+		}, []string{"channel"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "notifier_publish_errors_total",
+			Help: "Notifications that failed to be written to the stream, by channel.",
+		}, []string{"channel"}),
+		streamLength: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "notifier_stream_length",
+			Help: "Number of entries in the notifications stream after the last publish.",
+		}),
+	}
+}
+
+// MustRegister registers the publisher's metrics with reg (typically
+// prometheus.DefaultRegisterer). It panics if they are already registered.
+func (p *Publisher) MustRegister(reg prometheus.Registerer) {
+	reg.MustRegister(p.metrics.published, p.metrics.errors, p.metrics.streamLength)
 }
 
 // New creates a Publisher connected to the given Redis URL.
@@ -32,25 +196,221 @@ func New(redisURL string) (*Publisher, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parse redis url: %w", err)
 	}
-	return &Publisher{client: redis.NewClient(opts)}, nil
+	return NewFromClient(redis.NewClient(opts)), nil
 }
 
 // NewFromClient creates a Publisher from an existing Redis client (useful for testing).
 func NewFromClient(client *redis.Client) *Publisher {
-	return &Publisher{client: client}
+	return &Publisher{
+		client:  client,
+		metrics: newMetrics(),
+		logger:  slog.Default().With(slog.String("component", "publisher")),
+	}
+}
+
+// WithLogger sets the logger used by the publisher (default slog.Default()).
+func (p *Publisher) WithLogger(l *slog.Logger) *Publisher {
+	p.logger = l.With(slog.String("component", "publisher"))
+	return p
+}
+
+// WithTTL sets the TTL of notifications that do not carry their own, so
+// time-sensitive content is not delivered long after it was produced.
+func (p *Publisher) WithTTL(d time.Duration) *Publisher {
+	p.ttl = d
+	return p
+}
+
+// WithIdempotencyWindow sets the window used to derive an IdempotencyKey for
+// notifications without one: the same job, recipient, channel and content
+// published within one window (aligned to the Unix epoch, e.g. 14:00–14:05 for 5m) get
+// the same key, so a repeated firing does not reach the user twice, while the
+// next cron occurrence falls in a new window. 0 derives no keys.
+func (p *Publisher) WithIdempotencyWindow(d time.Duration) *Publisher {
+	p.idemWin = d
+	return p
+}
+
+// WithMaxLen caps the stream at approximately n entries, trimming the oldest
+// on each publish. Redis trims in whole macro nodes, so the stream may briefly
+// hold somewhat more than n entries.
+func (p *Publisher) WithMaxLen(n int64) *Publisher {
+	p.maxLen = n
+	return p
 }
 
 // Publish writes a notification to the Redis Stream.
 func (p *Publisher) Publish(ctx context.Context, n Notification) error {
-	return p.client.XAdd(ctx, &redis.XAddArgs{
-		Stream: StreamName,
-		Values: map[string]interface{}{
-			"job_id":  n.JobID,
-			"user_id": n.UserID,
-			"channel": n.Channel,
-			"content": n.Content,
-		},
-	}).Err()
+	ctx, span := tracer.Start(ctx, "publisher.stream_xadd", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", StreamFor(n.Priority)),
+			attribute.String("notification.channel", n.Channel),
+			attribute.String("job.id", n.JobID),
+		))
+	defer span.End()
+
+	err := p.client.XAdd(ctx, p.xaddArgs(ctx, n)).Err()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "xadd failed")
+		p.metrics.errors.WithLabelValues(n.Channel).Inc()
+		p.logFailure(n, err)
+		return err
+	}
+	p.metrics.published.WithLabelValues(n.Channel).Inc()
+	p.logPublished(n)
+	p.updateStreamLength(ctx)
+	return nil
+}
+
+// PublishBatch writes ns to the Redis Streams in order, sending all XADDs in
+// one pipelined round-trip. A notification that fails does not stop the
+// others; the returned error joins every failure. Each notification still gets
+// its own span, so consumers continue the trace of the message they read.
+func (p *Publisher) PublishBatch(ctx context.Context, ns []Notification) error {
+	if len(ns) == 0 {
+		return nil
+	}
+	pipe := p.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(ns))
+	spans := make([]trace.Span, len(ns))
+	for i, n := range ns {
+		spanCtx, span := tracer.Start(ctx, "publisher.stream_xadd", trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(
+				attribute.String("messaging.destination.name", StreamFor(n.Priority)),
+				attribute.String("notification.channel", n.Channel),
+				attribute.String("job.id", n.JobID),
+				attribute.Int("messaging.batch.message_count", len(ns)),
+			))
+		spans[i] = span
+		cmds[i] = pipe.XAdd(ctx, p.xaddArgs(spanCtx, n))
+	}
+	_, _ = pipe.Exec(ctx) // per-command errors are checked below
+
+	var errs []error
+	for i, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			spans[i].RecordError(err)
+			spans[i].SetStatus(codes.Error, "xadd failed")
+			p.metrics.errors.WithLabelValues(ns[i].Channel).Inc()
+			p.logFailure(ns[i], err)
+			errs = append(errs, fmt.Errorf("notification %d (channel %s): %w", i, ns[i].Channel, err))
+		} else {
+			p.metrics.published.WithLabelValues(ns[i].Channel).Inc()
+			p.logPublished(ns[i])
+		}
+		spans[i].End()
+	}
+	p.updateStreamLength(ctx)
+	if len(errs) > 0 {
+		return fmt.Errorf("publish %d of %d notifications failed: %w", len(errs), len(ns), errors.Join(errs...))
+	}
+	return nil
+}
+
+func (p *Publisher) logPublished(n Notification) {
+	p.logger.Debug("notification published",
+		slog.String("job_id", n.JobID), slog.String("user_id", n.UserID), slog.String("channel", n.Channel),
+		slog.String("trace_id", n.TraceID))
+}
+
+func (p *Publisher) logFailure(n Notification, err error) {
+	p.logger.Warn("failed to publish notification",
+		slog.String("job_id", n.JobID), slog.String("user_id", n.UserID), slog.String("channel", n.Channel),
+		slog.String("trace_id", n.TraceID), slog.Any("error", err))
+}
+
+// xaddArgs builds the XADD for n, carrying the trace context of ctx.
+func (p *Publisher) xaddArgs(ctx context.Context, n Notification) *redis.XAddArgs {
+	values := map[string]interface{}{
+		"job_id":   n.JobID,
+		"job_name": n.JobName,
+		"user_id":  n.UserID,
+		"channel":  n.Channel,
+		"content":  n.Content,
+		"format":   string(cmp.Or(n.Format, n.ParseMode)),
+	}
+	createdAt := n.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	values["created_at"] = createdAt.UTC().Format(time.RFC3339Nano)
+	if n.TraceID != "" {
+		values["trace_id"] = n.TraceID
+	}
+	ttl := n.TTL
+	if ttl == 0 {
+		ttl = p.ttl
+	}
+	if ttl > 0 {
+		// Whole seconds, rounded up so a sub-second TTL does not become "never".
+		values["ttl_seconds"] = strconv.FormatInt(int64(math.Ceil(ttl.Seconds())), 10)
+	}
+	if !n.DeliverAfter.IsZero() {
+		values["deliver_after"] = n.DeliverAfter.UTC().Format(time.RFC3339)
+	}
+	if n.Priority != PriorityNormal {
+		values["priority"] = strconv.Itoa(int(n.Priority))
+	}
+	if key := p.idempotencyKey(n); key != "" {
+		values["idempotency_key"] = key
+	}
+	if len(n.Metadata) > 0 {
+		raw, _ := json.Marshal(n.Metadata) // a map[string]string always marshals
+		values["meta"] = string(raw)
+	}
+	if len(n.JobMetadata) > 0 {
+		values["metadata"] = string(n.JobMetadata)
+	}
+	// Consumers continue the trace from the traceparent field.
+	tracing.Inject(ctx, values)
+	if len(p.signingKey) > 0 {
+		values[SignatureField] = computeHMAC(p.signingKey, values)
+	}
+	args := &redis.XAddArgs{
+		Stream: StreamFor(n.Priority),
+		Values: values,
+	}
+	if p.maxLen > 0 {
+		args.MaxLen = p.maxLen
+		args.Approx = true
+	}
+	return args
+}
+
+// updateStreamLength refreshes the stream length gauge. It is best effort; a
+// failed XLEN must not fail the publish.
+func (p *Publisher) updateStreamLength(ctx context.Context) {
+	if length, err := p.client.XLen(ctx, StreamName).Result(); err == nil {
+		p.metrics.streamLength.Set(float64(length))
+	}
+}
+
+// idempotencyKey returns n.IdempotencyKey, or the key derived from the job,
+// recipient, channel, content and current window when it is empty. The
+// recipient keeps a result fanned out to several users from being delivered
+// to the first of them only.
+func (p *Publisher) idempotencyKey(n Notification) string {
+	if n.IdempotencyKey != "" || p.idemWin <= 0 {
+		return n.IdempotencyKey
+	}
+	window := time.Now().Truncate(p.idemWin).Unix()
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%d\x00%s", n.JobID, n.UserID, n.Channel, window, n.Content)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Client returns the publisher's Redis client, for other components that need
+// Redis without opening a connection pool of their own. Close closes it.
+func (p *Publisher) Client() *redis.Client {
+	return p.client
+}
+
+// WaitReady pings Redis until it answers, up to attempts times with a
+// doubling delay (see retry.Ping), so startup survives Redis coming up late.
+func (p *Publisher) WaitReady(ctx context.Context, attempts int, delay time.Duration) error {
+	return retry.Ping(ctx, "redis", attempts, delay, func(ctx context.Context) error {
+		return p.client.Ping(ctx).Err()
+	})
 }
 
 // Close releases the Redis connection.
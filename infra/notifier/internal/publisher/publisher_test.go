@@ -1,14 +1,23 @@
 package publisher_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/allerac/notifier/internal/logging"
 	"github.com/allerac/notifier/internal/publisher"
 )
 
@@ -26,6 +35,7 @@ func TestPublisher_Publish_WritesToStream(t *testing.T) {
 
 	n := publisher.Notification{
 		JobID:   "job-1",
+		JobName: "Daily Digest",
 		UserID:  "user-1",
 		Channel: "telegram",
 		Content: "Hello, World!",
@@ -40,9 +50,259 @@ func TestPublisher_Publish_WritesToStream(t *testing.T) {
 
 	got := msgs[0].Values
 	assert.Equal(t, "job-1", got["job_id"])
+	assert.Equal(t, "Daily Digest", got["job_name"])
 	assert.Equal(t, "user-1", got["user_id"])
 	assert.Equal(t, "telegram", got["channel"])
 	assert.Equal(t, "Hello, World!", got["content"])
+	assert.Equal(t, "", got["format"])
+}
+
+func TestPublisher_Publish_WritesFormat(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "*hi*",
+		Format: publisher.FormatMarkdownV2,
+	}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "MarkdownV2", msgs[0].Values["format"])
+}
+
+func TestPublisher_Publish_WritesParseModeAsFormat(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+
+	require.NoError(t, pub.PublishBatch(ctx, []publisher.Notification{
+		{JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "<b>hi</b>", ParseMode: publisher.ParseModeHTML},
+		{JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "*hi*",
+			Format: publisher.FormatMarkdownV2, ParseMode: publisher.ParseModeHTML},
+	}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "HTML", msgs[0].Values["format"])
+	assert.Equal(t, "MarkdownV2", msgs[1].Values["format"], "Format wins")
+}
+
+func TestPublisher_Publish_RoutesByPriority(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "digest"}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-2", Channel: "telegram", Content: "server down", Priority: 1,
+	}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-3", Channel: "telegram", Content: "disk full", Priority: 10,
+	}))
+
+	normal, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, normal, 1)
+	assert.Equal(t, "digest", normal[0].Values["content"])
+	assert.NotContains(t, normal[0].Values, "priority")
+
+	high, err := client.XRange(ctx, publisher.HighPriorityStreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, high, 2, "any priority above normal is high")
+	assert.Equal(t, "server down", high[0].Values["content"])
+	assert.Equal(t, "1", high[0].Values["priority"])
+	assert.Equal(t, "10", high[1].Values["priority"])
+	assert.Equal(t, publisher.HighPriorityStreamName, publisher.StreamOf(high[0].Values))
+	assert.Equal(t, publisher.HighPriorityStreamName, publisher.StreamOf(high[1].Values))
+	assert.Equal(t, publisher.StreamName, publisher.StreamOf(normal[0].Values))
+}
+
+func TestPublisher_Publish_MetadataRoundTrips(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+	meta := map[string]string{"subject": "Weekly report", "reply_to": "42", "url": "https://example.com/r?a=1&b=2"}
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "hi", Metadata: meta}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, meta, publisher.MetadataOf(msgs[0].Values))
+}
+
+func TestPublisher_Publish_WritesJobMetadata(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", Channel: "telegram", Content: "hi", JobMetadata: []byte(`{"region":"EU"}`),
+	}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "hi"}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.JSONEq(t, `{"region":"EU"}`, msgs[0].Values["metadata"].(string))
+	assert.NotContains(t, msgs[1].Values, "metadata")
+}
+
+func TestPublisher_Publish_NoMetaFieldWithoutMetadata(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "hi"}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", Channel: "telegram", Content: "hi", Metadata: map[string]string{},
+	}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	for _, msg := range msgs {
+		assert.NotContains(t, msg.Values, "meta")
+		assert.Nil(t, publisher.MetadataOf(msg.Values))
+	}
+}
+
+func TestPublisher_Publish_WritesDeliverAfter(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+	at := time.Date(2030, 5, 1, 8, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "morning", DeliverAfter: at,
+	}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "now",
+	}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "2030-05-01T06:00:00Z", msgs[0].Values["deliver_after"])
+	assert.NotContains(t, msgs[1].Values, "deliver_after")
+}
+
+func TestPublisher_Publish_WritesCreatedAt(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+	at := time.Date(2030, 5, 1, 8, 0, 0, 500, time.FixedZone("CEST", 2*60*60))
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "result", CreatedAt: at,
+	}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "no timestamp",
+	}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "2030-05-01T06:00:00.0000005Z", msgs[0].Values["created_at"])
+	got, ok := publisher.CreatedAtOf(msgs[0].Values)
+	require.True(t, ok)
+	assert.True(t, at.Equal(got))
+	got, ok = publisher.CreatedAtOf(msgs[1].Values)
+	require.True(t, ok, "defaults to the publish time")
+	assert.WithinDuration(t, time.Now(), got, time.Minute)
+}
+
+func TestPublisher_Publish_WritesTraceID(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "traced", TraceID: "trace-1",
+	}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "untraced",
+	}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "trace-1", msgs[0].Values["trace_id"])
+	assert.NotContains(t, msgs[1].Values, "trace_id")
+}
+
+func TestCreatedAtOf_MissingField(t *testing.T) {
+	_, ok := publisher.CreatedAtOf(map[string]interface{}{"content": "hi"})
+	assert.False(t, ok)
+}
+
+func TestPublisher_Publish_WritesTTL(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	pub.WithTTL(time.Hour)
+	ctx := context.Background()
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "weather", TTL: 90 * time.Second,
+	}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "default",
+	}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "90", msgs[0].Values["ttl_seconds"], "own TTL wins")
+	assert.Equal(t, "3600", msgs[1].Values["ttl_seconds"], "publisher default")
+}
+
+func TestPublisher_Publish_NoTTLByDefault(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "hi"}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.NotContains(t, msgs[0].Values, "ttl_seconds")
+}
+
+func TestPublisher_Publish_DerivesIdempotencyKey(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	pub.WithIdempotencyWindow(time.Hour)
+	ctx := context.Background()
+
+	for _, n := range []publisher.Notification{
+		{JobID: "job-1", Channel: "telegram", Content: "Good morning"},
+		{JobID: "job-1", Channel: "telegram", Content: "Good morning"}, // repeated firing
+		{JobID: "job-1", Channel: "slack", Content: "Good morning"},
+		{JobID: "job-1", Channel: "telegram", Content: "Good evening"},
+		{JobID: "job-1", Channel: "telegram", Content: "Good morning", IdempotencyKey: "fire-42"},
+		{JobID: "job-1", UserID: "user-2", Channel: "telegram", Content: "Good morning"}, // fanned out
+	} {
+		require.NoError(t, pub.Publish(ctx, n))
+	}
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 6)
+	keys := make([]string, len(msgs))
+	for i, m := range msgs {
+		keys[i], _ = m.Values["idempotency_key"].(string)
+		assert.NotEmpty(t, keys[i])
+	}
+	assert.Equal(t, keys[0], keys[1], "same job, channel and content in one window")
+	assert.NotEqual(t, keys[0], keys[2], "channel is part of the key")
+	assert.NotEqual(t, keys[0], keys[3], "content is part of the key")
+	assert.Equal(t, "fire-42", keys[4], "an explicit key is kept")
+	assert.NotEqual(t, keys[0], keys[5], "recipient is part of the key")
+}
+
+func TestPublisher_Publish_NoIdempotencyKeyWithoutWindow(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "hi"}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.NotContains(t, msgs[0].Values, "idempotency_key")
 }
 
 func TestPublisher_Publish_MultipleNotifications(t *testing.T) {
@@ -64,6 +324,50 @@ func TestPublisher_Publish_MultipleNotifications(t *testing.T) {
 	assert.Len(t, msgs, 3)
 }
 
+func TestPublisher_PublishBatch_WritesAllInOrder(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	reg := prometheus.NewRegistry()
+	pub.MustRegister(reg)
+	ctx := context.Background()
+
+	var ns []publisher.Notification
+	for i := range 5 {
+		ns = append(ns, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: fmt.Sprintf("part %d", i)})
+	}
+	require.NoError(t, pub.PublishBatch(ctx, ns))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 5)
+	for i, msg := range msgs {
+		assert.Equal(t, fmt.Sprintf("part %d", i), msg.Values["content"])
+	}
+	assert.Equal(t, 5.0, gatheredValue(t, reg, "notifier_notifications_published_total", "telegram"))
+}
+
+func TestPublisher_PublishBatch_FailureDoesNotDropOthers(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+	// A plain key where the high-priority stream should be makes that one XADD fail.
+	require.NoError(t, client.Set(ctx, publisher.HighPriorityStreamName, "x", 0).Err())
+
+	err := pub.PublishBatch(ctx, []publisher.Notification{
+		{JobID: "job-1", Channel: "telegram", Content: "first"},
+		{JobID: "job-1", Channel: "slack", Content: "alert", Priority: publisher.PriorityHigh},
+		{JobID: "job-1", Channel: "discord", Content: "last"},
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 3")
+	assert.Contains(t, err.Error(), "channel slack")
+	assert.Contains(t, err.Error(), "WRONGTYPE")
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "first", msgs[0].Values["content"])
+	assert.Equal(t, "last", msgs[1].Values["content"])
+}
+
 func TestPublisher_Publish_RedisDown(t *testing.T) {
 	mr := miniredis.RunT(t)
 	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
@@ -76,3 +380,190 @@ func TestPublisher_Publish_RedisDown(t *testing.T) {
 	})
 	require.Error(t, err)
 }
+
+func TestPublisher_WaitReady_WaitsForRedis(t *testing.T) {
+	// Pick a free address, then bring Redis up on it only after the first pings failed.
+	mr := miniredis.NewMiniRedis()
+	require.NoError(t, mr.Start())
+	addr := mr.Addr()
+	mr.Close()
+	t.Cleanup(mr.Close)
+	pub := publisher.NewFromClient(redis.NewClient(&redis.Options{Addr: addr}))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = mr.StartAddr(addr)
+	}()
+
+	err := pub.WaitReady(context.Background(), 10, 20*time.Millisecond)
+
+	require.NoError(t, err)
+}
+
+func TestPublisher_WaitReady_GivesUp(t *testing.T) {
+	mr := miniredis.RunT(t)
+	pub := publisher.NewFromClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	mr.Close()
+
+	err := pub.WaitReady(context.Background(), 2, time.Millisecond)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "redis unreachable after 2 attempts")
+}
+
+// gatheredValue returns the value of the named metric with the given channel
+// label (or no labels when channel is empty), or 0 if it has not been emitted.
+func gatheredValue(t *testing.T, reg *prometheus.Registry, name, channel string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if channel != "" && (len(m.GetLabel()) == 0 || m.GetLabel()[0].GetValue() != channel) {
+				continue
+			}
+			if m.GetCounter() != nil {
+				return m.GetCounter().GetValue()
+			}
+			return m.GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestPublisher_WithLogger_LogsStructuredFields(t *testing.T) {
+	pub, _, mr := newTestPublisher(t)
+	var buf bytes.Buffer
+	pub.WithLogger(logging.NewLogger(&buf, slog.LevelDebug))
+	ctx := context.Background()
+	n := publisher.Notification{JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "hi"}
+
+	require.NoError(t, pub.Publish(ctx, n))
+	mr.Close()
+	require.Error(t, pub.Publish(ctx, n))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	for i, msg := range []string{"notification published", "failed to publish notification"} {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(lines[i]), &record))
+		assert.Equal(t, msg, record["msg"])
+		assert.Equal(t, "publisher", record["component"])
+		assert.Equal(t, "job-1", record["job_id"])
+		assert.Equal(t, "user-1", record["user_id"])
+		assert.Equal(t, "telegram", record["channel"])
+	}
+}
+
+func TestPublisher_Metrics_CountPublished(t *testing.T) {
+	pub, _, _ := newTestPublisher(t)
+	reg := prometheus.NewRegistry()
+	pub.MustRegister(reg)
+	ctx := context.Background()
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "a"}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "b"}))
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "webhook", Content: "c"}))
+
+	assert.Equal(t, 2.0, gatheredValue(t, reg, "notifier_notifications_published_total", "telegram"))
+	assert.Equal(t, 1.0, gatheredValue(t, reg, "notifier_notifications_published_total", "webhook"))
+	assert.Equal(t, 3.0, gatheredValue(t, reg, "notifier_stream_length", ""))
+	assert.Equal(t, 0, testutil.CollectAndCount(reg, "notifier_publish_errors_total"))
+}
+
+func TestPublisher_Metrics_CountErrors(t *testing.T) {
+	pub, _, mr := newTestPublisher(t)
+	reg := prometheus.NewRegistry()
+	pub.MustRegister(reg)
+
+	mr.Close()
+	err := pub.Publish(context.Background(), publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "hi"})
+	require.Error(t, err)
+
+	assert.Equal(t, 1.0, gatheredValue(t, reg, "notifier_publish_errors_total", "telegram"))
+	assert.Equal(t, 0, testutil.CollectAndCount(reg, "notifier_notifications_published_total"))
+}
+
+func TestPublisher_WithMaxLen_TrimsStream(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	const maxLen = 50
+	const trimMargin = 100 // XADD MAXLEN ~ may keep up to a macro node's worth extra
+	pub.WithMaxLen(maxLen)
+	ctx := context.Background()
+
+	for i := 0; i < 10*maxLen; i++ {
+		require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "x"}))
+	}
+
+	length, err := client.XLen(ctx, publisher.StreamName).Result()
+	require.NoError(t, err)
+	assert.LessOrEqual(t, length, int64(maxLen+trimMargin))
+	assert.GreaterOrEqual(t, length, int64(maxLen), "approximate trimming never drops below MaxLen")
+}
+
+func TestPublisher_ZeroMaxLen_DoesNotTrim(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "x"}))
+	}
+
+	length, err := client.XLen(ctx, publisher.StreamName).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), length)
+}
+
+func TestPublisher_WithSigningKey_SignsMessages(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	key := []byte("stream-secret")
+	pub.WithSigningKey(key)
+	ctx := context.Background()
+
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "hi"}))
+
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.NotEmpty(t, msgs[0].Values[publisher.SignatureField])
+	assert.True(t, publisher.VerifySignature(key, msgs[0].Values))
+	assert.False(t, publisher.VerifySignature([]byte("other-secret"), msgs[0].Values), "wrong key")
+}
+
+func TestVerifySignature_RejectsTamperedMessage(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	key := []byte("stream-secret")
+	pub.WithSigningKey(key)
+	ctx := context.Background()
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "hi"}))
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+	values := msgs[0].Values
+
+	values["content"] = "send money to ..."
+	assert.False(t, publisher.VerifySignature(key, values), "changed field")
+
+	values["content"] = "hi"
+	values["user_id"] = "user-2"
+	assert.False(t, publisher.VerifySignature(key, values), "changed recipient")
+
+	values["user_id"] = "user-1"
+	values["extra"] = "1"
+	assert.False(t, publisher.VerifySignature(key, values), "added field")
+
+	delete(values, "extra")
+	assert.True(t, publisher.VerifySignature(key, values), "restored")
+}
+
+func TestVerifySignature_UnsignedMessageFails(t *testing.T) {
+	pub, client, _ := newTestPublisher(t)
+	ctx := context.Background()
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{JobID: "job-1", Channel: "telegram", Content: "hi"}))
+	msgs, err := client.XRange(ctx, publisher.StreamName, "-", "+").Result()
+	require.NoError(t, err)
+
+	assert.NotContains(t, msgs[0].Values, publisher.SignatureField)
+	assert.False(t, publisher.VerifySignature([]byte("stream-secret"), msgs[0].Values))
+}
@@ -0,0 +1,50 @@
+package publisher
+
+import (
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SentinelClient returns a Redis client for the master named masterName, as
+// reported by the Sentinels at sentinelAddrs; it reconnects to the new master
+// after a failover. opts (may be nil) supplies the master's connection
+// settings such as password, DB and TLS, e.g. from redis.ParseURL(REDIS_URL);
+// its Addr is ignored.
+func SentinelClient(masterName string, sentinelAddrs []string, opts *redis.Options) (*redis.Client, error) {
+	if masterName == "" {
+		return nil, errors.New("sentinel master name is empty")
+	}
+	if len(sentinelAddrs) == 0 {
+		return nil, errors.New("no sentinel addresses")
+	}
+	if opts == nil {
+		opts = &redis.Options{}
+	}
+	return redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    masterName,
+		SentinelAddrs: sentinelAddrs,
+		Protocol:      opts.Protocol,
+		Username:      opts.Username,
+		Password:      opts.Password,
+		DB:            opts.DB,
+		ClientName:    opts.ClientName,
+		MaxRetries:    opts.MaxRetries,
+		DialTimeout:   opts.DialTimeout,
+		ReadTimeout:   opts.ReadTimeout,
+		WriteTimeout:  opts.WriteTimeout,
+		PoolSize:      opts.PoolSize,
+		MinIdleConns:  opts.MinIdleConns,
+		TLSConfig:     opts.TLSConfig,
+	}), nil
+}
+
+// NewFromSentinel creates a Publisher on a Sentinel-managed Redis master (see
+// SentinelClient).
+func NewFromSentinel(masterName string, sentinelAddrs []string, opts *redis.Options) (*Publisher, error) {
+	client, err := SentinelClient(masterName, sentinelAddrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromClient(client), nil
+}
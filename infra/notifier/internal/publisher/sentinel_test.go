@@ -0,0 +1,69 @@
+package publisher_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+// fakeSentinel starts a miniredis that answers SENTINEL queries as if master
+// were the current master of "mymaster".
+func fakeSentinel(t *testing.T, master *miniredis.Miniredis) string {
+	t.Helper()
+	s := miniredis.RunT(t)
+	host, port, err := net.SplitHostPort(master.Addr())
+	require.NoError(t, err)
+	require.NoError(t, s.Server().Register("SENTINEL", func(c *server.Peer, _ string, args []string) {
+		switch {
+		case len(args) == 2 && args[0] == "get-master-addr-by-name" && args[1] == "mymaster":
+			c.WriteLen(2)
+			c.WriteBulk(host)
+			c.WriteBulk(port)
+		case len(args) > 0 && args[0] == "get-master-addr-by-name":
+			c.WriteNull()
+		default: // "sentinels", "replicas": no peers
+			c.WriteLen(0)
+		}
+	}))
+	return s.Addr()
+}
+
+func TestPublisher_NewFromSentinel_PublishesToMaster(t *testing.T) {
+	master := miniredis.RunT(t)
+	pub, err := publisher.NewFromSentinel("mymaster", []string{fakeSentinel(t, master)}, nil)
+	require.NoError(t, err)
+	defer pub.Close()
+
+	err = pub.Publish(context.Background(), publisher.Notification{
+		JobID: "job-1", UserID: "user-1", Channel: "telegram", Content: "hi",
+	})
+
+	require.NoError(t, err)
+	entries, err := master.Stream(publisher.StreamName)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestPublisher_NewFromSentinel_UnknownMaster(t *testing.T) {
+	pub, err := publisher.NewFromSentinel("other", []string{fakeSentinel(t, miniredis.RunT(t))}, nil)
+	require.NoError(t, err)
+	defer pub.Close()
+
+	err = pub.WaitReady(context.Background(), 1, 0)
+
+	require.Error(t, err)
+}
+
+func TestSentinelClient_RequiresMasterAndAddrs(t *testing.T) {
+	_, err := publisher.SentinelClient("", []string{"127.0.0.1:26379"}, nil)
+	assert.Error(t, err)
+	_, err = publisher.SentinelClient("mymaster", nil, nil)
+	assert.Error(t, err)
+}
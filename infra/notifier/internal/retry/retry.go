@@ -0,0 +1,44 @@
+// Package retry waits for a dependency (PostgreSQL, Redis) to come up at
+// startup, so the notifier does not crash-loop when it starts before them.
+package retry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+// maxDelay caps the doubling wait between two attempts.
+const maxDelay = 30 * time.Second
+
+// Ping calls ping until it succeeds, attempts calls have failed, or ctx is
+// done. It waits delay after the first failure and doubles the wait after each
+// further one, up to 30s, adding a random extra of up to half the wait so
+// that instances restarted together do not retry in lockstep. what names the
+// dependency in logs and errors.
+func Ping(ctx context.Context, what string, attempts int, delay time.Duration, ping func(context.Context) error) error {
+	attempts = max(attempts, 1)
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = ping(ctx); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			return fmt.Errorf("%s unreachable after %d attempts: %w", what, attempts, err)
+		}
+		wait := delay
+		if delay >= 2 {
+			wait += rand.N(delay / 2)
+		}
+		slog.Warn("dependency not ready, retrying", slog.String("dependency", what), slog.Int("attempt", attempt),
+			slog.Duration("retry_in", wait), slog.Any("error", err))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s unreachable: %w (last error: %w)", what, ctx.Err(), err)
+		case <-time.After(wait):
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
@@ -0,0 +1,57 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/retry"
+)
+
+// flakyPing fails the first failures calls.
+func flakyPing(failures int) (func(context.Context) error, *int) {
+	calls := 0
+	return func(context.Context) error {
+		calls++
+		if calls <= failures {
+			return errors.New("connection refused")
+		}
+		return nil
+	}, &calls
+}
+
+func TestPing_SucceedsAfterFailures(t *testing.T) {
+	ping, calls := flakyPing(2)
+
+	err := retry.Ping(context.Background(), "postgres", 5, time.Millisecond, ping)
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, *calls)
+}
+
+func TestPing_GivesUpAfterAttempts(t *testing.T) {
+	ping, calls := flakyPing(10)
+
+	err := retry.Ping(context.Background(), "postgres", 3, time.Millisecond, ping)
+
+	require.Error(t, err)
+	assert.Equal(t, 3, *calls)
+	assert.Contains(t, err.Error(), "postgres unreachable after 3 attempts: connection refused")
+}
+
+func TestPing_StopsWhenContextDone(t *testing.T) {
+	ping, calls := flakyPing(10)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := retry.Ping(ctx, "redis", 100, time.Hour, ping)
+
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, *calls)
+	assert.Less(t, time.Since(start), time.Second)
+}
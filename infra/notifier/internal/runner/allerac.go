@@ -7,6 +7,10 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // AlleracRunner calls the Allerac /api/jobs/run endpoint, giving scheduled jobs
@@ -27,7 +31,16 @@ func NewAllerac(appURL, secret string) *AlleracRunner {
 }
 
 // Run sends the job prompt to /api/jobs/run and returns the text response.
-func (r *AlleracRunner) Run(ctx context.Context, userID, jobID, prompt string) (string, error) {
+func (r *AlleracRunner) Run(ctx context.Context, userID, jobID, prompt string) (_ string, err error) {
+	ctx, span := tracer.Start(ctx, "runner.llm_call", trace.WithAttributes(attribute.String("llm.backend", "allerac")))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "llm call failed")
+		}
+		span.End()
+	}()
+
 	body, err := json.Marshal(map[string]string{
 		"jobId":  jobID,
 		"userId": userID,
@@ -43,6 +56,7 @@ func (r *AlleracRunner) Run(ctx context.Context, userID, jobID, prompt string) (
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+r.secret)
+	injectTraceContext(ctx, req.Header)
 
 	resp, err := r.client.Do(req)
 	if err != nil {
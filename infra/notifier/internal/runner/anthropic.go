@@ -0,0 +1,137 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultAnthropicBaseURL is the Anthropic API root.
+	DefaultAnthropicBaseURL = "https://api.anthropic.com"
+
+	// anthropicVersion is the Messages API version sent as anthropic-version.
+	anthropicVersion = "2023-06-01"
+
+	// defaultAnthropicMaxTokens is used when no limit is configured; unlike
+	// OpenAI, the Messages API requires max_tokens.
+	defaultAnthropicMaxTokens = 1024
+)
+
+// AnthropicRequest is the request body of the Anthropic /v1/messages endpoint.
+// System messages go in System; Messages holds only user and assistant turns.
+type AnthropicRequest struct {
+	Model     string    `json:"model"`
+	MaxTokens int       `json:"max_tokens"`
+	System    string    `json:"system,omitempty"`
+	Messages  []ChatMsg `json:"messages"`
+}
+
+// AnthropicResponse is the (relevant subset of the) response from /v1/messages.
+// Failed requests answer {"type":"error","error":{...}} instead.
+type AnthropicResponse struct {
+	Type    string `json:"type"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// AnthropicProvider talks to the Anthropic Messages API.
+type AnthropicProvider struct {
+	baseURL   string
+	apiKey    string
+	maxTokens int
+	client    *http.Client
+}
+
+// NewAnthropicProvider creates an AnthropicProvider. baseURL is the API root
+// without the /v1 suffix; empty uses DefaultAnthropicBaseURL. maxTokens of 0
+// uses a default of 1024, since the API requires a limit. Set the API key with
+// WithAPIKey.
+func NewAnthropicProvider(baseURL string, maxTokens int) *AnthropicProvider {
+	if baseURL == "" {
+		baseURL = DefaultAnthropicBaseURL
+	}
+	if maxTokens <= 0 {
+		maxTokens = defaultAnthropicMaxTokens
+	}
+	return &AnthropicProvider{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		maxTokens: maxTokens,
+		client:    &http.Client{Timeout: 120 * time.Second},
+	}
+}
+
+// WithAPIKey sets the key sent as x-api-key (typically ANTHROPIC_API_KEY).
+func (p *AnthropicProvider) WithAPIKey(key string) *AnthropicProvider {
+	p.apiKey = key
+	return p
+}
+
+// Chat posts messages to /v1/messages and returns the first content block's
+// text. System messages are joined into the request's system field.
+func (p *AnthropicProvider) Chat(ctx context.Context, model string, messages []ChatMsg) (string, error) {
+	var system []string
+	turns := make([]ChatMsg, 0, len(messages))
+	for _, m := range messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		turns = append(turns, m)
+	}
+	body, err := json.Marshal(AnthropicRequest{
+		Model:     model,
+		MaxTokens: p.maxTokens,
+		System:    strings.Join(system, "\n\n"),
+		Messages:  turns,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", p.apiKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	injectTraceContext(ctx, req.Header)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result AnthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode response (status %d): %w", resp.StatusCode, err)
+	}
+	if result.Error != nil {
+		return "", fmt.Errorf("%w (%d %s): %s", ErrModel, resp.StatusCode, result.Error.Type, result.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: anthropic returned %d", ErrModel, resp.StatusCode)
+	}
+	recordUsage(ctx, result.Usage.InputTokens, result.Usage.OutputTokens)
+	if len(result.Content) == 0 {
+		return "", fmt.Errorf("%w: response has no content", ErrModel)
+	}
+	return result.Content[0].Text, nil
+}
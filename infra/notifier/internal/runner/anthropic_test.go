@@ -0,0 +1,107 @@
+package runner_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/runner"
+	"github.com/allerac/notifier/internal/scheduler"
+)
+
+var _ scheduler.SystemPromptRunner = runner.NewWithProvider(runner.NewAnthropicProvider("", 0), "")
+
+const anthropicSuccess = `{
+  "id": "msg_01",
+  "type": "message",
+  "role": "assistant",
+  "model": "claude-3-5-haiku-latest",
+  "content": [{"type": "text", "text": "Hello from Claude!"}],
+  "stop_reason": "end_turn",
+  "usage": {"input_tokens": 9, "output_tokens": 12}
+}`
+
+func newAnthropicRunner(t *testing.T, maxTokens int, handler http.HandlerFunc) *runner.Runner {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	r, err := runner.NewFromConfig(runner.Config{
+		Provider:  runner.ProviderAnthropic,
+		BaseURL:   srv.URL,
+		Model:     "claude-3-5-haiku-latest",
+		APIKey:    "sk-ant-test",
+		MaxTokens: maxTokens,
+	})
+	require.NoError(t, err)
+	return r
+}
+
+func TestAnthropicProvider_Run_Success(t *testing.T) {
+	var got runner.AnthropicRequest
+	r := newAnthropicRunner(t, 256, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/v1/messages", r.URL.Path)
+		assert.Equal(t, "sk-ant-test", r.Header.Get("x-api-key"))
+		assert.NotEmpty(t, r.Header.Get("anthropic-version"))
+		require.NoError(t, decodeJSON(r, &got))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(anthropicSuccess))
+	})
+
+	result, err := r.RunWithContext(context.Background(), "user-1", "Be brief.", "Say hello")
+
+	require.NoError(t, err)
+	assert.Equal(t, "Hello from Claude!", result)
+	assert.Equal(t, "claude-3-5-haiku-latest", got.Model)
+	assert.Equal(t, 256, got.MaxTokens)
+	assert.Equal(t, "Be brief.", got.System, "system prompt goes in the system field")
+	assert.Equal(t, []runner.ChatMsg{{Role: "user", Content: "Say hello"}}, got.Messages)
+}
+
+func TestAnthropicProvider_Run_DefaultMaxTokens(t *testing.T) {
+	var got runner.AnthropicRequest
+	r := newAnthropicRunner(t, 0, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, decodeJSON(r, &got))
+		w.Write([]byte(anthropicSuccess))
+	})
+
+	_, err := r.Run(context.Background(), "user-1", "job-1", "hello")
+
+	require.NoError(t, err)
+	assert.Equal(t, 1024, got.MaxTokens, "max_tokens is required by the API")
+	assert.Empty(t, got.System)
+}
+
+func TestAnthropicProvider_Run_APIError(t *testing.T) {
+	r := newAnthropicRunner(t, 0, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+	})
+
+	_, err := r.Run(context.Background(), "user-1", "job-1", "hello")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+	assert.Contains(t, err.Error(), "authentication_error")
+	assert.Contains(t, err.Error(), "invalid x-api-key")
+}
+
+func TestAnthropicProvider_Run_NoContent(t *testing.T) {
+	r := newAnthropicRunner(t, 0, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"type":"message","content":[]}`))
+	})
+
+	_, err := r.Run(context.Background(), "user-1", "job-1", "hello")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no content")
+}
+
+func TestNewFromConfig_AnthropicRequiresAPIKey(t *testing.T) {
+	_, err := runner.NewFromConfig(runner.Config{Provider: runner.ProviderAnthropic, Model: "claude-3-5-haiku-latest"})
+	assert.ErrorContains(t, err, "API key")
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the LLM while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("llm circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker stops calls to a failing LLM backend. After threshold
+// consecutive failures it opens and rejects calls with ErrCircuitOpen for
+// resetAfter; then it lets a single probe through (half-open). A successful
+// probe closes the breaker, a failed one opens it again.
+type CircuitBreaker struct {
+	threshold  int
+	resetAfter time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a closed CircuitBreaker.
+func NewCircuitBreaker(threshold int, resetAfter time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, resetAfter: resetAfter}
+}
+
+// State reports "closed", "open" or "half-open".
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state.String()
+}
+
+// allow reports whether a call may proceed. When the open period has elapsed
+// the caller becomes the half-open probe; other callers are rejected until the
+// probe's outcome is recorded.
+func (cb *CircuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.resetAfter {
+			return ErrCircuitOpen
+		}
+		cb.state = breakerHalfOpen
+	case breakerHalfOpen:
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of an allowed call. A call
+// abandoned by its caller (callerDone) says nothing about the backend: it is
+// not counted, and an abandoned probe just lets the next call probe instead.
+// It returns the states before and after the update.
+func (cb *CircuitBreaker) record(err error, callerDone bool) (from, to string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	before := cb.state
+	switch {
+	case err != nil && callerDone:
+		if cb.state == breakerHalfOpen {
+			cb.state = breakerOpen // openedAt already elapsed
+		}
+	case err == nil:
+		cb.state = breakerClosed
+		cb.failures = 0
+	case cb.state == breakerHalfOpen:
+		cb.trip()
+	default:
+		cb.failures++
+		if cb.failures >= cb.threshold {
+			cb.trip()
+		}
+	}
+	return before.String(), cb.state.String()
+}
+
+func (cb *CircuitBreaker) trip() {
+	cb.state = breakerOpen
+	cb.openedAt = time.Now()
+}
@@ -0,0 +1,151 @@
+package runner_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/logging"
+	"github.com/allerac/notifier/internal/runner"
+)
+
+// flakyServer fails every chat request while down is set and counts calls.
+func flakyServer(t *testing.T, down *atomic.Bool, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"message":{"role":"assistant","content":"ok"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCircuitBreaker_OpensAfterThreshold(t *testing.T) {
+	var down atomic.Bool
+	var calls atomic.Int32
+	down.Store(true)
+	srv := flakyServer(t, &down, &calls)
+	cb := runner.NewCircuitBreaker(5, time.Minute)
+	r := runner.New(srv.URL, "test").WithCircuitBreaker(cb)
+
+	for i := 0; i < 5; i++ {
+		_, err := r.Run(context.Background(), "u", "j", "hi")
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, runner.ErrCircuitOpen), "call %d reaches the backend", i+1)
+	}
+	assert.Equal(t, "open", cb.State())
+
+	_, err := r.Run(context.Background(), "u", "j", "hi")
+	assert.ErrorIs(t, err, runner.ErrCircuitOpen)
+	assert.Equal(t, int32(5), calls.Load(), "open breaker does not call the backend")
+}
+
+func TestCircuitBreaker_SuccessResetsFailureCount(t *testing.T) {
+	var down atomic.Bool
+	var calls atomic.Int32
+	srv := flakyServer(t, &down, &calls)
+	cb := runner.NewCircuitBreaker(3, time.Minute)
+	r := runner.New(srv.URL, "test").WithCircuitBreaker(cb)
+
+	down.Store(true)
+	r.Run(context.Background(), "u", "j", "hi")
+	r.Run(context.Background(), "u", "j", "hi")
+	down.Store(false)
+	_, err := r.Run(context.Background(), "u", "j", "hi")
+	require.NoError(t, err)
+	down.Store(true)
+	r.Run(context.Background(), "u", "j", "hi")
+	r.Run(context.Background(), "u", "j", "hi")
+
+	assert.Equal(t, "closed", cb.State(), "failures are counted consecutively")
+}
+
+func TestCircuitBreaker_ClosesAfterResetPeriod(t *testing.T) {
+	var down atomic.Bool
+	var calls atomic.Int32
+	down.Store(true)
+	srv := flakyServer(t, &down, &calls)
+	cb := runner.NewCircuitBreaker(2, 50*time.Millisecond)
+	r := runner.New(srv.URL, "test").WithCircuitBreaker(cb)
+
+	r.Run(context.Background(), "u", "j", "hi")
+	r.Run(context.Background(), "u", "j", "hi")
+	require.Equal(t, "open", cb.State())
+
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+
+	got, err := r.Run(context.Background(), "u", "j", "hi")
+	require.NoError(t, err, "probe is let through after the reset period")
+	assert.Equal(t, "ok", got)
+	assert.Equal(t, "closed", cb.State())
+}
+
+func TestCircuitBreaker_FailedProbeReopens(t *testing.T) {
+	var down atomic.Bool
+	var calls atomic.Int32
+	down.Store(true)
+	srv := flakyServer(t, &down, &calls)
+	cb := runner.NewCircuitBreaker(1, 50*time.Millisecond)
+	r := runner.New(srv.URL, "test").WithCircuitBreaker(cb)
+
+	r.Run(context.Background(), "u", "j", "hi")
+	time.Sleep(60 * time.Millisecond)
+
+	_, err := r.Run(context.Background(), "u", "j", "hi")
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, runner.ErrCircuitOpen), "probe reaches the backend")
+	assert.Equal(t, "open", cb.State())
+
+	_, err = r.Run(context.Background(), "u", "j", "hi")
+	assert.ErrorIs(t, err, runner.ErrCircuitOpen)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestCircuitBreaker_CancelledCallsAreNotCounted(t *testing.T) {
+	var down atomic.Bool
+	var calls atomic.Int32
+	srv := flakyServer(t, &down, &calls)
+	cb := runner.NewCircuitBreaker(1, time.Minute)
+	r := runner.New(srv.URL, "test").WithCircuitBreaker(cb)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := r.Run(ctx, "u", "j", "hi")
+
+	require.Error(t, err)
+	assert.Equal(t, "closed", cb.State())
+}
+
+func TestCircuitBreaker_LogsStateChanges(t *testing.T) {
+	var down atomic.Bool
+	var calls atomic.Int32
+	down.Store(true)
+	srv := flakyServer(t, &down, &calls)
+	var buf bytes.Buffer
+	r := runner.New(srv.URL, "test").
+		WithCircuitBreaker(runner.NewCircuitBreaker(1, time.Minute)).
+		WithLogger(logging.NewLogger(&buf, slog.LevelInfo))
+
+	r.Run(context.Background(), "u", "j", "hi")
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record), buf.String())
+	assert.Equal(t, "runner", record["component"])
+	assert.Equal(t, "closed", record["from"])
+	assert.Equal(t, "open", record["to"])
+}
@@ -0,0 +1,81 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// cachedResult is a successful LLM response and when it stops being served.
+type cachedResult struct {
+	content string
+	expires time.Time
+}
+
+// CachingRunner is a Runner that answers a conversation it has already sent
+// to the LLM within ttl from memory, so identical prompts from different
+// users, or a job retried after a failed delivery, cost a single LLM call.
+// Only successful responses are cached; the cache is per process.
+type CachingRunner struct {
+	*Runner
+
+	ttl   time.Duration
+	mu    sync.RWMutex
+	cache map[string]cachedResult // SHA-256 of model and messages → response
+}
+
+// WithCache makes r serve repeated conversations from a cache for ttl and
+// returns it as a CachingRunner. The cache key covers the model and every
+// message, but not the user or job, so it suits prompts whose answer does
+// not depend on who asks or exactly when.
+func (r *Runner) WithCache(ttl time.Duration) *CachingRunner {
+	c := &CachingRunner{Runner: r, ttl: ttl, cache: make(map[string]cachedResult)}
+	r.cache = c
+	return c
+}
+
+// FlushCache drops every cached response.
+func (c *CachingRunner) FlushCache() {
+	c.mu.Lock()
+	c.cache = make(map[string]cachedResult)
+	c.mu.Unlock()
+}
+
+func (c *CachingRunner) get(key string) (string, bool) {
+	c.mu.RLock()
+	res, ok := c.cache[key]
+	c.mu.RUnlock()
+	if !ok || time.Now().After(res.expires) {
+		return "", false
+	}
+	return res.content, true
+}
+
+// put stores content under key, dropping expired entries on the way so the
+// map does not grow with prompts that are never asked again.
+func (c *CachingRunner) put(key, content string) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, res := range c.cache {
+		if now.After(res.expires) {
+			delete(c.cache, k)
+		}
+	}
+	c.cache[key] = cachedResult{content: content, expires: now.Add(c.ttl)}
+}
+
+// cacheKey hashes the model and messages; the NUL separators keep different
+// splits of the same text apart.
+func cacheKey(model string, messages []ChatMsg) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	for _, m := range messages {
+		h.Write([]byte{0})
+		h.Write([]byte(m.Role))
+		h.Write([]byte{0})
+		h.Write([]byte(m.Content))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,81 @@
+package runner_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/runner"
+)
+
+func TestCachingRunner_IdenticalPromptsCallLLMOnce(t *testing.T) {
+	var down atomic.Bool
+	var calls atomic.Int32
+	srv := flakyServer(t, &down, &calls)
+	r := runner.New(srv.URL, "test-model").WithCache(time.Minute)
+
+	first, err := r.Run(context.Background(), "user-1", "job-1", "What is 2+2?")
+	require.NoError(t, err)
+	second, err := r.Run(context.Background(), "user-2", "job-2", "What is 2+2?")
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, first, second)
+
+	_, err = r.Run(context.Background(), "user-1", "job-1", "What is 3+3?")
+	require.NoError(t, err)
+	_, err = r.RunWithContext(context.Background(), "user-1", "You are terse.", "What is 2+2?")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load(), "other prompts and system prompts are separate entries")
+}
+
+func TestCachingRunner_ExpiresAfterTTL(t *testing.T) {
+	var down atomic.Bool
+	var calls atomic.Int32
+	srv := flakyServer(t, &down, &calls)
+	r := runner.New(srv.URL, "test-model").WithCache(20 * time.Millisecond)
+
+	_, err := r.Run(context.Background(), "user-1", "job-1", "What is 2+2?")
+	require.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+	_, err = r.Run(context.Background(), "user-1", "job-1", "What is 2+2?")
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestCachingRunner_DoesNotCacheFailures(t *testing.T) {
+	var down atomic.Bool
+	var calls atomic.Int32
+	srv := flakyServer(t, &down, &calls)
+	r := runner.New(srv.URL, "test-model").WithCache(time.Minute)
+
+	down.Store(true)
+	_, err := r.Run(context.Background(), "user-1", "job-1", "What is 2+2?")
+	require.Error(t, err)
+	down.Store(false)
+	result, err := r.Run(context.Background(), "user-1", "job-1", "What is 2+2?")
+	require.NoError(t, err)
+
+	assert.Equal(t, "ok", result)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestCachingRunner_FlushCache(t *testing.T) {
+	var down atomic.Bool
+	var calls atomic.Int32
+	srv := flakyServer(t, &down, &calls)
+	r := runner.New(srv.URL, "test-model").WithCache(time.Minute)
+
+	_, err := r.Run(context.Background(), "user-1", "job-1", "What is 2+2?")
+	require.NoError(t, err)
+	r.FlushCache()
+	_, err = r.Run(context.Background(), "user-1", "job-1", "What is 2+2?")
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(2), calls.Load())
+}
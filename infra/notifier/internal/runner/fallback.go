@@ -0,0 +1,46 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+// ErrModel is wrapped by the errors of providers whose backend was reached but
+// answered with an error, e.g. a model that is not pulled or does not fit in
+// memory. Trying another model may help; see WithFallbackModels.
+var ErrModel = errors.New("llm error")
+
+// WithFallbackModels sets the models Run tries, in order, when the runner's
+// model fails with ErrModel or ErrEmptyResponse. Other failures (the backend
+// is unreachable, the context ended, the prompt is too long) are returned
+// right away, since another model would fail the same way. The model that
+// answered is reported in the Usage tracked by the context (see TrackUsage).
+// Streamed replies always come from the runner's own model.
+func (r *Runner) WithFallbackModels(models ...string) *Runner {
+	r.fallbacks = models
+	return r
+}
+
+// complete sends messages to r.model and then to each fallback model until
+// one answers, returning the model that did. A blank answer counts as a
+// failure when rejectEmpty is set.
+func (r *Runner) complete(ctx context.Context, messages []ChatMsg) (model, content string, err error) {
+	models := append([]string{r.model}, r.fallbacks...)
+	for i, model := range models {
+		content, err = r.provider.Chat(ctx, model, messages)
+		if err == nil && r.rejectEmpty && strings.TrimSpace(content) == "" {
+			err = ErrEmptyResponse
+		}
+		if err == nil {
+			return model, content, nil
+		}
+		if i == len(models)-1 || ctx.Err() != nil || !(errors.Is(err, ErrModel) || errors.Is(err, ErrEmptyResponse)) {
+			return model, "", err
+		}
+		r.logger.Warn("llm model failed, trying fallback model",
+			slog.String("model", model), slog.String("fallback", models[i+1]), slog.Any("error", err))
+	}
+	return "", "", err // unreachable: models is never empty
+}
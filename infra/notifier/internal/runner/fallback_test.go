@@ -0,0 +1,90 @@
+package runner_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/runner"
+)
+
+// modelServer fails every chat request for the "big" model the way Ollama
+// does when a model does not fit in memory, answers the others with the
+// model's name, and records the models asked for.
+func modelServer(t *testing.T, models *[]string) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		*models = append(*models, req.Model)
+		mu.Unlock()
+		if req.Model == "big" {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(runner.ChatResponse{Error: "model requires more system memory than is available"})
+			return
+		}
+		json.NewEncoder(w).Encode(runner.ChatResponse{Message: runner.ChatMsg{Role: "assistant", Content: "from " + req.Model}})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRunner_FallbackModels_UsesFirstThatAnswers(t *testing.T) {
+	var models []string
+	r := runner.New(modelServer(t, &models).URL, "big").WithFallbackModels("big", "small", "tiny")
+
+	ctx, usage := runner.TrackUsage(context.Background())
+	got, err := r.Run(ctx, "user-1", "job-1", "hello")
+
+	require.NoError(t, err)
+	assert.Equal(t, "from small", got)
+	assert.Equal(t, []string{"big", "big", "small"}, models, "fallbacks tried in order, stopping at the first answer")
+	assert.Equal(t, "small", usage.Model)
+}
+
+func TestRunner_FallbackModels_PrimaryAnswers(t *testing.T) {
+	var models []string
+	r := runner.New(modelServer(t, &models).URL, "small").WithFallbackModels("tiny")
+
+	ctx, usage := runner.TrackUsage(context.Background())
+	got, err := r.Run(ctx, "user-1", "job-1", "hello")
+
+	require.NoError(t, err)
+	assert.Equal(t, "from small", got)
+	assert.Equal(t, []string{"small"}, models)
+	assert.Equal(t, "small", usage.Model)
+}
+
+func TestRunner_FallbackModels_AllFail(t *testing.T) {
+	var models []string
+	r := runner.New(modelServer(t, &models).URL, "big").WithFallbackModels("big")
+
+	_, err := r.Run(context.Background(), "user-1", "job-1", "hello")
+
+	require.ErrorIs(t, err, runner.ErrModel)
+	assert.Contains(t, err.Error(), "more system memory")
+	assert.Len(t, models, 2)
+}
+
+func TestRunner_FallbackModels_NotOnNetworkError(t *testing.T) {
+	var models []string
+	srv := modelServer(t, &models)
+	srv.Close() // connection refused
+
+	r := runner.New(srv.URL, "big").WithFallbackModels("small")
+	_, err := r.Run(context.Background(), "user-1", "job-1", "hello")
+
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, runner.ErrModel)
+	assert.Contains(t, err.Error(), "http request")
+}
@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"unicode/utf8"
+)
+
+// ErrPromptTooLong is returned, wrapped with the prompt's length, when a
+// prompt is longer than the runner's limit (see WithMaxPromptChars). Sending
+// the same prompt again cannot succeed, so callers should not retry it.
+var ErrPromptTooLong = errors.New("prompt too long")
+
+// promptLimitKey carries a per-call prompt limit through the context.
+type promptLimitKey struct{}
+
+// LimitPrompt returns a context whose runner calls use maxChars instead of
+// the runner's own limit, e.g. a job's setting. maxChars <= 0 leaves the
+// runner's limit in place.
+func LimitPrompt(ctx context.Context, maxChars int) context.Context {
+	if maxChars <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, promptLimitKey{}, maxChars)
+}
+
+// WithMaxPromptChars caps the user prompt (the newest message) at maxChars
+// characters; 0 removes the cap. A longer prompt fails with ErrPromptTooLong
+// before reaching the LLM or, with truncate, loses its beginning so that the
+// end (usually the actual question) is kept.
+func (r *Runner) WithMaxPromptChars(maxChars int, truncate bool) *Runner {
+	r.maxPromptChars = maxChars
+	r.truncatePrompt = truncate
+	return r
+}
+
+// limitPrompt applies the prompt limit in effect for ctx to the last of
+// messages, returning a copy if it had to be truncated.
+func (r *Runner) limitPrompt(ctx context.Context, messages []ChatMsg) ([]ChatMsg, error) {
+	limit := r.maxPromptChars
+	if v, ok := ctx.Value(promptLimitKey{}).(int); ok {
+		limit = v
+	}
+	if limit <= 0 || len(messages) == 0 {
+		return messages, nil
+	}
+	prompt := messages[len(messages)-1].Content
+	n := utf8.RuneCountInString(prompt)
+	if n <= limit {
+		return messages, nil
+	}
+	if !r.truncatePrompt {
+		return nil, fmt.Errorf("%w: %d characters, limit is %d", ErrPromptTooLong, n, limit)
+	}
+	cut := 0
+	for range n - limit {
+		_, size := utf8.DecodeRuneInString(prompt[cut:])
+		cut += size
+	}
+	r.logger.Warn("prompt truncated", slog.Int("chars", n), slog.Int("limit", limit))
+	out := append([]ChatMsg(nil), messages...)
+	out[len(out)-1].Content = prompt[cut:]
+	return out, nil
+}
@@ -0,0 +1,71 @@
+package runner_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/runner"
+)
+
+// echoServer answers every chat request with its last message and counts the
+// requests.
+func echoServer(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req struct {
+			Messages []runner.ChatMsg `json:"messages"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		json.NewEncoder(w).Encode(runner.ChatResponse{Message: req.Messages[len(req.Messages)-1]})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRunner_MaxPromptChars_RejectsLongPrompt(t *testing.T) {
+	var calls atomic.Int32
+	r := runner.New(echoServer(t, &calls).URL, "test-model").WithMaxPromptChars(10, false)
+
+	_, err := r.Run(context.Background(), "user-1", "job-1", "Summarise all of this, please")
+
+	require.ErrorIs(t, err, runner.ErrPromptTooLong)
+	assert.Contains(t, err.Error(), "29 characters, limit is 10")
+	assert.Zero(t, calls.Load(), "LLM not called")
+
+	got, err := r.Run(context.Background(), "user-1", "job-1", "Short one")
+	require.NoError(t, err)
+	assert.Equal(t, "Short one", got)
+}
+
+func TestRunner_MaxPromptChars_TruncatesFromFront(t *testing.T) {
+	var calls atomic.Int32
+	r := runner.New(echoServer(t, &calls).URL, "test-model").WithMaxPromptChars(12, true)
+
+	got, err := r.RunWithContext(context.Background(), "user-1", "You are a very long-winded persona.", "ééééé What's new?")
+
+	require.NoError(t, err)
+	assert.Equal(t, " What's new?", got, "the beginning is cut, counting characters rather than bytes")
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestRunner_LimitPrompt_OverridesRunnerLimit(t *testing.T) {
+	var calls atomic.Int32
+	r := runner.New(echoServer(t, &calls).URL, "test-model").WithMaxPromptChars(100, false)
+
+	_, err := r.Run(runner.LimitPrompt(context.Background(), 5), "user-1", "job-1", "Twelve chars")
+	require.ErrorIs(t, err, runner.ErrPromptTooLong)
+
+	tokens, errc := r.RunStream(runner.LimitPrompt(context.Background(), 5), "user-1", "Twelve chars")
+	for range tokens {
+	}
+	require.ErrorIs(t, <-errc, runner.ErrPromptTooLong)
+	assert.Zero(t, calls.Load())
+}
@@ -0,0 +1,187 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ChatResponse is the response from the Ollama chat endpoint. The token
+// counts are only set on the final object of a streamed response.
+type ChatResponse struct {
+	Message         ChatMsg `json:"message"`
+	Error           string  `json:"error"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+}
+
+type chatRequest struct {
+	Model    string    `json:"model"`
+	Messages []ChatMsg `json:"messages"`
+	Stream   bool      `json:"stream"`
+}
+
+// OllamaProvider talks to Ollama's native /api/chat endpoint.
+type OllamaProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewOllamaProvider creates an OllamaProvider for the given base URL.
+func NewOllamaProvider(baseURL string) *OllamaProvider {
+	return &OllamaProvider{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 120 * time.Second},
+	}
+}
+
+// Chat posts messages to /api/chat and returns the assistant's reply.
+func (p *OllamaProvider) Chat(ctx context.Context, model string, messages []ChatMsg) (string, error) {
+	body, err := json.Marshal(chatRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   false,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	injectTraceContext(ctx, req.Header)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode response: %w", err)
+	}
+	if result.Error != "" {
+		return "", fmt.Errorf("%w: %s", ErrModel, result.Error)
+	}
+	recordUsage(ctx, result.PromptEvalCount, result.EvalCount)
+	return result.Message.Content, nil
+}
+
+// Ping checks that the Ollama server is reachable and that model has been
+// pulled, using GET /api/tags.
+func (p *OllamaProvider) Ping(ctx context.Context, model string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/api/tags", nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ollama unreachable at %s: %w", p.baseURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama /api/tags returned %d", resp.StatusCode)
+	}
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return fmt.Errorf("decode /api/tags: %w", err)
+	}
+
+	available := make([]string, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		// Ollama reports untagged models as "name:latest".
+		if m.Name == model || m.Name == model+":latest" {
+			return nil
+		}
+		available = append(available, m.Name)
+	}
+	return fmt.Errorf("model %q not found on %s (available: %s)",
+		model, p.baseURL, strings.Join(available, ", "))
+}
+
+// ChatStream posts messages to /api/chat with streaming enabled. Ollama answers
+// with one JSON object per line; the content of each is sent on the returned
+// token channel as it arrives. Both channels are closed when the response ends;
+// at most one error is sent first.
+func (p *OllamaProvider) ChatStream(ctx context.Context, model string, messages []ChatMsg) (<-chan string, <-chan error) {
+	tokens := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(tokens)
+		if err := p.stream(ctx, model, messages, tokens); err != nil {
+			errc <- err
+		}
+	}()
+	return tokens, errc
+}
+
+func (p *OllamaProvider) stream(ctx context.Context, model string, messages []ChatMsg, tokens chan<- string) error {
+	body, err := json.Marshal(chatRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   true,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	injectTraceContext(ctx, req.Header)
+
+	// The client timeout would cut off a long generation; ctx bounds it instead.
+	client := *p.client
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var chunk streamChunk
+		if err := dec.Decode(&chunk); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decode stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return fmt.Errorf("%w: %s", ErrModel, chunk.Error)
+		}
+		recordUsage(ctx, chunk.PromptEvalCount, chunk.EvalCount)
+		if chunk.Message.Content != "" {
+			select {
+			case tokens <- chunk.Message.Content:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if chunk.Done {
+			return nil
+		}
+	}
+}
+
+// streamChunk is one line of a streaming /api/chat response.
+type streamChunk struct {
+	ChatResponse
+	Done bool `json:"done"`
+}
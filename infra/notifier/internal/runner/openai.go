@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAIChatRequest is the request body of the OpenAI /v1/chat/completions endpoint.
+type OpenAIChatRequest struct {
+	Model     string    `json:"model"`
+	Messages  []ChatMsg `json:"messages"`
+	MaxTokens int       `json:"max_tokens,omitempty"`
+}
+
+// OpenAIChatResponse is the (relevant subset of the) response from /v1/chat/completions.
+type OpenAIChatResponse struct {
+	Choices []struct {
+		Message      ChatMsg `json:"message"`
+		FinishReason string  `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error,omitempty"`
+}
+
+// OpenAIProvider talks to OpenAI or any API implementing /v1/chat/completions.
+type OpenAIProvider struct {
+	baseURL   string
+	apiKey    string
+	maxTokens int
+	client    *http.Client
+}
+
+// NewOpenAIProvider creates an OpenAIProvider. baseURL is the API root without
+// the /v1 suffix (e.g. "https://api.openai.com"). maxTokens of 0 omits the limit.
+func NewOpenAIProvider(baseURL, apiKey string, maxTokens int) *OpenAIProvider {
+	return &OpenAIProvider{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		apiKey:    apiKey,
+		maxTokens: maxTokens,
+		client:    &http.Client{Timeout: 120 * time.Second},
+	}
+}
+
+// Chat posts messages to /v1/chat/completions and returns the first choice.
+func (p *OpenAIProvider) Chat(ctx context.Context, model string, messages []ChatMsg) (string, error) {
+	body, err := json.Marshal(OpenAIChatRequest{
+		Model:     model,
+		Messages:  messages,
+		MaxTokens: p.maxTokens,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	injectTraceContext(ctx, req.Header)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result OpenAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode response (status %d): %w", resp.StatusCode, err)
+	}
+	if result.Error != nil {
+		return "", fmt.Errorf("%w (%d %s): %s", ErrModel, resp.StatusCode, result.Error.Type, result.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: openai returned %d", ErrModel, resp.StatusCode)
+	}
+	recordUsage(ctx, result.Usage.PromptTokens, result.Usage.CompletionTokens)
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("%w: response has no choices", ErrModel)
+	}
+	return result.Choices[0].Message.Content, nil
+}
@@ -0,0 +1,106 @@
+package runner_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/runner"
+	"github.com/allerac/notifier/internal/scheduler"
+)
+
+// Runners built on either provider plug into the scheduler.
+var (
+	_ scheduler.Runner             = runner.New("", "")
+	_ scheduler.SystemPromptRunner = runner.NewWithProvider(runner.NewOpenAIProvider("", "", 0), "")
+)
+
+const openAISuccess = `{
+  "id": "chatcmpl-123",
+  "object": "chat.completion",
+  "created": 1677652288,
+  "model": "gpt-4",
+  "choices": [{
+    "index": 0,
+    "message": {"role": "assistant", "content": "Hello from GPT-4!"},
+    "finish_reason": "stop"
+  }],
+  "usage": {"prompt_tokens": 9, "completion_tokens": 12, "total_tokens": 21}
+}`
+
+func newOpenAIRunner(t *testing.T, handler http.HandlerFunc) *runner.Runner {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	r, err := runner.NewFromConfig(runner.Config{
+		Provider:  runner.ProviderOpenAI,
+		BaseURL:   srv.URL,
+		Model:     "gpt-4",
+		APIKey:    "sk-test",
+		MaxTokens: 256,
+	})
+	require.NoError(t, err)
+	return r
+}
+
+func TestOpenAIProvider_Run_Success(t *testing.T) {
+	var got runner.OpenAIChatRequest
+	r := newOpenAIRunner(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"))
+		require.NoError(t, decodeJSON(r, &got))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(openAISuccess))
+	})
+
+	result, err := r.RunWithContext(context.Background(), "user-1", "Be brief.", "Say hello")
+
+	require.NoError(t, err)
+	assert.Equal(t, "Hello from GPT-4!", result)
+	assert.Equal(t, "gpt-4", got.Model)
+	assert.Equal(t, 256, got.MaxTokens)
+	require.Len(t, got.Messages, 2)
+	assert.Equal(t, "system", got.Messages[0].Role)
+	assert.Equal(t, runner.ChatMsg{Role: "user", Content: "Say hello"}, got.Messages[1])
+}
+
+func TestOpenAIProvider_Run_APIError(t *testing.T) {
+	r := newOpenAIRunner(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"message":"Incorrect API key provided","type":"invalid_request_error"}}`))
+	})
+
+	_, err := r.Run(context.Background(), "user-1", "job-1", "hello")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Incorrect API key provided")
+	assert.Contains(t, err.Error(), "401")
+}
+
+func TestOpenAIProvider_Run_NoChoices(t *testing.T) {
+	r := newOpenAIRunner(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[]}`))
+	})
+
+	_, err := r.Run(context.Background(), "user-1", "job-1", "hello")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no choices")
+}
+
+func TestNewFromConfig_Validation(t *testing.T) {
+	_, err := runner.NewFromConfig(runner.Config{Provider: runner.ProviderOpenAI, Model: "gpt-4"})
+	assert.ErrorContains(t, err, "API key")
+
+	_, err = runner.NewFromConfig(runner.Config{Provider: "bard"})
+	assert.ErrorContains(t, err, "unknown llm provider")
+
+	r, err := runner.NewFromConfig(runner.Config{BaseURL: "http://localhost:11434", Model: "qwen2.5:3b"})
+	require.NoError(t, err)
+	assert.NotNil(t, r, "empty provider defaults to Ollama")
+}
@@ -1,78 +1,328 @@
 package runner
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/allerac/notifier/internal/runner")
+
 // ChatMsg is a single message in a chat request/response.
 type ChatMsg struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
 
-// ChatResponse is the response from the Ollama chat endpoint.
-type ChatResponse struct {
-	Message ChatMsg `json:"message"`
-	Error   string  `json:"error"`
+// Provider sends a conversation to an LLM backend and returns the assistant's reply.
+type Provider interface {
+	Chat(ctx context.Context, model string, messages []ChatMsg) (string, error)
+}
+
+// StreamProvider is implemented by providers that can stream a reply as it is
+// generated. The token channel and the error channel are both closed when the
+// reply is complete; at most one error is sent.
+type StreamProvider interface {
+	ChatStream(ctx context.Context, model string, messages []ChatMsg) (<-chan string, <-chan error)
 }
 
-type chatRequest struct {
-	Model    string    `json:"model"`
-	Messages []ChatMsg `json:"messages"`
-	Stream   bool      `json:"stream"`
+// ProviderType selects the LLM backend used by NewFromConfig.
+type ProviderType string
+
+const (
+	ProviderOllama    ProviderType = "ollama"
+	ProviderOpenAI    ProviderType = "openai"
+	ProviderAnthropic ProviderType = "anthropic"
+)
+
+// Config describes the LLM backend for NewFromConfig.
+type Config struct {
+	Provider  ProviderType // defaults to ProviderOllama
+	BaseURL   string
+	Model     string
+	APIKey    string // OpenAI and Anthropic only
+	MaxTokens int    // OpenAI and Anthropic only; 0 lets OpenAI decide and gives Anthropic 1024
 }
 
-// Runner executes prompts against an Ollama-compatible LLM API.
+// ErrEmptyResponse is returned by Run when the LLM answers successfully but
+// with no content (e.g. while the model is still loading).
+var ErrEmptyResponse = errors.New("empty llm response")
+
+// Runner executes prompts against an LLM Provider.
 type Runner struct {
-	baseURL string
-	model   string
-	client  *http.Client
+	provider    Provider
+	model       string
+	rejectEmpty bool
+	breaker     *CircuitBreaker
+	cache       *CachingRunner // set by WithCache
+	logger      *slog.Logger
+
+	maxPromptChars int // 0 = no limit; see WithMaxPromptChars
+	truncatePrompt bool
+	fallbacks      []string // see WithFallbackModels
 }
 
 // New creates a Runner pointing at the given Ollama base URL.
 func New(baseURL, model string) *Runner {
+	return NewWithProvider(NewOllamaProvider(baseURL), model)
+}
+
+// NewWithProvider creates a Runner that sends prompts for model to p.
+func NewWithProvider(p Provider, model string) *Runner {
 	return &Runner{
-		baseURL: baseURL,
-		model:   model,
-		client:  &http.Client{Timeout: 120 * time.Second},
+		provider:    p,
+		model:       model,
+		rejectEmpty: true,
+		logger:      slog.Default().With(slog.String("component", "runner")),
 	}
 }
 
+// NewFromConfig creates a Runner for the provider selected in cfg.
+func NewFromConfig(cfg Config) (*Runner, error) {
+	switch cfg.Provider {
+	case ProviderOllama, "":
+		return New(cfg.BaseURL, cfg.Model), nil
+	case ProviderOpenAI:
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("openai provider requires an API key")
+		}
+		return NewWithProvider(NewOpenAIProvider(cfg.BaseURL, cfg.APIKey, cfg.MaxTokens), cfg.Model), nil
+	case ProviderAnthropic:
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("anthropic provider requires an API key")
+		}
+		return NewWithProvider(NewAnthropicProvider(cfg.BaseURL, cfg.MaxTokens).WithAPIKey(cfg.APIKey), cfg.Model), nil
+	default:
+		return nil, fmt.Errorf("unknown llm provider %q", cfg.Provider)
+	}
+}
+
+// WithRejectEmpty controls whether a blank response is treated as an error
+// (ErrEmptyResponse, so the scheduler retries it). Enabled by default; disable
+// it for jobs that legitimately expect empty output.
+func (r *Runner) WithRejectEmpty(reject bool) *Runner {
+	r.rejectEmpty = reject
+	return r
+}
+
+// WithCircuitBreaker guards every LLM call with cb, so a backend that keeps
+// failing is left alone for a while instead of being called by every job.
+func (r *Runner) WithCircuitBreaker(cb *CircuitBreaker) *Runner {
+	r.breaker = cb
+	return r
+}
+
+// WithLogger sets the logger used by the runner (default slog.Default()).
+func (r *Runner) WithLogger(l *slog.Logger) *Runner {
+	r.logger = l.With(slog.String("component", "runner"))
+	return r
+}
+
 // Run sends a prompt to the LLM and returns the response text.
-// userID and jobID are passed for context but not used in the Ollama request.
-func (r *Runner) Run(ctx context.Context, _, _ string, prompt string) (string, error) {
-	body, err := json.Marshal(chatRequest{
-		Model:    r.model,
-		Messages: []ChatMsg{{Role: "user", Content: prompt}},
-		Stream:   false,
-	})
-	if err != nil {
-		return "", fmt.Errorf("marshal request: %w", err)
+// userID and jobID are passed for context but not used in the LLM request.
+func (r *Runner) Run(ctx context.Context, userID, _ string, prompt string) (string, error) {
+	return r.RunWithContext(ctx, userID, "", prompt)
+}
+
+// RunWithContext sends userPrompt preceded by a system message carrying
+// systemPrompt (a persona or background context). An empty systemPrompt sends
+// the user message alone, exactly like Run.
+func (r *Runner) RunWithContext(ctx context.Context, _, systemPrompt, userPrompt string) (string, error) {
+	var messages []ChatMsg
+	if systemPrompt != "" {
+		messages = append(messages, ChatMsg{Role: "system", Content: systemPrompt})
 	}
+	messages = append(messages, ChatMsg{Role: "user", Content: userPrompt})
+	return r.chat(ctx, messages)
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/api/chat", bytes.NewReader(body))
+// RunWithHistory continues a conversation: history (oldest first, e.g. the
+// job's previous prompts and answers) is sent as-is, followed by prompt as the
+// newest user message. The caller's slice is not modified.
+func (r *Runner) RunWithHistory(ctx context.Context, _ string, history []ChatMsg, prompt string) (string, error) {
+	messages := make([]ChatMsg, 0, len(history)+1)
+	messages = append(messages, history...)
+	messages = append(messages, ChatMsg{Role: "user", Content: prompt})
+	return r.chat(ctx, messages)
+}
+
+// RunStream sends a prompt to the LLM and returns its reply token by token.
+// Both channels are closed once the reply is complete or has failed; a failure
+// is sent on the error channel first. Providers that cannot stream deliver the
+// whole reply as a single token. The cache and the empty-response check do not
+// apply to streamed replies.
+func (r *Runner) RunStream(ctx context.Context, _ string, prompt string) (<-chan string, <-chan error) {
+	messages, err := r.limitPrompt(ctx, []ChatMsg{{Role: "user", Content: prompt}})
 	if err != nil {
-		return "", fmt.Errorf("create request: %w", err)
+		tokens, errc := make(chan string), make(chan error, 1)
+		errc <- err
+		close(errc)
+		close(tokens)
+		return tokens, errc
+	}
+	sp, ok := r.provider.(StreamProvider)
+	if !ok {
+		tokens, errc := make(chan string, 1), make(chan error, 1)
+		go func() {
+			defer close(errc)
+			defer close(tokens)
+			content, err := r.chat(ctx, messages)
+			if err != nil {
+				errc <- err
+				return
+			}
+			tokens <- content
+		}()
+		return tokens, errc
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := r.client.Do(req)
+	if r.breaker != nil {
+		if err := r.breaker.allow(); err != nil {
+			tokens, errc := make(chan string), make(chan error, 1)
+			errc <- err
+			close(errc)
+			close(tokens)
+			return tokens, errc
+		}
+	}
+	ctx, span := tracer.Start(ctx, "runner.llm_call", trace.WithAttributes(
+		attribute.String("llm.model", r.model), attribute.Bool("llm.stream", true)))
+	in, inErr := sp.ChatStream(ctx, r.model, messages)
+	tokens, errc := make(chan string), make(chan error, 1)
+	go func() {
+		defer span.End()
+		defer close(errc)
+		defer close(tokens)
+		for tok := range in {
+			select {
+			case tokens <- tok:
+			case <-ctx.Done():
+				// Unblock the provider; it stops on the cancelled context.
+				for range in {
+				}
+			}
+		}
+		err := <-inErr
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "llm call failed")
+		}
+		if r.breaker != nil {
+			if from, to := r.breaker.record(err, ctx.Err() != nil); from != to {
+				r.logger.Warn("llm circuit breaker state changed",
+					slog.String("from", from), slog.String("to", to), slog.String("model", r.model))
+			}
+		}
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return tokens, errc
+}
+
+// chat sends messages to the provider, subject to the prompt limit and the
+// circuit breaker, and applies the empty-response policy. With a cache, a
+// fresh cached response is returned without calling the provider, and
+// successful ones are cached.
+func (r *Runner) chat(ctx context.Context, messages []ChatMsg) (string, error) {
+	messages, err := r.limitPrompt(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	var key string
+	if r.cache != nil {
+		key = cacheKey(r.model, messages)
+		if content, ok := r.cache.get(key); ok {
+			r.logger.Debug("llm response served from cache", slog.String("model", r.model))
+			return content, nil
+		}
+	}
+	if r.breaker != nil {
+		if err := r.breaker.allow(); err != nil {
+			return "", err
+		}
+	}
+	ctx, span := tracer.Start(ctx, "runner.llm_call", trace.WithAttributes(attribute.String("llm.model", r.model)))
+	defer span.End()
+	model, content, err := r.complete(ctx, messages)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "llm call failed")
+	}
+	if r.breaker != nil {
+		// A blank answer still means the backend is up.
+		callErr := err
+		if errors.Is(err, ErrEmptyResponse) {
+			callErr = nil
+		}
+		if from, to := r.breaker.record(callErr, ctx.Err() != nil); from != to {
+			r.logger.Warn("llm circuit breaker state changed",
+				slog.String("from", from), slog.String("to", to), slog.String("model", r.model))
+		}
+	}
 	if err != nil {
-		return "", fmt.Errorf("http request: %w", err)
+		return "", err
+	}
+	recordModel(ctx, model)
+	if model != r.model {
+		span.SetAttributes(attribute.String("llm.answered_by", model))
+		r.logger.Info("llm answered by fallback model", slog.String("model", r.model), slog.String("answered_by", model))
+		// Cached answers are keyed by r.model; keep fallback ones out.
+		return content, nil
+	}
+	if r.cache != nil {
+		r.cache.put(key, content)
+	}
+	return content, nil
+}
+
+// Ping checks that the LLM backend is ready to serve r.model. Providers that
+// cannot be checked (no Ping method) are assumed ready.
+func (r *Runner) Ping(ctx context.Context) error {
+	p, ok := r.provider.(interface {
+		Ping(ctx context.Context, model string) error
+	})
+	if !ok {
+		return nil
 	}
-	defer resp.Body.Close()
+	return p.Ping(ctx, r.model)
+}
 
-	var result ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("decode response: %w", err)
+// Warmup sends a throwaway prompt so Ollama loads r.model into memory before
+// the first job needs it, and logs how long that took. It bypasses the
+// circuit breaker and the empty-response check. An unreachable server is
+// logged and not an error, so startup can go on without it; other failures
+// (e.g. a model that is not pulled, or ctx expiring mid-load) are returned.
+// Other providers have no model to load and return nil right away.
+func (r *Runner) Warmup(ctx context.Context) error {
+	if _, ok := r.provider.(*OllamaProvider); !ok {
+		return nil
+	}
+	start := time.Now()
+	_, err := r.provider.Chat(ctx, r.model, []ChatMsg{{Role: "user", Content: "ping"}})
+	if urlErr := (*url.Error)(nil); errors.As(err, &urlErr) && ctx.Err() == nil {
+		r.logger.Warn("llm warmup skipped, backend unreachable", slog.String("model", r.model), slog.Any("error", err))
+		return nil
 	}
-	if result.Error != "" {
-		return "", fmt.Errorf("llm error: %s", result.Error)
+	if err != nil {
+		return fmt.Errorf("warmup: %w", err)
 	}
-	return result.Message.Content, nil
+	r.logger.Info("llm warmed up", slog.String("model", r.model), slog.Duration("latency", time.Since(start)))
+	return nil
+}
+
+// injectTraceContext adds the W3C trace-context headers of the span in ctx to
+// an outgoing request, so the LLM backend can join the trace.
+func injectTraceContext(ctx context.Context, h http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
 }
@@ -3,8 +3,10 @@ package runner_test
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -78,3 +80,243 @@ func TestRunner_Run_SendsPromptInRequest(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, wantPrompt, gotPrompt)
 }
+
+func emptyResponseServer(t *testing.T, content string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(runner.ChatResponse{
+			Message: runner.ChatMsg{Role: "assistant", Content: content},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRunner_Run_EmptyResponseRejectedByDefault(t *testing.T) {
+	for _, content := range []string{"", "  \n\t "} {
+		srv := emptyResponseServer(t, content)
+
+		_, err := runner.New(srv.URL, "test-model").Run(context.Background(), "user-1", "job-1", "hello")
+
+		require.Error(t, err)
+		assert.ErrorIs(t, err, runner.ErrEmptyResponse)
+		assert.Contains(t, err.Error(), "empty llm response")
+	}
+}
+
+func TestRunner_Run_EmptyResponseAllowedWhenDisabled(t *testing.T) {
+	srv := emptyResponseServer(t, "")
+
+	result, err := runner.New(srv.URL, "test-model").
+		WithRejectEmpty(false).
+		Run(context.Background(), "user-1", "job-1", "hello")
+
+	require.NoError(t, err)
+	assert.Equal(t, "", result)
+}
+
+func tagsServer(t *testing.T, models ...string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/api/tags", r.URL.Path)
+		type model struct {
+			Name string `json:"name"`
+		}
+		resp := struct {
+			Models []model `json:"models"`
+		}{}
+		for _, m := range models {
+			resp.Models = append(resp.Models, model{Name: m})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRunner_Ping_ModelAvailable(t *testing.T) {
+	srv := tagsServer(t, "llama3:latest", "qwen2.5:3b")
+	require.NoError(t, runner.New(srv.URL, "qwen2.5:3b").Ping(context.Background()))
+}
+
+func TestRunner_Ping_UntaggedModelMatchesLatest(t *testing.T) {
+	srv := tagsServer(t, "llama3:latest")
+	require.NoError(t, runner.New(srv.URL, "llama3").Ping(context.Background()))
+}
+
+func TestRunner_Ping_ModelMissing(t *testing.T) {
+	srv := tagsServer(t, "llama3:latest")
+
+	err := runner.New(srv.URL, "qwen2.5:3").Ping(context.Background())
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `model "qwen2.5:3" not found`)
+	assert.Contains(t, err.Error(), "llama3:latest")
+}
+
+func TestRunner_Ping_ServerUnavailable(t *testing.T) {
+	err := runner.New("http://127.0.0.1:1", "test-model").Ping(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unreachable")
+}
+
+// captureMessages returns a server that records the messages of the last chat request.
+func captureMessages(t *testing.T, got *[]runner.ChatMsg) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []runner.ChatMsg `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		*got = req.Messages
+		json.NewEncoder(w).Encode(runner.ChatResponse{
+			Message: runner.ChatMsg{Role: "assistant", Content: "ok"},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRunner_RunWithContext_SystemPromptFirst(t *testing.T) {
+	var got []runner.ChatMsg
+	srv := captureMessages(t, &got)
+
+	_, err := runner.New(srv.URL, "test-model").
+		RunWithContext(context.Background(), "user-1", "You are a pirate.", "Say hello")
+
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, runner.ChatMsg{Role: "system", Content: "You are a pirate."}, got[0])
+	assert.Equal(t, runner.ChatMsg{Role: "user", Content: "Say hello"}, got[1])
+}
+
+func TestRunner_RunWithContext_NoSystemPrompt(t *testing.T) {
+	var got []runner.ChatMsg
+	srv := captureMessages(t, &got)
+
+	_, err := runner.New(srv.URL, "test-model").
+		RunWithContext(context.Background(), "user-1", "", "Say hello")
+
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "user", got[0].Role, "no system message at index 0")
+}
+
+func decodeJSON(r *http.Request, v any) error {
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+func TestRunner_RunWithHistory_AppendsPromptAfterHistory(t *testing.T) {
+	var got []runner.ChatMsg
+	srv := captureMessages(t, &got)
+
+	history := []runner.ChatMsg{
+		{Role: "system", Content: "You summarize changes."},
+		{Role: "user", Content: "What changed today?"},
+		{Role: "assistant", Content: "Two PRs merged."},
+	}
+	_, err := runner.New(srv.URL, "test-model").
+		RunWithHistory(context.Background(), "user-1", history, "What changed since last time?")
+
+	require.NoError(t, err)
+	require.Len(t, got, 4)
+	assert.Equal(t, history, got[:3], "history sent first, in order")
+	assert.Equal(t, runner.ChatMsg{Role: "user", Content: "What changed since last time?"}, got[3])
+	assert.Len(t, history, 3, "caller's history not modified")
+}
+
+func TestRunner_RunWithHistory_EmptyHistory(t *testing.T) {
+	var got []runner.ChatMsg
+	srv := captureMessages(t, &got)
+
+	_, err := runner.New(srv.URL, "test-model").
+		RunWithHistory(context.Background(), "user-1", nil, "hello")
+
+	require.NoError(t, err)
+	assert.Equal(t, []runner.ChatMsg{{Role: "user", Content: "hello"}}, got)
+}
+
+func TestRunner_Warmup_SendsOnePing(t *testing.T) {
+	var calls atomic.Int32
+	var got struct {
+		Model    string           `json:"model"`
+		Messages []runner.ChatMsg `json:"messages"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		assert.Equal(t, "/api/chat", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		json.NewEncoder(w).Encode(runner.ChatResponse{Message: runner.ChatMsg{Role: "assistant", Content: "pong"}})
+	}))
+	defer srv.Close()
+
+	require.NoError(t, runner.New(srv.URL, "qwen2.5:3b").Warmup(context.Background()))
+
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, "qwen2.5:3b", got.Model)
+	assert.Equal(t, []runner.ChatMsg{{Role: "user", Content: "ping"}}, got.Messages)
+}
+
+func TestRunner_Warmup_UnreachableIsNotAnError(t *testing.T) {
+	r := runner.New("http://127.0.0.1:1", "qwen2.5:3b")
+
+	assert.NoError(t, r.Warmup(context.Background()))
+}
+
+func TestRunner_Warmup_ModelErrorIsReturned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(runner.ChatResponse{Error: `model "missing" not found, try pulling it first`})
+	}))
+	defer srv.Close()
+
+	err := runner.New(srv.URL, "missing").Warmup(context.Background())
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+}
+
+func TestRunner_RunStream_SendsEachChunk(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		assert.True(t, req.Stream)
+
+		for _, line := range []string{
+			`{"message":{"role":"assistant","content":"Good "},"done":false}`,
+			`{"message":{"role":"assistant","content":"morning"},"done":false}`,
+			`{"message":{"role":"assistant","content":"!"},"done":true}`,
+		} {
+			fmt.Fprintln(w, line)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	tokens, errc := runner.New(srv.URL, "test-model").RunStream(context.Background(), "user-1", "greet me")
+
+	var got []string
+	for tok := range tokens {
+		got = append(got, tok)
+	}
+	require.NoError(t, <-errc)
+	assert.Equal(t, []string{"Good ", "morning", "!"}, got)
+}
+
+func TestRunner_RunStream_LLMError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hi"},"done":false}`)
+		fmt.Fprintln(w, `{"error":"model unloaded"}`)
+	}))
+	defer srv.Close()
+
+	tokens, errc := runner.New(srv.URL, "test-model").RunStream(context.Background(), "user-1", "hello")
+
+	for range tokens {
+	}
+	err := <-errc
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "model unloaded")
+}
@@ -0,0 +1,41 @@
+package runner
+
+import "context"
+
+// Usage counts the tokens spent by LLM calls, as reported by the backend.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+
+	// Model is the model that answered the last successful call, which is
+	// a fallback model when the runner's own failed (see WithFallbackModels).
+	Model string `json:"model,omitempty"`
+}
+
+// usageKey carries a *Usage through the context of runner calls.
+type usageKey struct{}
+
+// TrackUsage returns a context that adds the token usage of every LLM call
+// made with it to the returned Usage, e.g. all attempts of one job execution.
+// Read the Usage once the calls have returned (for RunStream, once its
+// channels are closed). Cached responses and backends that report no usage
+// (such as the Allerac pipeline) add nothing.
+func TrackUsage(ctx context.Context) (context.Context, *Usage) {
+	u := &Usage{}
+	return context.WithValue(ctx, usageKey{}, u), u
+}
+
+// recordUsage adds a call's token counts to the Usage tracked by ctx, if any.
+func recordUsage(ctx context.Context, promptTokens, completionTokens int) {
+	if u, ok := ctx.Value(usageKey{}).(*Usage); ok {
+		u.PromptTokens += promptTokens
+		u.CompletionTokens += completionTokens
+	}
+}
+
+// recordModel notes in the Usage tracked by ctx, if any, which model answered.
+func recordModel(ctx context.Context, model string) {
+	if u, ok := ctx.Value(usageKey{}).(*Usage); ok {
+		u.Model = model
+	}
+}
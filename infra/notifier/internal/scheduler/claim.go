@@ -0,0 +1,69 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// firingLookback bounds how late a cron callback may start and still be
+// matched to the activation it belongs to (see firingTime).
+const firingLookback = time.Minute
+
+// fire executes the firing of job scheduled for at, unless another notifier
+// instance has claimed it already. Every instance runs the same cron entries,
+// so each firing is claimed in scheduled_jobs.last_fired_at first: only the
+// instance whose UPDATE moves it forward to at executes the job. Nothing is
+// held during the execution, and an instance that fires late finds the firing
+// taken even after a fast run has finished elsewhere.
+func (s *Scheduler) fire(ctx context.Context, job Job, at time.Time) {
+	claimed, err := s.claimFiring(ctx, job.ID, at)
+	if err != nil {
+		s.logger.Error("failed to claim job firing", slog.String("job_id", job.ID), slog.Time("scheduled", at), slog.Any("error", err))
+		return
+	}
+	if !claimed {
+		s.logger.Info("job firing claimed by another instance, skipping",
+			slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Time("scheduled", at))
+		return
+	}
+	s.ExecuteJob(ctx, job)
+}
+
+// claimFiring records at as the job's last firing and reports whether this
+// call did so. Firings only move forward, so a claim for a firing at or before
+// the last one fails, as does one for a job that no longer exists.
+func (s *Scheduler) claimFiring(ctx context.Context, jobID string, at time.Time) (bool, error) {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE scheduled_jobs
+		SET last_fired_at = $2
+		WHERE id = $1 AND (last_fired_at IS NULL OR last_fired_at < $2)
+	`, jobID, at)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// firingTime returns the activation of the job's schedule that a cron callback
+// started at now belongs to: the last one at or before now. Instances compute
+// it from their own clock and the shared schedule, so they agree on it even if
+// the callback starts a little late. Without a match it falls back to now
+// truncated to the second, the resolution of cron schedules.
+func (s *Scheduler) firingTime(jobID string, now time.Time) time.Time {
+	at := now.Truncate(time.Second)
+	s.mu.Lock()
+	entryID, ok := s.entries[jobID]
+	s.mu.Unlock()
+	if !ok {
+		return at
+	}
+	schedule := s.cron.Entry(entryID).Schedule
+	if schedule == nil {
+		return at
+	}
+	for t := schedule.Next(now.Add(-firingLookback)); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+		at = t
+	}
+	return at
+}
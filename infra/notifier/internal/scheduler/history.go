@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ExecutionRecord is one job_executions row. CompletedAt is zero while the
+// execution is still running.
+type ExecutionRecord struct {
+	ID          string    `json:"id"`
+	JobID       string    `json:"job_id"`
+	Status      string    `json:"status"`
+	Result      string    `json:"result,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	TraceID     string    `json:"trace_id,omitempty"` // empty for executions recorded before trace IDs
+
+	// Tokens spent by the execution's LLM calls, retries included; zero when
+	// none were made or the backend does not report usage.
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// TokenUsage is the LLM token usage of a job's executions started in
+// [From, To).
+type TokenUsage struct {
+	JobID            string    `json:"job_id"`
+	From             time.Time `json:"from"`
+	To               time.Time `json:"to"`
+	Executions       int       `json:"executions"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+}
+
+// GetExecutionHistory returns page (1-based) of jobID's executions, newest
+// first, pageSize per page, along with the job's total number of executions.
+func (s *Scheduler) GetExecutionHistory(ctx context.Context, jobID string, page, pageSize int) ([]ExecutionRecord, int, error) {
+	if page < 1 || pageSize < 1 {
+		return nil, 0, fmt.Errorf("invalid page %d or page size %d", page, pageSize)
+	}
+	offset := (page - 1) * pageSize
+	rows, err := s.db.Query(ctx, `
+		SELECT id, job_id, status, COALESCE(result, ''), started_at, completed_at, COALESCE(trace_id, ''),
+			COALESCE(prompt_tokens, 0), COALESCE(completion_tokens, 0), COUNT(*) OVER()
+		FROM job_executions
+		WHERE job_id = $3
+		ORDER BY started_at DESC, id
+		LIMIT $1 OFFSET $2
+	`, pageSize, offset, jobID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	records := []ExecutionRecord{}
+	total := 0
+	for rows.Next() {
+		var r ExecutionRecord
+		var completedAt *time.Time
+		if err := rows.Scan(&r.ID, &r.JobID, &r.Status, &r.Result, &r.StartedAt, &completedAt, &r.TraceID,
+			&r.PromptTokens, &r.CompletionTokens, &total); err != nil {
+			return nil, 0, err
+		}
+		if completedAt != nil {
+			r.CompletedAt = *completedAt
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// A page past the end has no rows to carry the window count.
+	if len(records) == 0 && offset > 0 {
+		err = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM job_executions WHERE job_id = $1`, jobID).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return records, total, nil
+}
+
+// GetTokenUsage sums the token usage of jobID's executions started in
+// [from, to).
+func (s *Scheduler) GetTokenUsage(ctx context.Context, jobID string, from, to time.Time) (TokenUsage, error) {
+	u := TokenUsage{JobID: jobID, From: from, To: to}
+	err := s.db.QueryRow(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0)
+		FROM job_executions
+		WHERE job_id = $1 AND started_at >= $2 AND started_at < $3
+	`, jobID, from, to).Scan(&u.Executions, &u.PromptTokens, &u.CompletionTokens)
+	if err != nil {
+		return TokenUsage{}, err
+	}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	return u, nil
+}
@@ -0,0 +1,36 @@
+package scheduler
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// metrics are the Prometheus collectors maintained by a Scheduler. They are
+// always updated; WithMetrics exposes them on a registry.
+type metrics struct {
+	executions     *prometheus.CounterVec
+	attempts       *prometheus.CounterVec
+	runnerDuration prometheus.Histogram
+}
+
+func newMetrics() metrics {
+	return metrics{
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "notifier_job_executions_total",
+			Help: "Finished job executions, by final status (completed, failed, timed_out, deduplicated, rate_limited).",
+		}, []string{"status"}),
+		attempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "notifier_runner_attempts_total",
+			Help: "LLM runner calls, retries included, by result (success or error).",
+		}, []string{"result"}),
+		runnerDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "notifier_runner_duration_seconds",
+			Help:    "Duration of a single LLM runner call.",
+			Buckets: prometheus.ExponentialBuckets(0.5, 2, 10), // 0.5s … 256s
+		}),
+	}
+}
+
+// WithMetrics registers the scheduler's metrics with reg (typically
+// prometheus.DefaultRegisterer). It panics if they are already registered.
+func (s *Scheduler) WithMetrics(reg prometheus.Registerer) *Scheduler {
+	reg.MustRegister(s.metrics.executions, s.metrics.attempts, s.metrics.runnerDuration)
+	return s
+}
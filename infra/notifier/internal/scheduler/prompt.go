@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// builtinVars returns the variables every prompt template can use, for the
+// moment now (in UTC): __date__ (2006-01-02), __time__ (15:04) and
+// __weekday__ (Monday).
+func builtinVars(now time.Time) map[string]string {
+	now = now.UTC()
+	return map[string]string{
+		"__date__":    now.Format(time.DateOnly),
+		"__time__":    now.Format("15:04"),
+		"__weekday__": now.Weekday().String(),
+	}
+}
+
+// jobVars returns the variables describing job itself: Now (the moment now in
+// UTC, a time.Time, so "{{.Now.Format "Jan 2"}}" works), UserID and JobName.
+func jobVars(job Job, now time.Time) map[string]any {
+	return map[string]any{
+		"Now":     now.UTC(),
+		"UserID":  job.UserID,
+		"JobName": job.Name,
+	}
+}
+
+// renderPrompt executes tmpl as a text/template with vars as its data, e.g.
+// "Briefing for {{.__date__}}". Referencing a variable that is not in vars is
+// an error rather than an empty string. Values are inserted as plain text:
+// template syntax inside a value is not evaluated.
+func renderPrompt(tmpl string, vars map[string]any) (string, error) {
+	t, err := template.New("prompt").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("parse prompt template: %w", err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("render prompt template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// jobPrompt renders job.Prompt with the job's TemplateVars, the built-in
+// variables for now and the job's own variables (see jobVars); the built-ins
+// take precedence. Prompts without "{{" are returned unchanged.
+func jobPrompt(job Job, now time.Time) (string, error) {
+	if !strings.Contains(job.Prompt, "{{") {
+		return job.Prompt, nil
+	}
+	vars := make(map[string]any, len(job.TemplateVars)+6)
+	for k, v := range job.TemplateVars {
+		vars[k] = v
+	}
+	for k, v := range builtinVars(now) {
+		vars[k] = v
+	}
+	for k, v := range jobVars(job, now) {
+		vars[k] = v
+	}
+	return renderPrompt(job.Prompt, vars)
+}
@@ -0,0 +1,56 @@
+package scheduler
+
+import (
+	"math"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter decides whether a user's job may execute now. A denied execution
+// is recorded as "rate_limited" and not retried; the job fires again on its
+// next schedule.
+type RateLimiter interface {
+	Allow(userID string) bool
+}
+
+// TokenBucketRateLimiter gives every user their own token bucket, so one user
+// with many jobs firing at the same minute cannot monopolise the LLM. Buckets
+// are per instance and created on a user's first execution.
+type TokenBucketRateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter
+}
+
+// NewTokenBucketRateLimiter allows each user rps executions per second on
+// average, with bursts of up to max(1, rps) executions.
+func NewTokenBucketRateLimiter(rps float64) *TokenBucketRateLimiter {
+	return &TokenBucketRateLimiter{
+		limit:   rate.Limit(rps),
+		burst:   int(math.Max(1, rps)),
+		buckets: make(map[string]*rate.Limiter),
+	}
+}
+
+// Allow implements RateLimiter.
+func (l *TokenBucketRateLimiter) Allow(userID string) bool {
+	l.mu.Lock()
+	b, ok := l.buckets[userID]
+	if !ok {
+		b = rate.NewLimiter(l.limit, l.burst)
+		l.buckets[userID] = b
+	}
+	l.mu.Unlock()
+	return b.Allow()
+}
+
+// WithRateLimiter makes every execution ask rl for permission first, after
+// the firing has been claimed (see claimFiring) so only the instance that
+// runs it spends a token. nil (the default) disables rate limiting.
+func (s *Scheduler) WithRateLimiter(rl RateLimiter) *Scheduler {
+	s.rateLimiter = rl
+	return s
+}
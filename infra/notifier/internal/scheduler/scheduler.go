@@ -2,24 +2,58 @@ package scheduler
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/rand/v2"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
 	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/allerac/notifier/internal/channels"
 	"github.com/allerac/notifier/internal/publisher"
+	"github.com/allerac/notifier/internal/runner"
 )
 
+var (
+	// ErrJobNotFound is returned by TriggerNow for a job that does not exist,
+	// is disabled or is paused, and by PauseJob and ResumeJob for a job that
+	// does not exist or is disabled (deleted, or a one-off job that has fired).
+	ErrJobNotFound = errors.New("job not found or disabled")
+	// ErrJobAlreadyRunning is returned by TriggerNow while the job is executing.
+	ErrJobAlreadyRunning = errors.New("job is already running")
+	// ErrJobNotSchedulable wraps the registration error ResumeJob got for an
+	// enabled job it could not schedule.
+	ErrJobNotSchedulable = errors.New("job cannot be scheduled")
+)
+
+var tracer = otel.Tracer("github.com/allerac/notifier/internal/scheduler")
+
 const (
 	maxRunnerAttempts  = 3
 	defaultRetryDelay  = 5 * time.Second
 	watchReconnectWait = 5 * time.Second
+
+	// defaultStopTimeout covers a job with the default retry policy and a
+	// slow LLM; see WithStopTimeout.
+	defaultStopTimeout = 150 * time.Second
+	// shutdownUpdateTimeout bounds marking interrupted executions on Stop.
+	shutdownUpdateTimeout = 5 * time.Second
 )
 
 // DBPool is the subset of pgxpool.Pool used by the Scheduler.
@@ -34,31 +68,120 @@ type Runner interface {
 	Run(ctx context.Context, userID, jobID, prompt string) (string, error)
 }
 
-// NotificationPublisher sends a notification to a delivery channel.
+// SystemPromptRunner is implemented by runners that accept a separate system
+// message. Jobs with a SystemPrompt use it when the configured runner supports it.
+type SystemPromptRunner interface {
+	RunWithContext(ctx context.Context, userID, systemPrompt, userPrompt string) (string, error)
+}
+
+// StreamingRunner is implemented by runners that can return the reply token by
+// token. Jobs with UseStreaming use it when the configured runner supports it.
+type StreamingRunner interface {
+	RunStream(ctx context.Context, userID, prompt string) (<-chan string, <-chan error)
+}
+
+// NotificationPublisher sends notifications to their delivery channels, all
+// of one execution in a single call.
 type NotificationPublisher interface {
-	Publish(ctx context.Context, n publisher.Notification) error
+	PublishBatch(ctx context.Context, ns []publisher.Notification) error
 }
 
 // Job represents a scheduled prompt job.
 type Job struct {
-	ID       string
-	UserID   string
-	Name     string
-	CronExpr string
-	Prompt   string
-	Channels []string
+	ID           string
+	UserID       string
+	Name         string
+	CronExpr     string
+	Prompt       string
+	SystemPrompt string // optional persona/context sent as a system message
+	Channels     []string
+	Format       publisher.Format // markup of the LLM output, for channels that render it
+	Timezone     string           // IANA zone CronExpr is evaluated in; empty = server local time
+
+	// ExecutionTimeout bounds the LLM call(s) of one execution, retries
+	// included; 0 means no job-level limit.
+	ExecutionTimeout time.Duration
+	// MaxAttempts and RetryDelay override the runner retry policy for this
+	// job; zero keeps the scheduler defaults.
+	MaxAttempts int
+	RetryDelay  time.Duration
+	// DeduplicationWindow suppresses a result identical to one already
+	// published for the job within the window; 0 disables the check.
+	DeduplicationWindow time.Duration
+	// DeliveryDelay holds the job's notifications back this long after the
+	// result is ready (Notification.DeliverAfter), e.g. generate at 02:00 and
+	// deliver at 08:00; 0 delivers right away.
+	DeliveryDelay time.Duration
+	// RunAt makes the job a one-off: it fires once at this time and CronExpr
+	// is ignored. Zero means a recurring job.
+	RunAt time.Time
+	// CatchUpWindow makes RegisterJob execute the job once for every firing
+	// it missed within this long before registration (and after LastRunAt),
+	// e.g. while the notifier was down; 0 skips missed firings.
+	CatchUpWindow time.Duration
+	// LastRunAt is when the job last completed, as loaded from the database;
+	// zero if it never has. Only catch-up uses it.
+	LastRunAt time.Time
+	// TemplateVars are the user variables available to a templated Prompt
+	// (see renderPrompt), next to the built-in __date__, __time__ and __weekday__.
+	TemplateVars map[string]string
+	// Metadata is free-form operator data (e.g. {"report_type": "weekly"}).
+	// The scheduler does not interpret it; it travels with every notification
+	// of the job for consumers and audit logs.
+	Metadata map[string]json.RawMessage
+	// UseStreaming asks the runner for a streamed reply (see StreamingRunner),
+	// which keeps a long generation from hitting the LLM client's response
+	// timeout. The notification still carries the complete output.
+	UseStreaming bool
+	// MaxPromptChars caps the rendered prompt for this job in place of the
+	// runner's limit (see runner.LimitPrompt); 0 keeps the runner's. A longer
+	// prompt fails the execution without retries.
+	MaxPromptChars int
+	// Priority is given to every notification of the job; publisher.PriorityHigh
+	// and above are delivered ahead of the normal backlog, e.g. for alerts.
+	Priority publisher.Priority
+	// Recipients, when set, receive the job's result instead of UserID, who
+	// only owns the job: the LLM runs once and every recipient gets the same
+	// notifications (see resolveRecipients).
+	Recipients []string
+}
+
+// MustMetadata returns the raw JSON value stored under key in the job's
+// Metadata. It panics if the key is missing, so use it only for keys the job
+// is known to carry.
+func (j Job) MustMetadata(key string) json.RawMessage {
+	v, ok := j.Metadata[key]
+	if !ok {
+		panic(fmt.Sprintf("scheduler: job %s has no metadata %q", j.ID, key))
+	}
+	return v
 }
 
 // Scheduler loads jobs from PostgreSQL and executes them on cron schedule.
 type Scheduler struct {
-	db         DBPool
-	cron       *cron.Cron
-	runner     Runner
-	publisher  NotificationPublisher
-	retryDelay time.Duration
+	db          DBPool
+	cron        *cron.Cron
+	runner      Runner
+	publisher   NotificationPublisher
+	retryDelay  time.Duration
+	maxJitter   time.Duration // random extra retry wait stays below this; < 0 = half the job's retry delay
+	limits      channels.Limits
+	seconds     bool          // cron expressions carry a leading seconds field
+	redis       *redis.Client // optional; deduplication is skipped without it
+	slots       chan struct{} // bounds concurrent executions; nil = unlimited
+	rateLimiter RateLimiter   // per-user execution budget; nil = unlimited
+	metrics     metrics
 
 	mu      sync.Mutex
 	entries map[string]cron.EntryID // job.ID → cron entry
+
+	inflight    sync.WaitGroup // ExecuteJob calls in progress
+	running     sync.Map       // job.ID → struct{} for jobs executing on this instance
+	executions  sync.Map       // execution ID → struct{} for job_executions rows still "running"
+	stopTimeout time.Duration  // how long Stop waits for running executions
+	started     atomic.Bool    // between Start and Stop; see Ping
+
+	logger *slog.Logger
 }
 
 // New creates a Scheduler with default settings.
@@ -69,8 +192,60 @@ func New(db DBPool, r Runner, p NotificationPublisher) *Scheduler {
 		runner:     r,
 		publisher:  p,
 		retryDelay: defaultRetryDelay,
+		maxJitter:  -1,
+		limits:     channels.DefaultLimits(),
 		entries:    make(map[string]cron.EntryID),
+		metrics:    newMetrics(),
+		logger:     slog.Default().With(slog.String("component", "scheduler")),
+
+		stopTimeout: defaultStopTimeout,
+	}
+}
+
+// WithLogger sets the logger used by the scheduler (default slog.Default()).
+func (s *Scheduler) WithLogger(l *slog.Logger) *Scheduler {
+	s.logger = l.With(slog.String("component", "scheduler"))
+	return s
+}
+
+// WithStopTimeout sets how long Stop waits for running executions before
+// marking them failed (default 150s). It should leave room for a job's full
+// retry policy, or SIGTERM cuts off jobs that would have finished.
+func (s *Scheduler) WithStopTimeout(timeout time.Duration) *Scheduler {
+	s.stopTimeout = timeout
+	return s
+}
+
+// WithSeconds switches the cron parser to six fields, the first being seconds
+// (e.g. "*/30 * * * * *" for every 30 seconds). The mode applies to every job,
+// so five-field expressions are rejected once it is enabled. It must be called
+// before Start.
+func (s *Scheduler) WithSeconds(enabled bool) *Scheduler {
+	s.seconds = enabled
+	if enabled {
+		s.cron = cron.New(cron.WithSeconds())
+	} else {
+		s.cron = cron.New()
 	}
+	return s
+}
+
+// WithRedis gives the scheduler a Redis client for result deduplication (see
+// Job.DeduplicationWindow).
+func (s *Scheduler) WithRedis(c *redis.Client) *Scheduler {
+	s.redis = c
+	return s
+}
+
+// WithMaxConcurrent caps how many jobs this instance executes at once; n <= 0
+// removes the cap. Executions over the cap wait for a free slot rather than
+// being skipped. Must be called before Start.
+func (s *Scheduler) WithMaxConcurrent(n int) *Scheduler {
+	s.slots = nil
+	if n > 0 {
+		s.slots = make(chan struct{}, n)
+	}
+	return s
 }
 
 // WithRetryDelay overrides the base delay between runner retry attempts.
@@ -80,6 +255,22 @@ func (s *Scheduler) WithRetryDelay(d time.Duration) *Scheduler {
 	return s
 }
 
+// WithJitter sets the upper bound of the random wait added to every retry
+// delay, so jobs that failed together (e.g. the LLM backend restarting) do not
+// all retry at the same instant. The default is half the job's retry delay;
+// 0 disables jitter.
+func (s *Scheduler) WithJitter(d time.Duration) *Scheduler {
+	s.maxJitter = max(d, 0)
+	return s
+}
+
+// WithChannelLimits overrides the per-channel content length limits applied
+// when fanning a result out to the job's channels.
+func (s *Scheduler) WithChannelLimits(l channels.Limits) *Scheduler {
+	s.limits = l
+	return s
+}
+
 // Start loads all enabled jobs from the database and begins the cron scheduler.
 func (s *Scheduler) Start(ctx context.Context) error {
 	jobs, err := s.LoadJobs(ctx)
@@ -88,45 +279,430 @@ func (s *Scheduler) Start(ctx context.Context) error {
 	}
 	for _, job := range jobs {
 		if err := s.RegisterJob(ctx, job); err != nil {
-			log.Printf("[scheduler] Skipping job %q: %v", job.Name, err)
+			s.logger.Warn("skipping job", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Any("error", err))
 		}
 	}
 	s.cron.Start()
-	log.Printf("[scheduler] Started with %d jobs", len(jobs))
+	s.started.Store(true)
+	s.logger.Info("scheduler started", slog.Int("jobs", len(jobs)))
+	return nil
+}
+
+// Ping reports whether the scheduler is firing jobs, i.e. Start succeeded and
+// Stop has not been called; it has the signature of a health check.
+func (s *Scheduler) Ping(context.Context) error {
+	if !s.started.Load() {
+		return errors.New("scheduler not running")
+	}
 	return nil
 }
 
-// Stop halts the cron scheduler.
-func (s *Scheduler) Stop() {
-	s.cron.Stop()
+// Stop halts the cron scheduler so no new jobs fire, then waits for executions
+// already in progress to finish (and publish their results) until ctx expires
+// or the stop timeout elapses. Executions still running then are marked
+// failed with result "shutdown", so they are not left "running" forever.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.started.Store(false)
+	cronDone := s.cron.Stop() // done once running cron callbacks have returned
+	ctx, cancel := context.WithTimeout(ctx, s.stopTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		<-cronDone.Done()
+		s.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.failRunningExecutions()
+		return fmt.Errorf("waiting for running jobs: %w", ctx.Err())
+	}
+}
+
+// failRunningExecutions marks the executions still in progress as failed by
+// shutdown. Their goroutines may yet finish and overwrite the status if the
+// process lives long enough.
+func (s *Scheduler) failRunningExecutions() {
+	var ids []string
+	s.executions.Range(func(id, _ any) bool {
+		ids = append(ids, id.(string))
+		return true
+	})
+	if len(ids) == 0 {
+		return
+	}
+	// Stop's ctx has expired; the update gets a short budget of its own.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownUpdateTimeout)
+	defer cancel()
+	tag, err := s.db.Exec(ctx, `
+		UPDATE job_executions
+		SET status = 'failed', result = 'shutdown', completed_at = $1
+		WHERE id = ANY($2) AND status = 'running'
+	`, time.Now(), ids)
+	if err != nil {
+		s.logger.Error("failed to mark interrupted executions", slog.Int("count", len(ids)), slog.Any("error", err))
+		return
+	}
+	s.metrics.executions.WithLabelValues("failed").Add(float64(tag.RowsAffected()))
+	s.logger.Warn("executions interrupted by shutdown", slog.Any("execution_ids", ids))
+}
+
+// RegisterJob adds a single job to the live cron scheduler. The outcome is
+// persisted to scheduled_jobs.registration_error so failures stay visible.
+// A job with a CatchUpWindow is then executed in the background once for each
+// firing it missed (see missedFirings), one execution after the other.
+func (s *Scheduler) RegisterJob(ctx context.Context, job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.registerLocked(job)
+	s.recordRegistration(ctx, job.ID, err)
+	if err != nil {
+		return err
+	}
+	if missed := s.missedFiringsLocked(job, time.Now()); len(missed) > 0 {
+		s.logger.Info("catching up on missed firings", slog.String("job_id", job.ID), slog.String("job_name", job.Name),
+			slog.Int("missed", len(missed)), slog.Time("first", missed[0]))
+		s.inflight.Add(1)
+		go func() {
+			defer s.inflight.Done()
+			for _, at := range missed {
+				s.fire(context.Background(), job, at)
+			}
+		}()
+	}
+	return nil
 }
 
-// RegisterJob adds a single job to the live cron scheduler.
-func (s *Scheduler) RegisterJob(_ context.Context, job Job) error {
+// missedFiringsLocked returns the times the registered job's schedule fired
+// in the CatchUpWindow before now, leaving out those up to its LastRunAt.
+// One-off jobs have no missed firings: a past RunAt fires on registration.
+// The caller must hold s.mu.
+func (s *Scheduler) missedFiringsLocked(job Job, now time.Time) []time.Time {
+	entryID, ok := s.entries[job.ID]
+	if job.CatchUpWindow <= 0 || !job.RunAt.IsZero() || !ok {
+		return nil
+	}
+	schedule := s.cron.Entry(entryID).Schedule
+	since := now.Add(-job.CatchUpWindow)
+	if job.LastRunAt.After(since) {
+		since = job.LastRunAt
+	}
+	var missed []time.Time
+	for t := schedule.Next(since); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+		missed = append(missed, t)
+	}
+	return missed
+}
+
+// RegisterOnce schedules job to fire exactly once, at at, instead of on its
+// cron expression. A time in the past fires immediately. When it fires, the
+// job is disabled in the database before it executes, so it does not fire
+// again after a restart, on another instance or when re-registered.
+func (s *Scheduler) RegisterOnce(ctx context.Context, job Job, at time.Time) error {
+	job.RunAt = at
+	return s.RegisterJob(ctx, job)
+}
+
+// recordRegistration stores (or clears, when err is nil) the job's registration
+// error, along with its next fire time. The write is skipped when both values
+// are unchanged so the resulting NOTIFY does not loop back into SyncJob
+// forever. The caller must hold s.mu.
+func (s *Scheduler) recordRegistration(ctx context.Context, jobID string, err error) {
+	var regErr *string
+	if err != nil {
+		msg := err.Error()
+		regErr = &msg
+	}
+	var nextRun *time.Time
+	if next, ok := s.nextRunLocked(jobID); ok {
+		nextRun = &next
+	}
+	if _, dbErr := s.db.Exec(ctx, `
+		UPDATE scheduled_jobs
+		SET registration_error = $2, next_run_at = $3
+		WHERE id = $1 AND (registration_error IS DISTINCT FROM $2 OR next_run_at IS DISTINCT FROM $3)
+	`, jobID, regErr, nextRun); dbErr != nil {
+		s.logger.Error("failed to record registration status", slog.String("job_id", jobID), slog.Any("error", dbErr))
+	}
+}
+
+// recordNextRun stores the job's next fire time after an execution (NULL once
+// a one-off job has fired).
+func (s *Scheduler) recordNextRun(ctx context.Context, jobID string) {
+	var nextRun *time.Time
+	if next, ok := s.NextRun(jobID); ok {
+		nextRun = &next
+	}
+	if _, err := s.db.Exec(ctx, `
+		UPDATE scheduled_jobs
+		SET next_run_at = $2
+		WHERE id = $1 AND next_run_at IS DISTINCT FROM $2
+	`, jobID, nextRun); err != nil {
+		s.logger.Error("failed to record next run", slog.String("job_id", jobID), slog.Any("error", err))
+	}
+}
+
+// NextRun returns when the job fires next, in the zone of its schedule. ok is
+// false for a job that is not registered or will not fire again.
+func (s *Scheduler) NextRun(jobID string) (next time.Time, ok bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.registerLocked(job)
+	return s.nextRunLocked(jobID)
+}
+
+func (s *Scheduler) nextRunLocked(jobID string) (time.Time, bool) {
+	entryID, ok := s.entries[jobID]
+	if !ok {
+		return time.Time{}, false
+	}
+	entry := s.cron.Entry(entryID)
+	if !entry.Valid() {
+		return time.Time{}, false
+	}
+	next := entry.Next
+	if next.IsZero() {
+		// Entries get their Next once the cron is started.
+		next = entry.Schedule.Next(time.Now())
+	}
+	return next, !next.IsZero()
+}
+
+// JobStatus is a job's scheduling state as reported by the admin API.
+type JobStatus struct {
+	ID                string     `json:"id"`
+	UserID            string     `json:"user_id"`
+	Name              string     `json:"name"`
+	CronExpr          string     `json:"cron_expr"`
+	Enabled           bool       `json:"enabled"`
+	Registered        bool       `json:"registered"`
+	RegistrationError string     `json:"registration_error,omitempty"`
+	LastRunAt         *time.Time `json:"last_run_at,omitempty"`
+	NextRunAt         *time.Time `json:"next_run_at,omitempty"`
+}
+
+// ListJobs returns every job in the database along with whether it is
+// currently registered in the cron and the last recorded registration error.
+func (s *Scheduler) ListJobs(ctx context.Context) ([]JobStatus, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, user_id, name, cron_expr, enabled, COALESCE(registration_error, ''), last_run_at
+		FROM scheduled_jobs
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []JobStatus
+	for rows.Next() {
+		var j JobStatus
+		if err := rows.Scan(&j.ID, &j.UserID, &j.Name, &j.CronExpr, &j.Enabled,
+			&j.RegistrationError, &j.LastRunAt); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	for i := range jobs {
+		_, jobs[i].Registered = s.entries[jobs[i].ID]
+		if next, ok := s.nextRunLocked(jobs[i].ID); ok {
+			jobs[i].NextRunAt = &next
+		}
+	}
+	s.mu.Unlock()
+	return jobs, nil
+}
+
+// NextRunTimes returns, for every registered job, its next n fire times keyed
+// by job name. Jobs sharing a name are disambiguated with their ID.
+func (s *Scheduler) NextRunTimes(n int) map[string][]time.Time {
+	now := time.Now()
+	out := make(map[string][]time.Time)
+	for _, entry := range s.cron.Entries() {
+		cj, ok := entry.Job.(cronJob)
+		if !ok {
+			continue
+		}
+		times := make([]time.Time, 0, n)
+		next := now
+		for i := 0; i < n; i++ {
+			next = entry.Schedule.Next(next)
+			if next.IsZero() {
+				break // schedule never fires again
+			}
+			times = append(times, next)
+		}
+		key := cj.job.Name
+		if _, dup := out[key]; dup {
+			key = fmt.Sprintf("%s (%s)", cj.job.Name, cj.job.ID)
+		}
+		out[key] = times
+	}
+	return out
+}
+
+// cronSpec returns the job's cron expression with its time zone applied via the
+// CRON_TZ= prefix understood by robfig/cron. Expressions that already carry a
+// zone are left alone.
+func cronSpec(job Job) string {
+	if job.Timezone == "" || strings.HasPrefix(job.CronExpr, "CRON_TZ=") || strings.HasPrefix(job.CronExpr, "TZ=") {
+		return job.CronExpr
+	}
+	return "CRON_TZ=" + job.Timezone + " " + job.CronExpr
+}
+
+// cronJob is the cron.Job registered for a scheduled job. Keeping the Job on
+// the cron entry lets NextRunTimes report schedules by job name.
+type cronJob struct {
+	s   *Scheduler
+	job Job
+}
+
+func (c cronJob) Run() {
+	if !c.job.RunAt.IsZero() {
+		c.s.runOnce(context.Background(), c.job)
+		return
+	}
+	c.s.fire(context.Background(), c.job, c.s.firingTime(c.job.ID, time.Now()))
+}
+
+// onceSchedule is the cron.Schedule of a one-off job: a single activation at
+// at, or none once at has passed.
+type onceSchedule struct {
+	at time.Time
+}
+
+func (o onceSchedule) Next(t time.Time) time.Time {
+	if t.Before(o.at) {
+		return o.at
+	}
+	return time.Time{}
+}
+
+// checkFieldCount reports an expression whose field count does not match the
+// cron parser mode, which robfig/cron would otherwise reject with a terse
+// "expected exactly 5 fields" (or silently misread, for some six-field specs).
+// Descriptors such as "@hourly" are valid in both modes.
+func (s *Scheduler) checkFieldCount(expr string) error {
+	fields := strings.Fields(expr)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		return nil
+	}
+	want, mode := 5, "minute"
+	if s.seconds {
+		want, mode = 6, "seconds"
+	}
+	if len(fields) != want {
+		return fmt.Errorf("invalid cron expr %q: has %d fields, expected %d (%s precision; see NOTIFIER_CRON_SECONDS)",
+			expr, len(fields), want, mode)
+	}
+	return nil
 }
 
 func (s *Scheduler) registerLocked(job Job) error {
-	entryID, err := s.cron.AddFunc(job.CronExpr, func() {
-		s.ExecuteJob(context.Background(), job)
-	})
+	if !job.RunAt.IsZero() {
+		return s.registerOnceLocked(job)
+	}
+	if err := s.checkFieldCount(job.CronExpr); err != nil {
+		return err
+	}
+	spec := cronSpec(job)
+	entryID, err := s.cron.AddJob(spec, cronJob{s: s, job: job})
 	if err != nil {
-		return fmt.Errorf("invalid cron expr %q: %w", job.CronExpr, err)
+		return fmt.Errorf("invalid cron expr %q: %w", spec, err)
 	}
 	s.entries[job.ID] = entryID
-	log.Printf("[scheduler] Registered job: %q (%s)", job.Name, job.CronExpr)
+	s.logger.Info("job registered", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.String("cron", spec))
+	return nil
+}
+
+// registerOnceLocked adds a one-off job. It still gets a cron entry, so
+// reloads, NextRunTimes and removal treat it like any other job; a job that is
+// already due is started right away as its entry would never fire.
+func (s *Scheduler) registerOnceLocked(job Job) error {
+	cj := cronJob{s: s, job: job}
+	s.entries[job.ID] = s.cron.Schedule(onceSchedule{at: job.RunAt}, cj)
+	s.logger.Info("one-off job registered", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Time("run_at", job.RunAt))
+	if !job.RunAt.After(time.Now()) {
+		s.inflight.Add(1)
+		go func() {
+			defer s.inflight.Done()
+			cj.Run()
+		}()
+	}
 	return nil
 }
 
-// LoadJobs fetches all enabled jobs from the database.
+// runOnce fires a one-off job. The job is claimed by disabling it first: only
+// the firing that flips enabled executes it, and the resulting NOTIFY removes
+// the entry everywhere. A crash during the execution therefore loses the run
+// rather than repeating it.
+func (s *Scheduler) runOnce(ctx context.Context, job Job) {
+	var id string
+	err := s.db.QueryRow(ctx, `
+		UPDATE scheduled_jobs
+		SET enabled = false
+		WHERE id = $1 AND enabled = true
+		RETURNING id
+	`, job.ID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.logger.Info("one-off job already fired or disabled, skipping", slog.String("job_id", job.ID), slog.String("job_name", job.Name))
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to claim one-off job", slog.String("job_id", job.ID), slog.Any("error", err))
+		return
+	}
+	s.ExecuteJob(ctx, job)
+}
+
+// jobSelect selects the columns scanJob reads from scheduled_jobs, ready for
+// a WHERE clause.
+const jobSelect = `
+	SELECT id, user_id, name, COALESCE(cron_expr, ''), prompt, COALESCE(system_prompt, ''), channels, COALESCE(message_format, ''), COALESCE(timezone, ''), COALESCE(timeout_seconds, 0), run_at, COALESCE(max_attempts, 0), COALESCE(retry_delay_seconds, 0), COALESCE(dedup_window_seconds, 0), COALESCE(template_vars, '{}'), COALESCE(metadata, '{}'), COALESCE(delivery_delay_seconds, 0), COALESCE(catch_up_window_seconds, 0), last_run_at, COALESCE(use_streaming, false), COALESCE(max_prompt_chars, 0), COALESCE(priority, 0), COALESCE(recipient_user_ids::text[], '{}')
+	FROM scheduled_jobs
+`
+
+// scanJob reads a job selected with jobSelect.
+func scanJob(row pgx.Row) (Job, error) {
+	var j Job
+	var timeoutSeconds int
+	var runAt *time.Time
+	var lastRunAt *time.Time
+	var retryDelaySeconds, dedupWindowSeconds, deliveryDelaySeconds, catchUpSeconds, priority int
+	if err := row.Scan(&j.ID, &j.UserID, &j.Name, &j.CronExpr, &j.Prompt, &j.SystemPrompt, &j.Channels, &j.Format, &j.Timezone,
+		&timeoutSeconds, &runAt, &j.MaxAttempts, &retryDelaySeconds, &dedupWindowSeconds, &j.TemplateVars, &j.Metadata, &deliveryDelaySeconds,
+		&catchUpSeconds, &lastRunAt, &j.UseStreaming, &j.MaxPromptChars, &priority, &j.Recipients); err != nil {
+		return Job{}, err
+	}
+	j.ExecutionTimeout = time.Duration(timeoutSeconds) * time.Second
+	j.RetryDelay = time.Duration(retryDelaySeconds) * time.Second
+	j.DeduplicationWindow = time.Duration(dedupWindowSeconds) * time.Second
+	j.DeliveryDelay = time.Duration(deliveryDelaySeconds) * time.Second
+	j.CatchUpWindow = time.Duration(catchUpSeconds) * time.Second
+	j.Priority = publisher.Priority(priority)
+	if runAt != nil {
+		j.RunAt = *runAt
+	}
+	if lastRunAt != nil {
+		j.LastRunAt = *lastRunAt
+	}
+	return j, nil
+}
+
+// LoadJobs fetches all enabled, unpaused jobs from the database.
 func (s *Scheduler) LoadJobs(ctx context.Context) ([]Job, error) {
-	rows, err := s.db.Query(ctx, `
-		SELECT id, user_id, name, cron_expr, prompt, channels
-		FROM scheduled_jobs
-		WHERE enabled = true
-	`)
+	rows, err := s.db.Query(ctx, jobSelect+`WHERE enabled = true AND NOT paused`)
 	if err != nil {
 		return nil, err
 	}
@@ -134,8 +710,8 @@ func (s *Scheduler) LoadJobs(ctx context.Context) ([]Job, error) {
 
 	var jobs []Job
 	for rows.Next() {
-		var j Job
-		if err := rows.Scan(&j.ID, &j.UserID, &j.Name, &j.CronExpr, &j.Prompt, &j.Channels); err != nil {
+		j, err := scanJob(rows)
+		if err != nil {
 			return nil, err
 		}
 		jobs = append(jobs, j)
@@ -145,12 +721,7 @@ func (s *Scheduler) LoadJobs(ctx context.Context) ([]Job, error) {
 
 // loadJob fetches a single enabled job by ID. Returns nil if not found or disabled.
 func (s *Scheduler) loadJob(ctx context.Context, jobID string) (*Job, error) {
-	var j Job
-	err := s.db.QueryRow(ctx, `
-		SELECT id, user_id, name, cron_expr, prompt, channels
-		FROM scheduled_jobs
-		WHERE id = $1 AND enabled = true
-	`, jobID).Scan(&j.ID, &j.UserID, &j.Name, &j.CronExpr, &j.Prompt, &j.Channels)
+	j, err := scanJob(s.db.QueryRow(ctx, jobSelect+`WHERE id = $1 AND enabled = true AND NOT paused`, jobID))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil // disabled or deleted
@@ -167,32 +738,189 @@ func (s *Scheduler) SyncJob(ctx context.Context, jobID, action string) {
 	defer s.mu.Unlock()
 
 	// Always remove any existing cron entry for this job.
-	if entryID, ok := s.entries[jobID]; ok {
-		s.cron.Remove(entryID)
-		delete(s.entries, jobID)
-	}
+	s.removeLocked(jobID)
 
 	if action == "delete" {
-		log.Printf("[scheduler] Job %s removed (deleted)", jobID)
+		s.logger.Info("job removed", slog.String("job_id", jobID), slog.String("reason", "deleted"))
 		return
 	}
 
 	// Fetch fresh state from DB (returns nil if disabled or not found).
 	job, err := s.loadJob(ctx, jobID)
 	if err != nil {
-		log.Printf("[scheduler] Failed to reload job %s: %v", jobID, err)
+		s.logger.Error("failed to reload job", slog.String("job_id", jobID), slog.Any("error", err))
 		return
 	}
 	if job == nil {
-		log.Printf("[scheduler] Job %s not scheduled (disabled or not found)", jobID)
+		s.logger.Info("job not scheduled", slog.String("job_id", jobID), slog.String("reason", "disabled or not found"))
 		return
 	}
 
-	if err := s.registerLocked(*job); err != nil {
-		log.Printf("[scheduler] Failed to re-register job %q: %v", job.Name, err)
+	err = s.registerLocked(*job)
+	s.recordRegistration(ctx, job.ID, err)
+	if err != nil {
+		s.logger.Error("failed to re-register job", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Any("error", err))
 		return
 	}
-	log.Printf("[scheduler] Live-reloaded job %q (%s)", job.Name, job.CronExpr)
+	s.logger.Info("job live-reloaded", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.String("cron", job.CronExpr))
+}
+
+// RemoveJob unschedules a job on this instance without touching the database.
+// It reports whether the job was registered. Executions already running are
+// not interrupted.
+func (s *Scheduler) RemoveJob(jobID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removeLocked(jobID)
+}
+
+// removeLocked is RemoveJob for callers holding s.mu.
+func (s *Scheduler) removeLocked(jobID string) bool {
+	entryID, ok := s.entries[jobID]
+	if !ok {
+		return false
+	}
+	s.cron.Remove(entryID)
+	delete(s.entries, jobID)
+	return true
+}
+
+// PauseJob unschedules a job until ResumeJob. The job is marked paused in the
+// database, so other instances drop it too (via NOTIFY or the periodic Reload)
+// and it stays paused across restarts. Pausing a paused job is a no-op; an
+// unknown or disabled job returns ErrJobNotFound.
+func (s *Scheduler) PauseJob(ctx context.Context, jobID string) error {
+	if err := s.setPaused(ctx, jobID, true); err != nil {
+		return err
+	}
+	if s.RemoveJob(jobID) {
+		s.logger.Info("job paused", slog.String("job_id", jobID))
+	}
+	return nil
+}
+
+// ResumeJob unpauses a job and registers it from its current definition. A
+// one-off job paused before its run_at fires right away if run_at has passed
+// meanwhile; one that has already fired is disabled and, like an unknown or
+// deleted job, returns ErrJobNotFound. A job that cannot be scheduled (e.g.
+// an invalid cron expression) stays unpaused, its registration error is
+// recorded as for any other registration and returned wrapped in
+// ErrJobNotSchedulable.
+func (s *Scheduler) ResumeJob(ctx context.Context, jobID string) error {
+	if err := s.setPaused(ctx, jobID, false); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, err := s.loadJob(ctx, jobID)
+	if err != nil {
+		return fmt.Errorf("loading job: %w", err)
+	}
+	if job == nil {
+		return ErrJobNotFound // deleted in the meantime
+	}
+	s.removeLocked(jobID)
+	err = s.registerLocked(*job)
+	s.recordRegistration(ctx, job.ID, err)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrJobNotSchedulable, err)
+	}
+	s.logger.Info("job resumed", slog.String("job_id", job.ID), slog.String("job_name", job.Name))
+	return nil
+}
+
+// setPaused writes scheduled_jobs.paused, returning ErrJobNotFound if the job
+// does not exist or is disabled. Pausing is kept apart from enabled, which
+// also marks deleted jobs and fired one-off jobs, so resuming never brings
+// those back.
+func (s *Scheduler) setPaused(ctx context.Context, jobID string, paused bool) error {
+	var id string
+	err := s.db.QueryRow(ctx,
+		`UPDATE scheduled_jobs SET paused = $2 WHERE id = $1 AND enabled = true RETURNING id`,
+		jobID, paused,
+	).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrJobNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("updating job: %w", err)
+	}
+	return nil
+}
+
+// Reload reconciles the registered cron entries with the enabled jobs in the
+// database: entries of deleted or disabled jobs are removed, new jobs are
+// registered and jobs whose definition changed (e.g. a new cron expression)
+// are re-registered. Executions already running are not interrupted; they
+// finish with the definition they started with.
+func (s *Scheduler) Reload(ctx context.Context) error {
+	jobs, err := s.LoadJobs(ctx)
+	if err != nil {
+		return fmt.Errorf("loading jobs: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enabled := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		enabled[job.ID] = true
+	}
+	var removed, registered int
+	for jobID, entryID := range s.entries {
+		if !enabled[jobID] {
+			s.cron.Remove(entryID)
+			delete(s.entries, jobID)
+			removed++
+		}
+	}
+	for _, job := range jobs {
+		if entryID, ok := s.entries[job.ID]; ok {
+			if current, ok := s.cron.Entry(entryID).Job.(cronJob); ok && sameDefinition(current.job, job) {
+				continue
+			}
+			s.cron.Remove(entryID)
+			delete(s.entries, job.ID)
+		}
+		err := s.registerLocked(job)
+		s.recordRegistration(ctx, job.ID, err)
+		if err != nil {
+			s.logger.Warn("skipping job", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Any("error", err))
+			continue
+		}
+		registered++
+	}
+	if removed > 0 || registered > 0 {
+		s.logger.Info("jobs reloaded", slog.Int("registered", registered), slog.Int("removed", removed))
+	}
+	return nil
+}
+
+// sameDefinition reports whether a and b schedule and execute the same way.
+// LastRunAt is left out: it changes with every run.
+func sameDefinition(a, b Job) bool {
+	a.LastRunAt = b.LastRunAt
+	return reflect.DeepEqual(a, b)
+}
+
+// ReloadLoop calls Reload every interval until ctx is cancelled. It is a
+// safety net for changes whose NOTIFY was missed (e.g. while the LISTEN
+// connection was down); Watch remains the primary live-reload path.
+func (s *Scheduler) ReloadLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(ctx); err != nil {
+				s.logger.Error("periodic reload failed", slog.Any("error", err))
+			}
+		}
+	}
 }
 
 // Watch listens for PostgreSQL NOTIFY on the 'scheduled_jobs_changed' channel
@@ -204,8 +932,8 @@ func (s *Scheduler) Watch(ctx context.Context, dbURL string) {
 			if ctx.Err() != nil {
 				return // context cancelled — clean shutdown
 			}
-			log.Printf("[scheduler] LISTEN connection lost: %v — reconnecting in %s",
-				err, watchReconnectWait)
+			s.logger.Warn("LISTEN connection lost, reconnecting",
+				slog.Any("error", err), slog.Duration("retry_in", watchReconnectWait))
 			select {
 			case <-ctx.Done():
 				return
@@ -227,7 +955,7 @@ func (s *Scheduler) watch(ctx context.Context, dbURL string) error {
 	if _, err := conn.Exec(ctx, "LISTEN scheduled_jobs_changed"); err != nil {
 		return fmt.Errorf("listen: %w", err)
 	}
-	log.Printf("[scheduler] Watching for job changes via LISTEN/NOTIFY")
+	s.logger.Info("watching for job changes via LISTEN/NOTIFY")
 
 	for {
 		notification, err := conn.WaitForNotification(ctx)
@@ -240,66 +968,364 @@ func (s *Scheduler) watch(ctx context.Context, dbURL string) error {
 			JobID  string `json:"job_id"`
 		}
 		if err := json.Unmarshal([]byte(notification.Payload), &payload); err != nil {
-			log.Printf("[scheduler] Invalid notification payload %q: %v", notification.Payload, err)
+			s.logger.Warn("invalid notification payload", slog.String("payload", notification.Payload), slog.Any("error", err))
 			continue
 		}
 
-		log.Printf("[scheduler] NOTIFY received: action=%s job_id=%s", payload.Action, payload.JobID)
+		s.logger.Info("NOTIFY received", slog.String("action", payload.Action), slog.String("job_id", payload.JobID))
 		s.SyncJob(ctx, payload.JobID, payload.Action)
 	}
 }
 
 // ExecuteJob runs a job: calls the LLM (with retries), records the execution,
-// and publishes notifications to all configured channels.
+// and publishes notifications to all configured channels. It does not claim a
+// firing: cron firings go through fire, which calls it once claimed.
 // Exported so it can be triggered directly in tests and one-off scenarios.
 func (s *Scheduler) ExecuteJob(ctx context.Context, job Job) {
-	log.Printf("[scheduler] Executing job: %q", job.Name)
+	s.inflight.Add(1)
+	defer s.inflight.Done()
 
-	execID, err := s.createExecution(ctx, job.ID)
+	if _, running := s.running.LoadOrStore(job.ID, struct{}{}); running {
+		s.logger.Info("job already executing, skipping", slog.String("job_id", job.ID), slog.String("job_name", job.Name))
+		return
+	}
+	defer s.running.Delete(job.ID)
+	s.execute(ctx, job)
+}
+
+// TriggerNow runs an enabled job immediately, outside its cron schedule. The
+// execution happens in the background and survives the end of ctx; TriggerNow
+// returns once it has started. It returns ErrJobNotFound for an unknown or
+// disabled job and ErrJobAlreadyRunning if the job is executing on this
+// instance. Manual runs are not claimed like cron firings (see fire), so they
+// go ahead even while another instance runs the job.
+func (s *Scheduler) TriggerNow(ctx context.Context, jobID string) error {
+	job, err := s.loadJob(ctx, jobID)
 	if err != nil {
-		log.Printf("[scheduler] Failed to create execution record for job %s: %v", job.ID, err)
+		return fmt.Errorf("loading job: %w", err)
+	}
+	if job == nil {
+		return ErrJobNotFound
+	}
+	if _, running := s.running.LoadOrStore(job.ID, struct{}{}); running {
+		return ErrJobAlreadyRunning
+	}
+	s.logger.Info("job triggered manually", slog.String("job_id", job.ID), slog.String("job_name", job.Name))
+
+	s.inflight.Add(1)
+	go func() {
+		defer s.inflight.Done()
+		defer s.running.Delete(job.ID)
+		s.execute(context.WithoutCancel(ctx), *job)
+	}()
+	return nil
+}
+
+// execute is the body of ExecuteJob, run once the job is marked as running.
+func (s *Scheduler) execute(ctx context.Context, job Job) {
+	ctx, span := tracer.Start(ctx, "scheduler.execute_job", trace.WithAttributes(
+		attribute.String("job.id", job.ID),
+		attribute.String("job.name", job.Name),
+		attribute.String("job.user_id", job.UserID),
+	))
+	defer span.End()
+
+	// traceID ties together this execution's log lines, its job_executions row
+	// and the notifications it publishes (their trace_id stream field).
+	traceID := uuid.NewString()
+	span.SetAttributes(attribute.String("job.trace_id", traceID))
+	logger := s.logger.With(slog.String("trace_id", traceID))
+
+	defer s.recordNextRun(ctx, job.ID)
+
+	if s.rateLimiter != nil && !s.rateLimiter.Allow(job.UserID) {
+		logger.Warn("job rate limited", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.String("user_id", job.UserID))
+		span.SetStatus(codes.Error, "rate limited")
+		if execID, err := s.createExecution(ctx, job.ID, traceID); err != nil {
+			logger.Error("failed to create execution record", slog.String("job_id", job.ID), slog.Any("error", err))
+		} else {
+			_ = s.updateExecution(ctx, execID, "rate_limited", "user execution rate limit exceeded", runner.Usage{})
+		}
 		return
 	}
 
-	result, err := s.runWithRetry(ctx, job)
+	// A cron firing is already claimed (see fire), so waiting here for an
+	// execution slot does not let another instance run it meanwhile.
+	release, err := s.acquireSlot(ctx, job, logger)
+	if err != nil {
+		logger.Warn("job not executed while waiting for a free slot", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Any("error", err))
+		return
+	}
+	defer release()
+
+	logger.Info("executing job", slog.String("job_id", job.ID), slog.String("job_name", job.Name))
+
+	execID, err := s.createExecution(ctx, job.ID, traceID)
+	if err != nil {
+		logger.Error("failed to create execution record", slog.String("job_id", job.ID), slog.Any("error", err))
+		return
+	}
+	s.executions.Store(execID, struct{}{})
+	defer s.executions.Delete(execID)
+
+	prompt, err := jobPrompt(job, time.Now())
+	if err != nil {
+		// Retrying cannot fix a broken template.
+		logger.Error("job failed", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Any("error", err))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "prompt template failed")
+		_ = s.updateExecution(ctx, execID, "failed", err.Error(), runner.Usage{})
+		return
+	}
+	job.Prompt = prompt
+
+	// usage sums the tokens of every attempt, failed ones included.
+	runCtx, usage := runner.TrackUsage(runner.LimitPrompt(ctx, job.MaxPromptChars))
+	if job.ExecutionTimeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, job.ExecutionTimeout)
+		defer cancel()
+	}
+	result, err := s.runWithRetry(runCtx, job, logger)
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		// A result that arrives after the deadline is discarded as well.
+		logger.Warn("job timed out", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Duration("timeout", job.ExecutionTimeout))
+		msg := fmt.Sprintf("timed out after %s", job.ExecutionTimeout)
+		if err != nil {
+			msg += ": " + err.Error()
+		}
+		span.SetStatus(codes.Error, msg)
+		_ = s.updateExecution(ctx, execID, "timed_out", msg, *usage)
+		return
+	}
 	if err != nil {
-		log.Printf("[scheduler] Job %q failed after %d attempts: %v", job.Name, maxRunnerAttempts, err)
-		_ = s.updateExecution(ctx, execID, "failed", err.Error())
+		logger.Error("job failed", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Int("attempts", s.maxAttempts(job)), slog.Any("error", err))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "job failed")
+		_ = s.updateExecution(ctx, execID, "failed", err.Error(), *usage)
 		return
 	}
 
-	_ = s.updateExecution(ctx, execID, "completed", result)
+	if job.DeduplicationWindow > 0 && s.redis != nil {
+		sum := sha256.Sum256([]byte(result))
+		dup, err := s.deduplicate(ctx, job.ID, hex.EncodeToString(sum[:]), job.DeduplicationWindow)
+		if err != nil {
+			// Better a possible duplicate than a lost notification.
+			logger.Warn("deduplication check failed, publishing anyway", slog.String("job_id", job.ID), slog.Any("error", err))
+		} else if dup {
+			logger.Info("duplicate result suppressed", slog.String("job_id", job.ID), slog.String("job_name", job.Name),
+				slog.Duration("window", job.DeduplicationWindow))
+			_ = s.updateExecution(ctx, execID, "deduplicated", result, *usage)
+			return
+		}
+	}
 
+	_ = s.updateExecution(ctx, execID, "completed", result, *usage)
+	createdAt := time.Now().UTC()
+
+	var jobMetadata json.RawMessage
+	if len(job.Metadata) > 0 {
+		if jobMetadata, err = json.Marshal(job.Metadata); err != nil {
+			// Only a value set through RegisterJob can be invalid JSON; the
+			// notification is still worth more than its metadata.
+			logger.Warn("invalid job metadata, publishing without it", slog.String("job_id", job.ID), slog.Any("error", err))
+			jobMetadata = nil
+		}
+	}
+	var deliverAfter time.Time
+	if job.DeliveryDelay > 0 {
+		deliverAfter = time.Now().Add(job.DeliveryDelay)
+	}
+	recipients := s.resolveRecipients(ctx, job, logger)
+	var notifications []publisher.Notification
 	for _, channel := range job.Channels {
-		if err := s.publisher.Publish(ctx, publisher.Notification{
-			JobID:   job.ID,
-			UserID:  job.UserID,
-			Channel: channel,
-			Content: result,
-		}); err != nil {
-			log.Printf("[scheduler] Failed to publish to channel %q: %v", channel, err)
+		// Each channel gets the result fitted to its own length limit, which
+		// may mean several consecutive messages. Formatted content is left
+		// whole: cutting it here could split a tag or an escape, so the
+		// Telegram consumer splits it with the markup in view.
+		contents := []string{result}
+		if job.Format == "" {
+			contents = s.limits.Apply(channel, result)
 		}
+		for _, userID := range recipients {
+			for _, content := range contents {
+				notifications = append(notifications, publisher.Notification{
+					JobID:        job.ID,
+					JobName:      job.Name,
+					UserID:       userID,
+					Channel:      channel,
+					Content:      content,
+					Format:       job.Format,
+					DeliverAfter: deliverAfter,
+					Priority:     job.Priority,
+					JobMetadata:  jobMetadata,
+					CreatedAt:    createdAt,
+					TraceID:      traceID,
+				})
+			}
+		}
+	}
+	if err := s.publisher.PublishBatch(ctx, notifications); err != nil {
+		logger.Error("failed to publish", slog.String("job_id", job.ID), slog.Any("error", err))
 	}
 }
 
-// runWithRetry calls the runner up to maxRunnerAttempts times with exponential backoff.
-// Delays: 1×retryDelay, 2×retryDelay, … (capped at maxRunnerAttempts-1 waits).
-func (s *Scheduler) runWithRetry(ctx context.Context, job Job) (string, error) {
+// resolveRecipients returns the users an execution of job notifies: the job's
+// owner, or its Recipients that still exist, once each and in order. If the
+// lookup fails the Recipients are used as stored, so a database hiccup does
+// not drop a broadcast.
+func (s *Scheduler) resolveRecipients(ctx context.Context, job Job, logger *slog.Logger) []string {
+	if len(job.Recipients) == 0 {
+		return []string{job.UserID}
+	}
+	var valid []string
+	for _, id := range job.Recipients {
+		if uuid.Validate(id) == nil {
+			valid = append(valid, id)
+		}
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT u.id::text
+		FROM unnest($1::uuid[]) WITH ORDINALITY AS r(id, pos)
+		JOIN users u ON u.id = r.id
+		GROUP BY u.id
+		ORDER BY MIN(r.pos)
+	`, valid)
+	if err != nil {
+		logger.Warn("resolving recipients failed, using the stored list", slog.String("job_id", job.ID), slog.Any("error", err))
+		return job.Recipients
+	}
+	defer rows.Close()
+	var recipients []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			logger.Warn("resolving recipients failed, using the stored list", slog.String("job_id", job.ID), slog.Any("error", err))
+			return job.Recipients
+		}
+		recipients = append(recipients, id)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Warn("resolving recipients failed, using the stored list", slog.String("job_id", job.ID), slog.Any("error", err))
+		return job.Recipients
+	}
+	if len(recipients) < len(job.Recipients) {
+		logger.Warn("recipients skipped: not users, or listed twice", slog.String("job_id", job.ID),
+			slog.Int("recipients", len(job.Recipients)), slog.Int("resolved", len(recipients)))
+	}
+	return recipients
+}
+
+// acquireSlot blocks until an execution slot is free (see WithMaxConcurrent)
+// or ctx is done, and returns the function that frees the slot again.
+func (s *Scheduler) acquireSlot(ctx context.Context, job Job, logger *slog.Logger) (func(), error) {
+	if s.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		logger.Debug("waiting for a free execution slot", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Int("max_concurrent", cap(s.slots)))
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return func() { <-s.slots }, nil
+}
+
+// maxAttempts is the number of runner calls allowed for one execution of job.
+func (s *Scheduler) maxAttempts(job Job) int {
+	if job.MaxAttempts > 0 {
+		return job.MaxAttempts
+	}
+	return maxRunnerAttempts
+}
+
+// baseRetryDelay is the backoff unit between runner attempts for job.
+func (s *Scheduler) baseRetryDelay(job Job) time.Duration {
+	if job.RetryDelay > 0 {
+		return job.RetryDelay
+	}
+	return s.retryDelay
+}
+
+// jitterFor is the upper bound of the random wait added to a retry delay of
+// job (see WithJitter).
+func (s *Scheduler) jitterFor(job Job) time.Duration {
+	if s.maxJitter >= 0 {
+		return s.maxJitter
+	}
+	return s.baseRetryDelay(job) / 2
+}
+
+// jitter returns base plus a random duration in [0, maxJitter). The
+// math/rand/v2 top-level source is randomly seeded and safe for concurrent
+// executions.
+func jitter(base, maxJitter time.Duration) time.Duration {
+	if maxJitter <= 0 {
+		return base
+	}
+	return base + time.Duration(rand.Int64N(int64(maxJitter)))
+}
+
+// deduplicate reports whether contentHash was already published for jobID
+// within window. The first call for a hash claims it for window (SET NX EX), so
+// identical results within the window count as duplicates.
+func (s *Scheduler) deduplicate(ctx context.Context, jobID, contentHash string, window time.Duration) (bool, error) {
+	key := "notifications:dedup:" + jobID + ":" + contentHash
+	claimed, err := s.redis.SetNX(ctx, key, 1, window).Result()
+	if err != nil {
+		return false, err
+	}
+	return !claimed, nil
+}
+
+// runWithRetry calls the runner up to the job's max attempts (default
+// maxRunnerAttempts) with exponential backoff. Delays: 1×retryDelay,
+// 2×retryDelay, … where retryDelay is the job's own or the scheduler default.
+// A prompt over the runner's limit (runner.ErrPromptTooLong) is not retried.
+func (s *Scheduler) runWithRetry(ctx context.Context, job Job, logger *slog.Logger) (string, error) {
+	maxAttempts, retryDelay := s.maxAttempts(job), s.baseRetryDelay(job)
+	ctx, span := tracer.Start(ctx, "scheduler.run_with_retry", trace.WithAttributes(
+		attribute.String("job.id", job.ID),
+		attribute.Int("job.max_attempts", maxAttempts),
+	))
+	defer span.End()
+
 	var lastErr error
-	for attempt := 1; attempt <= maxRunnerAttempts; attempt++ {
-		result, err := s.runner.Run(ctx, job.UserID, job.ID, job.Prompt)
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		span.SetAttributes(attribute.Int("job.attempts", attempt))
+		start := time.Now()
+		result, err := s.run(ctx, job)
+		s.metrics.runnerDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			s.metrics.attempts.WithLabelValues("error").Inc()
+		} else {
+			s.metrics.attempts.WithLabelValues("success").Inc()
+		}
 		if err == nil {
 			if attempt > 1 {
-				log.Printf("[scheduler] Job %q succeeded on attempt %d/%d", job.Name, attempt, maxRunnerAttempts)
+				logger.Info("job succeeded after retry", slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Int("attempt", attempt), slog.Int("max_attempts", maxAttempts))
 			}
 			return result, nil
 		}
 		lastErr = err
+		span.AddEvent("attempt failed", trace.WithAttributes(
+			attribute.Int("attempt", attempt), attribute.String("error", err.Error())))
+		if errors.Is(err, runner.ErrPromptTooLong) {
+			// The same prompt would be rejected again.
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "prompt too long")
+			return "", err
+		}
 
-		if attempt < maxRunnerAttempts {
-			delay := s.retryDelay * time.Duration(attempt)
-			log.Printf("[scheduler] Job %q attempt %d/%d failed: %v — retrying in %s",
-				job.Name, attempt, maxRunnerAttempts, err, delay)
+		if attempt < maxAttempts {
+			delay := jitter(retryDelay*time.Duration(attempt), s.jitterFor(job))
+			logger.Warn("job attempt failed, retrying",
+				slog.String("job_id", job.ID), slog.String("job_name", job.Name), slog.Int("attempt", attempt),
+				slog.Int("max_attempts", maxAttempts), slog.Duration("retry_in", delay), slog.Any("error", err))
 			select {
 			case <-ctx.Done():
 				return "", ctx.Err()
@@ -307,27 +1333,66 @@ func (s *Scheduler) runWithRetry(ctx context.Context, job Job) (string, error) {
 			}
 		}
 	}
-	return "", fmt.Errorf("all %d attempts failed, last: %w", maxRunnerAttempts, lastErr)
+	span.RecordError(lastErr)
+	span.SetStatus(codes.Error, "all attempts failed")
+	return "", fmt.Errorf("all %d attempts failed, last: %w", maxAttempts, lastErr)
+}
+
+// run makes a single runner call for job. A system prompt goes through
+// RunWithContext when the runner supports it; otherwise it is prepended to the
+// user prompt so the persona is not silently dropped.
+func (s *Scheduler) run(ctx context.Context, job Job) (string, error) {
+	if sr, ok := s.runner.(StreamingRunner); ok && job.UseStreaming {
+		prompt := job.Prompt
+		if job.SystemPrompt != "" {
+			prompt = job.SystemPrompt + "\n\n" + prompt
+		}
+		return collectStream(sr.RunStream(ctx, job.UserID, prompt))
+	}
+	if job.SystemPrompt == "" {
+		return s.runner.Run(ctx, job.UserID, job.ID, job.Prompt)
+	}
+	if sr, ok := s.runner.(SystemPromptRunner); ok {
+		return sr.RunWithContext(ctx, job.UserID, job.SystemPrompt, job.Prompt)
+	}
+	return s.runner.Run(ctx, job.UserID, job.ID, job.SystemPrompt+"\n\n"+job.Prompt)
+}
+
+// collectStream reads tokens until the stream ends and returns them joined,
+// or the stream's error.
+func collectStream(tokens <-chan string, errc <-chan error) (string, error) {
+	var b strings.Builder
+	for tok := range tokens {
+		b.WriteString(tok)
+	}
+	if err := <-errc; err != nil {
+		return "", err
+	}
+	return b.String(), nil
 }
 
-func (s *Scheduler) createExecution(ctx context.Context, jobID string) (string, error) {
+func (s *Scheduler) createExecution(ctx context.Context, jobID, traceID string) (string, error) {
 	var id string
 	err := s.db.QueryRow(ctx, `
-		INSERT INTO job_executions (job_id, status, started_at)
-		VALUES ($1, 'running', $2)
+		INSERT INTO job_executions (job_id, status, started_at, trace_id)
+		VALUES ($1, 'running', $2, $3)
 		RETURNING id
-	`, jobID, time.Now()).Scan(&id)
+	`, jobID, time.Now(), traceID).Scan(&id)
 	return id, err
 }
 
-func (s *Scheduler) updateExecution(ctx context.Context, execID, status, result string) error {
+// updateExecution records the final status and token usage of an execution
+// and counts it in notifier_job_executions_total. Zero token counts are stored
+// as NULL (no LLM call, or a backend that does not report usage).
+func (s *Scheduler) updateExecution(ctx context.Context, execID, status, result string, usage runner.Usage) error {
+	s.metrics.executions.WithLabelValues(status).Inc()
 	_, err := s.db.Exec(ctx, `
 		UPDATE job_executions
-		SET status = $1, result = $2, completed_at = $3
+		SET status = $1, result = $2, completed_at = $3, prompt_tokens = NULLIF($5, 0), completion_tokens = NULLIF($6, 0)
 		WHERE id = $4
-	`, status, result, time.Now(), execID)
+	`, status, result, time.Now(), execID, usage.PromptTokens, usage.CompletionTokens)
 	if err != nil {
-		log.Printf("[scheduler] Failed to update execution %s: %v", execID, err)
+		s.logger.Error("failed to update execution", slog.String("execution_id", execID), slog.Any("error", err))
 		return err
 	}
 	if status == "completed" {
@@ -337,7 +1402,7 @@ func (s *Scheduler) updateExecution(ctx context.Context, execID, status, result
 			WHERE id = (SELECT job_id FROM job_executions WHERE id = $2)
 		`, time.Now(), execID)
 		if err != nil {
-			log.Printf("[scheduler] Failed to update last_run_at: %v", err)
+			s.logger.Error("failed to update last_run_at", slog.String("execution_id", execID), slog.Any("error", err))
 		}
 	}
 	return nil
@@ -1,18 +1,40 @@
 package scheduler_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
+	"github.com/allerac/notifier/internal/channels"
+	"github.com/allerac/notifier/internal/consumers/core"
+	"github.com/allerac/notifier/internal/logging"
 	"github.com/allerac/notifier/internal/publisher"
+	"github.com/allerac/notifier/internal/runner"
 	"github.com/allerac/notifier/internal/scheduler"
 )
 
@@ -21,18 +43,154 @@ import (
 type mockDB struct {
 	execID string
 	err    error
+	rows   [][]any         // returned by Query, one slice of column values per row
+	users  map[string]bool // existing users for recipient lookups; nil = every UUID is one
+
+	mu       sync.Mutex
+	execs    []execCall
+	queries  []execCall        // Query calls, recorded like Exec ones
+	inserts  []execCall        // INSERT … RETURNING calls made through QueryRow
+	fired    map[any]time.Time // last claimed firing, keyed by job ID
+	disabled map[any]bool      // jobs disabled by a one-off firing, hidden from later loads
+	paused   map[any]bool      // jobs paused by PauseJob, hidden from later loads
+}
+
+type execCall struct {
+	sql  string
+	args []any
 }
 
-func (m *mockDB) Query(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
-	return nil, nil
+func (m *mockDB) Query(_ context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries = append(m.queries, execCall{sql: sql, args: args})
+	if strings.Contains(sql, "JOIN users") {
+		var rows [][]any
+		seen := map[string]bool{}
+		for _, id := range args[0].([]string) {
+			if !seen[id] && (m.users == nil || m.users[id]) {
+				seen[id] = true
+				rows = append(rows, []any{id})
+			}
+		}
+		return &mockRows{rows: rows}, nil
+	}
+	var rows [][]any
+	for _, row := range m.rows {
+		if !m.disabled[row[0]] && !m.paused[row[0]] {
+			rows = append(rows, row)
+		}
+	}
+	return &mockRows{rows: rows}, nil
 }
-func (m *mockDB) QueryRow(_ context.Context, _ string, _ ...any) pgx.Row {
-	return &mockRow{id: m.execID, err: m.err}
+func (m *mockDB) QueryRow(_ context.Context, sql string, args ...any) pgx.Row {
+	if m.err != nil {
+		return &mockRow{err: m.err}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case strings.HasPrefix(strings.TrimSpace(sql), "INSERT"):
+		m.inserts = append(m.inserts, execCall{sql: sql, args: args})
+	case strings.Contains(sql, "SET enabled = false"):
+		if m.disabled[args[0]] {
+			return &mockRow{err: pgx.ErrNoRows}
+		}
+		if m.disabled == nil {
+			m.disabled = map[any]bool{}
+		}
+		m.disabled[args[0]] = true
+		return &mockRow{id: args[0].(string)}
+	case strings.Contains(sql, "SET paused = $2"):
+		for _, row := range m.rows {
+			if row[0] == args[0] && !m.disabled[row[0]] {
+				if m.paused == nil {
+					m.paused = map[any]bool{}
+				}
+				m.paused[args[0]] = args[1].(bool)
+				return &mockRow{id: args[0].(string)}
+			}
+		}
+		return &mockRow{err: pgx.ErrNoRows}
+	case strings.Contains(sql, "SUM(prompt_tokens)"):
+		m.queries = append(m.queries, execCall{sql: sql, args: args})
+		return &mockRows{rows: m.rows, i: 1}
+	case strings.Contains(sql, "FROM scheduled_jobs"):
+		for _, row := range m.rows {
+			if row[0] == args[0] && !m.disabled[row[0]] && !m.paused[row[0]] {
+				return &mockRows{rows: [][]any{row}, i: 1}
+			}
+		}
+		return &mockRow{err: pgx.ErrNoRows}
+	}
+	return &mockRow{id: m.execID}
 }
-func (m *mockDB) Exec(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+func (m *mockDB) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.execs = append(m.execs, execCall{sql: sql, args: args})
+	if m.err == nil && strings.Contains(sql, "SET last_fired_at") {
+		// Like the real claim, only a later firing moves last_fired_at. The
+		// database compares wall clock times, so drop the monotonic reading.
+		at := args[1].(time.Time).Round(0)
+		if last, ok := m.fired[args[0]]; ok && !last.Before(at) {
+			return pgconn.NewCommandTag("UPDATE 0"), nil
+		}
+		if m.fired == nil {
+			m.fired = map[any]time.Time{}
+		}
+		m.fired[args[0]] = at
+		return pgconn.NewCommandTag("UPDATE 1"), nil
+	}
 	return pgconn.CommandTag{}, m.err
 }
 
+// execsMatching returns the recorded Exec calls whose SQL contains substr.
+func (m *mockDB) execsMatching(substr string) []execCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []execCall
+	for _, e := range m.execs {
+		if strings.Contains(e.sql, substr) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// mockRows iterates over canned rows, assigning values to Scan destinations by position.
+type mockRows struct {
+	rows [][]any
+	i    int
+}
+
+func (r *mockRows) Close()                                       {}
+func (r *mockRows) Err() error                                   { return nil }
+func (r *mockRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *mockRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *mockRows) Values() ([]any, error)                       { return r.rows[r.i-1], nil }
+func (r *mockRows) RawValues() [][]byte                          { return nil }
+func (r *mockRows) Conn() *pgx.Conn                              { return nil }
+
+func (r *mockRows) Next() bool {
+	r.i++
+	return r.i <= len(r.rows)
+}
+
+func (r *mockRows) Scan(dest ...any) error {
+	row := r.rows[r.i-1]
+	for i, d := range dest {
+		if i >= len(row) || row[i] == nil {
+			continue
+		}
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(row[i]))
+	}
+	return nil
+}
+
 type mockRow struct {
 	id  string
 	err error
@@ -77,16 +235,77 @@ func (m *failThenSucceedRunner) Run(_ context.Context, _, _, _ string) (string,
 	return m.result, nil
 }
 
+// systemPromptRunner records the prompts passed to RunWithContext.
+type systemPromptRunner struct {
+	countingRunner
+	gotSystem, gotUser string
+}
+
+func (m *systemPromptRunner) RunWithContext(_ context.Context, _, systemPrompt, userPrompt string) (string, error) {
+	m.gotSystem, m.gotUser = systemPrompt, userPrompt
+	return m.result, m.err
+}
+
+// streamingRunner streams tokens (or fails with err) and counts RunStream calls.
+type streamingRunner struct {
+	countingRunner
+	tokens  []string
+	streams atomic.Int32
+}
+
+func (m *streamingRunner) RunStream(_ context.Context, _, _ string) (<-chan string, <-chan error) {
+	m.streams.Add(1)
+	tokens, errc := make(chan string, len(m.tokens)), make(chan error, 1)
+	for _, tok := range m.tokens {
+		tokens <- tok
+	}
+	if m.err != nil {
+		errc <- m.err
+	}
+	close(tokens)
+	close(errc)
+	return tokens, errc
+}
+
+// concurrencyRunner records the highest number of calls in flight at once.
+type concurrencyRunner struct {
+	delay    time.Duration
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (m *concurrencyRunner) Run(ctx context.Context, _, _, _ string) (string, error) {
+	n := m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	for {
+		peak := m.peak.Load()
+		if n <= peak || m.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	select {
+	case <-time.After(m.delay):
+		return "done", nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
 type mockPublisher struct {
+	mu            sync.Mutex
 	notifications []publisher.Notification
+	batches       int
 	err           error
 }
 
-func (m *mockPublisher) Publish(_ context.Context, n publisher.Notification) error {
+func (m *mockPublisher) PublishBatch(_ context.Context, ns []publisher.Notification) error {
 	if m.err != nil {
 		return m.err
 	}
-	m.notifications = append(m.notifications, n)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches++
+	m.notifications = append(m.notifications, ns...)
 	return nil
 }
 
@@ -113,6 +332,27 @@ func TestScheduler_ExecuteJob_Success(t *testing.T) {
 	require.Len(t, pub.notifications, 1)
 	assert.Equal(t, "Hello, World!", pub.notifications[0].Content)
 	assert.Equal(t, "telegram", pub.notifications[0].Channel)
+	assert.Equal(t, "Test Job", pub.notifications[0].JobName)
+}
+
+func TestScheduler_ExecuteJob_PropagatesTraceID(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	pub := &mockPublisher{}
+	job := baseJob()
+	job.Channels = []string{"telegram", "webhook"}
+	var buf bytes.Buffer
+
+	newSched(db, &countingRunner{result: "Hello"}, pub).WithLogger(logging.NewLogger(&buf, slog.LevelInfo)).
+		ExecuteJob(context.Background(), job)
+
+	require.Len(t, db.inserts, 1)
+	traceID := db.inserts[0].args[2].(string)
+	assert.NoError(t, uuid.Validate(traceID))
+	require.Len(t, pub.notifications, 2)
+	for _, n := range pub.notifications {
+		assert.Equal(t, traceID, n.TraceID)
+	}
+	assert.Contains(t, buf.String(), `"trace_id":"`+traceID+`"`)
 }
 
 func TestScheduler_ExecuteJob_RetriesOnTransientFailure(t *testing.T) {
@@ -137,6 +377,377 @@ func TestScheduler_ExecuteJob_AllAttemptsExhausted(t *testing.T) {
 	assert.Empty(t, pub.notifications, "no notifications when all attempts fail")
 }
 
+func TestScheduler_Metrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	run := &failThenSucceedRunner{failUntil: 2, result: "ok"}
+	sched := newSched(&mockDB{execID: "exec-1"}, run, &mockPublisher{}).WithMetrics(reg)
+
+	sched.ExecuteJob(context.Background(), baseJob())
+
+	assert.Equal(t, 1.0, counterValue(t, reg, "notifier_job_executions_total", "completed"))
+	assert.Equal(t, 2.0, counterValue(t, reg, "notifier_runner_attempts_total", "error"))
+	assert.Equal(t, 1.0, counterValue(t, reg, "notifier_runner_attempts_total", "success"))
+	assert.Equal(t, 1, testutil.CollectAndCount(reg, "notifier_runner_duration_seconds"))
+}
+
+// counterValue returns the value of the single-label counter name{label}
+// gathered from reg, or 0 if there is no such sample.
+func counterValue(t *testing.T, reg *prometheus.Registry, name, label string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if m.GetLabel()[0].GetValue() == label {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// templatedPrompt runs job with prompt and vars and returns the prompt the
+// runner received, or the failure recorded for the execution.
+func templatedPrompt(t *testing.T, prompt string, vars map[string]string) (got string, failure string) {
+	t.Helper()
+	db := &mockDB{execID: "exec-1"}
+	run := &systemPromptRunner{countingRunner: countingRunner{result: "ok"}}
+	job := baseJob()
+	job.SystemPrompt = "You are concise."
+	job.Prompt = prompt
+	job.TemplateVars = vars
+
+	newSched(db, run, &mockPublisher{}).ExecuteJob(context.Background(), job)
+
+	updates := db.execsMatching("UPDATE job_executions")
+	require.Len(t, updates, 1)
+	if updates[0].args[0] == "failed" {
+		return run.gotUser, updates[0].args[1].(string)
+	}
+	return run.gotUser, ""
+}
+
+func TestScheduler_ExecuteJob_RendersPromptTemplate(t *testing.T) {
+	got, failure := templatedPrompt(t, "Briefing for {{.city}} on {{.__weekday__}} {{.__date__}}", map[string]string{"city": "Lisbon"})
+
+	require.Empty(t, failure)
+	today := time.Now().UTC()
+	assert.Equal(t, "Briefing for Lisbon on "+today.Weekday().String()+" "+today.Format(time.DateOnly), got)
+}
+
+func TestScheduler_ExecuteJob_RendersJobVars(t *testing.T) {
+	got, failure := templatedPrompt(t, `{{.JobName}} for {{.UserID}} on {{.Now.Format "2006-01-02"}}`, map[string]string{"JobName": "spoofed"})
+
+	require.Empty(t, failure)
+	assert.Equal(t, "Test Job for user-1 on "+time.Now().UTC().Format(time.DateOnly), got)
+}
+
+func TestScheduler_ExecuteJob_PromptWithoutTemplateUnchanged(t *testing.T) {
+	got, failure := templatedPrompt(t, "Give me a morning briefing", nil)
+
+	require.Empty(t, failure)
+	assert.Equal(t, "Give me a morning briefing", got)
+}
+
+func TestScheduler_ExecuteJob_BuiltinVarsCannotBeOverridden(t *testing.T) {
+	got, failure := templatedPrompt(t, "{{.__date__}}", map[string]string{"__date__": "1999-12-31"})
+
+	require.Empty(t, failure)
+	assert.Equal(t, time.Now().UTC().Format(time.DateOnly), got)
+}
+
+func TestScheduler_ExecuteJob_TemplateVarIsNotEvaluated(t *testing.T) {
+	got, failure := templatedPrompt(t, "Topic: {{.topic}}", map[string]string{"topic": `{{.__date__}} {{printf "%s" "x"}}`})
+
+	require.Empty(t, failure)
+	assert.Equal(t, `Topic: {{.__date__}} {{printf "%s" "x"}}`, got, "template syntax in a value stays literal text")
+}
+
+func TestScheduler_ExecuteJob_MissingTemplateVarFails(t *testing.T) {
+	got, failure := templatedPrompt(t, "Briefing for {{.city}}", nil)
+
+	assert.Empty(t, got, "runner not called")
+	assert.Contains(t, failure, "render prompt template")
+	assert.Contains(t, failure, "city")
+}
+
+func TestScheduler_ExecuteJob_InvalidTemplateSyntaxFails(t *testing.T) {
+	got, failure := templatedPrompt(t, "Briefing for {{.city", map[string]string{"city": "Lisbon"})
+
+	assert.Empty(t, got, "runner not called")
+	assert.Contains(t, failure, "parse prompt template")
+}
+
+func TestScheduler_ExecuteJob_MaxAttemptsOneFailsFast(t *testing.T) {
+	db := &mockDB{execID: "exec-3"}
+	run := &countingRunner{err: fmt.Errorf("LLM down")}
+	job := baseJob()
+	job.MaxAttempts = 1
+
+	newSched(db, run, &mockPublisher{}).ExecuteJob(context.Background(), job)
+
+	assert.Equal(t, int32(1), run.calls.Load(), "no retry")
+	updates := db.execsMatching("UPDATE job_executions")
+	require.Len(t, updates, 1)
+	assert.Equal(t, "failed", updates[0].args[0])
+}
+
+func TestScheduler_ExecuteJob_PromptTooLongIsNotRetried(t *testing.T) {
+	db := &mockDB{execID: "exec-3"}
+	run := &countingRunner{err: fmt.Errorf("%w: 9000 characters, limit is 8000", runner.ErrPromptTooLong)}
+
+	newSched(db, run, &mockPublisher{}).ExecuteJob(context.Background(), baseJob())
+
+	assert.Equal(t, int32(1), run.calls.Load(), "no retry")
+	updates := db.execsMatching("UPDATE job_executions")
+	require.Len(t, updates, 1)
+	assert.Equal(t, "failed", updates[0].args[0])
+	assert.Contains(t, updates[0].args[1], "prompt too long")
+}
+
+func TestScheduler_ExecuteJob_PerJobMaxPromptChars(t *testing.T) {
+	var calls atomic.Int32
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode(runner.ChatResponse{Message: runner.ChatMsg{Role: "assistant", Content: "ok"}})
+	}))
+	defer llm.Close()
+	db := &mockDB{execID: "exec-1"}
+	job := baseJob()
+	job.Prompt = "Briefing for {{.JobName}}"
+	job.MaxPromptChars = 10
+
+	newSched(db, runner.New(llm.URL, "test-model").WithMaxPromptChars(1000, false), &mockPublisher{}).ExecuteJob(context.Background(), job)
+
+	assert.Zero(t, calls.Load(), "the job's limit applies to the rendered prompt")
+	updates := db.execsMatching("UPDATE job_executions")
+	require.Len(t, updates, 1)
+	assert.Equal(t, "failed", updates[0].args[0])
+	assert.Contains(t, updates[0].args[1], "21 characters, limit is 10")
+}
+
+func TestScheduler_ExecuteJob_PublishesWithJobPriority(t *testing.T) {
+	pub := &mockPublisher{}
+	job := baseJob()
+	job.Channels = []string{"telegram", "webpush"}
+	job.Priority = publisher.PriorityHigh
+
+	newSched(&mockDB{execID: "exec-1"}, &countingRunner{result: "disk almost full"}, pub).ExecuteJob(context.Background(), job)
+
+	require.Len(t, pub.notifications, 2)
+	for _, n := range pub.notifications {
+		assert.Equal(t, publisher.PriorityHigh, n.Priority, n.Channel)
+	}
+}
+
+func TestScheduler_ExecuteJob_SingleRecipientIsOwner(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	pub := &mockPublisher{}
+	job := baseJob()
+	job.Channels = []string{"telegram", "slack"}
+
+	newSched(db, &countingRunner{result: "hello"}, pub).ExecuteJob(context.Background(), job)
+
+	require.Len(t, pub.notifications, 2)
+	for _, n := range pub.notifications {
+		assert.Equal(t, "user-1", n.UserID)
+	}
+	assert.Empty(t, db.queries, "no recipient lookup")
+}
+
+// recipientDeliverer records the user_id of every message a dispatcher
+// delivers.
+type recipientDeliverer struct {
+	mu    sync.Mutex
+	users []string
+}
+
+func (d *recipientDeliverer) Deliver(_ context.Context, msg redis.XMessage) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user, _ := msg.Values["user_id"].(string)
+	d.users = append(d.users, user)
+	return nil
+}
+
+func (d *recipientDeliverer) delivered() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.users...)
+}
+
+func TestScheduler_ExecuteJob_FansOutToRecipients(t *testing.T) {
+	const (
+		ana   = "0b6f6a4e-8d3c-4a57-9a0e-2f1f6f5d8c11"
+		bruno = "5e2d1c7a-1b9f-4f0e-8c3d-7a6b5c4d3e21"
+		gone  = "9d8c7b6a-5f4e-4d3c-8b2a-1f0e9d8c7b6a"
+	)
+	mr := miniredis.RunT(t)
+	client := func() *redis.Client {
+		rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { rc.Close() })
+		return rc
+	}
+	ctx := context.Background()
+	// Deliver through the real publisher and dispatchers, with the default
+	// idempotency window, so every recipient's copy must survive the
+	// dispatcher's duplicate check.
+	received := map[string]*recipientDeliverer{}
+	for _, channel := range []string{"telegram", "slack"} {
+		received[channel] = &recipientDeliverer{}
+		disp := core.New(client(), channel, channel+"-group", received[channel])
+		require.NoError(t, disp.Start(ctx))
+		t.Cleanup(func() { disp.Stop(ctx) })
+	}
+	pub := publisher.NewFromClient(client()).WithIdempotencyWindow(5 * time.Minute)
+
+	db := &mockDB{execID: "exec-1", users: map[string]bool{ana: true, bruno: true}}
+	run := &countingRunner{result: "weekly summary"}
+	job := baseJob()
+	job.Channels = []string{"telegram", "slack"}
+	job.Recipients = []string{ana, bruno, gone, ana, "not-a-uuid"}
+
+	scheduler.New(db, run, pub).WithRetryDelay(time.Millisecond).ExecuteJob(ctx, job)
+
+	assert.Equal(t, int32(1), run.calls.Load(), "the LLM runs once for every recipient")
+	require.Len(t, db.queries, 1, "recipients resolved once per run")
+	assert.Equal(t, []any{[]string{ana, bruno, gone, ana}}, db.queries[0].args)
+	for channel, d := range received {
+		require.Eventually(t, func() bool { return len(d.delivered()) == 2 }, 2*time.Second, 5*time.Millisecond,
+			"%s: one delivery per recipient", channel)
+		assert.ElementsMatch(t, []string{ana, bruno}, d.delivered(), channel)
+	}
+}
+
+func TestScheduler_ExecuteJob_PerJobMaxAttempts(t *testing.T) {
+	run := &failThenSucceedRunner{failUntil: 4, result: "finally"}
+	pub := &mockPublisher{}
+	job := baseJob()
+	job.MaxAttempts = 5
+
+	newSched(&mockDB{execID: "exec-1"}, run, pub).ExecuteJob(context.Background(), job)
+
+	assert.Equal(t, int32(5), run.calls.Load())
+	require.Len(t, pub.notifications, 1)
+	assert.Equal(t, "finally", pub.notifications[0].Content)
+}
+
+func TestScheduler_ExecuteJob_DeduplicatesWithinWindow(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+
+	db := &mockDB{execID: "exec-1"}
+	pub := &mockPublisher{}
+	sched := newSched(db, &countingRunner{result: "same digest"}, pub).WithRedis(rdb)
+	job := baseJob()
+	job.DeduplicationWindow = time.Minute
+
+	sched.ExecuteJob(context.Background(), job)
+	sched.ExecuteJob(context.Background(), job)
+	assert.Len(t, pub.notifications, 1, "identical result within the window is suppressed")
+
+	mr.FastForward(time.Minute + time.Second)
+	sched.ExecuteJob(context.Background(), job)
+	assert.Len(t, pub.notifications, 2, "delivered again once the window has passed")
+
+	updates := db.execsMatching("UPDATE job_executions")
+	require.Len(t, updates, 3)
+	assert.Equal(t, "completed", updates[0].args[0])
+	assert.Equal(t, "deduplicated", updates[1].args[0])
+	assert.Equal(t, "completed", updates[2].args[0])
+}
+
+func TestScheduler_ExecuteJob_DifferentResultsNotDeduplicated(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+
+	run := &countingRunner{result: "first"}
+	pub := &mockPublisher{}
+	sched := newSched(&mockDB{execID: "exec-1"}, run, pub).WithRedis(rdb)
+	job := baseJob()
+	job.DeduplicationWindow = time.Minute
+
+	sched.ExecuteJob(context.Background(), job)
+	run.result = "second"
+	sched.ExecuteJob(context.Background(), job)
+
+	assert.Len(t, pub.notifications, 2)
+}
+
+func TestScheduler_ExecuteJob_DeduplicationFailsOpen(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	mr.Close()
+
+	pub := &mockPublisher{}
+	job := baseJob()
+	job.DeduplicationWindow = time.Minute
+
+	newSched(&mockDB{execID: "exec-1"}, &countingRunner{result: "hi"}, pub).WithRedis(rdb).
+		ExecuteJob(context.Background(), job)
+
+	assert.Len(t, pub.notifications, 1, "a Redis outage must not drop the notification")
+}
+
+func TestScheduler_ExecuteJob_MaxConcurrent(t *testing.T) {
+	run := &concurrencyRunner{delay: 20 * time.Millisecond}
+	pub := &mockPublisher{}
+	sched := newSched(&mockDB{execID: "exec-1"}, run, pub).WithMaxConcurrent(2)
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		job := baseJob()
+		job.ID = fmt.Sprintf("job-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sched.ExecuteJob(context.Background(), job)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), run.peak.Load(), "never more than 2 runner calls in flight")
+	assert.Len(t, pub.notifications, 10, "jobs over the limit wait instead of being dropped")
+}
+
+func TestScheduler_ExecuteJob_WaitingForSlotRespectsCancel(t *testing.T) {
+	run := &concurrencyRunner{delay: time.Second}
+	db := &mockDB{execID: "exec-1"}
+	sched := newSched(db, run, &mockPublisher{}).WithMaxConcurrent(1)
+
+	busy := baseJob()
+	busy.ID = "job-busy"
+	go sched.ExecuteJob(context.Background(), busy)
+	require.Eventually(t, func() bool { return run.inFlight.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	sched.ExecuteJob(ctx, baseJob())
+
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "gave up waiting once ctx was done")
+	assert.Len(t, db.execsMatching("UPDATE job_executions"), 0, "no execution recorded for the job that never ran")
+}
+
+func TestScheduler_ExecuteJob_PerJobRetryDelay(t *testing.T) {
+	run := &failThenSucceedRunner{failUntil: 2, result: "ok"}
+	job := baseJob()
+	job.RetryDelay = 40 * time.Millisecond
+
+	start := time.Now()
+	newSched(&mockDB{execID: "exec-1"}, run, &mockPublisher{}).ExecuteJob(context.Background(), job)
+
+	// 1×40ms + 2×40ms instead of the scheduler's 1ms test delay
+	assert.GreaterOrEqual(t, time.Since(start), 120*time.Millisecond)
+	assert.Equal(t, int32(3), run.calls.Load())
+}
+
 func TestScheduler_ExecuteJob_MultipleChannels(t *testing.T) {
 	run := &countingRunner{result: "Hello!"}
 	pub := &mockPublisher{}
@@ -148,6 +759,7 @@ func TestScheduler_ExecuteJob_MultipleChannels(t *testing.T) {
 	require.Len(t, pub.notifications, 2)
 	assert.Equal(t, "telegram", pub.notifications[0].Channel)
 	assert.Equal(t, "browser", pub.notifications[1].Channel)
+	assert.Equal(t, 1, pub.batches, "all channels are published in one batch")
 }
 
 func TestScheduler_ExecuteJob_DBCreateExecutionError(t *testing.T) {
@@ -162,6 +774,69 @@ func TestScheduler_ExecuteJob_DBCreateExecutionError(t *testing.T) {
 	assert.Empty(t, pub.notifications)
 }
 
+// blockingRunner signals on started and waits for release before answering
+// (with an error if ctx was cancelled meanwhile).
+type blockingRunner struct {
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingRunner) Run(ctx context.Context, _, _, _ string) (string, error) {
+	m.calls.Add(1)
+	close(m.started)
+	<-m.release
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return "done", nil
+}
+
+func TestScheduler_Start_OneInstancePerFiring(t *testing.T) {
+	db := &mockDB{execID: "exec-1", rows: [][]any{
+		{"job-1", "user-1", "Every second", "@every 1s", "say hello", "", []string{"telegram"}},
+	}}
+	run := &countingRunner{result: "tick"}
+	pubA, pubB := &mockPublisher{}, &mockPublisher{}
+	// Two replicas fire the same cron entry against the same database.
+	instanceA := newSched(db, run, pubA)
+	instanceB := newSched(db, run, pubB)
+	ctx := context.Background()
+	require.NoError(t, instanceA.Start(ctx))
+	require.NoError(t, instanceB.Start(ctx))
+
+	time.Sleep(2500 * time.Millisecond)
+	require.NoError(t, instanceA.Stop(ctx))
+	require.NoError(t, instanceB.Stop(ctx))
+
+	calls := run.calls.Load()
+	assert.True(t, calls >= 2 && calls <= 3, "one execution per firing, got %d", calls)
+	assert.Equal(t, int(calls), len(pubA.notifications)+len(pubB.notifications))
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	assert.Zero(t, db.fired["job-1"].Nanosecond(), "firings are claimed at their scheduled second")
+}
+
+func TestScheduler_RegisterJob_LateInstanceSkipsClaimedFirings(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	run := &countingRunner{result: "ok"}
+	job := baseJob()
+	job.CronExpr = "* * * * *"
+	job.CatchUpWindow = 5 * time.Minute
+	job.LastRunAt = time.Now().Add(-3 * time.Minute)
+
+	// The second replica catches up on the same firings after the first has
+	// run them all; nothing is held in between.
+	for range 2 {
+		sched := newSched(db, run, &mockPublisher{})
+		require.NoError(t, sched.RegisterJob(context.Background(), job))
+		require.NoError(t, sched.Stop(context.Background()))
+	}
+
+	assert.Equal(t, int32(3), run.calls.Load(), "each missed firing runs once")
+	assert.Len(t, db.execsMatching("SET last_fired_at"), 6)
+}
+
 func TestScheduler_RegisterJob_InvalidCronExpr(t *testing.T) {
 	sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{})
 	err := sched.RegisterJob(context.Background(), scheduler.Job{
@@ -193,3 +868,1066 @@ func TestScheduler_ExecuteJob_ContextCancelledDuringRetry(t *testing.T) {
 	assert.LessOrEqual(t, run.calls.Load(), int32(2))
 	assert.Empty(t, pub.notifications)
 }
+
+func TestScheduler_ExecuteJob_AppliesPerChannelLimits(t *testing.T) {
+	run := &countingRunner{result: "First sentence here. Second sentence follows. Third one ends it."}
+	pub := &mockPublisher{}
+	job := baseJob()
+	job.Channels = []string{"telegram", "sms"}
+
+	newSched(&mockDB{execID: "exec-5"}, run, pub).
+		WithChannelLimits(channels.Limits{
+			"telegram": {MaxLen: 50, Mode: channels.Split},
+			"sms":      {MaxLen: 20, Mode: channels.Truncate},
+		}).
+		ExecuteJob(context.Background(), job)
+
+	var telegram, sms []string
+	for _, n := range pub.notifications {
+		switch n.Channel {
+		case "telegram":
+			telegram = append(telegram, n.Content)
+		case "sms":
+			sms = append(sms, n.Content)
+		}
+	}
+	assert.Equal(t, []string{"First sentence here. Second sentence follows.", "Third one ends it."}, telegram)
+	assert.Equal(t, []string{"First sentence here…"}, sms)
+}
+
+func TestScheduler_ExecuteJob_LeavesFormattedContentWhole(t *testing.T) {
+	tests := []struct {
+		format  publisher.Format
+		content string
+	}{
+		{publisher.FormatHTML, "<b>" + strings.Repeat("word ", 1000) + "</b>"},
+		{publisher.FormatMarkdownV2, "`" + strings.Repeat("code. ", 1000) + "` and **" + strings.Repeat("bold ", 500) + "**"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			for name, limits := range map[string]channels.Limits{
+				"default limits": channels.DefaultLimits(),
+				"telegram limit": {"telegram": {MaxLen: 50, Mode: channels.Split}},
+			} {
+				pub := &mockPublisher{}
+				job := baseJob()
+				job.Channels = []string{"telegram", "discord"}
+				job.Format = tt.format
+
+				newSched(&mockDB{execID: "exec-5"}, &countingRunner{result: tt.content}, pub).
+					WithChannelLimits(limits).
+					ExecuteJob(context.Background(), job)
+
+				require.Len(t, pub.notifications, 2, name)
+				for _, n := range pub.notifications {
+					assert.Equal(t, tt.content, n.Content, "%s: %s content is split by its consumer", name, n.Channel)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduler_RegisterJob_PersistsRegistrationError(t *testing.T) {
+	db := &mockDB{}
+	sched := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+
+	err := sched.RegisterJob(context.Background(), scheduler.Job{
+		ID: "bad", Name: "Bad Cron", CronExpr: "not-a-cron",
+	})
+	require.Error(t, err)
+
+	updates := db.execsMatching("registration_error")
+	require.Len(t, updates, 1)
+	assert.Equal(t, "bad", updates[0].args[0])
+	regErr, ok := updates[0].args[1].(*string)
+	require.True(t, ok)
+	require.NotNil(t, regErr, "error persisted")
+	assert.Contains(t, *regErr, "invalid cron expr")
+}
+
+func TestScheduler_RegisterJob_ClearsRegistrationErrorOnSuccess(t *testing.T) {
+	db := &mockDB{}
+	sched := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+
+	require.NoError(t, sched.RegisterJob(context.Background(), baseJob()))
+
+	updates := db.execsMatching("registration_error")
+	require.Len(t, updates, 1)
+	assert.Nil(t, updates[0].args[1], "error cleared")
+}
+
+func TestScheduler_ListJobs_ReportsRegistrationErrors(t *testing.T) {
+	db := &mockDB{rows: [][]any{
+		{"job-1", "user-1", "Good", "0 8 * * *", true, "", (*time.Time)(nil)},
+		{"bad", "user-1", "Bad Cron", "not-a-cron", true, `invalid cron expr "not-a-cron"`, (*time.Time)(nil)},
+	}}
+	sched := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+	require.NoError(t, sched.RegisterJob(context.Background(), baseJob()))
+
+	jobs, err := sched.ListJobs(context.Background())
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+
+	assert.True(t, jobs[0].Registered)
+	assert.Empty(t, jobs[0].RegistrationError)
+
+	assert.False(t, jobs[1].Registered)
+	assert.Contains(t, jobs[1].RegistrationError, "invalid cron expr")
+}
+
+func TestScheduler_ExecuteJob_PassesSystemPrompt(t *testing.T) {
+	run := &systemPromptRunner{countingRunner: countingRunner{result: "Arr!"}}
+	job := baseJob()
+	job.SystemPrompt = "You are a pirate."
+
+	newSched(&mockDB{execID: "exec-sys"}, run, &mockPublisher{}).ExecuteJob(context.Background(), job)
+
+	assert.Equal(t, "You are a pirate.", run.gotSystem)
+	assert.Equal(t, "say hello", run.gotUser)
+	assert.Equal(t, int32(0), run.calls.Load(), "Run not used when a system prompt is set")
+}
+
+func TestScheduler_ExecuteJob_UseStreamingCollectsTokens(t *testing.T) {
+	run := &streamingRunner{tokens: []string{"Good ", "morning", "!"}}
+	pub := &mockPublisher{}
+	job := baseJob()
+	job.UseStreaming = true
+
+	newSched(&mockDB{execID: "exec-stream"}, run, pub).ExecuteJob(context.Background(), job)
+
+	assert.Equal(t, int32(1), run.streams.Load())
+	assert.Equal(t, int32(0), run.calls.Load(), "Run not used when streaming")
+	require.Len(t, pub.notifications, 1)
+	assert.Equal(t, "Good morning!", pub.notifications[0].Content)
+}
+
+func TestScheduler_ExecuteJob_StreamErrorIsRetried(t *testing.T) {
+	run := &streamingRunner{countingRunner: countingRunner{err: fmt.Errorf("stream broke")}, tokens: []string{"partial"}}
+	pub := &mockPublisher{}
+	job := baseJob()
+	job.UseStreaming = true
+
+	newSched(&mockDB{execID: "exec-stream"}, run, pub).ExecuteJob(context.Background(), job)
+
+	assert.Equal(t, int32(3), run.streams.Load(), "a failed stream counts as a failed attempt")
+	assert.Empty(t, pub.notifications, "partial output is not published")
+}
+
+func TestScheduler_ExecuteJob_NoSystemPromptUsesRun(t *testing.T) {
+	run := &systemPromptRunner{countingRunner: countingRunner{result: "Hello"}}
+
+	newSched(&mockDB{execID: "exec-nosys"}, run, &mockPublisher{}).ExecuteJob(context.Background(), baseJob())
+
+	assert.Equal(t, int32(1), run.calls.Load())
+	assert.Empty(t, run.gotSystem)
+}
+
+func TestScheduler_ExecuteJob_PublishesJobFormat(t *testing.T) {
+	pub := &mockPublisher{}
+	job := baseJob()
+	job.Format = publisher.FormatMarkdownV2
+
+	newSched(&mockDB{execID: "exec-fmt"}, &countingRunner{result: "**hi**"}, pub).
+		ExecuteJob(context.Background(), job)
+
+	require.Len(t, pub.notifications, 1)
+	assert.Equal(t, publisher.FormatMarkdownV2, pub.notifications[0].Format)
+}
+
+func TestScheduler_NextRunTimes(t *testing.T) {
+	sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{})
+	job := baseJob()
+	job.Name = "Every Minute"
+	job.CronExpr = "* * * * *"
+	require.NoError(t, sched.RegisterJob(context.Background(), job))
+
+	next := sched.NextRunTimes(5)
+
+	times := next["Every Minute"]
+	require.Len(t, times, 5)
+	assert.WithinDuration(t, time.Now(), times[0], time.Minute)
+	for i := 1; i < len(times); i++ {
+		assert.InDelta(t, time.Minute.Seconds(), times[i].Sub(times[i-1]).Seconds(), 1)
+	}
+}
+
+func TestScheduler_NextRunTimes_DuplicateNames(t *testing.T) {
+	sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{})
+	a, b := baseJob(), baseJob()
+	b.ID = "job-2"
+	require.NoError(t, sched.RegisterJob(context.Background(), a))
+	require.NoError(t, sched.RegisterJob(context.Background(), b))
+
+	next := sched.NextRunTimes(1)
+
+	assert.Len(t, next, 2)
+	assert.Contains(t, next, "Test Job")
+}
+
+func TestScheduler_RegisterJob_Timezone(t *testing.T) {
+	sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{})
+	ny, utc := baseJob(), baseJob()
+	ny.ID, ny.Name, ny.CronExpr, ny.Timezone = "job-ny", "New York", "0 9 * * *", "America/New_York"
+	utc.ID, utc.Name, utc.CronExpr, utc.Timezone = "job-utc", "UTC", "0 9 * * *", "UTC"
+	require.NoError(t, sched.RegisterJob(context.Background(), ny))
+	require.NoError(t, sched.RegisterJob(context.Background(), utc))
+
+	next := sched.NextRunTimes(1)
+
+	require.Len(t, next["UTC"], 1)
+	require.Len(t, next["New York"], 1)
+	utcRun, nyRun := next["UTC"][0].UTC(), next["New York"][0].UTC()
+	assert.Equal(t, 9, utcRun.Hour())
+	assert.Contains(t, []int{13, 14}, nyRun.Hour(), "9:00 in New York is 13:00 or 14:00 UTC depending on DST")
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	assert.Equal(t, 9, nyRun.In(loc).Hour())
+}
+
+func TestScheduler_RegisterJob_InvalidTimezone(t *testing.T) {
+	sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{})
+	job := baseJob()
+	job.Timezone = "Mars/Olympus_Mons"
+
+	err := sched.RegisterJob(context.Background(), job)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "CRON_TZ=Mars/Olympus_Mons")
+}
+
+func TestScheduler_WithSeconds_FiresEverySecond(t *testing.T) {
+	sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{}).WithSeconds(true)
+	job := baseJob()
+	job.CronExpr = "*/30 * * * * *"
+	require.NoError(t, sched.RegisterJob(context.Background(), job))
+
+	times := sched.NextRunTimes(3)["Test Job"]
+
+	require.Len(t, times, 3)
+	for i := 1; i < len(times); i++ {
+		assert.Equal(t, 30*time.Second, times[i].Sub(times[i-1]))
+	}
+}
+
+func TestScheduler_RegisterJob_FieldCountMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		seconds bool
+		expr    string
+		want    string
+	}{
+		{"six fields in minute mode", false, "*/30 * * * * *", "has 6 fields, expected 5"},
+		{"five fields in seconds mode", true, "0 8 * * *", "has 5 fields, expected 6"},
+		{"zone prefix not counted", true, "CRON_TZ=UTC 0 8 * * *", "has 5 fields, expected 6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDB{}
+			sched := scheduler.New(db, &countingRunner{}, &mockPublisher{}).WithSeconds(tt.seconds)
+			job := baseJob()
+			job.CronExpr = tt.expr
+
+			err := sched.RegisterJob(context.Background(), job)
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+			assert.Contains(t, err.Error(), "NOTIFIER_CRON_SECONDS")
+			assert.Empty(t, sched.NextRunTimes(1))
+		})
+	}
+}
+
+func TestScheduler_RegisterJob_DescriptorsWorkInBothModes(t *testing.T) {
+	for _, seconds := range []bool{false, true} {
+		sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{}).WithSeconds(seconds)
+		job := baseJob()
+		job.CronExpr = "@hourly"
+		assert.NoError(t, sched.RegisterJob(context.Background(), job), "seconds=%v", seconds)
+	}
+}
+
+// oneOffRow is a LoadJobs result row for a job that fires once at runAt.
+func oneOffRow(id string, runAt time.Time) []any {
+	return append(jobRow(id, "Reminder", ""), publisher.FormatPlain, "", 0, &runAt)
+}
+
+func TestScheduler_RegisterOnce_FiresOnceAtTime(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	run := &countingRunner{result: "reminder"}
+	sched := newSched(db, run, &mockPublisher{})
+	require.NoError(t, sched.Start(context.Background()))
+	defer sched.Stop(context.Background())
+
+	at := time.Now().Add(200 * time.Millisecond)
+	require.NoError(t, sched.RegisterOnce(context.Background(), baseJob(), at))
+	assert.Equal(t, []time.Time{at}, sched.NextRunTimes(3)["Test Job"], "a single activation")
+
+	require.Eventually(t, func() bool { return run.calls.Load() == 1 }, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), run.calls.Load())
+	assert.Empty(t, sched.NextRunTimes(1)["Test Job"], "no activation left after firing")
+}
+
+func TestScheduler_RegisterOnce_PastTimeRunsImmediately(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	run := &countingRunner{result: "late reminder"}
+	pub := &mockPublisher{}
+	sched := newSched(db, run, pub) // cron not started: the job must not wait for it
+
+	require.NoError(t, sched.RegisterOnce(context.Background(), baseJob(), time.Now().Add(-time.Hour)))
+	require.NoError(t, sched.Stop(context.Background())) // waits for the run
+
+	assert.Equal(t, int32(1), run.calls.Load())
+	require.Len(t, pub.notifications, 1)
+	assert.Equal(t, "late reminder", pub.notifications[0].Content)
+}
+
+func TestScheduler_RegisterOnce_DoesNotRefireAfterRestart(t *testing.T) {
+	db := &mockDB{execID: "exec-1", rows: [][]any{oneOffRow("job-1", time.Now().Add(-time.Minute))}}
+	run := &countingRunner{result: "reminder"}
+
+	first := newSched(db, run, &mockPublisher{})
+	require.NoError(t, first.Start(context.Background()))
+	require.NoError(t, first.Stop(context.Background()))
+	require.Equal(t, int32(1), run.calls.Load())
+	assert.Len(t, db.execsMatching("UPDATE job_executions"), 1)
+
+	second := newSched(db, run, &mockPublisher{})
+	require.NoError(t, second.Start(context.Background()))
+	require.NoError(t, second.Stop(context.Background()))
+
+	assert.Equal(t, int32(1), run.calls.Load(), "a fired one-off job is disabled and not loaded again")
+}
+
+func TestScheduler_RegisterOnce_ReRegistrationDoesNotRefire(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	run := &countingRunner{result: "reminder"}
+	sched := newSched(db, run, &mockPublisher{})
+	job := baseJob()
+	past := time.Now().Add(-time.Minute)
+
+	// e.g. SyncJob reacting to the job's own last_run_at update
+	require.NoError(t, sched.RegisterOnce(context.Background(), job, past))
+	require.NoError(t, sched.Stop(context.Background()))
+	require.NoError(t, sched.RegisterOnce(context.Background(), job, past))
+	require.NoError(t, sched.Stop(context.Background()))
+
+	assert.Equal(t, int32(1), run.calls.Load())
+}
+
+func TestScheduler_LoadJobs_ReadsRetryPolicy(t *testing.T) {
+	row := append(jobRow("job-1", "Flaky API", "0 8 * * *"), publisher.FormatPlain, "", 0, nil, 5, 30)
+	sched := scheduler.New(&mockDB{rows: [][]any{row}}, &countingRunner{}, &mockPublisher{})
+
+	jobs, err := sched.LoadJobs(context.Background())
+
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 5, jobs[0].MaxAttempts)
+	assert.Equal(t, 30*time.Second, jobs[0].RetryDelay)
+}
+
+func TestScheduler_JobMetadata_TravelsWithNotifications(t *testing.T) {
+	meta := map[string]json.RawMessage{"report_type": json.RawMessage(`"weekly"`), "regions": json.RawMessage(`["EU","US"]`)}
+	row := append(jobRow("job-1", "Report", "0 8 * * *"), publisher.FormatPlain, "", 0, nil, 0, 0, 0, nil, meta)
+	db := &mockDB{rows: [][]any{row}, execID: "exec-1"}
+	pub := &mockPublisher{}
+	sched := newSched(db, &countingRunner{result: "ok"}, pub)
+
+	jobs, err := sched.LoadJobs(context.Background())
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.JSONEq(t, `"weekly"`, string(jobs[0].MustMetadata("report_type")))
+
+	sched.ExecuteJob(context.Background(), jobs[0])
+
+	require.Len(t, pub.notifications, 1)
+	assert.JSONEq(t, `{"report_type":"weekly","regions":["EU","US"]}`, string(pub.notifications[0].JobMetadata))
+}
+
+func TestScheduler_DeliveryDelay_HoldsNotificationsBack(t *testing.T) {
+	row := append(jobRow("job-1", "Night Report", "0 2 * * *"), publisher.FormatPlain, "", 0, nil, 0, 0, 0, nil, nil, 6*3600)
+	db := &mockDB{rows: [][]any{row}, execID: "exec-1"}
+	pub := &mockPublisher{}
+	sched := newSched(db, &countingRunner{result: "ok"}, pub)
+
+	jobs, err := sched.LoadJobs(context.Background())
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 6*time.Hour, jobs[0].DeliveryDelay)
+
+	sched.ExecuteJob(context.Background(), jobs[0])
+
+	require.Len(t, pub.notifications, 1)
+	assert.WithinDuration(t, time.Now().Add(6*time.Hour), pub.notifications[0].DeliverAfter, 5*time.Second)
+}
+
+func TestScheduler_JobMetadata_EmptyIsNotPublished(t *testing.T) {
+	pub := &mockPublisher{}
+	newSched(&mockDB{execID: "exec-1"}, &countingRunner{result: "ok"}, pub).ExecuteJob(context.Background(), baseJob())
+
+	require.Len(t, pub.notifications, 1)
+	assert.Nil(t, pub.notifications[0].JobMetadata)
+}
+
+func TestJob_MustMetadata_PanicsOnMissingKey(t *testing.T) {
+	job := baseJob()
+	assert.Panics(t, func() { job.MustMetadata("region") })
+}
+
+func TestScheduler_LoadJobs_ReadsRunAt(t *testing.T) {
+	at := time.Date(2030, 1, 2, 15, 0, 0, 0, time.UTC)
+	db := &mockDB{rows: [][]any{oneOffRow("job-1", at), jobRow("job-2", "Daily", "0 8 * * *")}}
+	sched := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+
+	jobs, err := sched.LoadJobs(context.Background())
+
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, at, jobs[0].RunAt)
+	assert.True(t, jobs[1].RunAt.IsZero())
+}
+
+func TestScheduler_NextRun_UnknownJob(t *testing.T) {
+	sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{})
+
+	_, ok := sched.NextRun("missing")
+
+	assert.False(t, ok)
+}
+
+func TestScheduler_NextRun_HonoursTimezone(t *testing.T) {
+	sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{})
+	job := baseJob()
+	job.CronExpr, job.Timezone = "30 9 * * *", "America/New_York"
+	require.NoError(t, sched.RegisterJob(context.Background(), job))
+
+	next, ok := sched.NextRun(job.ID)
+
+	require.True(t, ok)
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	assert.Equal(t, 9, next.In(loc).Hour())
+	assert.Equal(t, 30, next.In(loc).Minute())
+	assert.True(t, next.After(time.Now()))
+	assert.WithinDuration(t, time.Now(), next, 24*time.Hour)
+}
+
+func TestScheduler_NextRun_MatchesRunningCron(t *testing.T) {
+	sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{})
+	require.NoError(t, sched.Start(context.Background()))
+	defer sched.Stop(context.Background())
+	require.NoError(t, sched.RegisterJob(context.Background(), baseJob()))
+
+	next, ok := sched.NextRun("job-1")
+
+	require.True(t, ok)
+	assert.Equal(t, sched.NextRunTimes(1)["Test Job"][0], next)
+}
+
+func TestScheduler_RegisterJob_PersistsNextRunAt(t *testing.T) {
+	db := &mockDB{}
+	sched := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+	require.NoError(t, sched.RegisterJob(context.Background(), baseJob()))
+	require.Error(t, sched.RegisterJob(context.Background(), scheduler.Job{ID: "bad", CronExpr: "not-a-cron"}))
+
+	updates := db.execsMatching("next_run_at")
+	require.Len(t, updates, 2)
+	next, ok := sched.NextRun("job-1")
+	require.True(t, ok)
+	assert.Equal(t, &next, updates[0].args[2])
+	assert.Nil(t, updates[1].args[2], "no next run for a job that failed to register")
+}
+
+func TestScheduler_ExecuteJob_RecordsNextRunAt(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	sched := newSched(db, &countingRunner{result: "ok"}, &mockPublisher{})
+	job := baseJob()
+	require.NoError(t, sched.RegisterJob(context.Background(), job))
+
+	sched.ExecuteJob(context.Background(), job)
+
+	updates := db.execsMatching("SET next_run_at")
+	require.Len(t, updates, 1)
+	next, ok := sched.NextRun(job.ID)
+	require.True(t, ok)
+	assert.Equal(t, &next, updates[0].args[1])
+}
+
+func TestScheduler_NextRun_OneOffAfterFiring(t *testing.T) {
+	sched := newSched(&mockDB{execID: "exec-1"}, &countingRunner{result: "ok"}, &mockPublisher{})
+	require.NoError(t, sched.RegisterOnce(context.Background(), baseJob(), time.Now().Add(-time.Second)))
+	require.NoError(t, sched.Stop(context.Background()))
+
+	_, ok := sched.NextRun("job-1")
+
+	assert.False(t, ok, "a fired one-off job has no next run")
+}
+
+// jobRow is a LoadJobs result row for mockDB.rows.
+func jobRow(id, name, cronExpr string) []any {
+	return []any{id, "user-1", name, cronExpr, "say hello", "", []string{"telegram"}}
+}
+
+func TestScheduler_Reload_AddsAndRemovesJobs(t *testing.T) {
+	db := &mockDB{rows: [][]any{jobRow("job-1", "First", "0 8 * * *"), jobRow("job-2", "Second", "0 9 * * *")}}
+	sched := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+
+	require.NoError(t, sched.Reload(context.Background()))
+	next := sched.NextRunTimes(1)
+	assert.Contains(t, next, "First")
+	assert.Contains(t, next, "Second")
+
+	db.rows = [][]any{jobRow("job-2", "Second", "0 9 * * *"), jobRow("job-3", "Third", "0 10 * * *")}
+	require.NoError(t, sched.Reload(context.Background()))
+
+	next = sched.NextRunTimes(1)
+	assert.NotContains(t, next, "First", "deleted or disabled job is unscheduled")
+	assert.Contains(t, next, "Second")
+	assert.Contains(t, next, "Third")
+	assert.Len(t, next, 2)
+}
+
+func TestScheduler_RemoveJob(t *testing.T) {
+	sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{})
+	require.NoError(t, sched.RegisterJob(context.Background(), baseJob()))
+
+	assert.True(t, sched.RemoveJob("job-1"))
+	assert.NotContains(t, sched.NextRunTimes(1), "Test Job")
+	assert.False(t, sched.RemoveJob("job-1"), "already removed")
+}
+
+func TestScheduler_PauseResumeJob(t *testing.T) {
+	db := &mockDB{rows: [][]any{jobRow("job-1", "Report", "0 8 * * *")}}
+	sched := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+	require.NoError(t, sched.Reload(context.Background()))
+
+	require.NoError(t, sched.PauseJob(context.Background(), "job-1"))
+	assert.NotContains(t, sched.NextRunTimes(1), "Report")
+	require.NoError(t, sched.Reload(context.Background()))
+	assert.NotContains(t, sched.NextRunTimes(1), "Report", "pause is persisted, so a reload keeps it paused")
+	require.NoError(t, sched.PauseJob(context.Background(), "job-1"), "pausing twice is a no-op")
+
+	require.NoError(t, sched.ResumeJob(context.Background(), "job-1"))
+	assert.Contains(t, sched.NextRunTimes(1), "Report")
+}
+
+func TestScheduler_PauseResumeJob_NotFound(t *testing.T) {
+	sched := scheduler.New(&mockDB{}, &countingRunner{}, &mockPublisher{})
+
+	assert.ErrorIs(t, sched.PauseJob(context.Background(), "missing"), scheduler.ErrJobNotFound)
+	assert.ErrorIs(t, sched.ResumeJob(context.Background(), "missing"), scheduler.ErrJobNotFound)
+}
+
+func TestScheduler_ResumeJob_FiredOneOffStaysDone(t *testing.T) {
+	db := &mockDB{execID: "exec-1", rows: [][]any{oneOffRow("job-1", time.Now().Add(-time.Minute))}}
+	run := &countingRunner{result: "reminder"}
+	sched := newSched(db, run, &mockPublisher{})
+	require.NoError(t, sched.Start(context.Background()))
+	require.Eventually(t, func() bool { return run.calls.Load() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	err := sched.ResumeJob(context.Background(), "job-1")
+	require.NoError(t, sched.Stop(context.Background()))
+
+	assert.ErrorIs(t, err, scheduler.ErrJobNotFound, "a fired one-off job is not paused")
+	assert.Equal(t, int32(1), run.calls.Load(), "resuming does not fire it again")
+}
+
+func TestScheduler_ResumeJob_PausedOneOffFires(t *testing.T) {
+	db := &mockDB{execID: "exec-1", rows: [][]any{oneOffRow("job-1", time.Now().Add(time.Hour))}}
+	run := &countingRunner{result: "reminder"}
+	sched := newSched(db, run, &mockPublisher{})
+	require.NoError(t, sched.Reload(context.Background()))
+	require.NoError(t, sched.PauseJob(context.Background(), "job-1"))
+
+	db.rows[0] = oneOffRow("job-1", time.Now().Add(-time.Minute)) // run_at passed while paused
+	require.NoError(t, sched.ResumeJob(context.Background(), "job-1"))
+	require.NoError(t, sched.Stop(context.Background()))
+
+	assert.Equal(t, int32(1), run.calls.Load())
+}
+
+func TestScheduler_ResumeJob_InvalidCron(t *testing.T) {
+	db := &mockDB{rows: [][]any{jobRow("job-1", "Broken", "not a cron")}}
+	sched := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+
+	err := sched.ResumeJob(context.Background(), "job-1")
+
+	require.ErrorIs(t, err, scheduler.ErrJobNotSchedulable)
+	assert.Contains(t, err.Error(), "invalid cron expr")
+	assert.Len(t, db.execsMatching("registration_error"), 1, "the error is recorded")
+}
+
+func TestScheduler_Reload_ReschedulesChangedCron(t *testing.T) {
+	db := &mockDB{rows: [][]any{jobRow("job-1", "Report", "0 8 * * *")}}
+	sched := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+	require.NoError(t, sched.Reload(context.Background()))
+
+	db.rows = [][]any{jobRow("job-1", "Report", "* * * * *")}
+	require.NoError(t, sched.Reload(context.Background()))
+
+	times := sched.NextRunTimes(2)["Report"]
+	require.Len(t, times, 2)
+	assert.InDelta(t, time.Minute.Seconds(), times[1].Sub(times[0]).Seconds(), 1, "new expression is in effect")
+}
+
+func TestScheduler_Reload_LeavesUnchangedJobsAlone(t *testing.T) {
+	db := &mockDB{rows: [][]any{jobRow("job-1", "Report", "0 8 * * *")}}
+	sched := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+	require.NoError(t, sched.Reload(context.Background()))
+	registrations := len(db.execsMatching("registration_error"))
+
+	require.NoError(t, sched.Reload(context.Background()))
+
+	assert.Len(t, db.execsMatching("registration_error"), registrations, "job is not re-registered")
+	assert.Len(t, sched.NextRunTimes(1), 1)
+}
+
+func TestScheduler_Reload_DoesNotInterruptRunningExecution(t *testing.T) {
+	db := &mockDB{execID: "exec-1", rows: [][]any{jobRow("job-1", "Report", "0 8 * * *")}}
+	run := &blockingRunner{started: make(chan struct{}), release: make(chan struct{})}
+	pub := &mockPublisher{}
+	sched := newSched(db, run, pub)
+	require.NoError(t, sched.Reload(context.Background()))
+
+	done := make(chan struct{})
+	go func() {
+		sched.ExecuteJob(context.Background(), baseJob())
+		close(done)
+	}()
+	<-run.started
+
+	db.rows = nil // job deleted while it runs
+	require.NoError(t, sched.Reload(context.Background()))
+	assert.Empty(t, sched.NextRunTimes(1))
+
+	close(run.release)
+	<-done
+	require.Len(t, pub.notifications, 1)
+	assert.Equal(t, "done", pub.notifications[0].Content)
+}
+
+func TestScheduler_Reload_QueryError(t *testing.T) {
+	sched := scheduler.New(&mockDB{err: fmt.Errorf("db down")}, &countingRunner{}, &mockPublisher{})
+	assert.Error(t, sched.Reload(context.Background()))
+}
+
+func TestScheduler_TriggerNow_ExecutesJob(t *testing.T) {
+	db := &mockDB{execID: "exec-1", rows: [][]any{jobRow("job-1", "Report", "0 8 * * *")}}
+	pub := &mockPublisher{}
+	sched := newSched(db, &countingRunner{result: "report ready"}, pub)
+
+	require.NoError(t, sched.TriggerNow(context.Background(), "job-1"))
+	require.NoError(t, sched.Stop(context.Background())) // waits for the triggered run
+
+	updates := db.execsMatching("UPDATE job_executions")
+	require.Len(t, updates, 1)
+	assert.Equal(t, "completed", updates[0].args[0])
+	require.Len(t, pub.notifications, 1)
+	assert.Equal(t, "report ready", pub.notifications[0].Content)
+	assert.Equal(t, "job-1", pub.notifications[0].JobID)
+}
+
+func TestScheduler_TriggerNow_UnknownOrDisabledJob(t *testing.T) {
+	sched := newSched(&mockDB{}, &countingRunner{}, &mockPublisher{})
+	assert.ErrorIs(t, sched.TriggerNow(context.Background(), "missing"), scheduler.ErrJobNotFound)
+}
+
+func TestScheduler_TriggerNow_RejectsConcurrentTriggers(t *testing.T) {
+	db := &mockDB{execID: "exec-1", rows: [][]any{jobRow("job-1", "Report", "0 8 * * *")}}
+	run := &blockingRunner{started: make(chan struct{}), release: make(chan struct{})}
+	sched := newSched(db, run, &mockPublisher{})
+
+	var accepted, rejected atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch err := sched.TriggerNow(context.Background(), "job-1"); {
+			case err == nil:
+				accepted.Add(1)
+			case errors.Is(err, scheduler.ErrJobAlreadyRunning):
+				rejected.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	<-run.started
+
+	// A cron firing during the manual run is skipped as well.
+	sched.ExecuteJob(context.Background(), baseJob())
+
+	close(run.release)
+	require.NoError(t, sched.Stop(context.Background()))
+	assert.Equal(t, int32(1), accepted.Load())
+	assert.Equal(t, int32(9), rejected.Load())
+	assert.Equal(t, int32(1), run.calls.Load())
+}
+
+func TestScheduler_TriggerNow_OutlivesRequestContext(t *testing.T) {
+	db := &mockDB{execID: "exec-1", rows: [][]any{jobRow("job-1", "Report", "0 8 * * *")}}
+	pub := &mockPublisher{}
+	run := &blockingRunner{started: make(chan struct{}), release: make(chan struct{})}
+	sched := newSched(db, run, pub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, sched.TriggerNow(ctx, "job-1"))
+	<-run.started
+	cancel() // e.g. the admin HTTP request finished
+
+	close(run.release)
+	require.NoError(t, sched.Stop(context.Background()))
+	assert.Len(t, pub.notifications, 1)
+}
+
+// sleepyRunner answers after delay, ignoring cancellation.
+type sleepyRunner struct{ delay time.Duration }
+
+func (m sleepyRunner) Run(_ context.Context, _, _, _ string) (string, error) {
+	time.Sleep(m.delay)
+	return "late answer", nil
+}
+
+func TestScheduler_ExecuteJob_TimesOut(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	pub := &mockPublisher{}
+	job := baseJob()
+	job.ExecutionTimeout = 50 * time.Millisecond
+
+	newSched(db, sleepyRunner{delay: 200 * time.Millisecond}, pub).ExecuteJob(context.Background(), job)
+
+	updates := db.execsMatching("UPDATE job_executions")
+	require.Len(t, updates, 1)
+	assert.Equal(t, "timed_out", updates[0].args[0])
+	assert.Contains(t, updates[0].args[1], "timed out after 50ms")
+	assert.Empty(t, pub.notifications)
+}
+
+func TestScheduler_ExecuteJob_FinishesWithinTimeout(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	pub := &mockPublisher{}
+	job := baseJob()
+	job.ExecutionTimeout = time.Second
+
+	newSched(db, sleepyRunner{delay: 10 * time.Millisecond}, pub).ExecuteJob(context.Background(), job)
+
+	updates := db.execsMatching("UPDATE job_executions")
+	require.Len(t, updates, 1)
+	assert.Equal(t, "completed", updates[0].args[0])
+	assert.Len(t, pub.notifications, 1)
+}
+
+func TestScheduler_LoadJobs_ReadsTimeout(t *testing.T) {
+	row := append(jobRow("job-1", "Report", "0 8 * * *"), publisher.FormatPlain, "", 30)
+	sched := scheduler.New(&mockDB{rows: [][]any{row}}, &countingRunner{}, &mockPublisher{})
+
+	jobs, err := sched.LoadJobs(context.Background())
+
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 30*time.Second, jobs[0].ExecutionTimeout)
+}
+
+func TestScheduler_WithLogger_WritesStructuredRecords(t *testing.T) {
+	var buf bytes.Buffer
+	db := &mockDB{execID: "exec-1"}
+	sched := newSched(db, &failThenSucceedRunner{failUntil: 1, result: "ok"}, &mockPublisher{}).
+		WithLogger(logging.NewLogger(&buf, slog.LevelInfo))
+
+	sched.ExecuteJob(context.Background(), baseJob())
+
+	var retry map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record), line)
+		assert.Equal(t, "scheduler", record["component"])
+		if record["msg"] == "job attempt failed, retrying" {
+			retry = record
+		}
+	}
+	require.NotNil(t, retry, "retry is logged")
+	assert.Equal(t, "WARN", retry["level"])
+	assert.Equal(t, "job-1", retry["job_id"])
+	assert.Equal(t, float64(1), retry["attempt"])
+	assert.Contains(t, retry, "error")
+}
+
+func TestScheduler_ExecuteJob_RateLimitedPerUser(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	run := &countingRunner{result: "hello"}
+	pub := &mockPublisher{}
+	// One execution per user, refilled far outside the test's lifetime.
+	sched := newSched(db, run, pub).WithRateLimiter(scheduler.NewTokenBucketRateLimiter(0.001))
+	other := baseJob()
+	other.ID, other.UserID = "job-2", "user-2"
+
+	sched.ExecuteJob(context.Background(), baseJob())
+	sched.ExecuteJob(context.Background(), baseJob())
+	sched.ExecuteJob(context.Background(), other)
+
+	assert.Equal(t, int32(2), run.calls.Load(), "the over-limit execution never reaches the runner")
+	updates := db.execsMatching("UPDATE job_executions")
+	require.Len(t, updates, 3)
+	assert.Equal(t, "completed", updates[0].args[0])
+	assert.Equal(t, "rate_limited", updates[1].args[0])
+	assert.Equal(t, "completed", updates[2].args[0], "other users are not affected")
+	assert.Len(t, pub.notifications, 2)
+}
+
+func TestTokenBucketRateLimiter_AllowsBurstOfRPS(t *testing.T) {
+	rl := scheduler.NewTokenBucketRateLimiter(3)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, rl.Allow("user-1"), "execution %d", i+1)
+	}
+	assert.False(t, rl.Allow("user-1"))
+	assert.True(t, rl.Allow("user-2"))
+}
+
+// retryDelays runs job with a runner that fails once and returns the logged
+// retry_in of every run.
+func retryDelays(t *testing.T, sched func(*scheduler.Scheduler) *scheduler.Scheduler, runs int) []time.Duration {
+	t.Helper()
+	var delays []time.Duration
+	for i := 0; i < runs; i++ {
+		var buf bytes.Buffer
+		s := sched(newSched(&mockDB{execID: "exec-1"}, &failThenSucceedRunner{failUntil: 1, result: "ok"}, &mockPublisher{}).
+			WithLogger(logging.NewLogger(&buf, slog.LevelInfo)))
+		s.ExecuteJob(context.Background(), baseJob())
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var record map[string]any
+			require.NoError(t, json.Unmarshal([]byte(line), &record), line)
+			if record["msg"] == "job attempt failed, retrying" {
+				delays = append(delays, time.Duration(record["retry_in"].(float64)))
+			}
+		}
+	}
+	require.Len(t, delays, runs)
+	return delays
+}
+
+func TestScheduler_RetryDelayHasJitter(t *testing.T) {
+	const base = time.Millisecond // newSched's retry delay
+	delays := retryDelays(t, func(s *scheduler.Scheduler) *scheduler.Scheduler { return s }, 100)
+
+	distinct := map[time.Duration]bool{}
+	for _, d := range delays {
+		assert.GreaterOrEqual(t, d, base)
+		assert.Less(t, d, base+base/2, "default jitter is half the retry delay")
+		distinct[d] = true
+	}
+	assert.Greater(t, len(distinct), 1, "retries of simultaneous failures are spread out")
+}
+
+func TestScheduler_WithJitter_ZeroIsDeterministic(t *testing.T) {
+	delays := retryDelays(t, func(s *scheduler.Scheduler) *scheduler.Scheduler { return s.WithJitter(0) }, 5)
+
+	for _, d := range delays {
+		assert.Equal(t, time.Millisecond, d)
+	}
+}
+
+func TestScheduler_GetExecutionHistory_PagesWithLimitOffset(t *testing.T) {
+	started := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	completed := started.Add(2 * time.Second)
+	db := &mockDB{rows: [][]any{
+		{"exec-3", "job-1", "running", "", started.Add(time.Hour), (*time.Time)(nil), "trace-3", 0, 0, 45},
+		{"exec-2", "job-1", "completed", "Good morning!", started, &completed, "", 12, 34, 45},
+	}}
+	s := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+
+	records, total, err := s.GetExecutionHistory(context.Background(), "job-1", 3, 20)
+
+	require.NoError(t, err)
+	assert.Equal(t, 45, total)
+	require.Len(t, records, 2)
+	assert.Equal(t, scheduler.ExecutionRecord{
+		ID: "exec-3", JobID: "job-1", Status: "running", StartedAt: started.Add(time.Hour), TraceID: "trace-3",
+	}, records[0])
+	assert.Equal(t, scheduler.ExecutionRecord{
+		ID: "exec-2", JobID: "job-1", Status: "completed", Result: "Good morning!", StartedAt: started, CompletedAt: completed,
+		PromptTokens: 12, CompletionTokens: 34,
+	}, records[1])
+
+	require.Len(t, db.queries, 1)
+	assert.Contains(t, db.queries[0].sql, "COUNT(*) OVER()")
+	assert.Contains(t, db.queries[0].sql, "LIMIT $1 OFFSET $2")
+	assert.Equal(t, []any{20, 40, "job-1"}, db.queries[0].args, "page 3 of 20 skips 40 rows")
+}
+
+func TestScheduler_ExecuteJob_RecordsTokenUsage(t *testing.T) {
+	var calls atomic.Int32
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// A failed attempt still spent tokens.
+			json.NewEncoder(w).Encode(runner.ChatResponse{PromptEvalCount: 26})
+			return
+		}
+		json.NewEncoder(w).Encode(runner.ChatResponse{
+			Message:         runner.ChatMsg{Role: "assistant", Content: "Good morning!"},
+			PromptEvalCount: 26,
+			EvalCount:       298,
+		})
+	}))
+	defer llm.Close()
+	db := &mockDB{execID: "exec-1"}
+
+	newSched(db, runner.New(llm.URL, "test-model"), &mockPublisher{}).ExecuteJob(context.Background(), baseJob())
+
+	updates := db.execsMatching("UPDATE job_executions")
+	require.Len(t, updates, 1)
+	assert.Equal(t, "completed", updates[0].args[0])
+	assert.Equal(t, 52, updates[0].args[4], "prompt tokens of both attempts")
+	assert.Equal(t, 298, updates[0].args[5])
+}
+
+func TestScheduler_GetTokenUsage_SumsRange(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	db := &mockDB{rows: [][]any{{7, 350, 1400}}}
+	s := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+
+	usage, err := s.GetTokenUsage(context.Background(), "job-1", from, to)
+
+	require.NoError(t, err)
+	assert.Equal(t, scheduler.TokenUsage{
+		JobID: "job-1", From: from, To: to, Executions: 7, PromptTokens: 350, CompletionTokens: 1400, TotalTokens: 1750,
+	}, usage)
+	require.Len(t, db.queries, 1)
+	assert.Equal(t, []any{"job-1", from, to}, db.queries[0].args)
+}
+
+func TestScheduler_GetExecutionHistory_RejectsInvalidPage(t *testing.T) {
+	db := &mockDB{}
+	s := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+
+	_, _, err := s.GetExecutionHistory(context.Background(), "job-1", 0, 20)
+	require.Error(t, err)
+	_, _, err = s.GetExecutionHistory(context.Background(), "job-1", 1, 0)
+	require.Error(t, err)
+	assert.Empty(t, db.queries)
+}
+
+func TestScheduler_RegisterJob_CatchesUpMissedFirings(t *testing.T) {
+	run := &countingRunner{result: "ok"}
+	sched := newSched(&mockDB{execID: "exec-1"}, run, &mockPublisher{})
+	job := baseJob()
+	job.CronExpr = "* * * * *"
+	job.CatchUpWindow = 5 * time.Minute
+	job.LastRunAt = time.Now().Add(-3 * time.Minute)
+
+	require.NoError(t, sched.RegisterJob(context.Background(), job))
+	require.NoError(t, sched.Stop(context.Background()))
+
+	assert.Equal(t, int32(3), run.calls.Load(), "one execution per minute since the last run")
+}
+
+func TestScheduler_RegisterJob_CatchUpLimitedToWindow(t *testing.T) {
+	run := &countingRunner{result: "ok"}
+	sched := newSched(&mockDB{execID: "exec-1"}, run, &mockPublisher{})
+	job := baseJob()
+	job.CronExpr = "* * * * *"
+	job.CatchUpWindow = 5 * time.Minute
+	job.LastRunAt = time.Now().Add(-time.Hour)
+
+	require.NoError(t, sched.RegisterJob(context.Background(), job))
+	require.NoError(t, sched.Stop(context.Background()))
+
+	assert.Equal(t, int32(5), run.calls.Load())
+}
+
+func TestScheduler_RegisterJob_NoCatchUpByDefault(t *testing.T) {
+	run := &countingRunner{result: "ok"}
+	sched := newSched(&mockDB{execID: "exec-1"}, run, &mockPublisher{})
+	job := baseJob()
+	job.CronExpr = "* * * * *"
+	job.LastRunAt = time.Now().Add(-time.Hour)
+
+	require.NoError(t, sched.RegisterJob(context.Background(), job))
+	require.NoError(t, sched.Stop(context.Background()))
+
+	assert.Equal(t, int32(0), run.calls.Load())
+}
+
+func TestScheduler_Reload_IgnoresLastRunAt(t *testing.T) {
+	row := func(lastRunAt time.Time) []any {
+		r := make([]any, 19)
+		copy(r, jobRow("job-1", "Report", "0 8 * * *"))
+		r[18] = &lastRunAt
+		return r
+	}
+	db := &mockDB{rows: [][]any{row(time.Now().Add(-time.Hour))}}
+	sched := scheduler.New(db, &countingRunner{}, &mockPublisher{})
+	require.NoError(t, sched.Reload(context.Background()))
+	registrations := len(db.execsMatching("registration_error"))
+
+	db.rows = [][]any{row(time.Now())}
+	require.NoError(t, sched.Reload(context.Background()))
+
+	assert.Len(t, db.execsMatching("registration_error"), registrations, "a new last_run_at does not re-register the job")
+}
+
+func TestScheduler_ExecuteJob_RecordsRetrySpan(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	run := &failThenSucceedRunner{failUntil: 1, result: "ok"}
+	newSched(&mockDB{execID: "exec-1"}, run, &mockPublisher{}).ExecuteJob(context.Background(), baseJob())
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		spans[s.Name()] = s
+	}
+	job, retry := spans["scheduler.execute_job"], spans["scheduler.run_with_retry"]
+	require.NotNil(t, job)
+	require.NotNil(t, retry)
+	assert.Equal(t, job.SpanContext().SpanID(), retry.Parent().SpanID())
+	require.Len(t, retry.Events(), 1, "one event per failed attempt")
+	assert.Contains(t, retry.Attributes(), attribute.Int("job.attempts", 2))
+}
+
+func TestScheduler_Ping_ReportsRunning(t *testing.T) {
+	sched := newSched(&mockDB{}, &countingRunner{}, &mockPublisher{})
+	ctx := context.Background()
+
+	assert.Error(t, sched.Ping(ctx), "not started")
+	require.NoError(t, sched.Start(ctx))
+	assert.NoError(t, sched.Ping(ctx))
+	require.NoError(t, sched.Stop(ctx))
+	assert.Error(t, sched.Ping(ctx), "stopped")
+}
+
+func TestScheduler_Stop_WaitsForRunningExecution(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	run := &blockingRunner{started: make(chan struct{}), release: make(chan struct{})}
+	pub := &mockPublisher{}
+	sched := newSched(db, run, pub)
+	go sched.ExecuteJob(context.Background(), baseJob())
+	<-run.started
+
+	time.AfterFunc(50*time.Millisecond, func() { close(run.release) })
+	require.NoError(t, sched.Stop(context.Background()))
+
+	assert.Len(t, pub.notifications, 1, "the running job finished and published")
+	assert.Empty(t, db.execsMatching("'shutdown'"))
+}
+
+func TestScheduler_Stop_MarksExecutionFailedAfterTimeout(t *testing.T) {
+	db := &mockDB{execID: "exec-1"}
+	run := &blockingRunner{started: make(chan struct{}), release: make(chan struct{})}
+	sched := newSched(db, run, &mockPublisher{}).WithStopTimeout(20 * time.Millisecond)
+	go sched.ExecuteJob(context.Background(), baseJob())
+	<-run.started
+	defer close(run.release)
+
+	err := sched.Stop(context.Background())
+
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	marked := db.execsMatching("'shutdown'")
+	require.Len(t, marked, 1)
+	assert.Contains(t, marked[0].sql, "status = 'failed'")
+	assert.Equal(t, []string{"exec-1"}, marked[0].args[1])
+}
@@ -0,0 +1,81 @@
+// Package tracing configures OpenTelemetry for the notifier and carries trace
+// context across the Redis Stream between publisher and consumers.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Setup installs the W3C trace-context propagator and, when endpoint is set,
+// a tracer provider exporting spans over OTLP/HTTP to endpoint (e.g.
+// "http://otel-collector:4318"). With an empty endpoint tracing stays a no-op.
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, endpoint, serviceName string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// Like OTEL_EXPORTER_OTLP_ENDPOINT, a bare collector address gets the
+	// standard traces path; WithEndpointURL alone would post to "/".
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint)}
+	if u, err := url.Parse(endpoint); err == nil && strings.Trim(u.Path, "/") == "" {
+		opts = append(opts, otlptracehttp.WithURLPath("/v1/traces"))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create otlp exporter: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// StreamCarrier adapts the field map of a Redis Stream message to a
+// propagation.TextMapCarrier, so trace context travels with the notification.
+type StreamCarrier map[string]interface{}
+
+// Get returns the string value of key, or "" if it is missing or not a string.
+func (c StreamCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+// Set stores value under key.
+func (c StreamCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys lists the carrier's keys.
+func (c StreamCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Inject writes the trace context of ctx into a stream message's fields.
+func Inject(ctx context.Context, values map[string]interface{}) {
+	otel.GetTextMapPropagator().Inject(ctx, StreamCarrier(values))
+}
+
+// Extract returns ctx carrying the trace context found in a stream message's fields.
+func Extract(ctx context.Context, values map[string]interface{}) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, StreamCarrier(values))
+}
@@ -0,0 +1,44 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/allerac/notifier/internal/tracing"
+)
+
+func TestInjectExtract_RoundTripsThroughStreamFields(t *testing.T) {
+	shutdown, err := tracing.Setup(context.Background(), "", "test")
+	require.NoError(t, err)
+	defer shutdown(context.Background())
+
+	tp := trace.NewTracerProvider()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "publish")
+	defer span.End()
+
+	values := map[string]interface{}{"content": "hello"}
+	tracing.Inject(ctx, values)
+	require.Contains(t, values, "traceparent")
+
+	got := oteltrace.SpanContextFromContext(tracing.Extract(context.Background(), values))
+	assert.True(t, got.IsRemote())
+	assert.Equal(t, span.SpanContext().TraceID(), got.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), got.SpanID())
+}
+
+func TestExtract_WithoutTraceFieldsHasNoSpan(t *testing.T) {
+	ctx := tracing.Extract(context.Background(), map[string]interface{}{"content": "hello"})
+	assert.False(t, oteltrace.SpanContextFromContext(ctx).IsValid())
+}
+
+func TestStreamCarrier_IgnoresNonStringValues(t *testing.T) {
+	c := tracing.StreamCarrier{"traceparent": 42, "tracestate": "a=b"}
+	assert.Empty(t, c.Get("traceparent"))
+	assert.Equal(t, "a=b", c.Get("tracestate"))
+	assert.ElementsMatch(t, []string{"traceparent", "tracestate"}, c.Keys())
+}
@@ -0,0 +1,158 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pushlib "github.com/SherClockHolmes/webpush-go"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/coder/websocket"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/allerac/notifier/internal/consumers/browser"
+	"github.com/allerac/notifier/internal/consumers/webpush"
+	"github.com/allerac/notifier/internal/publisher"
+)
+
+// browserDB satisfies webpush.DBPool and browser.DBPool: every user has the
+// one push subscription and no queued browser notifications.
+type browserDB struct {
+	subscription string
+}
+
+func (m *browserDB) Query(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
+	return emptyRows{}, nil
+}
+
+func (m *browserDB) QueryRow(_ context.Context, _ string, _ ...any) pgx.Row {
+	return subscriptionRow{m.subscription}
+}
+
+func (m *browserDB) Exec(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+type subscriptionRow struct{ subscription string }
+
+func (r subscriptionRow) Scan(dest ...any) error {
+	*dest[0].(*string) = "sub-1"
+	*dest[1].(*string) = r.subscription
+	return nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Close()                                       {}
+func (emptyRows) Err() error                                   { return nil }
+func (emptyRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (emptyRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (emptyRows) Next() bool                                   { return false }
+func (emptyRows) Scan(...any) error                            { return nil }
+func (emptyRows) Values() ([]any, error)                       { return nil, nil }
+func (emptyRows) RawValues() [][]byte                          { return nil }
+func (emptyRows) Conn() *pgx.Conn                              { return nil }
+
+// pushSubscription returns a PushSubscription JSON for endpoint with freshly
+// generated client keys.
+func pushSubscription(t *testing.T, endpoint string) string {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	auth := make([]byte, 16)
+	_, err = rand.Read(auth)
+	require.NoError(t, err)
+	raw, err := json.Marshal(map[string]any{
+		"endpoint": endpoint,
+		"keys": map[string]string{
+			"p256dh": base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+			"auth":   base64.RawURLEncoding.EncodeToString(auth),
+		},
+	})
+	require.NoError(t, err)
+	return string(raw)
+}
+
+// TestBrowser_PushAndWebSocketBothDeliver runs the Web Push and WebSocket
+// consumers, which read the browser channel in separate groups, against one
+// notification carrying a derived idempotency key: each must deliver it.
+func TestBrowser_PushAndWebSocketBothDeliver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	pushes := 0
+	pushSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		pushes++
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer pushSrv.Close()
+	db := &browserDB{subscription: pushSubscription(t, pushSrv.URL)}
+
+	mr := miniredis.RunT(t)
+	private, public, err := pushlib.GenerateVAPIDKeys()
+	require.NoError(t, err)
+	push, err := webpush.New("redis://"+mr.Addr(), db, webpush.VAPID{
+		PublicKey: public, PrivateKey: private, Subject: "mailto:ops@example.com",
+	})
+	require.NoError(t, err)
+	require.NoError(t, push.Start(ctx))
+	defer push.Close()
+
+	const secret = "ws-secret"
+	ws, err := browser.New("redis://"+mr.Addr(), db, secret)
+	require.NoError(t, err)
+	require.NoError(t, ws.Start(ctx))
+	defer ws.Close()
+	wsSrv := httptest.NewServer(http.HandlerFunc(ws.ServeWS))
+	defer wsSrv.Close()
+
+	expires := time.Now().Add(time.Minute)
+	q := url.Values{
+		"user_id": {"user-1"},
+		"expires": {strconv.FormatInt(expires.Unix(), 10)},
+		"token":   {browser.Token(secret, "user-1", expires)},
+	}
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(wsSrv.URL, "http")+"/ws?"+q.Encode(), nil)
+	require.NoError(t, err)
+	defer conn.CloseNow()
+	require.Eventually(t, func() bool { return ws.Connections("user-1") == 1 }, time.Second, 5*time.Millisecond)
+
+	pub := publisher.NewFromClient(redis.NewClient(&redis.Options{Addr: mr.Addr()})).WithIdempotencyWindow(5 * time.Minute)
+	defer pub.Close()
+	require.NoError(t, pub.Publish(ctx, publisher.Notification{
+		JobID: "job-1", JobName: "Morning Briefing", UserID: "user-1", Channel: "browser", Content: "Good morning!",
+	}))
+
+	readCtx, readDone := context.WithTimeout(ctx, 3*time.Second)
+	defer readDone()
+	_, data, err := conn.Read(readCtx)
+	require.NoError(t, err, "the WebSocket consumer delivers")
+	var n browser.Notification
+	require.NoError(t, json.Unmarshal(data, &n))
+	assert.Equal(t, "Good morning!", n.Content)
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return pushes == 1
+	}, 3*time.Second, 10*time.Millisecond, "the Web Push consumer delivers too")
+}
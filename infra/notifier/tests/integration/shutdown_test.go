@@ -0,0 +1,129 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	telegram "github.com/allerac/notifier/internal/consumers/telegram"
+	"github.com/allerac/notifier/internal/publisher"
+	"github.com/allerac/notifier/internal/scheduler"
+)
+
+// --- mocks ---
+
+// mockDB satisfies both scheduler.DBPool and telegram.DBPool. Every QueryRow
+// scans a fixed execution ID / chat ID / bot token, depending on the destination types.
+type mockDB struct{}
+
+func (m *mockDB) Query(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
+	return nil, nil
+}
+func (m *mockDB) QueryRow(_ context.Context, _ string, _ ...any) pgx.Row {
+	return mockRow{}
+}
+func (m *mockDB) Exec(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+type mockRow struct{}
+
+func (mockRow) Scan(dest ...any) error {
+	for _, d := range dest {
+		switch p := d.(type) {
+		case *string:
+			*p = "test-value"
+		case *int64:
+			*p = 4242
+		}
+	}
+	return nil
+}
+
+// slowRunner signals on started and then takes delay to answer, simulating an
+// LLM call that is still in flight when SIGTERM arrives.
+type slowRunner struct {
+	started chan struct{}
+	delay   time.Duration
+}
+
+func (r *slowRunner) Run(ctx context.Context, _, _, _ string) (string, error) {
+	close(r.started)
+	select {
+	case <-time.After(r.delay):
+		return "Delivered despite shutdown", nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// TestShutdown_JobFiredBeforeShutdownIsDelivered fires a job, starts the
+// shutdown sequence while the runner is still working, and asserts the result
+// still reaches Telegram: scheduler.Stop waits for the job to publish, and
+// Consumer.Stop drains the stream before returning.
+//
+// Run with:
+//
+//	go test -tags integration ./tests/integration/...
+func TestShutdown_JobFiredBeforeShutdownIsDelivered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mr := miniredis.RunT(t)
+	redisURL := "redis://" + mr.Addr()
+
+	var mu sync.Mutex
+	var delivered []string
+	tgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		delivered = append(delivered, payload["text"].(string))
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}))
+	defer tgSrv.Close()
+
+	consumer, err := telegram.NewForTest(redisURL, &mockDB{}, "", tgSrv.URL)
+	require.NoError(t, err)
+	require.NoError(t, consumer.Start(ctx))
+	defer consumer.Close()
+
+	pub := publisher.NewFromClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	defer pub.Close()
+
+	run := &slowRunner{started: make(chan struct{}), delay: 200 * time.Millisecond}
+	sched := scheduler.New(&mockDB{}, run, pub)
+
+	go sched.ExecuteJob(ctx, scheduler.Job{
+		ID: "job-1", UserID: "user-1", Name: "Shutdown Job",
+		CronExpr: "0 8 * * *", Prompt: "hello", Channels: []string{"telegram"},
+	})
+	<-run.started
+
+	// --- shutdown sequence, same order as cmd/notifier ---
+
+	stopCtx, stopDone := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stopDone()
+
+	require.NoError(t, sched.Stop(stopCtx), "scheduler waits for the running job")
+	require.NoError(t, consumer.Stop(stopCtx), "consumer drains the stream")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, delivered, 1)
+	assert.Equal(t, "Delivered despite shutdown", delivered[0])
+}
@@ -0,0 +1,117 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	telegram "github.com/allerac/notifier/internal/consumers/telegram"
+	"github.com/allerac/notifier/internal/publisher"
+	"github.com/allerac/notifier/internal/runner"
+	"github.com/allerac/notifier/internal/scheduler"
+	"github.com/allerac/notifier/internal/tracing"
+)
+
+// TestTracing_SpansFollowTheNotification runs one job through the real
+// runner, publisher and Telegram consumer and checks that the spans form a
+// single trace: execute_job → run_with_retry → llm_call, execute_job →
+// stream_xadd, stream_xadd → consumer.process (across the Redis Stream), with
+// the trace context also sent to the LLM backend.
+func TestTracing_SpansFollowTheNotification(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	_, err := tracing.Setup(ctx, "", "notifier-test") // propagator only
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var traceparent string
+	llmSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparent = r.Header.Get("traceparent")
+		mu.Unlock()
+		w.Write([]byte(`{"message":{"role":"assistant","content":"traced answer"}}`))
+	}))
+	defer llmSrv.Close()
+
+	var delivered []string
+	tgSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		delivered = append(delivered, payload["text"].(string))
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}))
+	defer tgSrv.Close()
+
+	mr := miniredis.RunT(t)
+	consumer, err := telegram.NewForTest("redis://"+mr.Addr(), &mockDB{}, "", tgSrv.URL)
+	require.NoError(t, err)
+	require.NoError(t, consumer.Start(ctx))
+	defer consumer.Close()
+
+	pub := publisher.NewFromClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	defer pub.Close()
+
+	sched := scheduler.New(&mockDB{}, runner.New(llmSrv.URL, "test-model"), pub)
+	sched.ExecuteJob(ctx, scheduler.Job{
+		ID: "job-1", UserID: "user-1", Name: "Traced Job",
+		CronExpr: "0 8 * * *", Prompt: "hello", Channels: []string{"telegram"},
+	})
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(delivered) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	require.NoError(t, consumer.Stop(ctx))
+	mu.Lock()
+	defer mu.Unlock()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	exec, retry, llm := spans["scheduler.execute_job"], spans["scheduler.run_with_retry"], spans["runner.llm_call"]
+	xadd, process := spans["publisher.stream_xadd"], spans["consumer.process"]
+	require.NotNil(t, exec)
+	require.NotNil(t, retry)
+	require.NotNil(t, llm)
+	require.NotNil(t, xadd)
+	require.NotNil(t, process)
+
+	assert.False(t, exec.Parent().IsValid(), "execute_job is the root span")
+	assert.Equal(t, exec.SpanContext().SpanID(), retry.Parent().SpanID())
+	assert.Equal(t, retry.SpanContext().SpanID(), llm.Parent().SpanID(), "LLM attempts are children of the retry loop")
+	assert.Equal(t, exec.SpanContext().SpanID(), xadd.Parent().SpanID())
+	assert.Equal(t, xadd.SpanContext().SpanID(), process.Parent().SpanID(), "consumer continues the publisher's span")
+	assert.Equal(t, exec.SpanContext().TraceID(), process.SpanContext().TraceID())
+
+	assert.Contains(t, traceparent, llm.SpanContext().SpanID().String(), "trace context is sent to the LLM backend")
+	attrs := map[string]string{}
+	for _, kv := range exec.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	_, err = uuid.Parse(attrs["job.trace_id"])
+	assert.NoError(t, err, "job.trace_id is the execution's trace ID")
+	delete(attrs, "job.trace_id")
+	assert.Equal(t, map[string]string{"job.id": "job-1", "job.name": "Traced Job", "job.user_id": "user-1"}, attrs)
+}
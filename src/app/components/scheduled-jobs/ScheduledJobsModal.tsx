@@ -519,9 +519,9 @@ export default function ScheduledJobsModal({ isOpen, onClose, isDarkMode, userId
                               <div key={ex.id} className="flex items-start gap-1.5">
                                 <span className={`text-[10px] mt-0.5 flex-shrink-0 ${
                                   ex.status === 'completed' ? 'text-green-400' :
-                                  ex.status === 'failed'    ? 'text-red-400' : 'text-yellow-400'
+                                  ex.status === 'failed' || ex.status === 'timed_out' ? 'text-red-400' : 'text-yellow-400'
                                 }`}>
-                                  {ex.status === 'completed' ? '✓' : ex.status === 'failed' ? '✗' : '…'}
+                                  {ex.status === 'completed' ? '✓' : ex.status === 'failed' ? '✗' : ex.status === 'timed_out' ? '⏱' : '…'}
                                 </span>
                                 <div className="min-w-0">
                                   <span className={`text-[10px] ${isDarkMode ? 'text-gray-500' : 'text-gray-400'}`}>
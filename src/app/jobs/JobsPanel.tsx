@@ -368,8 +368,8 @@ function JobEditor({ job, userId, isDarkMode: d, domainSlug, onSaved, onDeleted,
               {executions.slice(0, 5).map(ex => (
                 <div key={ex.id} className={`p-2 rounded-lg ${d ? 'bg-gray-700/50' : 'bg-gray-50'}`}>
                   <div className="flex items-center gap-2">
-                    <span className={`text-xs ${ex.status === 'completed' ? 'text-green-400' : ex.status === 'failed' ? 'text-red-400' : 'text-yellow-400'}`}>
-                      {ex.status === 'completed' ? '✓' : ex.status === 'failed' ? '✗' : '…'}
+                    <span className={`text-xs ${ex.status === 'completed' ? 'text-green-400' : ex.status === 'failed' || ex.status === 'timed_out' ? 'text-red-400' : 'text-yellow-400'}`}>
+                      {ex.status === 'completed' ? '✓' : ex.status === 'failed' ? '✗' : ex.status === 'timed_out' ? '⏱' : '…'}
                     </span>
                     <span className={`text-xs ${d ? 'text-gray-400' : 'text-gray-500'}`}>{new Date(ex.startedAt).toLocaleString()}</span>
                   </div>
@@ -10,7 +10,7 @@ interface DBScheduledJob {
   id: string;
   user_id: string;
   name: string;
-  cron_expr: string;
+  cron_expr: string | null; // NULL for one-off jobs (run_at)
   prompt: string;
   channels: string[];
   domain_slug: string | null;
@@ -25,7 +25,7 @@ interface DBScheduledJob {
 interface DBJobExecution {
   id: string;
   job_id: string;
-  status: 'running' | 'completed' | 'failed';
+  status: 'running' | 'completed' | 'failed' | 'timed_out' | 'deduplicated' | 'rate_limited';
   result: string | null;
   started_at: Date;
   completed_at: Date | null;
@@ -36,7 +36,7 @@ function mapJob(row: DBScheduledJob): ScheduledJob {
     id: row.id,
     userId: row.user_id,
     name: row.name,
-    cronExpr: row.cron_expr,
+    cronExpr: row.cron_expr ?? '',
     prompt: row.prompt,
     channels: row.channels,
     domainSlug: row.domain_slug ?? null,
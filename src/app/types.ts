@@ -90,7 +90,7 @@ export interface ScheduledJob {
 export interface JobExecution {
   id: string;
   jobId: string;
-  status: 'running' | 'completed' | 'failed';
+  status: 'running' | 'completed' | 'failed' | 'timed_out' | 'deduplicated' | 'rate_limited';
   result: string | null;
   startedAt: string;
   completedAt: string | null;
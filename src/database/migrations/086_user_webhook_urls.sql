@@ -0,0 +1,19 @@
+-- Migration 086: Per-user webhook callback URLs for the notifier "webhook" channel
+--
+-- The notifier POSTs each notification to the user's URL with an
+-- X-Allerac-Signature header (HMAC-SHA256 of the body keyed with secret).
+
+CREATE TABLE IF NOT EXISTS user_webhook_urls (
+  id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  url        TEXT NOT NULL,
+  secret     TEXT NOT NULL, -- Encrypted signing secret (iv:ciphertext)
+  timeout_ms INTEGER NOT NULL DEFAULT 10000 CHECK (timeout_ms > 0),
+  enabled    BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_webhook_urls_user_id ON user_webhook_urls(user_id);
+
+COMMENT ON TABLE user_webhook_urls IS 'Callback URLs receiving notifier webhook-channel deliveries';
@@ -0,0 +1,9 @@
+-- Migration 087: Record why the notifier could not schedule a job
+--
+-- Set by the notifier when a job's cron expression fails to register and
+-- cleared once it registers successfully. NULL means no known problem.
+-- The notifier only writes the column when the value changes, so the
+-- scheduled_jobs_changed NOTIFY it triggers settles after one round.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS registration_error TEXT;
@@ -0,0 +1,6 @@
+-- Migration 088: Optional system prompt (persona/context) for scheduled jobs
+--
+-- When set, the notifier sends it as a "system" message ahead of the job prompt.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS system_prompt TEXT;
@@ -0,0 +1,9 @@
+-- Migration 089: Markup format of a scheduled job's output
+--
+-- When set, Telegram deliveries are sent with the matching parse_mode so
+-- Markdown/HTML in the LLM output is rendered instead of shown literally.
+-- NULL means plain text.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS message_format TEXT
+  CHECK (message_format IN ('MarkdownV2', 'HTML'));
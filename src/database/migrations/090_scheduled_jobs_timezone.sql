@@ -0,0 +1,7 @@
+-- Migration 090: Per-job time zone for cron expressions
+--
+-- IANA name (e.g. 'America/New_York'). The notifier evaluates cron_expr in this
+-- zone; NULL keeps the server's local time.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS timezone TEXT;
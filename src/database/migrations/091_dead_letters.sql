@@ -0,0 +1,21 @@
+-- Migration 091: Queryable history of notifications moved to the DLQ
+--
+-- The notifier's Redis DLQ stream is capped, so entries eventually age out.
+-- Each dead-lettered message is also recorded here. job_id / user_id are kept
+-- as plain text (no foreign keys) so the history survives deleted jobs and
+-- users, and malformed stream messages can still be recorded.
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+  id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  job_id      TEXT NOT NULL DEFAULT '',
+  user_id     TEXT NOT NULL DEFAULT '',
+  channel     TEXT NOT NULL DEFAULT '',
+  content     TEXT NOT NULL DEFAULT '',
+  reason      TEXT NOT NULL,
+  original_id TEXT NOT NULL, -- Redis stream ID of the failed message
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_user_id ON dead_letters(user_id, created_at DESC);
+
+COMMENT ON TABLE dead_letters IS 'Notifier messages that exhausted their delivery attempts';
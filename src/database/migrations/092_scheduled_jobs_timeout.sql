@@ -0,0 +1,13 @@
+-- Migration 092: Per-job execution timeout
+--
+-- timeout_seconds bounds a whole execution (all LLM attempts) in the notifier;
+-- NULL means no job-level limit. Executions that hit it are recorded with the
+-- new 'timed_out' status.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS timeout_seconds INTEGER CHECK (timeout_seconds > 0);
+
+ALTER TABLE job_executions DROP CONSTRAINT IF EXISTS job_executions_status_check;
+ALTER TABLE job_executions
+  ADD CONSTRAINT job_executions_status_check
+  CHECK (status IN ('running', 'completed', 'failed', 'timed_out'));
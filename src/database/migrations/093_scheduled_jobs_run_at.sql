@@ -0,0 +1,16 @@
+-- Migration 093: One-off scheduled jobs
+--
+-- A job with run_at fires once at that time instead of on cron_expr, which
+-- becomes optional for such jobs. The notifier disables the job when it fires,
+-- so it does not run again after a restart.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS run_at TIMESTAMPTZ;
+
+ALTER TABLE scheduled_jobs
+  ALTER COLUMN cron_expr DROP NOT NULL;
+
+ALTER TABLE scheduled_jobs DROP CONSTRAINT IF EXISTS scheduled_jobs_schedule_check;
+ALTER TABLE scheduled_jobs
+  ADD CONSTRAINT scheduled_jobs_schedule_check
+  CHECK (cron_expr IS NOT NULL OR run_at IS NOT NULL);
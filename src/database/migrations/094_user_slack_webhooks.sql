@@ -0,0 +1,18 @@
+-- Migration 094: Per-user Slack Incoming Webhooks for the notifier "slack" channel
+--
+-- The notifier POSTs {"text","username"} to webhook_url; slack_channel (e.g.
+-- '#alerts') overrides the channel the webhook posts to by default.
+
+CREATE TABLE IF NOT EXISTS user_slack_webhooks (
+  id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id       UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  webhook_url   TEXT NOT NULL,
+  slack_channel TEXT,
+  enabled       BOOLEAN NOT NULL DEFAULT true,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_slack_webhooks_user_id ON user_slack_webhooks(user_id);
+
+COMMENT ON TABLE user_slack_webhooks IS 'Slack Incoming Webhooks receiving notifier slack-channel deliveries';
@@ -0,0 +1,9 @@
+-- Migration 095: Per-job runner retry policy
+--
+-- max_attempts is the number of LLM calls one execution may make and
+-- retry_delay_seconds the backoff unit between them (waits grow as 1×, 2×, …).
+-- NULL keeps the notifier defaults (3 attempts, 5s).
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS max_attempts INTEGER CHECK (max_attempts > 0),
+  ADD COLUMN IF NOT EXISTS retry_delay_seconds INTEGER CHECK (retry_delay_seconds > 0);
@@ -0,0 +1,8 @@
+-- Migration 096: Next fire time of each scheduled job
+--
+-- Written by the notifier when it registers a job and after every execution;
+-- NULL while the job is not scheduled (disabled, invalid cron expression, or a
+-- one-off job that has fired).
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS next_run_at TIMESTAMPTZ;
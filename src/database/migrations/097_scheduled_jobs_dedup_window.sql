@@ -0,0 +1,13 @@
+-- Migration 097: Per-job result deduplication
+--
+-- dedup_window_seconds makes the notifier skip publishing a result identical
+-- to one it already published for the job within the window; NULL disables
+-- the check. Skipped executions are recorded with the new 'deduplicated' status.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS dedup_window_seconds INTEGER CHECK (dedup_window_seconds > 0);
+
+ALTER TABLE job_executions DROP CONSTRAINT IF EXISTS job_executions_status_check;
+ALTER TABLE job_executions
+  ADD CONSTRAINT job_executions_status_check
+  CHECK (status IN ('running', 'completed', 'failed', 'timed_out', 'deduplicated'));
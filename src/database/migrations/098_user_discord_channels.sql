@@ -0,0 +1,17 @@
+-- Migration 098: Per-user Discord channels for the notifier "discord" channel
+--
+-- The notifier posts as its bot (DISCORD_BOT_TOKEN) to channel_id, a Discord
+-- channel snowflake the bot has been given access to.
+
+CREATE TABLE IF NOT EXISTS user_discord_channels (
+  id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  channel_id TEXT NOT NULL,
+  enabled    BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_discord_channels_user_id ON user_discord_channels(user_id);
+
+COMMENT ON TABLE user_discord_channels IS 'Discord channels receiving notifier discord-channel deliveries';
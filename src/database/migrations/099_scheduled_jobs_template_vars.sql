@@ -0,0 +1,9 @@
+-- Migration 099: Prompt template variables
+--
+-- A prompt containing "{{" is rendered as a Go text/template before each run,
+-- e.g. 'Briefing for {{.city}} on {{.__date__}}'. template_vars holds the
+-- user's variables as a JSON object of strings; __date__, __time__ and
+-- __weekday__ (UTC) are always available. NULL = no user variables.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS template_vars JSONB CHECK (jsonb_typeof(template_vars) = 'object');
@@ -0,0 +1,9 @@
+-- Migration 100: Free-form job metadata
+--
+-- metadata holds operator data attached to a job, e.g.
+-- '{"report_type": "weekly", "region": "EU"}'. The notifier does not
+-- interpret it; it is copied to every notification of the job (stream field
+-- "metadata") and logged on delivery. NULL = no metadata.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS metadata JSONB CHECK (jsonb_typeof(metadata) = 'object');
@@ -0,0 +1,20 @@
+-- Migration 101: Web Push subscriptions for the notifier "browser" channel
+--
+-- subscription is the browser's PushSubscription.toJSON():
+-- {"endpoint": "...", "keys": {"p256dh": "...", "auth": "..."}}. The notifier
+-- pushes to the user's most recent enabled subscription and deletes it when
+-- the push service answers 404 or 410 (unsubscribed or expired).
+
+CREATE TABLE IF NOT EXISTS user_push_subscriptions (
+  id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id      UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  subscription JSONB NOT NULL CHECK (subscription ? 'endpoint' AND subscription ? 'keys'),
+  enabled      BOOLEAN NOT NULL DEFAULT true,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_push_subscriptions_user_id ON user_push_subscriptions(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_push_subscriptions_endpoint ON user_push_subscriptions((subscription->>'endpoint'));
+
+COMMENT ON TABLE user_push_subscriptions IS 'Web Push subscriptions receiving notifier browser-channel deliveries';
@@ -0,0 +1,8 @@
+-- Migration 102: Deferred delivery of a job's notifications
+--
+-- delivery_delay_seconds holds the notifications of each execution back for
+-- this long after the result is ready, e.g. a job that runs at 02:00 with a
+-- delay of 21600 is delivered at 08:00. NULL or 0 = deliver right away.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS delivery_delay_seconds INTEGER CHECK (delivery_delay_seconds >= 0);
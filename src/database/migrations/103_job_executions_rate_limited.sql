@@ -0,0 +1,9 @@
+-- Migration 103: Per-user execution rate limiting
+--
+-- With RATE_LIMIT_RPS set, the notifier skips executions of a user who is over
+-- their budget and records them with the new 'rate_limited' status.
+
+ALTER TABLE job_executions DROP CONSTRAINT IF EXISTS job_executions_status_check;
+ALTER TABLE job_executions
+  ADD CONSTRAINT job_executions_status_check
+  CHECK (status IN ('running', 'completed', 'failed', 'timed_out', 'deduplicated', 'rate_limited'));
@@ -0,0 +1,12 @@
+-- Migration 104: Discord webhook URLs for the notifier "discord" channel
+--
+-- A user can now give a Discord webhook URL instead of a channel the bot posts
+-- to; webhooks need no bot token. When both are set the webhook wins.
+
+ALTER TABLE user_discord_channels ADD COLUMN IF NOT EXISTS webhook_url TEXT;
+ALTER TABLE user_discord_channels ALTER COLUMN channel_id DROP NOT NULL;
+
+ALTER TABLE user_discord_channels DROP CONSTRAINT IF EXISTS user_discord_channels_target_check;
+ALTER TABLE user_discord_channels
+  ADD CONSTRAINT user_discord_channels_target_check
+  CHECK (channel_id IS NOT NULL OR webhook_url IS NOT NULL);
@@ -0,0 +1,18 @@
+-- Migration 105: Browser notifications waiting for a WebSocket connection
+--
+-- The notifier's browser WebSocket consumer stores a notification here when the
+-- user has no live connection, and sends and deletes it when they next connect.
+
+CREATE TABLE IF NOT EXISTS pending_browser_notifications (
+  id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  job_id     UUID,
+  title      TEXT NOT NULL DEFAULT '',
+  content    TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_pending_browser_notifications_user_id
+  ON pending_browser_notifications(user_id, created_at);
+
+COMMENT ON TABLE pending_browser_notifications IS 'Browser-channel notifications queued for the user''s next WebSocket connection';
@@ -0,0 +1,8 @@
+-- Migration 106: Catch up on firings missed while the notifier was down
+--
+-- When the notifier starts, a job with catch_up_window_seconds runs once for
+-- every firing it missed in that many seconds before startup (and after its
+-- last_run_at). NULL or 0 = missed firings are skipped.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS catch_up_window_seconds INTEGER CHECK (catch_up_window_seconds >= 0);
@@ -0,0 +1,11 @@
+-- Migration 107: Scope Telegram chat mappings to a bot
+--
+-- A user can have several bots in telegram_bot_configs, and a notification can
+-- pick one by name (bot_name). bot_id records which bot a chat mapping belongs
+-- to; the notifier prefers the selected bot's mapping and falls back to one
+-- with a NULL bot_id, which applies to every bot of the user.
+
+ALTER TABLE telegram_chat_mapping
+  ADD COLUMN IF NOT EXISTS bot_id UUID REFERENCES telegram_bot_configs(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_telegram_chat_mapping_bot_id ON telegram_chat_mapping(bot_id);
@@ -0,0 +1,24 @@
+-- Migration 108: Archive of delivered notifications
+--
+-- Notifier consumers started with archival enabled (NOTIFIER_DELIVERY_LOG)
+-- insert a row here for every notification they deliver, so delivery history
+-- outlives the capped Redis stream.
+
+CREATE TABLE IF NOT EXISTS notification_delivery_log (
+  id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id      TEXT,
+  job_id       TEXT,
+  channel      TEXT NOT NULL,
+  content      TEXT NOT NULL,
+  delivered_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  chat_id      TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_delivery_log_user_id
+  ON notification_delivery_log(user_id, delivered_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_notification_delivery_log_job_id
+  ON notification_delivery_log(job_id, delivered_at DESC);
+
+COMMENT ON TABLE notification_delivery_log IS 'Notifications delivered by the notifier, one row per successful delivery';
+COMMENT ON COLUMN notification_delivery_log.chat_id IS 'Recipient on the channel, e.g. the Telegram chat ID; NULL when the channel does not report one';
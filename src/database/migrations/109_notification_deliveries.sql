@@ -0,0 +1,24 @@
+-- Migration 109: Delivery receipts
+--
+-- The notifier's consumers insert a row here for every message they deliver,
+-- so a published notification can be told apart from a delivered one.
+-- provider_message_id is the channel provider's ID for the sent message, e.g.
+-- the Telegram message_id.
+
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+  id                  UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  job_id              TEXT,
+  user_id             TEXT,
+  channel             TEXT NOT NULL,
+  stream_id           TEXT NOT NULL,
+  delivered_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  provider_message_id TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_job_id
+  ON notification_deliveries(job_id, delivered_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_stream_id
+  ON notification_deliveries(stream_id);
+
+COMMENT ON TABLE notification_deliveries IS 'Delivery receipts: one row per message a notifier consumer delivered';
@@ -0,0 +1,15 @@
+-- Migration 110: Trace IDs
+--
+-- The notifier gives every job execution a trace ID (a UUID) and carries it
+-- on the notifications it publishes (the trace_id stream field) into the
+-- consumers' logs, so an execution, its stream entries, its deliveries and
+-- any dead letters can be correlated.
+
+ALTER TABLE job_executions
+  ADD COLUMN IF NOT EXISTS trace_id TEXT;
+
+ALTER TABLE dead_letters
+  ADD COLUMN IF NOT EXISTS trace_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_job_executions_trace_id ON job_executions(trace_id);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_trace_id ON dead_letters(trace_id);
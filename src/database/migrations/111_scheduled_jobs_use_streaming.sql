@@ -0,0 +1,8 @@
+-- Migration 111: Streamed LLM replies per job
+--
+-- With use_streaming the notifier reads the job's LLM reply as it is
+-- generated instead of waiting for one response. The notification still
+-- carries the complete output. NULL or false = a single blocking request.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS use_streaming BOOLEAN;
@@ -0,0 +1,11 @@
+-- Migration 112: LLM token usage per execution
+--
+-- Tokens the execution's LLM calls consumed, retries included, as reported by
+-- the backend. NULL = no LLM call was made or the backend reports no usage
+-- (e.g. the Allerac pipeline).
+
+ALTER TABLE job_executions
+  ADD COLUMN IF NOT EXISTS prompt_tokens INTEGER,
+  ADD COLUMN IF NOT EXISTS completion_tokens INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_job_executions_job_started ON job_executions(job_id, started_at);
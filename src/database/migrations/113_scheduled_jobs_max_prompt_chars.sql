@@ -0,0 +1,9 @@
+-- Migration 113: Per-job cap on the rendered prompt's length
+--
+-- A prompt longer than max_prompt_chars characters fails the execution (or is
+-- cut from the front when the notifier runs with NOTIFIER_PROMPT_TRUNCATE)
+-- instead of overflowing the model's context window.
+-- NULL or 0 = the notifier's NOTIFIER_MAX_PROMPT_CHARS applies.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS max_prompt_chars INTEGER CHECK (max_prompt_chars >= 0);
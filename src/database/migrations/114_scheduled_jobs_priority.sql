@@ -0,0 +1,9 @@
+-- Migration 114: Per-job notification priority
+--
+-- Notifications of jobs with priority >= 1 (publisher.PriorityHigh) go to
+-- the notifications:high stream, which consumers read before the normal one,
+-- so alerts do not wait behind a backlog of daily digests.
+-- NULL or 0 = normal priority.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS priority INTEGER CHECK (priority >= 0);
@@ -0,0 +1,10 @@
+-- Migration 115: Jobs that notify a list of users
+--
+-- A job with recipient_user_ids set (e.g. an admin's weekly summary for a
+-- team) runs its prompt once and sends the result to each listed user on each
+-- of the job's channels, instead of to its owner (user_id). IDs that are not
+-- users are skipped at run time.
+-- NULL or empty = the owner only.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS recipient_user_ids UUID[];
@@ -0,0 +1,10 @@
+-- Migration 116: Claim each cron firing once across notifier instances
+--
+-- Every notifier instance runs the same cron entries. Before executing a
+-- firing, an instance moves last_fired_at forward to the firing's scheduled
+-- time; only the instance whose UPDATE succeeds runs the job. Nothing is
+-- held while the job runs.
+-- NULL = never fired.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS last_fired_at TIMESTAMPTZ;
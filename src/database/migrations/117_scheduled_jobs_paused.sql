@@ -0,0 +1,9 @@
+-- Migration 117: Pause jobs without disabling them
+--
+-- Set by the notifier's POST /admin/jobs/{id}/pause and cleared by resume.
+-- A paused job is not scheduled. It is kept apart from enabled, which also
+-- marks deleted jobs and one-off jobs that have fired, so resuming a job
+-- never brings one of those back.
+
+ALTER TABLE scheduled_jobs
+  ADD COLUMN IF NOT EXISTS paused BOOLEAN NOT NULL DEFAULT false;